@@ -0,0 +1,353 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 管理员密码重置令牌，只保存令牌的哈希值
+type AdminPasswordResetToken struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	AdminUserID uint       `json:"admin_user_id" gorm:"index"`
+	TokenHash   string     `json:"-" gorm:"uniqueIndex;size:64"`
+	CreatedBy   uint       `json:"created_by"` // 发起重置的管理员ID
+	ExpiresAt   time.Time  `json:"expires_at"`
+	UsedAt      *time.Time `json:"used_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// 创建管理员请求
+type CreateAdminUserRequest struct {
+	Username         string `json:"username" binding:"required,min=3,max=64"`
+	Password         string `json:"password"` // 留空时生成临时密码
+	Role             string `json:"role" binding:"required"`
+	Department       string `json:"department"`
+	ExtraPermissions string `json:"extra_permissions"`
+}
+
+// 更新管理员请求
+type UpdateAdminUserRequest struct {
+	Role             *string `json:"role"`
+	Department       *string `json:"department"`
+	ExtraPermissions *string `json:"extra_permissions"`
+	IsActive         *bool   `json:"is_active"`
+}
+
+// 通过重置令牌设置新密码
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+// 校验角色、部门和额外权限
+func validateAdminRole(role, department, extraPermissions string) error {
+	if !isValidRole(role) {
+		return fmt.Errorf("无效的角色: %s", role)
+	}
+	if role == RoleManager && strings.TrimSpace(department) == "" {
+		return fmt.Errorf("部门主管必须指定部门")
+	}
+	for _, p := range strings.Split(extraPermissions, ",") {
+		if p = strings.TrimSpace(p); p != "" && !isValidPermission(p) {
+			return fmt.Errorf("无效的权限: %s", p)
+		}
+	}
+	return nil
+}
+
+// 停用或降级后是否还有可用的系统管理员
+func hasOtherActiveAdmin(excludeID uint) bool {
+	var count int64
+	db.Model(&AdminUser{}).Where("role = ? AND is_active = ? AND id <> ?", RoleAdmin, true, excludeID).Count(&count)
+	return count > 0
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// 获取管理员列表
+func getAdminUsers(c *gin.Context) {
+	var users []AdminUser
+	query := db.Order("id")
+	if role := c.Query("role"); role != "" {
+		query = query.Where("role = ?", role)
+	}
+	if active := c.Query("is_active"); active != "" {
+		query = query.Where("is_active = ?", active == "true")
+	}
+	if err := query.Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取管理员列表失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": users})
+}
+
+// 获取单个管理员
+func getAdminUser(c *gin.Context) {
+	var user AdminUser
+	if err := db.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "管理员不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"user":        user,
+		"permissions": user.EffectivePermissions(),
+	}})
+}
+
+// 创建管理员，新账号首次登录必须修改密码
+func createAdminUser(c *gin.Context) {
+	var req CreateAdminUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+	if err := validateAdminRole(req.Role, req.Department, req.ExtraPermissions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var existing AdminUser
+	if err := db.Where("username = ?", req.Username).First(&existing).Error; err == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "用户名已存在"})
+		return
+	}
+
+	password := req.Password
+	generated := password == ""
+	if generated {
+		password = generateSecureToken()[:16]
+	} else if err := validatePasswordPolicy(AdminUser{}, password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := AdminUser{
+		Username:    req.Username,
+		Role:        req.Role,
+		Department:  req.Department,
+		Permissions: req.ExtraPermissions,
+		IsActive:    true,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return setAdminPassword(tx, &user, password, true)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建管理员失败"})
+		return
+	}
+
+	recordAudit(c, "admin_user.create", "admin_user", user.ID, nil, user)
+	resp := gin.H{"message": "管理员创建成功", "data": user}
+	if generated {
+		resp["temporary_password"] = password
+	}
+	c.JSON(http.StatusCreated, resp)
+}
+
+// 更新管理员角色、部门、状态
+func updateAdminUser(c *gin.Context) {
+	var user AdminUser
+	if err := db.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "管理员不存在"})
+		return
+	}
+
+	var req UpdateAdminUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+
+	role, department, extra, active := user.Role, user.Department, user.Permissions, user.IsActive
+	if req.Role != nil {
+		role = *req.Role
+	}
+	if req.Department != nil {
+		department = *req.Department
+	}
+	if req.ExtraPermissions != nil {
+		extra = *req.ExtraPermissions
+	}
+	if req.IsActive != nil {
+		active = *req.IsActive
+	}
+	if err := validateAdminRole(role, department, extra); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 不能给自己授权：角色、部门范围和额外权限只能由其他管理员修改
+	if user.ID == c.GetUint("user_id") && (!active || role != user.Role || department != user.Department || extra != user.Permissions) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不能停用自己或修改自己的角色、部门和额外权限"})
+		return
+	}
+	if user.Role == RoleAdmin && user.IsActive && (!active || role != RoleAdmin) && !hasOtherActiveAdmin(user.ID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "至少需要保留一个启用的系统管理员"})
+		return
+	}
+
+	// 角色、权限或状态变化后旧令牌中的权限已过时，强制重新登录
+	changed := role != user.Role || department != user.Department || extra != user.Permissions || active != user.IsActive
+	before := user
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"role":        role,
+			"department":  department,
+			"permissions": extra,
+			"is_active":   active,
+		}).Error; err != nil {
+			return err
+		}
+		if changed {
+			return revokeAllSessions(tx, user.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新管理员失败"})
+		return
+	}
+
+	db.First(&user, user.ID)
+	recordAudit(c, "admin_user.update", "admin_user", user.ID, before, user)
+	c.JSON(http.StatusOK, gin.H{"message": "管理员更新成功", "data": user})
+}
+
+// 停用管理员（保留账号用于审计）
+func deactivateAdminUser(c *gin.Context) {
+	var user AdminUser
+	if err := db.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "管理员不存在"})
+		return
+	}
+	if user.ID == c.GetUint("user_id") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不能停用自己"})
+		return
+	}
+	if user.Role == RoleAdmin && user.IsActive && !hasOtherActiveAdmin(user.ID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "至少需要保留一个启用的系统管理员"})
+		return
+	}
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("is_active", false).Error; err != nil {
+			return err
+		}
+		return revokeAllSessions(tx, user.ID)
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "停用管理员失败"})
+		return
+	}
+	after := user
+	after.IsActive = false
+	recordAudit(c, "admin_user.deactivate", "admin_user", user.ID, user, after)
+	c.JSON(http.StatusOK, gin.H{"message": "管理员已停用"})
+}
+
+// 管理员发起密码重置，生成一次性重置链接
+func createPasswordResetToken(c *gin.Context) {
+	var user AdminUser
+	if err := db.Where("id = ? AND is_active = ?", c.Param("id"), true).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "管理员不存在或已停用"})
+		return
+	}
+
+	token := generateSecureToken()
+	expiresAt := time.Now().Add(appConfig.Auth.PasswordResetTTL)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// 之前未使用的重置令牌全部作废
+		now := time.Now()
+		if err := tx.Model(&AdminPasswordResetToken{}).
+			Where("admin_user_id = ? AND used_at IS NULL", user.ID).
+			Update("used_at", &now).Error; err != nil {
+			return err
+		}
+		return tx.Create(&AdminPasswordResetToken{
+			AdminUserID: user.ID,
+			TokenHash:   hashResetToken(token),
+			CreatedBy:   c.GetUint("user_id"),
+			ExpiresAt:   expiresAt,
+		}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成重置令牌失败"})
+		return
+	}
+	recordAudit(c, "admin_user.password_reset_issue", "admin_user", user.ID, nil, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "密码重置链接生成成功",
+		"url":        fmt.Sprintf("/web/reset-password.html?token=%s", token),
+		"token":      token,
+		"expires_at": expiresAt,
+	})
+}
+
+// 使用一次性令牌重置密码
+func resetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+
+	var resetToken AdminPasswordResetToken
+	if err := db.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hashResetToken(req.Token), time.Now()).
+		First(&resetToken).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "无效或过期的重置令牌"})
+		return
+	}
+
+	var user AdminUser
+	if err := db.Where("id = ? AND is_active = ?", resetToken.AdminUserID, true).First(&user).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "无效或过期的重置令牌"})
+		return
+	}
+	if err := validatePasswordPolicy(user, req.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		// 条件更新防止同一令牌被并发使用两次
+		result := tx.Model(&AdminPasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL", resetToken.ID).
+			Update("used_at", &now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if err := setAdminPassword(tx, &user, req.NewPassword, false); err != nil {
+			return err
+		}
+		return revokeAllSessions(tx, user.ID)
+	})
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "无效或过期的重置令牌"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "重置密码失败"})
+		return
+	}
+
+	recordAudit(c, "admin_user.password_reset", "admin_user", user.ID, nil, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "密码已重置，请使用新密码登录"})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func updateTestAdminUser(t *testing.T, callerID, targetID uint, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPut, "/api/v1/admin-users/1", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = gin.Params{{Key: "id", Value: strconv.FormatUint(uint64(targetID), 10)}}
+	c.Set("user_id", callerID)
+	c.Set("username", "hr")
+	updateAdminUser(c)
+	return w
+}
+
+// 管理员不能给自己加权限或改变自己的部门范围，其他管理员可以
+func TestUpdateAdminUserSelf(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name   string
+		self   bool
+		body   string
+		status int
+	}{
+		{"self extra permissions", true, `{"extra_permissions": "admin_users:write,payrolls:publish"}`, http.StatusBadRequest},
+		{"self department", true, `{"department": "财务部"}`, http.StatusBadRequest},
+		{"self role", true, `{"role": "admin"}`, http.StatusBadRequest},
+		{"self deactivate", true, `{"is_active": false}`, http.StatusBadRequest},
+		{"self unchanged", true, `{"role": "hr", "department": "人事部", "extra_permissions": "admin_users:write"}`, http.StatusOK},
+		{"other extra permissions", false, `{"extra_permissions": "admin_users:write,payrolls:publish"}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			caller := AdminUser{Username: "hr", Role: RoleHR, Department: "人事部", Permissions: PermAdminUsersWrite, IsActive: true}
+			other := AdminUser{Username: "hr2", Role: RoleHR, Department: "人事部", Permissions: PermAdminUsersWrite, IsActive: true}
+			if err := db.Create(&caller).Error; err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Create(&other).Error; err != nil {
+				t.Fatal(err)
+			}
+			target := other
+			if tt.self {
+				target = caller
+			}
+			w := updateTestAdminUser(t, caller.ID, target.ID, tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d, body = %s", w.Code, tt.status, w.Body)
+			}
+			var stored AdminUser
+			db.First(&stored, target.ID)
+			if tt.status != http.StatusOK && (stored.Permissions != target.Permissions || stored.Department != target.Department) {
+				t.Errorf("rejected update was saved: %+v", stored)
+			}
+		})
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 审计日志：记录管理员和员工的每一次数据变更，只允许追加
+type AuditLog struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	ActorType  string    `json:"actor_type" gorm:"index;size:20"` // admin, employee, public
+	ActorID    uint      `json:"actor_id" gorm:"index"`
+	ActorName  string    `json:"actor_name" gorm:"size:64"`
+	Action     string    `json:"action" gorm:"index;size:64"` // 如 payroll.update
+	EntityType string    `json:"entity_type" gorm:"index;size:40"`
+	EntityID   string    `json:"entity_id" gorm:"index;size:64"`
+	Changes    string    `json:"changes" gorm:"type:text"` // JSON：{"字段": {"before": ..., "after": ...}}
+	IPAddress  string    `json:"ip_address" gorm:"size:64"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at" gorm:"index"`
+}
+
+// 字段变化
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+var errAuditLogImmutable = errors.New("audit logs are append-only")
+
+// 审计日志不允许修改
+func (a *AuditLog) BeforeUpdate(tx *gorm.DB) error {
+	return errAuditLogImmutable
+}
+
+// 审计日志不允许删除
+func (a *AuditLog) BeforeDelete(tx *gorm.DB) error {
+	return errAuditLogImmutable
+}
+
+// 不记录到变更里的字段
+var auditIgnoredFields = map[string]bool{
+	"created_at":     true,
+	"updated_at":     true,
+	"token":          true, // 签名令牌等凭据不写入审计日志
+	"signature_data": true, // 签名图片只记录哈希
+}
+
+// 把实体转换为扁平的字段表：关联对象不展开；嵌套对象和JSON字符串字段（如 payroll_data）
+// 逐层展开为 "字段.子字段"，数组整体记录为规范化的JSON
+func auditSnapshot(v interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return fields
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fields
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fields
+	}
+
+	associations := auditAssociationKeys(v)
+	for key, value := range raw {
+		if auditIgnoredFields[key] || associations[key] {
+			continue
+		}
+		flattenAuditValue(fields, key, value)
+	}
+	return fields
+}
+
+func flattenAuditValue(fields map[string]interface{}, key string, value interface{}) {
+	switch val := value.(type) {
+	case map[string]interface{}:
+		for k, nv := range val {
+			flattenAuditValue(fields, key+"."+k, nv)
+		}
+		return
+	case []interface{}:
+		// json.Marshal 按键排序输出对象，相同内容得到相同的字符串
+		if data, err := json.Marshal(val); err == nil {
+			fields[key] = string(data)
+		}
+		return
+	case string:
+		var nested map[string]interface{}
+		if strings.HasPrefix(strings.TrimSpace(val), "{") && json.Unmarshal([]byte(val), &nested) == nil {
+			for k, nv := range nested {
+				flattenAuditValue(fields, key+"."+k, nv)
+			}
+			return
+		}
+	}
+	fields[key] = value
+}
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// 实体上的关联对象字段（如工资条的 employee、template），是否预加载不应产生变更记录
+func auditAssociationKeys(v interface{}) map[string]bool {
+	keys := map[string]bool{}
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return keys
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous || !field.IsExported() {
+			continue
+		}
+		ft := field.Type
+		if ft.Kind() == reflect.Slice {
+			ft = ft.Elem()
+		}
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() != reflect.Struct || ft == reflect.TypeOf(time.Time{}) ||
+			ft.Implements(jsonMarshalerType) || reflect.PtrTo(ft).Implements(jsonMarshalerType) {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = field.Name
+		}
+		keys[name] = true
+	}
+	return keys
+}
+
+// 比较变更前后的字段，新建时 before 为 nil，删除时 after 为 nil
+func auditDiff(before, after interface{}) map[string]AuditChange {
+	b, a := auditSnapshot(before), auditSnapshot(after)
+	changes := map[string]AuditChange{}
+	for key, bv := range b {
+		if av := a[key]; !reflect.DeepEqual(bv, av) {
+			changes[key] = AuditChange{Before: bv, After: av}
+		}
+	}
+	for key, av := range a {
+		if _, ok := b[key]; !ok && av != nil {
+			changes[key] = AuditChange{After: av}
+		}
+	}
+	return changes
+}
+
+// 记录一次数据变更；写入失败只记录日志，不影响业务请求
+func recordAudit(c *gin.Context, action, entityType string, entityID interface{}, before, after interface{}) {
+	entry := AuditLog{
+		ActorType:  "public",
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   fmt.Sprint(entityID),
+		IPAddress:  c.ClientIP(),
+		UserAgent:  c.GetHeader("User-Agent"),
+	}
+	if userID, ok := c.Get("user_id"); ok {
+		entry.ActorType = "admin"
+		entry.ActorID = userID.(uint)
+		entry.ActorName = c.GetString("username")
+	} else if employeeID, ok := currentEmployeeID(c); ok {
+		entry.ActorType = "employee"
+		entry.ActorID = employeeID
+		var employee Employee
+		if db.Select("id", "name", "employee_no").First(&employee, employeeID).Error == nil {
+			entry.ActorName = employee.EmployeeNo
+		}
+	}
+
+	if changes := auditDiff(before, after); len(changes) > 0 {
+		data, _ := json.Marshal(changes)
+		entry.Changes = string(data)
+	}
+
+	if err := db.Create(&entry).Error; err != nil {
+		log.Printf("Failed to write audit log %s %s/%v: %v", action, entityType, entityID, err)
+	}
+}
+
+// 根据查询参数筛选审计日志
+func auditLogQuery(c *gin.Context) (*gorm.DB, error) {
+	query := db.Model(&AuditLog{})
+	for param, column := range map[string]string{
+		"actor_type":  "actor_type",
+		"actor_name":  "actor_name",
+		"entity_type": "entity_type",
+		"entity_id":   "entity_id",
+	} {
+		if v := c.Query(param); v != "" {
+			query = query.Where(column+" = ?", v)
+		}
+	}
+	if v := c.Query("actor_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("actor_id 格式错误")
+		}
+		query = query.Where("actor_id = ?", id)
+	}
+	// action 以 . 结尾时按前缀匹配，如 payroll. 匹配所有工资条操作
+	if v := c.Query("action"); strings.HasSuffix(v, ".") {
+		query = query.Where("action LIKE ?", v+"%")
+	} else if v != "" {
+		query = query.Where("action = ?", v)
+	}
+	if v := c.Query("from"); v != "" {
+		from, err := parseAuditTime(v, false)
+		if err != nil {
+			return nil, fmt.Errorf("from 格式错误，应为 YYYY-MM-DD 或 RFC3339")
+		}
+		query = query.Where("created_at >= ?", from)
+	}
+	if v := c.Query("to"); v != "" {
+		to, err := parseAuditTime(v, true)
+		if err != nil {
+			return nil, fmt.Errorf("to 格式错误，应为 YYYY-MM-DD 或 RFC3339")
+		}
+		query = query.Where("created_at < ?", to)
+	}
+	return query, nil
+}
+
+// 日期按整天处理，结束日期包含当天
+func parseAuditTime(v string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// 查询审计日志
+func getAuditLogs(c *gin.Context) {
+	query, err := auditLogQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 500 {
+		pageSize = 50
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取审计日志失败"})
+		return
+	}
+
+	var logs []AuditLog
+	if err := query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&logs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取审计日志失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":      logs,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// 导出审计日志CSV，筛选条件与查询接口相同，每个变更字段单独一行便于核查
+func exportAuditLogs(c *gin.Context) {
+	query, err := auditLogQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filename := fmt.Sprintf("audit-logs-%s.csv", time.Now().Format("20060102-150405"))
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	// 带BOM，Excel打开中文不乱码
+	c.Writer.WriteString("\xEF\xBB\xBF")
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"时间", "操作人类型", "操作人ID", "操作人", "操作", "对象类型", "对象ID", "字段", "修改前", "修改后", "IP地址", "User-Agent"})
+
+	var batch []AuditLog
+	query.Order("id").FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+		for _, entry := range batch {
+			base := []string{
+				entry.CreatedAt.Format("2006-01-02 15:04:05"),
+				entry.ActorType,
+				strconv.FormatUint(uint64(entry.ActorID), 10),
+				csvSafe(entry.ActorName),
+				entry.Action,
+				entry.EntityType,
+				csvSafe(entry.EntityID),
+			}
+			tail := []string{entry.IPAddress, csvSafe(entry.UserAgent)}
+
+			var changes map[string]AuditChange
+			json.Unmarshal([]byte(entry.Changes), &changes)
+			if len(changes) == 0 {
+				w.Write(append(append(base, "", "", ""), tail...))
+				continue
+			}
+			keys := make([]string, 0, len(changes))
+			for k := range changes {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				row := append(append([]string{}, base...), k, auditValue(changes[k].Before), auditValue(changes[k].After))
+				w.Write(append(row, tail...))
+			}
+		}
+		w.Flush()
+		return w.Error()
+	})
+	w.Flush()
+}
+
+func auditValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return csvSafe(val)
+	default:
+		data, _ := json.Marshal(val)
+		return string(data)
+	}
+}
+
+// 防止以 = + - @ 开头的文本在电子表格中被当作公式执行
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
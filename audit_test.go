@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAuditSnapshotFlattensNestedValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		input interface{}
+		want  map[string]interface{}
+	}{
+		{
+			name:  "nil",
+			input: nil,
+			want:  map[string]interface{}{},
+		},
+		{
+			name: "nested maps",
+			input: map[string]interface{}{
+				"rates": map[string]interface{}{
+					"pension": map[string]interface{}{"employee_rate": 0.08, "employer_rate": 0.16},
+				},
+			},
+			want: map[string]interface{}{
+				"rates.pension.employee_rate": 0.08,
+				"rates.pension.employer_rate": 0.16,
+			},
+		},
+		{
+			name: "JSON string fields",
+			input: map[string]interface{}{
+				"payroll_data": `{"basic_salary": 8000, "bonus": {"q1": 500}}`,
+				"comment":      "{not json",
+			},
+			want: map[string]interface{}{
+				"payroll_data.basic_salary": 8000.0,
+				"payroll_data.bonus.q1":     500.0,
+				"comment":                   "{not json",
+			},
+		},
+		{
+			name: "arrays as canonical JSON",
+			input: map[string]interface{}{
+				"lines": []interface{}{map[string]interface{}{"name": "基本工资", "amount": 8000}},
+				"tags":  []string{"a", "b"},
+			},
+			want: map[string]interface{}{
+				"lines": `[{"amount":8000,"name":"基本工资"}]`,
+				"tags":  `["a","b"]`,
+			},
+		},
+		{
+			name:  "ignored fields",
+			input: map[string]interface{}{"token": "secret", "updated_at": "2024-01-01", "name": "张三"},
+			want:  map[string]interface{}{"name": "张三"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := auditSnapshot(tt.input); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("auditSnapshot() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuditDiffIgnoresAssociations(t *testing.T) {
+	before := Payroll{ID: 1, EmployeeID: 2, Period: "2024-05"}
+	after := before
+	after.Employee = Employee{ID: 2, Name: "张三"}
+	if changes := auditDiff(before, after); len(changes) != 0 {
+		t.Errorf("preloading an association produced changes: %#v", changes)
+	}
+
+	after.Period = "2024-06"
+	changes := auditDiff(before, after)
+	if len(changes) != 1 || changes["period"].Before != "2024-05" || changes["period"].After != "2024-06" {
+		t.Errorf("auditDiff() = %#v, want only period changed", changes)
+	}
+}
+
+func TestAuditDiffArrayChange(t *testing.T) {
+	before := map[string]interface{}{"permissions": []string{"payrolls:read"}}
+	after := map[string]interface{}{"permissions": []string{"payrolls:read", "payrolls:write"}}
+	changes := auditDiff(before, after)
+	want := AuditChange{Before: `["payrolls:read"]`, After: `["payrolls:read","payrolls:write"]`}
+	if changes["permissions"] != want {
+		t.Errorf("auditDiff()[permissions] = %#v, want %#v", changes["permissions"], want)
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 薪酬档案：按生效月份保存员工的基本工资、固定津贴、工资模板、发薪组和工资卡，保留全部历史。
+// 工资期间适用生效月份不晚于该期间的最近一条档案；创建工资条时未指定的模板和未填写的金额从档案带入。
+type CompensationProfile struct {
+	ID            uint             `json:"id" gorm:"primaryKey"`
+	EmployeeID    uint             `json:"employee_id" gorm:"uniqueIndex:idx_compensation_employee_from"`
+	EffectiveFrom string           `json:"effective_from" gorm:"uniqueIndex:idx_compensation_employee_from;size:7"` // 生效月份 2024-08
+	BaseSalary    Money            `json:"base_salary" gorm:"type:decimal(18,2)"`                                   // 基本工资（全月），带入 payroll.base_salary_field 字段
+	Allowances    map[string]Money `json:"allowances" gorm:"type:text;serializer:json"`                             // 固定津贴：模板字段名 → 每月金额
+	TemplateID    uint             `json:"template_id"`                                                             // 工资模板，0 表示不指定
+	PayGroup      string           `json:"pay_group" gorm:"size:32;index"`                                          // 发薪组，如 "月薪"、"外包"
+	BankName      string           `json:"bank_name" gorm:"size:64"`                                                // 工资卡开户行
+	BankAccount   string           `json:"bank_account" gorm:"size:34"`                                             // 工资卡账号
+	Comment       string           `json:"comment"`                                                                 // 调薪原因等说明
+	CreatedBy     uint             `json:"created_by"`
+	CreatedAt     time.Time        `json:"created_at"`
+	UpdatedAt     time.Time        `json:"updated_at"`
+}
+
+// 创建和更新薪酬档案请求
+type CompensationProfileRequest struct {
+	EffectiveFrom string           `json:"effective_from" binding:"required"`
+	BaseSalary    Money            `json:"base_salary"`
+	Allowances    map[string]Money `json:"allowances"`
+	TemplateID    uint             `json:"template_id"`
+	PayGroup      string           `json:"pay_group"`
+	BankName      string           `json:"bank_name"`
+	BankAccount   string           `json:"bank_account"`
+	Comment       string           `json:"comment"`
+}
+
+// 校验薪酬档案；指定了模板时，基本工资和固定津贴必须对应模板中可录入的数字字段
+func (req *CompensationProfileRequest) validate() FieldErrors {
+	errs := FieldErrors{}
+	if _, err := time.Parse("2006-01", req.EffectiveFrom); err != nil {
+		errs["effective_from"] = "生效月份格式应为 YYYY-MM"
+	}
+	if req.BaseSalary.IsNegative() {
+		errs["base_salary"] = "基本工资不能为负数"
+	}
+	req.PayGroup = strings.TrimSpace(req.PayGroup)
+	if utf8.RuneCountInString(req.PayGroup) > 32 {
+		errs["pay_group"] = "发薪组不超过32个字符"
+	}
+	req.BankName = strings.TrimSpace(req.BankName)
+	if utf8.RuneCountInString(req.BankName) > 64 {
+		errs["bank_name"] = "开户行不超过64个字符"
+	}
+	req.BankAccount = strings.ReplaceAll(req.BankAccount, " ", "")
+	if len(req.BankAccount) > 34 || strings.Trim(req.BankAccount, "0123456789") != "" {
+		errs["bank_account"] = "工资卡账号只能包含数字，不超过34位"
+	}
+
+	var schema *TemplateSchema
+	if req.TemplateID != 0 {
+		if _, s, err := loadTemplateSchema(req.TemplateID); err != nil {
+			errs["template_id"] = "工资模板不存在或字段定义无效"
+		} else {
+			schema = &s
+		}
+	}
+	baseField := appConfig.Payroll.BaseSalaryField
+	if schema != nil && req.BaseSalary.IsPositive() && !schema.inputNumberField(baseField) {
+		errs["base_salary"] = fmt.Sprintf("模板中没有可录入的基本工资字段 %s", baseField)
+	}
+	for key, amount := range req.Allowances {
+		name := "allowances." + key
+		switch {
+		case key == baseField:
+			errs[name] = "基本工资请填写在 base_salary"
+		case amount.IsNegative():
+			errs[name] = "固定津贴不能为负数"
+		case schema != nil && !schema.inputNumberField(key):
+			errs[name] = "模板中没有可录入的该数字字段"
+		case schema == nil && !templateFieldKeyPattern.MatchString(key):
+			errs[name] = "字段名只能包含字母、数字和下划线，且不能以数字开头"
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// 可以录入金额的数字字段：不是文本、公式或系统计算字段
+func (s TemplateSchema) inputNumberField(key string) bool {
+	field, ok := s.Fields[key]
+	return ok && field.Type == "number" && field.Formula == "" && field.Calculator == ""
+}
+
+// 查找员工在工资期间适用的薪酬档案，没有时返回 nil
+func findCompensationProfile(employeeID uint, period string) (*CompensationProfile, error) {
+	var profile CompensationProfile
+	err := db.Where("employee_id = ? AND effective_from <= ?", employeeID, period).Order("effective_from DESC").First(&profile).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// 档案带入工资条的金额：基本工资写入 payroll.base_salary_field，固定津贴按字段名写入，
+// 只带入模板中可录入的数字字段，金额为0的不带入
+func (p CompensationProfile) payrollDefaults(schema TemplateSchema) map[string]interface{} {
+	defaults := map[string]interface{}{}
+	if baseField := appConfig.Payroll.BaseSalaryField; p.BaseSalary.IsPositive() && schema.inputNumberField(baseField) {
+		defaults[baseField] = p.BaseSalary
+	}
+	for key, amount := range p.Allowances {
+		if amount.IsPositive() && schema.inputNumberField(key) {
+			defaults[key] = amount
+		}
+	}
+	return defaults
+}
+
+// 查找工资期间适用的薪酬档案，请求未指定模板时使用档案的模板
+func applyCompensationProfile(req *CreatePayrollRequest) (*CompensationProfile, error) {
+	profile, err := findCompensationProfile(req.EmployeeID, req.Period)
+	if err != nil {
+		return nil, err
+	}
+	if req.TemplateID == 0 {
+		if profile == nil || profile.TemplateID == 0 {
+			return nil, &PayrollDataError{Message: "工资数据校验失败", Fields: FieldErrors{"template_id": "未指定模板，员工也没有适用的薪酬档案模板"}}
+		}
+		req.TemplateID = profile.TemplateID
+	}
+	return profile, nil
+}
+
+// 薪酬档案所属的员工，不存在或已删除时返回 404
+func compensationEmployee(c *gin.Context) (Employee, bool) {
+	var employee Employee
+	if err := db.Where("deleted_at IS NULL").First(&employee, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
+		return employee, false
+	}
+	return employee, true
+}
+
+// 员工的薪酬档案历史，按生效月份从新到旧排列
+func getCompensationProfiles(c *gin.Context) {
+	employee, ok := compensationEmployee(c)
+	if !ok {
+		return
+	}
+	var profiles []CompensationProfile
+	if err := db.Where("employee_id = ?", employee.ID).Order("effective_from DESC").Find(&profiles).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取薪酬档案失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": profiles})
+}
+
+// 工资期间适用的薪酬档案，payroll_data 为按档案模板带入工资条的金额，可用于预填工资条
+func getCurrentCompensationProfile(c *gin.Context) {
+	employee, ok := compensationEmployee(c)
+	if !ok {
+		return
+	}
+	period := c.Query("period")
+	if period == "" {
+		period = time.Now().Format("2006-01")
+	}
+	if _, err := time.Parse("2006-01", period); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "工资期间格式应为 YYYY-MM"})
+		return
+	}
+	profile, err := findCompensationProfile(employee.ID, period)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取薪酬档案失败"})
+		return
+	}
+	if profile == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "该期间没有适用的薪酬档案"})
+		return
+	}
+	defaults := map[string]interface{}{}
+	if profile.TemplateID != 0 {
+		if _, schema, err := loadTemplateSchema(profile.TemplateID); err == nil {
+			defaults = profile.payrollDefaults(schema)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"data": profile, "payroll_data": defaults})
+}
+
+// 绑定并校验薪酬档案请求，同一员工同一生效月份只能有一条
+func bindCompensationProfile(c *gin.Context, employeeID, id uint) (CompensationProfileRequest, bool) {
+	var req CompensationProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return req, false
+	}
+	if errs := req.validate(); errs != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "薪酬档案校验失败", "field_errors": errs})
+		return req, false
+	}
+	var count int64
+	db.Model(&CompensationProfile{}).Where("employee_id = ? AND effective_from = ? AND id <> ?", employeeID, req.EffectiveFrom, id).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "该员工同一生效月份的薪酬档案已存在"})
+		return req, false
+	}
+	if req.Allowances == nil {
+		req.Allowances = map[string]Money{}
+	}
+	return req, true
+}
+
+// 新建薪酬档案，调薪、调岗时新建一条生效月份为变动当月的档案，之前的档案保留
+func createCompensationProfile(c *gin.Context) {
+	employee, ok := compensationEmployee(c)
+	if !ok {
+		return
+	}
+	req, ok := bindCompensationProfile(c, employee.ID, 0)
+	if !ok {
+		return
+	}
+	profile := CompensationProfile{
+		EmployeeID:    employee.ID,
+		EffectiveFrom: req.EffectiveFrom,
+		BaseSalary:    req.BaseSalary,
+		Allowances:    req.Allowances,
+		TemplateID:    req.TemplateID,
+		PayGroup:      req.PayGroup,
+		BankName:      req.BankName,
+		BankAccount:   req.BankAccount,
+		Comment:       req.Comment,
+		CreatedBy:     c.GetUint("user_id"),
+	}
+	if err := db.Create(&profile).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存薪酬档案失败"})
+		return
+	}
+	recordAudit(c, "compensation_profile.create", "compensation_profile", profile.ID, nil, profile)
+
+	c.JSON(http.StatusCreated, gin.H{"data": profile})
+}
+
+// 更正录错的薪酬档案；已被工资条引用的档案不能修改，调薪需新建生效月份更晚的档案。修改前后的内容记入审计日志
+func updateCompensationProfile(c *gin.Context) {
+	employee, ok := compensationEmployee(c)
+	if !ok {
+		return
+	}
+	var profile CompensationProfile
+	if err := db.Where("employee_id = ?", employee.ID).First(&profile, c.Param("profile_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "薪酬档案不存在"})
+		return
+	}
+	if profileInUse(profile.ID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "已有工资条使用该薪酬档案，不能修改，请新建档案调整"})
+		return
+	}
+	req, ok := bindCompensationProfile(c, employee.ID, profile.ID)
+	if !ok {
+		return
+	}
+	before := profile
+	profile.EffectiveFrom = req.EffectiveFrom
+	profile.BaseSalary = req.BaseSalary
+	profile.Allowances = req.Allowances
+	profile.TemplateID = req.TemplateID
+	profile.PayGroup = req.PayGroup
+	profile.BankName = req.BankName
+	profile.BankAccount = req.BankAccount
+	profile.Comment = req.Comment
+	if err := db.Save(&profile).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存薪酬档案失败"})
+		return
+	}
+	recordAudit(c, "compensation_profile.update", "compensation_profile", profile.ID, before, profile)
+
+	c.JSON(http.StatusOK, gin.H{"data": profile})
+}
+
+// 是否已有工资条引用该档案，被引用的档案只读，保证工资条能追溯带入时的档案内容
+func profileInUse(id uint) bool {
+	var used int64
+	db.Model(&Payroll{}).Where("compensation_profile_id = ?", id).Count(&used)
+	return used > 0
+}
+
+// 删除录错的薪酬档案，已被工资条引用的档案不能删除
+func deleteCompensationProfile(c *gin.Context) {
+	employee, ok := compensationEmployee(c)
+	if !ok {
+		return
+	}
+	var profile CompensationProfile
+	if err := db.Where("employee_id = ?", employee.ID).First(&profile, c.Param("profile_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "薪酬档案不存在"})
+		return
+	}
+	if profileInUse(profile.ID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "已有工资条使用该薪酬档案，不能删除，请新建档案调整"})
+		return
+	}
+	if err := db.Delete(&profile).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除薪酬档案失败"})
+		return
+	}
+	recordAudit(c, "compensation_profile.delete", "compensation_profile", profile.ID, profile, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "薪酬档案已删除"})
+}
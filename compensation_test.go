@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func createTestCompensationProfile(t *testing.T, employeeID uint, effectiveFrom, baseSalary string, templateID uint) CompensationProfile {
+	t.Helper()
+	profile := CompensationProfile{EmployeeID: employeeID, EffectiveFrom: effectiveFrom, BaseSalary: money(baseSalary), TemplateID: templateID}
+	if err := db.Create(&profile).Error; err != nil {
+		t.Fatal(err)
+	}
+	return profile
+}
+
+// 工资期间适用生效月份不晚于该期间的最近一条档案
+func TestFindCompensationProfile(t *testing.T) {
+	setupTestDB(t)
+	employee := createTaxTestEmployee(t, "2020-01-01")
+	other := Employee{Name: "李四", EmployeeNo: "T002", Status: "active"}
+	if err := db.Create(&other).Error; err != nil {
+		t.Fatal(err)
+	}
+	createTestCompensationProfile(t, employee.ID, "2024-01", "8000", 0)
+	createTestCompensationProfile(t, employee.ID, "2024-07", "9000", 0)
+	createTestCompensationProfile(t, employee.ID, "2025-01", "10000", 0)
+	createTestCompensationProfile(t, other.ID, "2023-01", "20000", 0)
+
+	tests := []struct {
+		period string
+		want   string // 适用档案的基本工资，为空表示没有适用档案
+	}{
+		{"2023-12", ""},
+		{"2024-01", "8000"},
+		{"2024-06", "8000"},
+		{"2024-07", "9000"},
+		{"2024-12", "9000"},
+		{"2025-01", "10000"},
+		{"2026-03", "10000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.period, func(t *testing.T) {
+			profile, err := findCompensationProfile(employee.ID, tt.period)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if profile != nil {
+				got = profile.BaseSalary.String()
+			}
+			if got != tt.want {
+				t.Errorf("profile for %s: base salary = %q, want %q", tt.period, got, tt.want)
+			}
+		})
+	}
+}
+
+// 工资条从适用档案带入模板和基本工资；被工资条引用的档案不能修改或删除
+func TestCompensationProfileInUse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupTestDB(t)
+	employee := createTaxTestEmployee(t, "2020-01-01")
+	template := PayrollTemplate{Name: "标准", Fields: `{"basic_salary": {"name": "基本工资", "kind": "earning", "taxable": true}}`, IsActive: true}
+	if err := db.Create(&template).Error; err != nil {
+		t.Fatal(err)
+	}
+	used := createTestCompensationProfile(t, employee.ID, "2024-01", "8000", template.ID)
+	unused := createTestCompensationProfile(t, employee.ID, "2025-01", "9000", template.ID)
+
+	req := CreatePayrollRequest{EmployeeID: employee.ID, Period: "2024-09"}
+	calc, err := computePayroll(&req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.TemplateID != template.ID || calc.TotalGross.String() != "8000" {
+		t.Fatalf("template = %d, total gross = %s, want %d, 8000", req.TemplateID, calc.TotalGross, template.ID)
+	}
+	profileID, _ := calc.compensationRef()
+	payroll := Payroll{UUID: generateUUID(), EmployeeID: employee.ID, Period: req.Period, TemplateID: req.TemplateID,
+		PayrollData: "{}", Status: "draft", CompensationProfileID: profileID}
+	if err := db.Create(&payroll).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(handler gin.HandlerFunc, method string, profile CompensationProfile, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(method, "/api/v1/admin/employees/compensation", strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Params = gin.Params{{Key: "id", Value: strconv.Itoa(int(employee.ID))}, {Key: "profile_id", Value: strconv.Itoa(int(profile.ID))}}
+		c.Set("user_id", uint(1))
+		c.Set("username", "admin")
+		handler(c)
+		return w
+	}
+	body := func(effectiveFrom string) string {
+		return fmt.Sprintf(`{"effective_from": %q, "base_salary": "8500", "template_id": %d}`, effectiveFrom, template.ID)
+	}
+	tests := []struct {
+		name    string
+		handler gin.HandlerFunc
+		method  string
+		profile CompensationProfile
+		body    string
+		status  int
+	}{
+		{"update used", updateCompensationProfile, http.MethodPut, used, body("2024-01"), http.StatusBadRequest},
+		{"delete used", deleteCompensationProfile, http.MethodDelete, used, "", http.StatusBadRequest},
+		{"update to existing month", updateCompensationProfile, http.MethodPut, unused, body("2024-01"), http.StatusBadRequest},
+		{"update unused", updateCompensationProfile, http.MethodPut, unused, body("2025-02"), http.StatusOK},
+		{"delete unused", deleteCompensationProfile, http.MethodDelete, unused, "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := send(tt.handler, tt.method, tt.profile, tt.body); w.Code != tt.status {
+				t.Errorf("status = %d, want %d, body = %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+
+	var stored CompensationProfile
+	db.First(&stored, used.ID)
+	if stored.EffectiveFrom != "2024-01" || stored.BaseSalary.String() != "8000" {
+		t.Errorf("used profile changed: %s %s", stored.EffectiveFrom, stored.BaseSalary)
+	}
+}
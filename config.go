@@ -0,0 +1,370 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultJWTSecret     = "payroll-jwt-secret-key-2025"
+	defaultAdminPassword = "admin123"
+)
+
+// 系统配置
+type Config struct {
+	Profile  string         `yaml:"profile"` // dev, test, production
+	Server   ServerConfig   `yaml:"server"`
+	Database DatabaseConfig `yaml:"database"`
+	Auth     AuthConfig     `yaml:"auth"`
+	Notify   NotifyConfig   `yaml:"notify"`
+	Seal     SealConfig     `yaml:"seal"`
+	PDF      PDFConfig      `yaml:"pdf"`
+	Payroll  PayrollConfig  `yaml:"payroll"`
+}
+
+// 服务配置
+type ServerConfig struct {
+	Port           int      `yaml:"port"`
+	PublicURL      string   `yaml:"public_url"`      // 对外访问地址，用于邮件和短信中的链接
+	UploadsDir     string   `yaml:"uploads_dir"`     // 签名图片等上传文件目录
+	WebDir         string   `yaml:"web_dir"`         // 前端静态文件目录
+	SecurityLog    string   `yaml:"security_log"`    // 安全日志文件，留空时输出到标准错误
+	TrustedProxies []string `yaml:"trusted_proxies"` // 可信反向代理，只有来自这些地址的 X-Forwarded-For 才会被采用
+}
+
+// 认证配置
+type AuthConfig struct {
+	JWTSecret          string         `yaml:"jwt_secret"`
+	TokenTTL           time.Duration  `yaml:"token_ttl"`          // 访问令牌有效期，过期后用刷新令牌换取
+	RefreshTokenTTL    time.Duration  `yaml:"refresh_token_ttl"`  // 刷新令牌有效期
+	RememberTokenTTL   time.Duration  `yaml:"remember_token_ttl"` // 记住登录时的刷新令牌有效期
+	SignTokenTTL       time.Duration  `yaml:"sign_token_ttl"`     // 离职签名链接有效期
+	PasswordResetTTL   time.Duration  `yaml:"password_reset_ttl"` // 管理员密码重置链接有效期
+	AdminPassword      string         `yaml:"admin_password"`     // 初始管理员密码，留空时自动生成
+	BcryptCost         int            `yaml:"bcrypt_cost"`        // 密码哈希强度
+	PasswordPolicy     PasswordPolicy `yaml:"password_policy"`
+	Lockout            LockoutPolicy  `yaml:"lockout"`
+	TOTP               TOTPConfig     `yaml:"totp"`
+	EmployeeTokenTTL   time.Duration  `yaml:"employee_token_ttl"`    // 员工门户登录有效期
+	EmployeeCodeTTL    time.Duration  `yaml:"employee_code_ttl"`     // 员工登录验证码和登录链接有效期
+	PayslipLinkTTL     time.Duration  `yaml:"payslip_link_ttl"`      // 通知中工资条访问链接的有效期
+	PayslipLinkMaxUses int            `yaml:"payslip_link_max_uses"` // 工资条访问链接最多使用次数
+}
+
+var appConfig *Config
+
+// 默认配置
+func defaultConfig() *Config {
+	return &Config{
+		Profile: "production",
+		Server: ServerConfig{
+			Port:       40010,
+			PublicURL:  "http://localhost:40010",
+			UploadsDir: "./uploads",
+			WebDir:     "./web",
+		},
+		Database: DatabaseConfig{
+			Type:            "sqlite",
+			MaxOpenConns:    25,
+			MaxIdleConns:    10,
+			ConnMaxLifetime: time.Hour,
+			ConnMaxIdleTime: 10 * time.Minute,
+			AutoMigrate:     true,
+		},
+		Notify: NotifyConfig{
+			SMTP: SMTPConfig{Port: 587},
+		},
+		Auth: AuthConfig{
+			JWTSecret:        defaultJWTSecret,
+			TokenTTL:         15 * time.Minute,
+			RefreshTokenTTL:  24 * time.Hour,
+			RememberTokenTTL: 7 * 24 * time.Hour,
+			SignTokenTTL:     7 * 24 * time.Hour,
+			PasswordResetTTL: 24 * time.Hour,
+			BcryptCost:       12,
+			PasswordPolicy: PasswordPolicy{
+				MinLength:  10,
+				MinClasses: 3,
+				History:    5,
+			},
+			Lockout: LockoutPolicy{
+				MaxAttempts:   5,
+				IPMaxAttempts: 20,
+				Window:        15 * time.Minute,
+				Duration:      15 * time.Minute,
+				BaseDelay:     500 * time.Millisecond,
+				MaxDelay:      8 * time.Second,
+			},
+			TOTP: TOTPConfig{
+				Issuer:       "Payroll System",
+				ChallengeTTL: 5 * time.Minute,
+			},
+			EmployeeTokenTTL:   2 * time.Hour,
+			EmployeeCodeTTL:    10 * time.Minute,
+			PayslipLinkTTL:     30 * 24 * time.Hour,
+			PayslipLinkMaxUses: 10,
+		},
+		Payroll: PayrollConfig{
+			StrictTemplates: true,
+			BaseSalaryField: "basic_salary",
+			Rounding: PayrollRounding{
+				Line:  RoundingPolicy{Unit: "fen", Mode: "half_up"},
+				Total: RoundingPolicy{Unit: "fen", Mode: "half_up"},
+			},
+			IncomeTax: defaultIncomeTaxConfig(),
+		},
+	}
+}
+
+// 加载配置：默认值 < 配置文件 < 环境变量
+func loadConfig(path string) (*Config, error) {
+	cfg := defaultConfig()
+
+	if path == "" {
+		path = os.Getenv("PAYROLL_CONFIG")
+	}
+	if path == "" {
+		if _, err := os.Stat("config.yaml"); err == nil {
+			path = "config.yaml"
+		}
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read config %s: %w", path, err)
+		}
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("parse config %s: %w", path, err)
+		}
+	}
+
+	if err := applyEnvOverrides(cfg); err != nil {
+		return nil, err
+	}
+
+	cfg.Database.Type = strings.ToLower(cfg.Database.Type)
+	cfg.Server.PublicURL = strings.TrimRight(cfg.Server.PublicURL, "/")
+	if cfg.Database.URL == "" && (cfg.Database.Type == "sqlite" || cfg.Database.Type == "sqlite3") {
+		cfg.Database.URL = "payroll.db"
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func applyEnvOverrides(cfg *Config) error {
+	envString("APP_PROFILE", &cfg.Profile)
+	envString("UPLOADS_DIR", &cfg.Server.UploadsDir)
+	envString("WEB_DIR", &cfg.Server.WebDir)
+	envString("SECURITY_LOG", &cfg.Server.SecurityLog)
+	envString("PUBLIC_URL", &cfg.Server.PublicURL)
+	if v := os.Getenv("TRUSTED_PROXIES"); v != "" {
+		cfg.Server.TrustedProxies = strings.Split(v, ",")
+	}
+	envString("DATABASE_TYPE", &cfg.Database.Type)
+	envString("DATABASE_URL", &cfg.Database.URL)
+	envString("JWT_SECRET", &cfg.Auth.JWTSecret)
+	envString("ADMIN_PASSWORD", &cfg.Auth.AdminPassword)
+	envString("TOTP_ISSUER", &cfg.Auth.TOTP.Issuer)
+	envString("SMTP_HOST", &cfg.Notify.SMTP.Host)
+	envString("SMTP_USERNAME", &cfg.Notify.SMTP.Username)
+	envString("SMTP_PASSWORD", &cfg.Notify.SMTP.Password)
+	envString("SMTP_FROM", &cfg.Notify.SMTP.From)
+	envString("SMS_WEBHOOK_URL", &cfg.Notify.SMSWebhook)
+	envString("SEAL_KEY_FILE", &cfg.Seal.KeyFile)
+	envString("SEAL_KEY_ID", &cfg.Seal.KeyID)
+	envString("PDF_FONT_FILE", &cfg.PDF.FontFile)
+	envString("PAYROLL_LINE_ROUNDING_UNIT", &cfg.Payroll.Rounding.Line.Unit)
+	envString("PAYROLL_LINE_ROUNDING_MODE", &cfg.Payroll.Rounding.Line.Mode)
+	envString("PAYROLL_TOTAL_ROUNDING_UNIT", &cfg.Payroll.Rounding.Total.Unit)
+	envString("PAYROLL_TOTAL_ROUNDING_MODE", &cfg.Payroll.Rounding.Total.Mode)
+	envString("PAYROLL_BASE_SALARY_FIELD", &cfg.Payroll.BaseSalaryField)
+
+	return errors.Join(
+		envInt("PORT", &cfg.Server.Port),
+		envInt("DB_MAX_OPEN_CONNS", &cfg.Database.MaxOpenConns),
+		envInt("DB_MAX_IDLE_CONNS", &cfg.Database.MaxIdleConns),
+		envDuration("DB_CONN_MAX_LIFETIME", &cfg.Database.ConnMaxLifetime),
+		envDuration("DB_CONN_MAX_IDLE_TIME", &cfg.Database.ConnMaxIdleTime),
+		envBool("DB_AUTO_MIGRATE", &cfg.Database.AutoMigrate),
+		envDuration("JWT_TTL", &cfg.Auth.TokenTTL),
+		envDuration("JWT_REFRESH_TTL", &cfg.Auth.RefreshTokenTTL),
+		envDuration("JWT_REMEMBER_TTL", &cfg.Auth.RememberTokenTTL),
+		envDuration("SIGN_TOKEN_TTL", &cfg.Auth.SignTokenTTL),
+		envDuration("PASSWORD_RESET_TTL", &cfg.Auth.PasswordResetTTL),
+		envInt("BCRYPT_COST", &cfg.Auth.BcryptCost),
+		envInt("PASSWORD_MIN_LENGTH", &cfg.Auth.PasswordPolicy.MinLength),
+		envInt("PASSWORD_MIN_CLASSES", &cfg.Auth.PasswordPolicy.MinClasses),
+		envInt("PASSWORD_HISTORY", &cfg.Auth.PasswordPolicy.History),
+		envInt("LOGIN_MAX_ATTEMPTS", &cfg.Auth.Lockout.MaxAttempts),
+		envInt("LOGIN_IP_MAX_ATTEMPTS", &cfg.Auth.Lockout.IPMaxAttempts),
+		envDuration("LOGIN_LOCKOUT_WINDOW", &cfg.Auth.Lockout.Window),
+		envDuration("LOGIN_LOCKOUT_DURATION", &cfg.Auth.Lockout.Duration),
+		envBool("TOTP_REQUIRED", &cfg.Auth.TOTP.Required),
+		envDuration("EMPLOYEE_TOKEN_TTL", &cfg.Auth.EmployeeTokenTTL),
+		envDuration("EMPLOYEE_CODE_TTL", &cfg.Auth.EmployeeCodeTTL),
+		envDuration("PAYSLIP_LINK_TTL", &cfg.Auth.PayslipLinkTTL),
+		envInt("PAYSLIP_LINK_MAX_USES", &cfg.Auth.PayslipLinkMaxUses),
+		envInt("SMTP_PORT", &cfg.Notify.SMTP.Port),
+		envBool("PAYROLL_STRICT_TEMPLATES", &cfg.Payroll.StrictTemplates),
+	)
+}
+
+// 是否为开发环境
+func (c *Config) IsDev() bool {
+	return c.Profile == "dev" || c.Profile == "development"
+}
+
+// 校验配置
+func (c *Config) Validate() error {
+	var errs []string
+
+	if c.Server.Port <= 0 || c.Server.Port > 65535 {
+		errs = append(errs, fmt.Sprintf("server.port %d out of range", c.Server.Port))
+	}
+	if c.Server.PublicURL == "" {
+		errs = append(errs, "server.public_url is required")
+	}
+	if c.Server.UploadsDir == "" {
+		errs = append(errs, "server.uploads_dir is required")
+	}
+	if c.Server.WebDir == "" {
+		errs = append(errs, "server.web_dir is required")
+	}
+	if _, err := databaseDialector(c.Database); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 {
+		errs = append(errs, "database connection pool sizes must not be negative")
+	}
+	if c.Auth.JWTSecret == "" {
+		errs = append(errs, "auth.jwt_secret is required")
+	}
+	if c.Auth.TokenTTL <= 0 || c.Auth.RefreshTokenTTL <= 0 || c.Auth.RememberTokenTTL <= 0 || c.Auth.SignTokenTTL <= 0 || c.Auth.PasswordResetTTL <= 0 {
+		errs = append(errs, "auth token lifetimes must be positive")
+	}
+	if c.Auth.BcryptCost < bcrypt.MinCost || c.Auth.BcryptCost > bcrypt.MaxCost {
+		errs = append(errs, fmt.Sprintf("auth.bcrypt_cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost))
+	}
+	if c.Auth.PasswordPolicy.MinLength < 8 {
+		errs = append(errs, "auth.password_policy.min_length must be at least 8")
+	}
+	if c.Auth.PasswordPolicy.MinClasses < 1 || c.Auth.PasswordPolicy.MinClasses > 4 {
+		errs = append(errs, "auth.password_policy.min_classes must be between 1 and 4")
+	}
+	if c.Auth.PasswordPolicy.History < 0 {
+		errs = append(errs, "auth.password_policy.history must not be negative")
+	}
+	if c.Auth.Lockout.MaxAttempts < 1 || c.Auth.Lockout.IPMaxAttempts < 1 {
+		errs = append(errs, "auth.lockout attempt thresholds must be at least 1")
+	}
+	if c.Auth.Lockout.Window <= 0 || c.Auth.Lockout.Duration <= 0 {
+		errs = append(errs, "auth.lockout window and duration must be positive")
+	}
+	if c.Auth.Lockout.BaseDelay < 0 || c.Auth.Lockout.MaxDelay < c.Auth.Lockout.BaseDelay {
+		errs = append(errs, "auth.lockout.max_delay must not be less than base_delay")
+	}
+	if c.Auth.TOTP.Issuer == "" || strings.Contains(c.Auth.TOTP.Issuer, ":") {
+		errs = append(errs, "auth.totp.issuer is required and must not contain ':'")
+	}
+	if c.Auth.TOTP.ChallengeTTL <= 0 {
+		errs = append(errs, "auth.totp.challenge_ttl must be positive")
+	}
+	if c.Auth.EmployeeTokenTTL <= 0 || c.Auth.EmployeeCodeTTL <= 0 {
+		errs = append(errs, "auth.employee_token_ttl and employee_code_ttl must be positive")
+	}
+	if c.Auth.PayslipLinkTTL <= 0 || c.Auth.PayslipLinkMaxUses < 1 {
+		errs = append(errs, "auth.payslip_link_ttl must be positive and payslip_link_max_uses at least 1")
+	}
+	if c.Notify.SMTP.Host != "" && (c.Notify.SMTP.Port <= 0 || c.Notify.SMTP.From == "") {
+		errs = append(errs, "notify.smtp requires port and from when host is set")
+	}
+	if c.Seal.KeyFile != "" && (c.Seal.KeyID == "" || len(c.Seal.KeyID) > 64) {
+		errs = append(errs, "seal.key_id is required (at most 64 characters) when seal.key_file is set")
+	}
+	if err := c.Payroll.Rounding.Line.validate("payroll.rounding.line"); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := c.Payroll.Rounding.Total.validate("payroll.rounding.total"); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := c.Payroll.IncomeTax.validate(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if !templateFieldKeyPattern.MatchString(c.Payroll.BaseSalaryField) {
+		errs = append(errs, "payroll.base_salary_field must be a valid template field key")
+	}
+
+	if !c.IsDev() {
+		if c.Auth.JWTSecret == defaultJWTSecret {
+			errs = append(errs, "auth.jwt_secret must be changed outside the dev profile")
+		} else if len(c.Auth.JWTSecret) < 32 {
+			errs = append(errs, "auth.jwt_secret must be at least 32 characters outside the dev profile")
+		}
+		if c.Auth.AdminPassword == defaultAdminPassword {
+			errs = append(errs, "auth.admin_password must be changed outside the dev profile")
+		}
+		if c.Seal.KeyFile == "" {
+			errs = append(errs, "seal.key_file is required outside the dev profile")
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func envString(key string, dst *string) {
+	if v := os.Getenv(key); v != "" {
+		*dst = v
+	}
+}
+
+func envInt(key string, dst *int) error {
+	v := os.Getenv(key)
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", key, err)
+	}
+	*dst = n
+	return nil
+}
+
+func envBool(key string, dst *bool) error {
+	v := os.Getenv(key)
+	if v == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", key, err)
+	}
+	*dst = b
+	return nil
+}
+
+func envDuration(key string, dst *time.Duration) error {
+	v := os.Getenv(key)
+	if v == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", key, err)
+	}
+	*dst = d
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 非开发环境必须更换默认JWT密钥和默认管理员密码，并配置封存密钥
+func TestConfigValidateProfileSecrets(t *testing.T) {
+	production := func(c *Config) {
+		c.Auth.JWTSecret = strings.Repeat("s", 32)
+		c.Seal.KeyFile, c.Seal.KeyID = "seal.pem", "seal-2024"
+	}
+	tests := []struct {
+		name    string
+		profile string
+		modify  func(c *Config)
+		wantErr string
+	}{
+		{"dev with defaults", "dev", func(c *Config) { c.Auth.AdminPassword = defaultAdminPassword }, ""},
+		{"development alias", "development", func(c *Config) { c.Auth.AdminPassword = defaultAdminPassword }, ""},
+		{"production configured", "production", func(*Config) {}, ""},
+		{"production default jwt secret", "production", func(c *Config) { c.Auth.JWTSecret = defaultJWTSecret },
+			"auth.jwt_secret must be changed outside the dev profile"},
+		{"test profile default jwt secret", "test", func(c *Config) { c.Auth.JWTSecret = defaultJWTSecret },
+			"auth.jwt_secret must be changed outside the dev profile"},
+		{"production short jwt secret", "production", func(c *Config) { c.Auth.JWTSecret = "short-secret" },
+			"auth.jwt_secret must be at least 32 characters"},
+		{"production default admin password", "production", func(c *Config) { c.Auth.AdminPassword = defaultAdminPassword },
+			"auth.admin_password must be changed outside the dev profile"},
+		{"production without seal key", "production", func(c *Config) { c.Seal.KeyFile = "" },
+			"seal.key_file is required outside the dev profile"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.Profile = tt.profile
+			if !cfg.IsDev() {
+				production(cfg)
+			}
+			tt.modify(cfg)
+			err := cfg.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Validate() error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Validate() error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// 环境变量覆盖配置文件，配置文件覆盖默认值
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("profile: dev\nserver:\n  port: 9000\n  public_url: https://payroll.example.com/\nauth:\n  token_ttl: 30m\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("APP_PROFILE", "")
+	t.Setenv("DATABASE_URL", "")
+	t.Setenv("PORT", "9100")
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != 9100 || cfg.Auth.TokenTTL != 30*time.Minute || cfg.Auth.RefreshTokenTTL != 24*time.Hour {
+		t.Errorf("port = %d, token ttl = %v, refresh ttl = %v, want 9100, 30m, 24h", cfg.Server.Port, cfg.Auth.TokenTTL, cfg.Auth.RefreshTokenTTL)
+	}
+	if cfg.Server.PublicURL != "https://payroll.example.com" || cfg.Database.URL != "payroll.db" {
+		t.Errorf("public url = %q, database url = %q", cfg.Server.PublicURL, cfg.Database.URL)
+	}
+
+	t.Setenv("PORT", "not-a-number")
+	if _, err := loadConfig(path); err == nil || !strings.Contains(err.Error(), "invalid PORT") {
+		t.Errorf("loadConfig() error = %v, want invalid PORT", err)
+	}
+}
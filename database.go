@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// 数据库配置
+type DatabaseConfig struct {
+	Type            string        `yaml:"type"`               // sqlite, mysql, postgres
+	URL             string        `yaml:"url"`                // DSN，SQLite时为数据库文件路径
+	MaxOpenConns    int           `yaml:"max_open_conns"`     // 最大打开连接数，0表示不限制
+	MaxIdleConns    int           `yaml:"max_idle_conns"`     // 最大空闲连接数
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`  // 连接最长存活时间
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"` // 空闲连接最长保留时间
+	AutoMigrate     bool          `yaml:"auto_migrate"`       // 启动时自动执行未执行的迁移
+}
+
+// 根据数据库类型选择GORM方言
+func databaseDialector(cfg DatabaseConfig) (gorm.Dialector, error) {
+	switch cfg.Type {
+	case "sqlite", "sqlite3":
+		return sqlite.Open(cfg.URL), nil
+	case "mysql", "mariadb":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("DATABASE_URL is required for %s", cfg.Type)
+		}
+		return mysql.Open(cfg.URL), nil
+	case "postgres", "postgresql", "pgsql":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("DATABASE_URL is required for %s", cfg.Type)
+		}
+		return postgres.Open(cfg.URL), nil
+	default:
+		return nil, fmt.Errorf("unsupported database type: %s", cfg.Type)
+	}
+}
+
+// 打开数据库连接并设置连接池
+func openDatabase(cfg DatabaseConfig) (*gorm.DB, error) {
+	dialector, err := databaseDialector(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := conn.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	if err := sqlDB.Ping(); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 文档封存签名算法
+const (
+	SealAlgorithmEd25519 = "Ed25519"
+	SealAlgorithmRS256   = "RS256" // RSA PKCS#1 v1.5 + SHA-256
+)
+
+const sealBundleFormat = "payroll-document-seal/v1"
+
+// 封存密钥配置
+type SealConfig struct {
+	KeyFile string `yaml:"key_file"` // PEM格式私钥（PKCS#8 的 Ed25519 或 RSA，也支持 PKCS#1 RSA）
+	KeyID   string `yaml:"key_id"`   // 密钥编号，轮换密钥时必须更换
+}
+
+// 封存公钥：每个密钥编号首次使用时登记，轮换后旧文档仍可用原公钥核验
+type DocumentSealKey struct {
+	ID          uint      `json:"-" gorm:"primaryKey"`
+	KeyID       string    `json:"key_id" gorm:"uniqueIndex;size:64"`
+	Algorithm   string    `json:"algorithm" gorm:"size:20"`
+	PublicKey   string    `json:"public_key" gorm:"type:text"` // PEM格式公钥
+	Fingerprint string    `json:"fingerprint" gorm:"size:64"`  // 公钥DER的SHA-256
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// 文档封存记录：服务端对签名完成时的文档快照和签名证据做的分离式数字签名
+type DocumentSeal struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	EntityType  string    `json:"entity_type" gorm:"index:idx_document_seal_entity;size:20"` // payroll, resignation
+	EntityID    uint      `json:"-" gorm:"index:idx_document_seal_entity"`
+	EntityUUID  string    `json:"entity_id" gorm:"size:36"`
+	KeyID       string    `json:"key_id" gorm:"size:64"`
+	Algorithm   string    `json:"algorithm" gorm:"size:20"`
+	Payload     string    `json:"payload" gorm:"type:text"`    // 被签名的规范化JSON，按原样保存
+	PayloadHash string    `json:"payload_hash" gorm:"size:64"` // 便于检索和人工比对
+	Signature   string    `json:"signature" gorm:"type:text"`  // Base64
+	SealedAt    time.Time `json:"sealed_at"`
+}
+
+// 导出的封存包，可离线用公钥核验
+type SealBundle struct {
+	Format      string            `json:"format"`
+	EntityType  string            `json:"entity_type"`
+	EntityID    string            `json:"entity_id"`
+	KeyID       string            `json:"key_id"`
+	Algorithm   string            `json:"algorithm"`
+	PublicKey   string            `json:"public_key"`
+	Fingerprint string            `json:"fingerprint"`
+	Payload     string            `json:"payload"`
+	Signature   string            `json:"signature"`
+	SealedAt    time.Time         `json:"sealed_at"`
+	Images      map[string]string `json:"images"` // 签名图片（Base64），键为 payload 中的 image_hash
+}
+
+// 封存证据中的单条签名，IP和User-Agent是签名时服务端从请求连接取得的值
+type sealSignatureEvidence struct {
+	SignatureID   uint   `json:"signature_id"`
+	SignerType    string `json:"signer_type"`
+	SignedAt      string `json:"signed_at"`
+	IPAddress     string `json:"ip_address"`
+	UserAgent     string `json:"user_agent"`
+	DeviceInfo    string `json:"device_info"`
+	ContentHash   string `json:"content_hash"`
+	ImageHash     string `json:"image_hash"`
+	PrevHash      string `json:"prev_hash"`
+	SignatureHash string `json:"signature_hash"`
+}
+
+type documentSealer struct {
+	keyID       string
+	algorithm   string
+	privateKey  crypto.Signer
+	publicKey   string
+	fingerprint string
+}
+
+var sealer *documentSealer
+
+// 加载封存私钥；dev 环境未配置时生成临时 Ed25519 密钥，公钥登记后重启仍可核验
+func initDocumentSealer(cfg SealConfig) error {
+	var key crypto.Signer
+	keyID := cfg.KeyID
+	if cfg.KeyFile == "" {
+		if !appConfig.IsDev() {
+			return errors.New("seal.key_file is required")
+		}
+		// 临时密钥使用公钥指纹作为编号，避免与已登记的编号冲突
+		keyID = ""
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		key = priv
+	} else {
+		data, err := os.ReadFile(cfg.KeyFile)
+		if err != nil {
+			return fmt.Errorf("read seal key: %w", err)
+		}
+		if key, err = parseSealPrivateKey(data); err != nil {
+			return err
+		}
+	}
+
+	s := &documentSealer{privateKey: key}
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		s.algorithm = SealAlgorithmEd25519
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return errors.New("seal RSA key must be at least 2048 bits")
+		}
+		s.algorithm = SealAlgorithmRS256
+	default:
+		return errors.New("seal key must be Ed25519 or RSA")
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(der)
+	s.fingerprint = fmt.Sprintf("%x", sum)
+	s.publicKey = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if keyID == "" {
+		keyID = "dev-" + s.fingerprint[:12]
+		log.Printf("WARNING: seal.key_file not configured, using ephemeral dev seal key %s", keyID)
+	}
+	s.keyID = keyID
+
+	if err := registerSealKey(s); err != nil {
+		return err
+	}
+	sealer = s
+	return nil
+}
+
+func parseSealPrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("seal key is not PEM encoded")
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse seal key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("seal key must be Ed25519 or RSA")
+	}
+	return signer, nil
+}
+
+// 登记公钥；同一密钥编号对应不同公钥时拒绝启动，防止旧文档无法核验
+func registerSealKey(s *documentSealer) error {
+	var existing DocumentSealKey
+	err := db.Where("key_id = ?", s.keyID).First(&existing).Error
+	if err == nil {
+		if existing.Fingerprint != s.fingerprint {
+			return fmt.Errorf("seal key id %q is already registered with a different key, use a new key_id when rotating", s.keyID)
+		}
+		return nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return db.Create(&DocumentSealKey{
+		KeyID:       s.keyID,
+		Algorithm:   s.algorithm,
+		PublicKey:   s.publicKey,
+		Fingerprint: s.fingerprint,
+	}).Error
+}
+
+func (s *documentSealer) sign(payload []byte) ([]byte, error) {
+	if s.algorithm == SealAlgorithmEd25519 {
+		return s.privateKey.Sign(rand.Reader, payload, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(payload)
+	return s.privateKey.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// 用登记的公钥核验封存签名
+func verifySealSignature(key DocumentSealKey, payload []byte, signature []byte) bool {
+	block, _ := pem.Decode([]byte(key.PublicKey))
+	if block == nil {
+		return false
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return false
+	}
+	switch k := pub.(type) {
+	case ed25519.PublicKey:
+		return key.Algorithm == SealAlgorithmEd25519 && ed25519.Verify(k, payload, signature)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(payload)
+		return key.Algorithm == SealAlgorithmRS256 && rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature) == nil
+	}
+	return false
+}
+
+// 生成封存记录，snapshot 为文档内容快照（规范化JSON）
+func sealDocument(tx *gorm.DB, entityType string, entityID uint, entityUUID, snapshot string, evidence []sealSignatureEvidence) (DocumentSeal, error) {
+	if sealer == nil {
+		return DocumentSeal{}, errors.New("document sealer not initialized")
+	}
+
+	now := time.Now()
+	payload, err := json.Marshal(map[string]interface{}{
+		"format":      sealBundleFormat,
+		"entity_type": entityType,
+		"entity_id":   entityUUID,
+		"key_id":      sealer.keyID,
+		"algorithm":   sealer.algorithm,
+		"sealed_at":   canonicalTime(&now),
+		"document":    json.RawMessage(snapshot),
+		"signatures":  evidence,
+	})
+	if err != nil {
+		return DocumentSeal{}, err
+	}
+	signature, err := sealer.sign(payload)
+	if err != nil {
+		return DocumentSeal{}, err
+	}
+
+	seal := DocumentSeal{
+		EntityType:  entityType,
+		EntityID:    entityID,
+		EntityUUID:  entityUUID,
+		KeyID:       sealer.keyID,
+		Algorithm:   sealer.algorithm,
+		Payload:     string(payload),
+		PayloadHash: sha256Hex(payload),
+		Signature:   base64.StdEncoding.EncodeToString(signature),
+		SealedAt:    now,
+	}
+	return seal, tx.Create(&seal).Error
+}
+
+func payrollSignatureEvidence(sig PayrollSignature) sealSignatureEvidence {
+	return sealSignatureEvidence{
+		SignatureID:   sig.ID,
+		SignerType:    "employee",
+		SignedAt:      canonicalTime(&sig.SignedAt).(string),
+		IPAddress:     sig.IPAddress,
+		UserAgent:     sig.UserAgent,
+		DeviceInfo:    sig.DeviceInfo,
+		ContentHash:   sig.ContentHash,
+		ImageHash:     sig.ImageHash,
+		PrevHash:      sig.PrevHash,
+		SignatureHash: sig.SignatureHash,
+	}
+}
+
+func resignationSignatureEvidence(sig ResignationSignature) sealSignatureEvidence {
+	return sealSignatureEvidence{
+		SignatureID:   sig.ID,
+		SignerType:    sig.SignerType,
+		SignedAt:      canonicalTime(&sig.SignedAt).(string),
+		IPAddress:     sig.IPAddress,
+		UserAgent:     sig.UserAgent,
+		DeviceInfo:    sig.DeviceInfo,
+		ContentHash:   sig.ContentHash,
+		ImageHash:     sig.ImageHash,
+		PrevHash:      sig.PrevHash,
+		SignatureHash: sig.SignatureHash,
+	}
+}
+
+// 工资条签收后封存
+func sealPayroll(tx *gorm.DB, payroll Payroll, signature PayrollSignature) error {
+	_, err := sealDocument(tx, "payroll", payroll.ID, payroll.UUID, signature.ContentSnapshot,
+		[]sealSignatureEvidence{payrollSignatureEvidence(signature)})
+	return err
+}
+
+// 离职文件所有签名完成后封存
+func sealResignation(tx *gorm.DB, application ResignationApplication) error {
+	var signatures []ResignationSignature
+	if err := tx.Where("application_id = ?", application.ID).Order("id").Find(&signatures).Error; err != nil {
+		return err
+	}
+	evidence := make([]sealSignatureEvidence, 0, len(signatures))
+	for _, sig := range signatures {
+		evidence = append(evidence, resignationSignatureEvidence(sig))
+	}
+	_, err := sealDocument(tx, "resignation", application.ID, application.UUID, resignationSnapshot(application), evidence)
+	return err
+}
+
+// 生成封存包，附带公钥和签名图片
+func buildSealBundle(seal DocumentSeal, images [][]byte) (SealBundle, error) {
+	var key DocumentSealKey
+	if err := db.Where("key_id = ?", seal.KeyID).First(&key).Error; err != nil {
+		return SealBundle{}, err
+	}
+	bundle := SealBundle{
+		Format:      sealBundleFormat,
+		EntityType:  seal.EntityType,
+		EntityID:    seal.EntityUUID,
+		KeyID:       seal.KeyID,
+		Algorithm:   seal.Algorithm,
+		PublicKey:   key.PublicKey,
+		Fingerprint: key.Fingerprint,
+		Payload:     seal.Payload,
+		Signature:   seal.Signature,
+		SealedAt:    seal.SealedAt,
+		Images:      map[string]string{},
+	}
+	for _, image := range images {
+		if image != nil {
+			bundle.Images[sha256Hex(image)] = base64.StdEncoding.EncodeToString(image)
+		}
+	}
+	return bundle, nil
+}
+
+func sendSealBundle(c *gin.Context, bundle SealBundle) {
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("%s-%s-seal.json", bundle.EntityType, bundle.EntityID)))
+	c.JSON(http.StatusOK, bundle)
+}
+
+// 导出工资条封存包
+func getPayrollSeal(c *gin.Context) {
+	var payroll Payroll
+	if err := db.Where("uuid = ?", c.Param("id")).First(&payroll).Error; err != nil || !canViewPayroll(c, payroll) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payroll not found"})
+		return
+	}
+
+	var seal DocumentSeal
+	if err := db.Where("entity_type = ? AND entity_id = ?", "payroll", payroll.ID).Order("id DESC").First(&seal).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "工资条尚未封存"})
+		return
+	}
+
+	var signatures []PayrollSignature
+	db.Where("payroll_id = ?", payroll.ID).Find(&signatures)
+	images := make([][]byte, 0, len(signatures))
+	for _, sig := range signatures {
+		images = append(images, loadSignatureImageFile(sig.SignatureData))
+	}
+
+	bundle, err := buildSealBundle(seal, images)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成封存包失败"})
+		return
+	}
+	sendSealBundle(c, bundle)
+}
+
+// 导出离职文件封存包
+func getResignationSeal(c *gin.Context) {
+	var application ResignationApplication
+	if err := db.First(&application, "uuid = ?", c.Param("id")).Error; err != nil || !canAccessEmployee(c, application.EmployeeID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "离职申请不存在"})
+		return
+	}
+
+	var seal DocumentSeal
+	if err := db.Where("entity_type = ? AND entity_id = ?", "resignation", application.ID).Order("id DESC").First(&seal).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "离职文件尚未封存"})
+		return
+	}
+
+	var signatures []ResignationSignature
+	db.Where("application_id = ?", application.ID).Find(&signatures)
+	images := make([][]byte, 0, len(signatures))
+	for _, sig := range signatures {
+		image, _ := decodeSignatureImage(sig.SignatureData)
+		images = append(images, image)
+	}
+
+	bundle, err := buildSealBundle(seal, images)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成封存包失败"})
+		return
+	}
+	sendSealBundle(c, bundle)
+}
+
+// 封存公钥列表，供第三方离线核验
+func getSealKeys(c *gin.Context) {
+	var keys []DocumentSealKey
+	if err := db.Order("id").Find(&keys).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": keys, "active_key_id": sealer.keyID})
+}
+
+// 核验封存包：公钥必须是本系统登记的公钥，签名图片须与封存时的哈希一致
+func verifySealBundle(c *gin.Context) {
+	var bundle SealBundle
+	if err := c.ShouldBindJSON(&bundle); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+
+	result := gin.H{"key_id": bundle.KeyID, "entity_type": bundle.EntityType, "entity_id": bundle.EntityID}
+	var key DocumentSealKey
+	if err := db.Where("key_id = ?", bundle.KeyID).First(&key).Error; err != nil {
+		result["valid"] = false
+		result["reason"] = "unknown_key"
+		c.JSON(http.StatusOK, result)
+		return
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(bundle.Signature)
+	if err != nil || !verifySealSignature(key, []byte(bundle.Payload), signature) {
+		result["valid"] = false
+		result["reason"] = "bad_signature"
+		c.JSON(http.StatusOK, result)
+		return
+	}
+
+	// 签名有效后再以 payload 为准检查元数据和签名图片
+	var payload struct {
+		EntityType string                  `json:"entity_type"`
+		EntityID   string                  `json:"entity_id"`
+		KeyID      string                  `json:"key_id"`
+		SealedAt   string                  `json:"sealed_at"`
+		Signatures []sealSignatureEvidence `json:"signatures"`
+	}
+	if err := json.Unmarshal([]byte(bundle.Payload), &payload); err != nil ||
+		payload.KeyID != bundle.KeyID || payload.EntityType != bundle.EntityType || payload.EntityID != bundle.EntityID {
+		result["valid"] = false
+		result["reason"] = "metadata_mismatch"
+		c.JSON(http.StatusOK, result)
+		return
+	}
+
+	var missing []uint
+	for _, sig := range payload.Signatures {
+		image, err := base64.StdEncoding.DecodeString(bundle.Images[sig.ImageHash])
+		if err != nil || sha256Hex(image) != sig.ImageHash {
+			missing = append(missing, sig.SignatureID)
+		}
+	}
+	result["sealed_at"] = payload.SealedAt
+	result["signatures"] = len(payload.Signatures)
+	if len(missing) > 0 {
+		result["valid"] = false
+		result["reason"] = "image_mismatch"
+		result["invalid_images"] = missing
+		c.JSON(http.StatusOK, result)
+		return
+	}
+	result["valid"] = true
+	c.JSON(http.StatusOK, result)
+}
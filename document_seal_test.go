@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 签收后导出封存包
+func payrollSealBundle(t *testing.T, payroll Payroll) SealBundle {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/v1/payrolls/"+payroll.UUID+"/seal", nil)
+	c.Params = gin.Params{{Key: "id", Value: payroll.UUID}}
+	c.Set("employee_id", payroll.EmployeeID)
+	getPayrollSeal(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("seal status = %d, body = %s", w.Code, w.Body)
+	}
+	var bundle SealBundle
+	if err := json.Unmarshal(w.Body.Bytes(), &bundle); err != nil {
+		t.Fatal(err)
+	}
+	return bundle
+}
+
+func verifyTestSealBundle(t *testing.T, bundle SealBundle) map[string]interface{} {
+	t.Helper()
+	body, _ := json.Marshal(bundle)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/seals/verify", strings.NewReader(string(body)))
+	c.Request.Header.Set("Content-Type", "application/json")
+	verifySealBundle(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("verify status = %d, body = %s", w.Code, w.Body)
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func writeTestRSAKey(t *testing.T) string {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "seal.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// 封存包导出后可核验；修改 payload、签名图片、元数据或使用未登记的密钥编号都会被发现
+func TestSealBundleRoundTrip(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tamper := []struct {
+		name   string
+		modify func(b *SealBundle)
+		reason string
+	}{
+		{"intact", func(b *SealBundle) {}, ""},
+		{"payload", func(b *SealBundle) { b.Payload = strings.Replace(b.Payload, "203.0.113.7", "10.0.0.1", 1) }, "bad_signature"},
+		{"signature", func(b *SealBundle) { b.Signature = base64.StdEncoding.EncodeToString([]byte("forged")) }, "bad_signature"},
+		{"unknown key", func(b *SealBundle) { b.KeyID = "unknown" }, "unknown_key"},
+		{"entity id", func(b *SealBundle) { b.EntityID = generateUUID() }, "metadata_mismatch"},
+		{"image", func(b *SealBundle) {
+			for hash := range b.Images {
+				b.Images[hash] = base64.StdEncoding.EncodeToString([]byte("other image"))
+			}
+		}, "image_mismatch"},
+	}
+	keys := []struct {
+		name      string
+		algorithm string
+		config    func(t *testing.T) SealConfig
+	}{
+		{"ed25519", SealAlgorithmEd25519, func(t *testing.T) SealConfig { return SealConfig{} }},
+		{"rsa", SealAlgorithmRS256, func(t *testing.T) SealConfig { return SealConfig{KeyFile: writeTestRSAKey(t), KeyID: "rsa-test"} }},
+	}
+	for _, key := range keys {
+		t.Run(key.name, func(t *testing.T) {
+			setupTestDB(t)
+			setupTestSealer(t)
+			if err := initDocumentSealer(key.config(t)); err != nil {
+				t.Fatal(err)
+			}
+			payroll := createSignTestPayroll(t)
+			if w := signTestPayroll(t, payroll, `{"payroll_id": "`+payroll.UUID+`", "signature_data": "`+testSignatureImage+`"}`); w.Code != http.StatusOK {
+				t.Fatalf("sign status = %d, body = %s", w.Code, w.Body)
+			}
+			original := payrollSealBundle(t, payroll)
+			if original.Algorithm != key.algorithm {
+				t.Errorf("algorithm = %s, want %s", original.Algorithm, key.algorithm)
+			}
+
+			for _, tt := range tamper {
+				t.Run(tt.name, func(t *testing.T) {
+					bundle := original
+					bundle.Images = map[string]string{}
+					for hash, image := range original.Images {
+						bundle.Images[hash] = image
+					}
+					tt.modify(&bundle)
+					result := verifyTestSealBundle(t, bundle)
+					if valid := tt.reason == ""; result["valid"] != valid {
+						t.Errorf("valid = %v, want %v (result %v)", result["valid"], valid, result)
+					}
+					if tt.reason != "" && result["reason"] != tt.reason {
+						t.Errorf("reason = %v, want %s", result["reason"], tt.reason)
+					}
+				})
+			}
+		})
+	}
+}
+
+// 封存证据中的签收IP和User-Agent与签名记录一致，来自请求连接而不是请求体
+func TestPayrollSealEvidenceUsesServerSideClientInfo(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupTestDB(t)
+	setupTestSealer(t)
+	payroll := createSignTestPayroll(t)
+	w := signTestPayroll(t, payroll, `{"payroll_id": "`+payroll.UUID+`", "signature_data": "`+testSignatureImage+`",
+		"ip_address": "10.0.0.1", "user_agent": "forged"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+
+	var payload struct {
+		Signatures []sealSignatureEvidence `json:"signatures"`
+	}
+	if err := json.Unmarshal([]byte(payrollSealBundle(t, payroll).Payload), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if len(payload.Signatures) != 1 {
+		t.Fatalf("signatures = %d, want 1", len(payload.Signatures))
+	}
+	if got := payload.Signatures[0]; got.IPAddress != "203.0.113.7" || got.UserAgent != "payroll-test/1.0" {
+		t.Errorf("evidence ip = %q, user agent = %q, want 203.0.113.7, payroll-test/1.0", got.IPAddress, got.UserAgent)
+	}
+}
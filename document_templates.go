@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 文档模板：按类型保存多个版本，每个类型同时只有一个启用版本。
+// 版本创建后不再修改，修改模板即新建版本；没有启用版本时使用内置默认模板（版本0）。
+type DocumentTemplate struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Kind      string    `json:"kind" gorm:"uniqueIndex:idx_document_template_version;size:32"`
+	Version   int       `json:"version" gorm:"uniqueIndex:idx_document_template_version"`
+	Name      string    `json:"name"`
+	Content   string    `json:"content" gorm:"type:text"`  // html/template 模板
+	Branding  string    `json:"branding" gorm:"type:text"` // 品牌变量 JSON，模板中以 .Branding.xxx 引用
+	Comment   string    `json:"comment"`
+	IsActive  bool      `json:"is_active" gorm:"default:false"`
+	CreatedBy uint      `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// 创建模板版本请求
+type CreateDocumentTemplateRequest struct {
+	Kind     string            `json:"kind" binding:"required"`
+	Name     string            `json:"name"`
+	Content  string            `json:"content" binding:"required"`
+	Branding map[string]string `json:"branding"`
+	Comment  string            `json:"comment"`
+	Activate *bool             `json:"activate"` // 是否立即启用，默认启用
+}
+
+// 预览请求：content 为空时预览 template_id 指定的版本或当前启用版本；application_id 为空时使用示例数据
+type PreviewDocumentTemplateRequest struct {
+	Kind          string            `json:"kind" binding:"required"`
+	TemplateID    uint              `json:"template_id"`
+	Content       string            `json:"content"`
+	Branding      map[string]string `json:"branding"`
+	ApplicationID uint              `json:"application_id"`
+}
+
+// 文档模板类型
+type documentTemplateKind struct {
+	Name     string
+	Default  string
+	Branding map[string]string
+	Sample   func(branding map[string]string) interface{} // 示例数据，用于保存前试渲染和预览
+}
+
+const documentKindResignationReport = "resignation_report"
+
+var documentTemplateKinds = map[string]documentTemplateKind{
+	documentKindResignationReport: {
+		Name:    "离职报告",
+		Default: defaultResignationReportTemplate,
+		Branding: map[string]string{
+			"company_name":  "",
+			"logo_url":      "",
+			"primary_color": "#667eea",
+			"footer":        "",
+		},
+		Sample: sampleResignationReportDocument,
+	},
+}
+
+var (
+	brandingKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+	errTemplateKind    = errors.New("不支持的模板类型")
+)
+
+const maxBrandingValueLength = 1000
+
+var documentTemplateFuncs = template.FuncMap{
+	"yesno": boolToString,
+}
+
+func parseDocumentTemplate(content string) (*template.Template, error) {
+	return template.New("document").Funcs(documentTemplateFuncs).Option("missingkey=zero").Parse(content)
+}
+
+func parseBranding(raw string) map[string]string {
+	branding := map[string]string{}
+	json.Unmarshal([]byte(raw), &branding)
+	return branding
+}
+
+// 校验品牌变量：变量名为小写字母、数字和下划线，值长度有限制
+func validateBranding(branding map[string]string) error {
+	for key, value := range branding {
+		if !brandingKeyPattern.MatchString(key) {
+			return fmt.Errorf("品牌变量名 %q 无效，只能包含小写字母、数字和下划线", key)
+		}
+		if len(value) > maxBrandingValueLength {
+			return fmt.Errorf("品牌变量 %s 过长", key)
+		}
+	}
+	return nil
+}
+
+// 按模板渲染文档：品牌变量以类型默认值为基础，由模板版本中的值覆盖
+func renderDocumentTemplate(kind documentTemplateKind, content string, branding map[string]string, data func(map[string]string) interface{}) (string, error) {
+	tmpl, err := parseDocumentTemplate(content)
+	if err != nil {
+		return "", err
+	}
+	merged := map[string]string{}
+	for k, v := range kind.Branding {
+		merged[k] = v
+	}
+	for k, v := range branding {
+		merged[k] = v
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data(merged)); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// 某类型当前启用的模板，没有时返回内置默认模板
+func activeDocumentTemplate(kind string) (DocumentTemplate, error) {
+	def, ok := documentTemplateKinds[kind]
+	if !ok {
+		return DocumentTemplate{}, errTemplateKind
+	}
+	var tpl DocumentTemplate
+	if err := db.Where("kind = ? AND is_active = ?", kind, true).Order("version DESC").First(&tpl).Error; err == nil {
+		return tpl, nil
+	}
+	branding, _ := json.Marshal(def.Branding)
+	return DocumentTemplate{Kind: kind, Version: 0, Name: "内置" + def.Name + "模板", Content: def.Default, Branding: string(branding), IsActive: true}, nil
+}
+
+// 获取模板版本列表
+func getDocumentTemplates(c *gin.Context) {
+	query := db.Order("kind, version DESC")
+	if kind := c.Query("kind"); kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+	var templates []DocumentTemplate
+	if err := query.Find(&templates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取文档模板失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": templates})
+}
+
+// 获取当前启用的模板（未自定义时为内置模板），可作为编辑新版本的起点
+func getActiveDocumentTemplate(c *gin.Context) {
+	tpl, err := activeDocumentTemplate(c.Query("kind"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": tpl})
+}
+
+func getDocumentTemplate(c *gin.Context) {
+	var tpl DocumentTemplate
+	if err := db.First(&tpl, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "文档模板不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": tpl})
+}
+
+// 创建模板新版本：先用示例数据试渲染，模板有语法或字段错误时拒绝保存
+func createDocumentTemplate(c *gin.Context) {
+	var req CreateDocumentTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+	kind, ok := documentTemplateKinds[req.Kind]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errTemplateKind.Error()})
+		return
+	}
+	if err := validateBranding(req.Branding); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := renderDocumentTemplate(kind, req.Content, req.Branding, kind.Sample); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "模板无法渲染: " + err.Error()})
+		return
+	}
+
+	branding, _ := json.Marshal(req.Branding)
+	if req.Branding == nil {
+		branding = []byte("{}")
+	}
+	tpl := DocumentTemplate{
+		Kind:      req.Kind,
+		Name:      req.Name,
+		Content:   req.Content,
+		Branding:  string(branding),
+		Comment:   req.Comment,
+		IsActive:  req.Activate == nil || *req.Activate,
+		CreatedBy: c.GetUint("user_id"),
+	}
+	if tpl.Name == "" {
+		tpl.Name = kind.Name + "模板"
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var maxVersion int
+		if err := tx.Model(&DocumentTemplate{}).Where("kind = ?", req.Kind).Select("COALESCE(MAX(version), 0)").Scan(&maxVersion).Error; err != nil {
+			return err
+		}
+		tpl.Version = maxVersion + 1
+		if tpl.IsActive {
+			if err := tx.Model(&DocumentTemplate{}).Where("kind = ? AND is_active = ?", req.Kind, true).Update("is_active", false).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(&tpl).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文档模板失败"})
+		return
+	}
+	recordAudit(c, "document_template.create", "document_template", tpl.ID, nil, tpl)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "文档模板已保存",
+		"data":    tpl,
+	})
+}
+
+// 启用指定版本，可用于回滚到旧版本
+func activateDocumentTemplate(c *gin.Context) {
+	var tpl DocumentTemplate
+	if err := db.First(&tpl, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "文档模板不存在"})
+		return
+	}
+
+	before := tpl
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&DocumentTemplate{}).Where("kind = ? AND id <> ?", tpl.Kind, tpl.ID).Update("is_active", false).Error; err != nil {
+			return err
+		}
+		return tx.Model(&tpl).Update("is_active", true).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "启用文档模板失败"})
+		return
+	}
+	recordAudit(c, "document_template.activate", "document_template", tpl.ID, before, tpl)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("已启用版本 %d", tpl.Version),
+		"data":    tpl,
+	})
+}
+
+// 停用某类型的全部自定义版本，恢复使用内置模板
+func resetDocumentTemplate(c *gin.Context) {
+	kind := c.Query("kind")
+	if _, ok := documentTemplateKinds[kind]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errTemplateKind.Error()})
+		return
+	}
+	if err := db.Model(&DocumentTemplate{}).Where("kind = ? AND is_active = ?", kind, true).Update("is_active", false).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "恢复内置模板失败"})
+		return
+	}
+	recordAudit(c, "document_template.reset", "document_template", kind, nil, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "已恢复内置模板"})
+}
+
+// 预览模板渲染结果，返回HTML字符串，前端应在沙箱iframe中显示
+func previewDocumentTemplate(c *gin.Context) {
+	var req PreviewDocumentTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+	kind, ok := documentTemplateKinds[req.Kind]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errTemplateKind.Error()})
+		return
+	}
+
+	var tpl DocumentTemplate
+	switch {
+	case req.Content != "":
+		tpl = DocumentTemplate{Kind: req.Kind, Content: req.Content}
+	case req.TemplateID != 0:
+		if err := db.Where("kind = ?", req.Kind).First(&tpl, req.TemplateID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "文档模板不存在"})
+			return
+		}
+	default:
+		tpl, _ = activeDocumentTemplate(req.Kind)
+	}
+	branding := parseBranding(tpl.Branding)
+	if req.Branding != nil {
+		if err := validateBranding(req.Branding); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		branding = req.Branding
+	}
+
+	data := kind.Sample
+	if req.ApplicationID != 0 {
+		var application ResignationApplication
+		if err := db.Preload("Employee").First(&application, req.ApplicationID).Error; err != nil || !canAccessEmployee(c, application.EmployeeID) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "离职申请不存在"})
+			return
+		}
+		var report ResignationReport
+		if err := db.Where("application_id = ?", application.ID).Order("id DESC").First(&report).Error; err != nil {
+			report = ResignationReport{ApplicationID: application.ID, GeneratedAt: time.Now()}
+		}
+		data = func(branding map[string]string) interface{} {
+			return newResignationReportDocument(application, report, branding)
+		}
+	}
+
+	html, err := renderDocumentTemplate(kind, tpl.Content, branding, data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "模板无法渲染: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"html": html, "version": tpl.Version}})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func createTemplateTestApplication(t *testing.T, employeeName string) ResignationApplication {
+	t.Helper()
+	employee := Employee{Name: employeeName, EmployeeNo: "R001", Department: "技术部", Status: "active"}
+	if err := db.Create(&employee).Error; err != nil {
+		t.Fatal(err)
+	}
+	application := ResignationApplication{
+		UUID:            generateUUID(),
+		EmployeeID:      employee.ID,
+		ResignationType: "voluntary",
+		ResignationDate: time.Date(2024, 9, 1, 0, 0, 0, 0, time.Local),
+		LastWorkingDate: time.Date(2024, 9, 30, 0, 0, 0, 0, time.Local),
+		Reason:          "个人发展",
+	}
+	if err := db.Create(&application).Error; err != nil {
+		t.Fatal(err)
+	}
+	return application
+}
+
+func sendDocumentTemplateRequest(t *testing.T, handler gin.HandlerFunc, method, body string, params gin.Params) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, "/api/v1/admin/document-templates?kind="+documentKindResignationReport, strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = params
+	c.Set("user_id", uint(1))
+	c.Set("username", "admin")
+	handler(c)
+	return w
+}
+
+// 新建、启用和恢复内置模板后，离职报告按当前启用的版本渲染；无法渲染的模板不能保存
+func TestRenderResignationReportVersion(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupTestDB(t)
+	application := createTemplateTestApplication(t, "张三")
+	create := func(content string, activate bool) (*httptest.ResponseRecorder, DocumentTemplate) {
+		body, _ := json.Marshal(map[string]interface{}{
+			"kind": documentKindResignationReport, "content": content, "activate": activate,
+			"branding": map[string]string{"company_name": "示例科技"},
+		})
+		w := sendDocumentTemplateRequest(t, createDocumentTemplate, http.MethodPost, string(body), nil)
+		var resp struct {
+			Data DocumentTemplate `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w, resp.Data
+	}
+	render := func() (string, int) {
+		t.Helper()
+		html, version, err := renderResignationReport(application, ResignationReport{GeneratedAt: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return html, version
+	}
+
+	if html, version := render(); version != 0 || !strings.Contains(html, "张三") {
+		t.Fatalf("builtin template: version = %d", version)
+	}
+	if _, v1 := create(`<p>{{.Branding.company_name}} v1 {{.EmployeeName}}</p>`, true); v1.Version != 1 {
+		t.Fatalf("first version = %d, want 1", v1.Version)
+	}
+	_, v2 := create(`<p>v2 {{.EmployeeName}} {{.Branding.primary_color}}</p>`, false)
+
+	steps := []struct {
+		name    string
+		action  func()
+		version int
+		html    string
+	}{
+		{"second version saved inactive", func() {}, 1, "<p>示例科技 v1 张三</p>"},
+		{"activate second version", func() {
+			sendDocumentTemplateRequest(t, activateDocumentTemplate, http.MethodPost, "", gin.Params{{Key: "id", Value: strconv.Itoa(int(v2.ID))}})
+		}, 2, "<p>v2 张三 #667eea</p>"},
+		{"reset to builtin", func() {
+			sendDocumentTemplateRequest(t, resetDocumentTemplate, http.MethodPost, "", nil)
+		}, 0, "离职报告"},
+	}
+	for _, s := range steps {
+		s.action()
+		html, version := render()
+		if version != s.version || !strings.Contains(html, s.html) {
+			t.Errorf("%s: version = %d, html = %q, want version %d containing %q", s.name, version, html, s.version, s.html)
+		}
+	}
+
+	for _, content := range []string{`{{.EmployeeName`, `{{.Salary}}`, `{{template "other"}}`} {
+		if w, _ := create(content, true); w.Code != http.StatusBadRequest {
+			t.Errorf("template %q: status = %d, want 400", content, w.Code)
+		}
+	}
+}
+
+// 员工数据和品牌变量按所在上下文转义，不能注入脚本、样式或链接
+func TestRenderDocumentTemplateEscaping(t *testing.T) {
+	setupTestDB(t)
+	kind := documentTemplateKinds[documentKindResignationReport]
+	application := createTemplateTestApplication(t, `<script>alert("x")</script>`)
+	db.Preload("Employee").First(&application, application.ID)
+	data := func(branding map[string]string) interface{} {
+		return newResignationReportDocument(application, ResignationReport{GeneratedAt: time.Now()}, branding)
+	}
+
+	tests := []struct {
+		name     string
+		content  string
+		branding map[string]string
+		want     string
+		notWant  string
+	}{
+		{"text", `<p>{{.EmployeeName}}</p>`, nil, "&lt;script&gt;", "<script>"},
+		{"link", `<img src="{{.Branding.logo_url}}">`, map[string]string{"logo_url": "javascript:alert(1)"}, "#ZgotmplZ", "javascript:"},
+		{"style", `<style>h1 { color: {{.Branding.primary_color}}; }</style>`, map[string]string{"primary_color": "red;}</style><script>alert(1)</script>"}, "ZgotmplZ", "<script>"},
+		{"attribute", `<div title="{{.Branding.footer}}"></div>`, map[string]string{"footer": `"><script>alert(1)</script>`}, "&#34;&gt;&lt;script&gt;", "<script>"},
+		{"default branding", `<style>h1 { color: {{.Branding.primary_color}}; }</style>`, nil, "#667eea", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			html, err := renderDocumentTemplate(kind, tt.content, tt.branding, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(html, tt.want) || (tt.notWant != "" && strings.Contains(html, tt.notWant)) {
+				t.Errorf("rendered %q, want containing %q and not %q", html, tt.want, tt.notWant)
+			}
+		})
+	}
+}
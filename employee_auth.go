@@ -0,0 +1,438 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// JWT受众：管理员令牌和员工令牌互不通用
+const (
+	adminAudience    = "payroll-admin"
+	employeeAudience = "payroll-employee"
+)
+
+const (
+	employeeCodeAttempts = 5                // 每个验证码最多尝试次数
+	employeeCodeCooldown = 60 * time.Second // 同一员工两次发送的最小间隔
+)
+
+// 员工登录验证码或登录链接，只保存哈希值
+type EmployeeLoginCode struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	EmployeeID uint       `json:"employee_id" gorm:"index"`
+	Method     string     `json:"method" gorm:"size:20"`  // otp, magic_link
+	Channel    string     `json:"channel" gorm:"size:20"` // email, sms
+	CodeHash   string     `json:"-" gorm:"index;size:64"`
+	Attempts   int        `json:"attempts"`
+	IPAddress  string     `json:"ip_address"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	UsedAt     *time.Time `json:"used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// 员工JWT Claims
+type EmployeeClaims struct {
+	EmployeeID   uint   `json:"employee_id"`
+	EmployeeNo   string `json:"employee_no"`
+	TokenVersion int    `json:"ver"`
+	PayrollID    uint   `json:"pid,omitempty"` // 通过工资条访问链接登录时只能访问该工资条
+	LinkID       uint   `json:"lid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// 请求验证码或登录链接，identifier 为员工登记的邮箱或手机号
+type EmployeeLoginRequest struct {
+	Identifier string `json:"identifier" binding:"required"`
+}
+
+type EmployeeOTPVerifyRequest struct {
+	Identifier string `json:"identifier" binding:"required"`
+	Code       string `json:"code" binding:"required"`
+}
+
+type EmployeeMagicLinkVerifyRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// 员工登录响应
+type EmployeeLoginResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Employee  Employee  `json:"employee"`
+}
+
+// 开通门户的员工设置
+type EmployeePortalRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// 按邮箱或手机号查找可以登录门户的员工，返回发送渠道
+func findPortalEmployee(identifier string) (Employee, string, bool) {
+	identifier = strings.TrimSpace(identifier)
+	channel, column := "sms", "phone"
+	if strings.Contains(identifier, "@") {
+		channel, column = "email", "LOWER(email)"
+		identifier = strings.ToLower(identifier)
+	}
+
+	var employee Employee
+	if identifier == "" || db.Where(column+" = ? AND portal_enabled = ? AND deleted_at IS NULL", identifier, true).
+		Order("id").First(&employee).Error != nil {
+		return Employee{}, "", false
+	}
+	return employee, channel, true
+}
+
+func generateNumericCode(digits int) string {
+	max := big.NewInt(1)
+	for i := 0; i < digits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%0*d", digits, n)
+}
+
+func hashEmployeeCode(employeeID uint, code string) string {
+	return hashResetToken(fmt.Sprintf("%d:%s", employeeID, code))
+}
+
+// 是否在冷却期内刚发送过
+func employeeCodeRecentlySent(employeeID uint) bool {
+	var count int64
+	db.Model(&EmployeeLoginCode{}).
+		Where("employee_id = ? AND created_at > ?", employeeID, time.Now().Add(-employeeCodeCooldown)).
+		Count(&count)
+	return count > 0
+}
+
+// 生成并发送登录验证码或登录链接
+func sendEmployeeLoginCode(c *gin.Context, employee Employee, channel, method string) error {
+	var secret, hash, subject, body string
+	ttl := appConfig.Auth.EmployeeCodeTTL
+
+	switch method {
+	case "otp":
+		secret = generateNumericCode(6)
+		hash = hashEmployeeCode(employee.ID, secret)
+		subject = "工资条查询验证码"
+		body = fmt.Sprintf("%s，您好：\n\n您的工资条查询验证码为 %s，%d 分钟内有效。如非本人操作请忽略。",
+			employee.Name, secret, int(ttl.Minutes()))
+	case "magic_link":
+		secret = generateSecureToken()
+		hash = hashResetToken(secret)
+		link := fmt.Sprintf("%s/web/employee-login.html?token=%s", appConfig.Server.PublicURL, url.QueryEscape(secret))
+		subject = "工资条查询登录链接"
+		body = fmt.Sprintf("%s，您好：\n\n点击以下链接登录查看工资条，%d 分钟内有效且只能使用一次：\n%s\n\n如非本人操作请忽略。",
+			employee.Name, int(ttl.Minutes()), link)
+	}
+
+	if err := db.Create(&EmployeeLoginCode{
+		EmployeeID: employee.ID,
+		Method:     method,
+		Channel:    channel,
+		CodeHash:   hash,
+		IPAddress:  c.ClientIP(),
+		ExpiresAt:  time.Now().Add(ttl),
+	}).Error; err != nil {
+		return err
+	}
+
+	recipient := employee.Phone
+	if channel == "email" {
+		recipient = employee.Email
+	}
+	return sendMessage(channel, recipient, subject, body)
+}
+
+// 发送验证码或登录链接，无论员工是否存在都返回相同结果，避免探测
+func requestEmployeeLogin(method string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req EmployeeLoginRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "请输入邮箱或手机号"})
+			return
+		}
+		if !checkIPLockout(c, req.Identifier) {
+			return
+		}
+
+		if employee, channel, ok := findPortalEmployee(req.Identifier); ok && !employeeCodeRecentlySent(employee.ID) {
+			// 发送失败只记录在服务端，响应与账号不存在时相同
+			if err := sendEmployeeLoginCode(c, employee, channel, method); err != nil {
+				logSecurityEvent("employee_login_delivery_failed", map[string]interface{}{
+					"employee_id": employee.ID,
+					"channel":     channel,
+					"error":       err.Error(),
+				})
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":    "如果账号存在，验证信息已发送，请查收",
+			"expires_in": int(appConfig.Auth.EmployeeCodeTTL.Seconds()),
+		})
+	}
+}
+
+// 使用验证码登录
+func verifyEmployeeOTP(c *gin.Context) {
+	var req EmployeeOTPVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+	if !checkIPLockout(c, req.Identifier) {
+		return
+	}
+
+	employee, _, ok := findPortalEmployee(req.Identifier)
+	var code EmployeeLoginCode
+	if ok {
+		ok = db.Where("employee_id = ? AND method = ? AND used_at IS NULL AND expires_at > ? AND attempts < ?",
+			employee.ID, "otp", time.Now(), employeeCodeAttempts).
+			Order("created_at DESC").First(&code).Error == nil
+	}
+	if ok {
+		db.Model(&code).Update("attempts", gorm.Expr("attempts + 1"))
+		ok = code.CodeHash == hashEmployeeCode(employee.ID, strings.TrimSpace(req.Code))
+	}
+	if ok {
+		ok = consumeEmployeeLoginCode(code.ID)
+	}
+	if !ok {
+		failures := recordLoginFailure(c, nil, "employee:"+req.Identifier)
+		time.Sleep(loginFailureDelay(failures))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "验证码错误或已过期"})
+		return
+	}
+
+	completeEmployeeLogin(c, employee)
+}
+
+// 使用登录链接登录
+func verifyEmployeeMagicLink(c *gin.Context) {
+	var req EmployeeMagicLinkVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+
+	var code EmployeeLoginCode
+	if err := db.Where("code_hash = ? AND method = ? AND used_at IS NULL AND expires_at > ?",
+		hashResetToken(req.Token), "magic_link", time.Now()).First(&code).Error; err != nil || !consumeEmployeeLoginCode(code.ID) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "登录链接无效或已过期"})
+		return
+	}
+
+	var employee Employee
+	if err := db.Where("id = ? AND portal_enabled = ? AND deleted_at IS NULL", code.EmployeeID, true).First(&employee).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "登录链接无效或已过期"})
+		return
+	}
+
+	completeEmployeeLogin(c, employee)
+}
+
+// 条件更新保证验证码或链接只能使用一次
+func consumeEmployeeLoginCode(id uint) bool {
+	now := time.Now()
+	result := db.Model(&EmployeeLoginCode{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", &now)
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+func completeEmployeeLogin(c *gin.Context, employee Employee) {
+	token, expiresAt, err := generateEmployeeToken(employee, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成token失败"})
+		return
+	}
+
+	now := time.Now()
+	db.Model(&employee).Update("portal_last_login_at", &now)
+
+	c.JSON(http.StatusOK, EmployeeLoginResponse{
+		Token:     token,
+		ExpiresAt: expiresAt,
+		Employee:  employee,
+	})
+}
+
+// 生成员工访问令牌，link 不为空时令牌只能访问该链接对应的工资条
+func generateEmployeeToken(employee Employee, link *PayslipAccessLink) (string, time.Time, error) {
+	expiresAt := time.Now().Add(appConfig.Auth.EmployeeTokenTTL)
+	claims := EmployeeClaims{
+		EmployeeID:   employee.ID,
+		EmployeeNo:   employee.EmployeeNo,
+		TokenVersion: employee.PortalTokenVersion,
+	}
+	if link != nil {
+		if link.ExpiresAt.Before(expiresAt) {
+			expiresAt = link.ExpiresAt
+		}
+		claims.PayrollID = link.PayrollID
+		claims.LinkID = link.ID
+	}
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        generateUUID(),
+		Subject:   fmt.Sprint(employee.ID),
+		Audience:  jwt.ClaimStrings{employeeAudience},
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		Issuer:    "payroll",
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, err := token.SignedString([]byte(appConfig.Auth.JWTSecret))
+	return tokenString, expiresAt, err
+}
+
+// 验证员工访问令牌，门户关闭、令牌版本变化或访问链接被吊销后立即失效
+func verifyEmployeeToken(tokenString string) (*EmployeeClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &EmployeeClaims{}, func(token *jwt.Token) (interface{}, error) {
+		return []byte(appConfig.Auth.JWTSecret), nil
+	}, jwt.WithAudience(employeeAudience), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(*EmployeeClaims)
+	if !ok || !token.Valid {
+		return nil, jwt.ErrSignatureInvalid
+	}
+
+	var employee Employee
+	if err := db.Select("id", "portal_enabled", "portal_token_version", "deleted_at").First(&employee, claims.EmployeeID).Error; err != nil ||
+		!employee.PortalEnabled || employee.DeletedAt != nil || employee.PortalTokenVersion != claims.TokenVersion {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	if claims.LinkID != 0 && isPayslipLinkRevoked(claims.LinkID) {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	return claims, nil
+}
+
+// 在上下文中记录当前员工及访问范围
+func setEmployeeContext(c *gin.Context, claims *EmployeeClaims) {
+	c.Set("employee_id", claims.EmployeeID)
+	if claims.PayrollID != 0 {
+		c.Set("payroll_scope", claims.PayrollID)
+	}
+}
+
+// 员工令牌中间件
+func employeeAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, err := verifyEmployeeToken(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "请先登录", "code": "employee_login_required"})
+			c.Abort()
+			return
+		}
+		setEmployeeContext(c, claims)
+		c.Next()
+	}
+}
+
+// 工资条接口：员工只能访问自己的数据，管理员凭 payrolls:read 权限预览
+func payslipAccessMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if claims, err := verifyEmployeeToken(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")); err == nil {
+			setEmployeeContext(c, claims)
+			c.Next()
+			return
+		}
+		if !authorizeAdmin(c) {
+			return
+		}
+		if !hasPermission(c, PermPayrollsRead) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "权限不足", "required_permission": PermPayrollsRead})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// 当前请求的员工，管理员请求时 ok 为 false
+func currentEmployeeID(c *gin.Context) (uint, bool) {
+	id, ok := c.Get("employee_id")
+	if !ok {
+		return 0, false
+	}
+	return id.(uint), true
+}
+
+// 通过工资条访问链接登录时可访问的工资条
+func currentPayrollScope(c *gin.Context) (uint, bool) {
+	id, ok := c.Get("payroll_scope")
+	if !ok {
+		return 0, false
+	}
+	return id.(uint), true
+}
+
+// 当前请求是否可以查看某个工资条：员工只能看自己已发布的工资条
+func canViewPayroll(c *gin.Context, payroll Payroll) bool {
+	if employeeID, ok := currentEmployeeID(c); ok {
+		if scope, scoped := currentPayrollScope(c); scoped && scope != payroll.ID {
+			return false
+		}
+		return payroll.EmployeeID == employeeID && payroll.Status != "draft"
+	}
+	return canAccessEmployee(c, payroll.EmployeeID)
+}
+
+// 当前登录员工的信息
+func getEmployeeProfile(c *gin.Context) {
+	employeeID, _ := currentEmployeeID(c)
+	var employee Employee
+	if err := db.First(&employee, employeeID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": employee})
+}
+
+// 开通或关闭员工门户，关闭后已签发的员工令牌立即失效
+func updateEmployeePortal(c *gin.Context) {
+	var employee Employee
+	if err := db.Where("deleted_at IS NULL").First(&employee, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
+		return
+	}
+	if !canAccessEmployee(c, employee.ID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
+		return
+	}
+
+	var req EmployeePortalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before := employee
+	if err := db.Model(&employee).Updates(map[string]interface{}{
+		"portal_enabled":       req.Enabled,
+		"portal_token_version": gorm.Expr("portal_token_version + 1"),
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	db.First(&employee, employee.ID)
+	recordAudit(c, "employee.portal_update", "employee", employee.ID, before, employee)
+	c.JSON(http.StatusOK, gin.H{"data": employee})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func createPortalEmployee(t *testing.T, no, email string) Employee {
+	t.Helper()
+	employee := Employee{Name: "员工" + no, EmployeeNo: no, Email: email, Status: "active", PortalEnabled: true}
+	if err := db.Create(&employee).Error; err != nil {
+		t.Fatal(err)
+	}
+	return employee
+}
+
+// 投递失败时的响应与账号不存在时相同，失败只记录在服务端
+func TestRequestEmployeeLoginResponse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupTestDB(t)
+	// 非 dev 环境未配置邮件服务，投递必然失败
+	createPortalEmployee(t, "E001", "zhangsan@example.com")
+
+	request := func(identifier string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/employee-auth/otp", strings.NewReader(`{"identifier": "`+identifier+`"}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		requestEmployeeLogin("otp")(c)
+		return w
+	}
+	unknown := request("nobody@example.com")
+	for _, identifier := range []string{"zhangsan@example.com", "ZhangSan@example.com"} {
+		w := request(identifier)
+		if w.Code != unknown.Code || w.Body.String() != unknown.Body.String() {
+			t.Errorf("%s: got %d %s, want same as unknown account %d %s", identifier, w.Code, w.Body, unknown.Code, unknown.Body)
+		}
+	}
+	if unknown.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200", unknown.Code)
+	}
+}
+
+// 管理员令牌和员工令牌的受众不同，不能互相通用
+func TestTokenAudienceSeparation(t *testing.T) {
+	setupTestDB(t)
+	employee := createPortalEmployee(t, "E001", "zhangsan@example.com")
+	employeeToken, _, err := generateEmployeeToken(employee, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminToken, _, err := generateJWTToken(AdminUser{ID: 1, Username: "admin", Role: RoleAdmin}, generateUUID())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		token           string
+		admin, employee bool
+	}{
+		{"admin token", adminToken, true, false},
+		{"employee token", employeeToken, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := verifyJWTToken(tt.token); (err == nil) != tt.admin {
+				t.Errorf("verifyJWTToken error = %v, want accepted = %v", err, tt.admin)
+			}
+			if _, err := verifyEmployeeToken(tt.token); (err == nil) != tt.employee {
+				t.Errorf("verifyEmployeeToken error = %v, want accepted = %v", err, tt.employee)
+			}
+		})
+	}
+}
+
+// 员工令牌只能查看本人已发布的工资条
+func TestEmployeeCannotViewOtherPayslips(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupTestDB(t)
+	zhang := createPortalEmployee(t, "E001", "zhangsan@example.com")
+	li := createPortalEmployee(t, "E002", "lisi@example.com")
+	payroll := func(employee Employee, status string) Payroll {
+		p := Payroll{UUID: generateUUID(), EmployeeID: employee.ID, Period: "2024-09", PayrollData: "{}", Status: status}
+		if err := db.Create(&p).Error; err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	token, _, err := generateEmployeeToken(zhang, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.GET("/api/v1/payrolls/:id", payslipAccessMiddleware(), getPayroll)
+	tests := []struct {
+		name    string
+		payroll Payroll
+		status  int
+	}{
+		{"own published", payroll(zhang, "published"), http.StatusOK},
+		{"own draft", payroll(zhang, "draft"), http.StatusNotFound},
+		{"other employee", payroll(li, "published"), http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/payrolls/"+tt.payroll.UUID, nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			router.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d, body = %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
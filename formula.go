@@ -0,0 +1,534 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/shopspring/decimal"
+)
+
+// 工资模板公式
+//
+// 公式只支持数字、字段名、四则运算和取余、比较（结果为1或0）、&& || !、括号，
+// 以及 min、max、abs、round、floor、ceil、if 几个内置函数，没有赋值、循环和外部访问。
+// 公式在保存模板时解析和检查依赖，计算工资时按依赖顺序以十进制定点数求值，除法保留16位小数。
+
+const (
+	maxFormulaLength = 500
+	maxFormulaDepth  = 32
+)
+
+// 公式中可直接使用的出勤变量，模板字段不能使用这些名称
+var formulaBuiltinVars = map[string]bool{
+	"work_days":  true,
+	"month_days": true,
+}
+
+type formulaEnv map[string]decimal.Decimal
+
+type formulaNode interface {
+	eval(env formulaEnv) (decimal.Decimal, error)
+}
+
+type numberNode struct{ value decimal.Decimal }
+
+type identNode string
+
+type unaryNode struct {
+	op      string
+	operand formulaNode
+}
+
+type binaryNode struct {
+	op          string
+	left, right formulaNode
+}
+
+type callNode struct {
+	name string
+	args []formulaNode
+}
+
+// 内置函数及参数个数，-1 表示至少一个参数
+var formulaFuncs = map[string]int{
+	"min":   -1,
+	"max":   -1,
+	"abs":   1,
+	"round": 2,
+	"floor": 1,
+	"ceil":  1,
+	"if":    3,
+}
+
+var errDivisionByZero = errors.New("除数为0")
+
+var (
+	decimalOne     = decimal.NewFromInt(1)
+	maxRoundPlaces = decimal.NewFromInt(6)
+)
+
+func (n numberNode) eval(formulaEnv) (decimal.Decimal, error) { return n.value, nil }
+
+func (n identNode) eval(env formulaEnv) (decimal.Decimal, error) { return env[string(n)], nil }
+
+func (n unaryNode) eval(env formulaEnv) (decimal.Decimal, error) {
+	v, err := n.operand.eval(env)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if n.op == "!" {
+		return boolDecimal(v.IsZero()), nil
+	}
+	return v.Neg(), nil
+}
+
+func (n binaryNode) eval(env formulaEnv) (decimal.Decimal, error) {
+	l, err := n.left.eval(env)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	// && 和 || 短路求值
+	switch n.op {
+	case "&&":
+		if l.IsZero() {
+			return decimal.Zero, nil
+		}
+	case "||":
+		if !l.IsZero() {
+			return decimalOne, nil
+		}
+	}
+	r, err := n.right.eval(env)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	switch n.op {
+	case "+":
+		return l.Add(r), nil
+	case "-":
+		return l.Sub(r), nil
+	case "*":
+		return l.Mul(r), nil
+	case "/":
+		if r.IsZero() {
+			return decimal.Zero, errDivisionByZero
+		}
+		return l.Div(r), nil
+	case "%":
+		if r.IsZero() {
+			return decimal.Zero, errDivisionByZero
+		}
+		return l.Mod(r), nil
+	case "<":
+		return boolDecimal(l.LessThan(r)), nil
+	case "<=":
+		return boolDecimal(l.LessThanOrEqual(r)), nil
+	case ">":
+		return boolDecimal(l.GreaterThan(r)), nil
+	case ">=":
+		return boolDecimal(l.GreaterThanOrEqual(r)), nil
+	case "==":
+		return boolDecimal(l.Equal(r)), nil
+	case "!=":
+		return boolDecimal(!l.Equal(r)), nil
+	case "&&", "||":
+		return boolDecimal(!r.IsZero()), nil
+	}
+	return decimal.Zero, fmt.Errorf("未知运算符 %s", n.op)
+}
+
+func (n callNode) eval(env formulaEnv) (decimal.Decimal, error) {
+	// if 只计算被选中的分支
+	if n.name == "if" {
+		cond, err := n.args[0].eval(env)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		if !cond.IsZero() {
+			return n.args[1].eval(env)
+		}
+		return n.args[2].eval(env)
+	}
+
+	args := make([]decimal.Decimal, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		args[i] = v
+	}
+	switch n.name {
+	case "min":
+		return decimal.Min(args[0], args[1:]...), nil
+	case "max":
+		return decimal.Max(args[0], args[1:]...), nil
+	case "abs":
+		return args[0].Abs(), nil
+	case "round":
+		// 四舍五入，.5 向远离0的方向进位
+		if !args[1].IsInteger() || args[1].IsNegative() || args[1].GreaterThan(maxRoundPlaces) {
+			return decimal.Zero, errors.New("round 的小数位数须为0到6的整数")
+		}
+		return args[0].Round(int32(args[1].IntPart())), nil
+	case "floor":
+		return args[0].Floor(), nil
+	case "ceil":
+		return args[0].Ceil(), nil
+	}
+	return decimal.Zero, fmt.Errorf("未知函数 %s", n.name)
+}
+
+func boolDecimal(b bool) decimal.Decimal {
+	if b {
+		return decimalOne
+	}
+	return decimal.Zero
+}
+
+// 词法单元
+type formulaToken struct {
+	kind  string // number, ident, op, end
+	text  string
+	value decimal.Decimal
+	pos   int
+}
+
+func tokenizeFormula(src string) ([]formulaToken, error) {
+	var tokens []formulaToken
+	for i := 0; i < len(src); {
+		r, size := utf8.DecodeRuneInString(src[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r >= '0' && r <= '9' || r == '.':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+			v, err := decimal.NewFromString(src[start:i])
+			if err != nil || strings.Count(src[start:i], ".") > 1 {
+				return nil, fmt.Errorf("位置 %d: 无效的数字 %q", start+1, src[start:i])
+			}
+			tokens = append(tokens, formulaToken{kind: "number", text: src[start:i], value: v, pos: start})
+		case r == '_' || unicode.IsLetter(r):
+			start := i
+			for i < len(src) {
+				r, size := utf8.DecodeRuneInString(src[i:])
+				if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				i += size
+			}
+			tokens = append(tokens, formulaToken{kind: "ident", text: src[start:i], pos: start})
+		default:
+			op := ""
+			for _, candidate := range []string{"<=", ">=", "==", "!=", "&&", "||", "+", "-", "*", "/", "%", "<", ">", "!", "(", ")", ","} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("位置 %d: 不支持的字符 %q", i+1, r)
+			}
+			tokens = append(tokens, formulaToken{kind: "op", text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, formulaToken{kind: "end", pos: len(src)}), nil
+}
+
+// 运算符优先级，数字越大越先计算
+var formulaPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3,
+	"<": 4, "<=": 4, ">": 4, ">=": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+}
+
+type formulaParser struct {
+	tokens []formulaToken
+	pos    int
+	depth  int
+	idents map[string]bool
+}
+
+// 解析公式，返回语法树和引用到的字段名
+func parseFormula(src string) (formulaNode, []string, error) {
+	if strings.TrimSpace(src) == "" {
+		return nil, nil, errors.New("公式为空")
+	}
+	if len(src) > maxFormulaLength {
+		return nil, nil, fmt.Errorf("公式不能超过 %d 个字符", maxFormulaLength)
+	}
+	tokens, err := tokenizeFormula(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := &formulaParser{tokens: tokens, idents: map[string]bool{}}
+	node, err := p.parseExpr(0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tok := p.peek(); tok.kind != "end" {
+		return nil, nil, fmt.Errorf("位置 %d: 多余的 %q", tok.pos+1, tok.text)
+	}
+	idents := make([]string, 0, len(p.idents))
+	for name := range p.idents {
+		idents = append(idents, name)
+	}
+	sort.Strings(idents)
+	return node, idents, nil
+}
+
+func (p *formulaParser) peek() formulaToken { return p.tokens[p.pos] }
+
+func (p *formulaParser) next() formulaToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != "end" {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *formulaParser) expect(op string) error {
+	tok := p.next()
+	if tok.kind != "op" || tok.text != op {
+		return fmt.Errorf("位置 %d: 缺少 %q", tok.pos+1, op)
+	}
+	return nil
+}
+
+// 按优先级解析二元运算
+func (p *formulaParser) parseExpr(minPrec int) (formulaNode, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxFormulaDepth {
+		return nil, errors.New("公式嵌套过深")
+	}
+
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		prec, ok := formulaPrecedence[tok.text]
+		if tok.kind != "op" || !ok || prec <= minPrec {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseExpr(prec)
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: tok.text, left: left, right: right}
+	}
+}
+
+func (p *formulaParser) parseUnary() (formulaNode, error) {
+	tok := p.peek()
+	if tok.kind == "op" && (tok.text == "-" || tok.text == "+" || tok.text == "!") {
+		p.next()
+		p.depth++
+		defer func() { p.depth-- }()
+		if p.depth > maxFormulaDepth {
+			return nil, errors.New("公式嵌套过深")
+		}
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if tok.text == "+" {
+			return operand, nil
+		}
+		return unaryNode{op: tok.text, operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *formulaParser) parsePrimary() (formulaNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case "number":
+		return numberNode{tok.value}, nil
+	case "ident":
+		if p.peek().kind == "op" && p.peek().text == "(" {
+			return p.parseCall(tok)
+		}
+		p.idents[tok.text] = true
+		return identNode(tok.text), nil
+	case "op":
+		if tok.text == "(" {
+			node, err := p.parseExpr(0)
+			if err != nil {
+				return nil, err
+			}
+			return node, p.expect(")")
+		}
+	case "end":
+		return nil, errors.New("公式不完整")
+	}
+	return nil, fmt.Errorf("位置 %d: 意外的 %q", tok.pos+1, tok.text)
+}
+
+func (p *formulaParser) parseCall(name formulaToken) (formulaNode, error) {
+	arity, ok := formulaFuncs[name.text]
+	if !ok {
+		return nil, fmt.Errorf("位置 %d: 不支持的函数 %s", name.pos+1, name.text)
+	}
+	p.next() // (
+	var args []formulaNode
+	if !(p.peek().kind == "op" && p.peek().text == ")") {
+		for {
+			arg, err := p.parseExpr(0)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.peek().kind == "op" && p.peek().text == "," {
+				p.next()
+				continue
+			}
+			break
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if arity == -1 && len(args) == 0 || arity > 0 && len(args) != arity {
+		return nil, fmt.Errorf("函数 %s 的参数个数不正确", name.text)
+	}
+	return callNode{name: name.text, args: args}, nil
+}
+
+// 编译模板中的公式字段：检查引用的字段存在且为数字，按依赖排序，发现循环依赖时报错
+func compileTemplateFormulas(schema *TemplateSchema) FieldErrors {
+	errs := FieldErrors{}
+	deps := map[string][]string{}
+	schema.Formulas = map[string]formulaNode{}
+	for _, key := range schema.Keys {
+		field := schema.Fields[key]
+		if field.Formula == "" {
+			continue
+		}
+		node, idents, err := parseFormula(field.Formula)
+		if err != nil {
+			errs[key] = "公式错误: " + err.Error()
+			continue
+		}
+		for _, name := range idents {
+			if formulaBuiltinVars[name] {
+				continue
+			}
+			ref, ok := schema.Fields[name]
+			if !ok {
+				errs[key] = fmt.Sprintf("公式引用了不存在的字段 %s", name)
+				break
+			}
+			if ref.Type == "text" {
+				errs[key] = fmt.Sprintf("公式不能引用文本字段 %s", name)
+				break
+			}
+			if ref.Calculator != "" {
+				errs[key] = fmt.Sprintf("公式不能引用系统计算字段 %s", name)
+				break
+			}
+			if schema.Fields[name].Formula != "" {
+				deps[key] = append(deps[key], name)
+			}
+		}
+		schema.Formulas[key] = node
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	// 深度优先拓扑排序，遇到正在访问的节点即为循环依赖
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := map[string]int{}
+	var order []string
+	var path []string
+	var visit func(key string) bool
+	visit = func(key string) bool {
+		switch state[key] {
+		case done:
+			return true
+		case visiting:
+			start := 0
+			for i, k := range path {
+				if k == key {
+					start = i
+				}
+			}
+			cycle := append(append([]string{}, path[start:]...), key)
+			errs[key] = "公式循环依赖: " + strings.Join(cycle, " → ")
+			return false
+		}
+		state[key] = visiting
+		path = append(path, key)
+		for _, dep := range deps[key] {
+			if !visit(dep) {
+				return false
+			}
+		}
+		path = path[:len(path)-1]
+		state[key] = done
+		order = append(order, key)
+		return true
+	}
+	for _, key := range schema.Keys {
+		if _, ok := schema.Formulas[key]; ok && !visit(key) {
+			return errs
+		}
+	}
+	schema.FormulaOrder = order
+	return nil
+}
+
+// 公式算出的信息项（如小时工资、系数）保留的小数位
+const formulaInformationalPlaces = 4
+
+// 按依赖顺序计算公式字段，把结果写入 data；引用的输入字段未填写时按0计算。
+// 收入、扣款和单位缴纳的计算结果按单项舍入规则取整，信息项保留4位小数，后续公式引用取整后的值
+func evaluateTemplateFormulas(schema TemplateSchema, data map[string]interface{}, workDays, monthDays float64) FieldErrors {
+	if len(schema.FormulaOrder) == 0 {
+		return nil
+	}
+	env := formulaEnv{"work_days": decimal.NewFromFloat(workDays), "month_days": decimal.NewFromFloat(monthDays)}
+	for key, field := range schema.Fields {
+		if field.Type != "text" {
+			env[key], _ = decimalValue(data[key])
+		}
+	}
+	errs := FieldErrors{}
+	for _, key := range schema.FormulaOrder {
+		v, err := schema.Formulas[key].eval(env)
+		if err != nil {
+			errs[key] = "公式计算失败: " + err.Error()
+			continue
+		}
+		if schema.Fields[key].Kind == FieldKindInformational {
+			v = v.Round(formulaInformationalPlaces)
+		} else {
+			v = appConfig.Payroll.Rounding.Line.round(v)
+		}
+		env[key] = v
+		data[key] = newMoney(v)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
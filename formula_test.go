@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func evalFormula(t *testing.T, src string, env formulaEnv) (decimal.Decimal, error) {
+	t.Helper()
+	node, _, err := parseFormula(src)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return node.eval(env)
+}
+
+func TestFormulaEvaluation(t *testing.T) {
+	env := formulaEnv{
+		"basic_salary":   decimal.NewFromInt(8000),
+		"overtime_hours": decimal.NewFromInt(10),
+		"work_days":      decimal.NewFromInt(20),
+		"month_days":     decimal.NewFromInt(22),
+	}
+	tests := []struct {
+		formula string
+		want    string
+	}{
+		{"1 + 2 * 3", "7"},
+		{"(1 + 2) * 3", "9"},
+		{"10 - 4 - 3", "3"},
+		{"24 / 4 / 2", "3"},
+		{"7 % 4 * 2", "6"},
+		{"-2 * 3 + 10", "4"},
+		{"!0 + 1", "2"},
+		{"1 + 2 > 2", "1"},
+		{"1 < 2 == 1", "1"},
+		{"0 || 1 && 0", "0"},
+		{"1 || 0 && 0", "1"},
+		{"basic_salary / month_days * work_days", "7272.727272727272728"},
+		{"round(basic_salary / month_days * work_days, 2)", "7272.73"},
+		{"round(2.5, 0)", "3"},
+		{"round(-2.5, 0)", "-3"},
+		{"floor(-1.5) + ceil(1.2)", "0"},
+		{"min(3, basic_salary, 5) + max(1, 2)", "5"},
+		{"abs(-overtime_hours)", "10"},
+		{"if(overtime_hours > 8, 100, 0)", "100"},
+		{"if(0, 1 / 0, 2)", "2"},
+		{"0 && 1 / 0", "0"},
+		{"missing_field + 1", "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.formula, func(t *testing.T) {
+			got, err := evalFormula(t, tt.formula, env)
+			if err != nil {
+				t.Fatalf("eval(%q) error: %v", tt.formula, err)
+			}
+			if want := decimal.RequireFromString(tt.want); !got.Equal(want) {
+				t.Errorf("eval(%q) = %s, want %s", tt.formula, got, want)
+			}
+		})
+	}
+}
+
+func TestFormulaErrors(t *testing.T) {
+	nested := func(n int) string {
+		return strings.Repeat("(", n) + "1" + strings.Repeat(")", n)
+	}
+	tests := []struct {
+		name    string
+		formula string
+		wantErr string
+	}{
+		{"empty", "  ", "公式为空"},
+		{"too long", strings.Repeat("1+", maxFormulaLength/2) + "1", "公式不能超过"},
+		{"bad character", "basic_salary $ 2", "不支持的字符"},
+		{"bad number", "1.2.3", "无效的数字"},
+		{"unknown function", "sqrt(4)", "不支持的函数 sqrt"},
+		{"wrong arity", "round(1)", "参数个数不正确"},
+		{"no arguments", "min()", "参数个数不正确"},
+		{"incomplete", "1 +", "公式不完整"},
+		{"unbalanced", "(1 + 2", `缺少 ")"`},
+		{"trailing token", "1 2", "多余的"},
+		{"nesting at limit", nested(maxFormulaDepth - 1), ""},
+		{"nesting over limit", nested(maxFormulaDepth), "公式嵌套过深"},
+		{"unary nesting over limit", strings.Repeat("-", maxFormulaDepth) + "1", "公式嵌套过深"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := parseFormula(tt.formula)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("parseFormula(%q) error: %v", tt.formula, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseFormula(%q) error = %v, want containing %q", tt.formula, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFormulaRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    error
+		wantErr string
+	}{
+		{formula: "1 / 0", want: errDivisionByZero},
+		{formula: "5 % (2 - 2)", want: errDivisionByZero},
+		{formula: "if(1, 1 / 0, 2)", want: errDivisionByZero},
+		{formula: "round(1.234, 7)", wantErr: "round 的小数位数"},
+		{formula: "round(1.234, 1.5)", wantErr: "round 的小数位数"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.formula, func(t *testing.T) {
+			_, err := evalFormula(t, tt.formula, formulaEnv{})
+			switch {
+			case tt.want != nil && !errors.Is(err, tt.want):
+				t.Errorf("eval(%q) error = %v, want %v", tt.formula, err, tt.want)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("eval(%q) error = %v, want containing %q", tt.formula, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompileTemplateFormulas(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  string
+		wantErr string
+		order   []string
+	}{
+		{
+			name: "dependency order",
+			fields: `{
+				"overtime_pay": {"name": "加班费", "kind": "earning", "formula": "overtime_hours * hourly_rate * 1.5"},
+				"hourly_rate": {"name": "时薪", "kind": "informational", "formula": "basic_salary / 21.75 / 8"},
+				"basic_salary": {"name": "基本工资", "kind": "earning"},
+				"overtime_hours": {"name": "加班小时", "kind": "informational"}
+			}`,
+			order: []string{"hourly_rate", "overtime_pay"},
+		},
+		{
+			name:    "unknown identifier",
+			fields:  `{"bonus": {"name": "奖金", "kind": "earning", "formula": "basic_salary * 0.1"}}`,
+			wantErr: "公式引用了不存在的字段 basic_salary",
+		},
+		{
+			name: "text field",
+			fields: `{
+				"remark": {"name": "备注", "kind": "informational", "type": "text"},
+				"bonus": {"name": "奖金", "kind": "earning", "formula": "remark + 1"}
+			}`,
+			wantErr: "公式不能引用文本字段 remark",
+		},
+		{
+			name:    "self reference",
+			fields:  `{"bonus": {"name": "奖金", "kind": "earning", "formula": "bonus + 1"}}`,
+			wantErr: "公式循环依赖: bonus → bonus",
+		},
+		{
+			name: "cycle",
+			fields: `{
+				"a": {"name": "A", "kind": "earning", "formula": "b + 1"},
+				"b": {"name": "B", "kind": "earning", "formula": "c + 1"},
+				"c": {"name": "C", "kind": "earning", "formula": "a + 1"}
+			}`,
+			wantErr: "公式循环依赖: a → b → c → a",
+		},
+		{
+			name: "built-in attendance variables",
+			fields: `{
+				"basic_salary": {"name": "基本工资", "kind": "earning"},
+				"attendance_pay": {"name": "出勤工资", "kind": "earning", "formula": "basic_salary * work_days / month_days"}
+			}`,
+			order: []string{"attendance_pay"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := parseTemplateSchema(tt.fields)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseTemplateSchema() error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTemplateSchema() error: %v", err)
+			}
+			if strings.Join(schema.FormulaOrder, ",") != strings.Join(tt.order, ",") {
+				t.Errorf("FormulaOrder = %v, want %v", schema.FormulaOrder, tt.order)
+			}
+		})
+	}
+}
+
+func TestEvaluateTemplateFormulasRounding(t *testing.T) {
+	schema, err := parseTemplateSchema(`{
+		"basic_salary": {"name": "基本工资", "kind": "earning"},
+		"hourly_rate": {"name": "时薪", "kind": "informational", "formula": "basic_salary / 21.75 / 8"},
+		"overtime_pay": {"name": "加班费", "kind": "earning", "formula": "hourly_rate * 10 * 1.5"},
+		"leave_deduction": {"name": "事假扣款", "kind": "deduction", "formula": "basic_salary / 0"}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]interface{}{"basic_salary": 8000.0}
+	errs := evaluateTemplateFormulas(schema, data, 21.75, 21.75)
+	if !strings.Contains(errs["leave_deduction"], errDivisionByZero.Error()) {
+		t.Errorf("leave_deduction error = %q, want division by zero", errs["leave_deduction"])
+	}
+	// 信息项保留4位小数，后续公式引用取整后的值，收入项按单项规则舍入到分
+	if got := data["hourly_rate"].(Money); got.String() != "45.977" {
+		t.Errorf("hourly_rate = %s, want 45.977", got)
+	}
+	if got := data["overtime_pay"].(Money); got.String() != "689.66" {
+		t.Errorf("overtime_pay = %s, want 689.66", got)
+	}
+}
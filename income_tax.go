@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// 个人所得税：工资薪金所得按累计预扣法预扣预缴
+//
+// 累计预扣预缴应纳税所得额 = 累计收入 − 累计免税收入 − 累计减除费用 − 累计专项扣除 − 累计专项附加扣除
+// 本期应预扣预缴税额 = 累计应纳税所得额 × 预扣率 − 速算扣除数 − 累计已预扣预缴税额
+//
+// 累计收入减专项扣除（三险一金）即各月工资条的应税所得（应税收入减税前扣除），
+// 累计减除费用按本年在本单位任职的月份数计算，累计已预扣税额取本年已发布的工资条。
+//
+// 只有已发布和已签收的工资条计入累计：同一期间已发布的其他工资条（如单独发放的奖金）一并计入，草稿不计入。
+// 累计预扣要求按月份顺序计算，本年之前月份还有草稿，或已有之后月份的工资条时不能计算本期个税。
+
+// 个人所得税配置
+type IncomeTaxConfig struct {
+	BasicDeduction float64      `yaml:"basic_deduction"` // 每月基本减除费用
+	Brackets       []TaxBracket `yaml:"brackets"`        // 预扣率表，按累计应纳税所得额（全年口径）从低到高排列
+}
+
+// 预扣率表的一级
+type TaxBracket struct {
+	UpTo           float64 `yaml:"up_to"`           // 本级上限（含），0 表示无上限，只能用于最后一级
+	Rate           float64 `yaml:"rate"`            // 预扣率，如 0.03
+	QuickDeduction float64 `yaml:"quick_deduction"` // 速算扣除数
+}
+
+// 个人所得税预扣率表一（居民个人工资、薪金所得预扣预缴适用）
+func defaultIncomeTaxConfig() IncomeTaxConfig {
+	return IncomeTaxConfig{
+		BasicDeduction: 5000,
+		Brackets: []TaxBracket{
+			{UpTo: 36000, Rate: 0.03, QuickDeduction: 0},
+			{UpTo: 144000, Rate: 0.10, QuickDeduction: 2520},
+			{UpTo: 300000, Rate: 0.20, QuickDeduction: 16920},
+			{UpTo: 420000, Rate: 0.25, QuickDeduction: 31920},
+			{UpTo: 660000, Rate: 0.30, QuickDeduction: 52920},
+			{UpTo: 960000, Rate: 0.35, QuickDeduction: 85920},
+			{UpTo: 0, Rate: 0.45, QuickDeduction: 181920},
+		},
+	}
+}
+
+func (c IncomeTaxConfig) validate() error {
+	if c.BasicDeduction < 0 {
+		return fmt.Errorf("payroll.income_tax.basic_deduction must not be negative")
+	}
+	if len(c.Brackets) == 0 {
+		return fmt.Errorf("payroll.income_tax.brackets must not be empty")
+	}
+	for i, b := range c.Brackets {
+		last := i == len(c.Brackets)-1
+		switch {
+		case last && b.UpTo != 0:
+			return fmt.Errorf("payroll.income_tax.brackets: the last bracket must have no up_to limit")
+		case !last && b.UpTo <= 0:
+			return fmt.Errorf("payroll.income_tax.brackets[%d].up_to must be positive", i)
+		case i > 0 && !last && b.UpTo <= c.Brackets[i-1].UpTo:
+			return fmt.Errorf("payroll.income_tax.brackets must be in ascending order of up_to")
+		case b.Rate < 0 || b.Rate > 1:
+			return fmt.Errorf("payroll.income_tax.brackets[%d].rate must be between 0 and 1", i)
+		case b.QuickDeduction < 0:
+			return fmt.Errorf("payroll.income_tax.brackets[%d].quick_deduction must not be negative", i)
+		}
+	}
+	return nil
+}
+
+// 按累计应纳税所得额查找适用的一级
+func (c IncomeTaxConfig) bracket(taxable decimal.Decimal) TaxBracket {
+	for _, b := range c.Brackets {
+		if b.UpTo == 0 || taxable.LessThanOrEqual(decimal.NewFromFloat(b.UpTo)) {
+			return b
+		}
+	}
+	return c.Brackets[len(c.Brackets)-1]
+}
+
+// 个税计算过程，随工资条返回，便于核对
+type IncomeTaxResult struct {
+	TaxYear                    int     `json:"tax_year"`
+	Months                     int     `json:"months"`                       // 本年在本单位任职月份数
+	CumulativeIncome           Money   `json:"cumulative_income"`            // 累计收入减专项扣除
+	CumulativeBasicDeduction   Money   `json:"cumulative_basic_deduction"`   // 累计减除费用
+	CumulativeSpecialDeduction Money   `json:"cumulative_special_deduction"` // 累计专项附加扣除
+	CumulativeTaxable          Money   `json:"cumulative_taxable"`           // 累计预扣预缴应纳税所得额
+	Rate                       float64 `json:"rate"`
+	QuickDeduction             float64 `json:"quick_deduction"`
+	CumulativeTax              Money   `json:"cumulative_tax"`  // 累计应预扣税额
+	WithheldBefore             Money   `json:"withheld_before"` // 本年已发布工资条的已预扣税额
+	Tax                        Money   `json:"tax"`             // 本期应预扣税额
+}
+
+// 计算本期个税：taxableIncome 为本期应税所得，specialDeduction 为本期专项附加扣除。
+// 同一员工本年截至 period 已发布和已签收的工资条计入累计
+func calculateIncomeTax(employee Employee, period string, taxableIncome, specialDeduction decimal.Decimal) (IncomeTaxResult, error) {
+	month, err := time.Parse("2006-01", period)
+	if err != nil {
+		return IncomeTaxResult{}, fmt.Errorf("工资期间格式应为 YYYY-MM")
+	}
+	year := month.Year()
+	yearStart, yearEnd := fmt.Sprintf("%d-01", year), fmt.Sprintf("%d-12", year)
+
+	var later Payroll
+	err = db.Select("period").Where("employee_id = ? AND period > ? AND period <= ?", employee.ID, period, yearEnd).
+		Order("period").First(&later).Error
+	if err == nil {
+		return IncomeTaxResult{}, fmt.Errorf("本年已有 %s 的工资条，不能再计算之前月份的个税，否则之后各期的累计预扣会失效", later.Period)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return IncomeTaxResult{}, err
+	}
+	var draft Payroll
+	err = db.Select("period").Where("employee_id = ? AND period >= ? AND period < ? AND status = ?", employee.ID, yearStart, period, "draft").
+		Order("period").First(&draft).Error
+	if err == nil {
+		return IncomeTaxResult{}, fmt.Errorf("本年 %s 的工资条尚未发布，请先发布之前月份的工资条再计算本期个税", draft.Period)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return IncomeTaxResult{}, err
+	}
+
+	var earlier []Payroll
+	if err := db.Select("taxable_income", "special_deduction", "income_tax").
+		Where("employee_id = ? AND period >= ? AND period <= ? AND status IN ?", employee.ID, yearStart, period, []string{"published", "signed"}).
+		Find(&earlier).Error; err != nil {
+		return IncomeTaxResult{}, err
+	}
+	income, special, withheld := taxableIncome, specialDeduction, decimal.Zero
+	for _, p := range earlier {
+		income = income.Add(p.TaxableIncome.Decimal)
+		special = special.Add(p.SpecialDeduction.Decimal)
+		withheld = withheld.Add(p.IncomeTax.Decimal)
+	}
+
+	// 任职月份：本年入职的从入职当月算起
+	startMonth := time.January
+	if employee.JoinDate != nil && employee.JoinDate.Year() == year {
+		startMonth = employee.JoinDate.Month()
+	}
+	months := int(month.Month()-startMonth) + 1
+	if months < 1 {
+		months = 1
+	}
+
+	cfg := appConfig.Payroll.IncomeTax
+	basic := decimal.NewFromFloat(cfg.BasicDeduction).Mul(decimal.NewFromInt(int64(months)))
+	taxable := decimal.Max(income.Sub(basic).Sub(special), decimal.Zero)
+	b := cfg.bracket(taxable)
+	cumulativeTax := decimal.Max(taxable.Mul(decimal.NewFromFloat(b.Rate)).Sub(decimal.NewFromFloat(b.QuickDeduction)), decimal.Zero)
+	// 累计应预扣税额小于已预扣税额时本期为0，多预扣的部分在年度汇算时退还
+	tax := appConfig.Payroll.Rounding.Line.round(decimal.Max(cumulativeTax.Sub(withheld), decimal.Zero))
+
+	return IncomeTaxResult{
+		TaxYear:                    year,
+		Months:                     months,
+		CumulativeIncome:           newMoney(income),
+		CumulativeBasicDeduction:   newMoney(basic),
+		CumulativeSpecialDeduction: newMoney(special),
+		CumulativeTaxable:          newMoney(taxable),
+		Rate:                       b.Rate,
+		QuickDeduction:             b.QuickDeduction,
+		CumulativeTax:              newMoney(cumulativeTax),
+		WithheldBefore:             newMoney(withheld),
+		Tax:                        newMoney(tax),
+	}, nil
+}
+
+// 个税明细行的说明：累计应纳税所得额 × 预扣率 − 速算扣除数 − 累计已预扣税额
+func (r IncomeTaxResult) note() string {
+	return fmt.Sprintf("累计应税 %s × %s%% − %g − 已扣 %s",
+		formatMoney(r.CumulativeTaxable), decimal.NewFromFloat(r.Rate).Shift(2), r.QuickDeduction, formatMoney(r.WithheldBefore))
+}
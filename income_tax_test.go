@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+)
+
+func createTaxTestEmployee(t *testing.T, joinDate string) Employee {
+	t.Helper()
+	employee := Employee{Name: "张三", EmployeeNo: "T" + strings.ReplaceAll(t.Name(), "/", "_"), Status: "active"}
+	if joinDate != "" {
+		d, err := time.Parse("2006-01-02", joinDate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		employee.JoinDate = &d
+	}
+	if err := db.Create(&employee).Error; err != nil {
+		t.Fatal(err)
+	}
+	return employee
+}
+
+// 保存一期工资条，作为之后各期的累计数据
+func createTaxTestPayroll(t *testing.T, employee Employee, period, status string, result IncomeTaxResult, taxable, special decimal.Decimal) {
+	t.Helper()
+	payroll := Payroll{
+		UUID:             generateUUID(),
+		EmployeeID:       employee.ID,
+		Period:           period,
+		TaxableIncome:    newMoney(taxable),
+		SpecialDeduction: newMoney(special),
+		IncomeTax:        result.Tax,
+		Status:           status,
+	}
+	if err := db.Create(&payroll).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func dec(s string) decimal.Decimal {
+	return decimal.RequireFromString(s)
+}
+
+// 国家税务总局公告2018年第61号解读中的示例：逐月计算并发布，每期个税与示例一致
+func TestIncomeTaxOfficialExamples(t *testing.T) {
+	tests := []struct {
+		name     string
+		taxable  string // 每月应发工资减三险一金
+		special  string // 每月专项附加扣除
+		expected []string
+	}{
+		{"monthly 10000, insurance 1500, special 1000", "8500", "1000", []string{"75", "75", "75"}},
+		{"monthly 30000, insurance 4500, special 2000", "25500", "2000", []string{"555", "625", "1850"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			employee := createTaxTestEmployee(t, "2018-06-01")
+			for i, want := range tt.expected {
+				period := time.Date(2019, time.Month(i+1), 1, 0, 0, 0, 0, time.UTC).Format("2006-01")
+				result, err := calculateIncomeTax(employee, period, dec(tt.taxable), dec(tt.special))
+				if err != nil {
+					t.Fatalf("%s: %v", period, err)
+				}
+				if !result.Tax.Equal(dec(want)) {
+					t.Errorf("%s: tax = %s, want %s", period, result.Tax, want)
+				}
+				createTaxTestPayroll(t, employee, period, "published", result, dec(tt.taxable), dec(tt.special))
+			}
+		})
+	}
+}
+
+// 1月一次性计算，累计应纳税所得额落在各级，含级距上限
+func TestIncomeTaxBrackets(t *testing.T) {
+	setupTestDB(t)
+	employee := createTaxTestEmployee(t, "2020-01-01")
+	tests := []struct {
+		cumulativeTaxable string
+		rate              float64
+		tax               string
+	}{
+		{"0", 0.03, "0"},
+		{"36000", 0.03, "1080"},
+		{"36000.01", 0.10, "1080"},
+		{"100000", 0.10, "7480"},
+		{"144000", 0.10, "11880"},
+		{"200000", 0.20, "23080"},
+		{"400000", 0.25, "68080"},
+		{"500000", 0.30, "97080"},
+		{"800000", 0.35, "194080"},
+		{"960000", 0.35, "250080"},
+		{"1000000", 0.45, "268080"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cumulativeTaxable, func(t *testing.T) {
+			income := dec(tt.cumulativeTaxable).Add(decimal.NewFromInt(5000))
+			result, err := calculateIncomeTax(employee, "2024-01", income, decimal.Zero)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Rate != tt.rate || !result.Tax.Equal(dec(tt.tax)) {
+				t.Errorf("rate = %v, tax = %s, want %v, %s", result.Rate, result.Tax, tt.rate, tt.tax)
+			}
+		})
+	}
+}
+
+// 年中入职：减除费用从入职当月起算；之前年度入职的从1月起算
+func TestIncomeTaxMonths(t *testing.T) {
+	setupTestDB(t)
+	tests := []struct {
+		joinDate string
+		period   string
+		months   int
+	}{
+		{"2024-07-15", "2024-07", 1},
+		{"2024-07-15", "2024-09", 3},
+		{"2023-11-01", "2024-03", 3},
+		{"", "2024-12", 12},
+		{"2024-12-01", "2024-11", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.joinDate+"/"+tt.period, func(t *testing.T) {
+			employee := createTaxTestEmployee(t, tt.joinDate)
+			result, err := calculateIncomeTax(employee, tt.period, dec("10000"), decimal.Zero)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Months != tt.months {
+				t.Errorf("months = %d, want %d", result.Months, tt.months)
+			}
+			if want := decimal.NewFromInt(int64(5000 * tt.months)); !result.CumulativeBasicDeduction.Equal(want) {
+				t.Errorf("basic deduction = %s, want %s", result.CumulativeBasicDeduction, want)
+			}
+		})
+	}
+
+	// 7月入职，每月应税所得10000：7月和8月各 (10000 − 5000) × 3% = 150
+	employee := createTaxTestEmployee(t, "2024-07-15")
+	for _, period := range []string{"2024-07", "2024-08"} {
+		result, err := calculateIncomeTax(employee, period, dec("10000"), decimal.Zero)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Tax.Equal(dec("150")) {
+			t.Errorf("%s: tax = %s, want 150", period, result.Tax)
+		}
+		createTaxTestPayroll(t, employee, period, "published", result, dec("10000"), decimal.Zero)
+	}
+}
+
+// 累计应纳税所得额为负或累计应扣税额低于已扣税额时，本期个税为0
+func TestIncomeTaxClampedToZero(t *testing.T) {
+	setupTestDB(t)
+	employee := createTaxTestEmployee(t, "2020-01-01")
+
+	result, err := calculateIncomeTax(employee, "2024-01", dec("3000"), dec("1000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.CumulativeTaxable.IsZero() || !result.Tax.IsZero() {
+		t.Errorf("taxable = %s, tax = %s, want 0, 0", result.CumulativeTaxable, result.Tax)
+	}
+
+	result, err = calculateIncomeTax(employee, "2024-02", dec("30000"), decimal.Zero)
+	if err != nil {
+		t.Fatal(err)
+	}
+	createTaxTestPayroll(t, employee, "2024-02", "published", result, dec("30000"), decimal.Zero)
+	// 3月无收入：累计 (30000 − 5000 × 3) × 3% = 450，低于已扣的 (30000 − 10000) × 3% = 600
+	result, err = calculateIncomeTax(employee, "2024-03", decimal.Zero, decimal.Zero)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.WithheldBefore.Equal(dec("600")) || !result.CumulativeTax.Equal(dec("450")) || !result.Tax.IsZero() {
+		t.Errorf("withheld = %s, cumulative tax = %s, tax = %s, want 600, 450, 0", result.WithheldBefore, result.CumulativeTax, result.Tax)
+	}
+}
+
+func TestIncomeTaxRounding(t *testing.T) {
+	setupTestDB(t)
+	employee := createTaxTestEmployee(t, "2020-01-01")
+	saved := appConfig.Payroll.Rounding.Line
+	t.Cleanup(func() { appConfig.Payroll.Rounding.Line = saved })
+
+	tests := []struct {
+		policy RoundingPolicy
+		income string
+		tax    string
+	}{
+		{RoundingPolicy{Unit: "fen", Mode: "half_up"}, "5000.35", "0.01"},  // 0.0105
+		{RoundingPolicy{Unit: "fen", Mode: "half_up"}, "5001.5", "0.05"},   // 0.045
+		{RoundingPolicy{Unit: "fen", Mode: "half_even"}, "5001.5", "0.04"}, // 0.045
+		{RoundingPolicy{Unit: "yuan", Mode: "half_up"}, "5050", "2"},       // 1.5
+		{RoundingPolicy{Unit: "yuan", Mode: "half_even"}, "5050", "2"},     // 1.5
+		{RoundingPolicy{Unit: "yuan", Mode: "half_even"}, "5083.33", "2"},  // 2.4999
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy.Unit+"/"+tt.policy.Mode+"/"+tt.income, func(t *testing.T) {
+			appConfig.Payroll.Rounding.Line = tt.policy
+			result, err := calculateIncomeTax(employee, "2024-01", dec(tt.income), decimal.Zero)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.Tax.Equal(dec(tt.tax)) {
+				t.Errorf("tax = %s, want %s", result.Tax, tt.tax)
+			}
+		})
+	}
+}
+
+// 只累计已发布和已签收的工资条，同期已发布的其他工资条计入；顺序不对时拒绝计算
+func TestIncomeTaxPayrollSelection(t *testing.T) {
+	setupTestDB(t)
+	employee := createTaxTestEmployee(t, "2020-01-01")
+	taxed := func(tax string) IncomeTaxResult { return IncomeTaxResult{Tax: newMoney(dec(tax))} }
+
+	createTaxTestPayroll(t, employee, "2023-12", "signed", taxed("900"), dec("50000"), decimal.Zero)
+	createTaxTestPayroll(t, employee, "2024-01", "signed", taxed("150"), dec("10000"), decimal.Zero)
+	createTaxTestPayroll(t, employee, "2024-02", "published", taxed("150"), dec("10000"), decimal.Zero)
+	createTaxTestPayroll(t, employee, "2024-03", "published", taxed("60"), dec("2000"), decimal.Zero) // 同期单独发放的奖金
+	createTaxTestPayroll(t, employee, "2024-03", "draft", taxed("999"), dec("99999"), decimal.Zero)
+
+	result, err := calculateIncomeTax(employee, "2024-03", dec("10000"), decimal.Zero)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 累计 32000 − 15000 = 17000 × 3% = 510，已扣 360
+	if !result.CumulativeIncome.Equal(dec("32000")) || !result.WithheldBefore.Equal(dec("360")) || !result.Tax.Equal(dec("150")) {
+		t.Errorf("income = %s, withheld = %s, tax = %s, want 32000, 360, 150", result.CumulativeIncome, result.WithheldBefore, result.Tax)
+	}
+
+	createTaxTestPayroll(t, employee, "2024-05", "draft", taxed("0"), dec("10000"), decimal.Zero)
+	if _, err := calculateIncomeTax(employee, "2024-04", dec("10000"), decimal.Zero); err == nil || !strings.Contains(err.Error(), "2024-05") {
+		t.Errorf("later payroll: error = %v, want rejection mentioning 2024-05", err)
+	}
+	if _, err := calculateIncomeTax(employee, "2024-06", dec("10000"), decimal.Zero); err == nil || !strings.Contains(err.Error(), "2024-03") {
+		t.Errorf("earlier draft: error = %v, want rejection mentioning 2024-03", err)
+	}
+	// 下一年重新累计，不受上一年的工资条影响
+	if _, err := calculateIncomeTax(employee, "2025-01", dec("10000"), decimal.Zero); err != nil {
+		t.Errorf("next year: %v", err)
+	}
+}
+
+// 工资期间按字符串比较和累计，格式不对的期间在创建和更新时都以400拒绝
+func TestPayrollPeriodFormat(t *testing.T) {
+	setupTestDB(t)
+	gin.SetMode(gin.TestMode)
+	employee := createTaxTestEmployee(t, "2020-01-01")
+	template := PayrollTemplate{Name: "标准", Fields: `{"basic_salary": {"name": "基本工资", "kind": "earning", "taxable": true}}`, IsActive: true}
+	if err := db.Create(&template).Error; err != nil {
+		t.Fatal(err)
+	}
+	draft := Payroll{UUID: generateUUID(), EmployeeID: employee.ID, Period: "2024-08", TemplateID: template.ID, PayrollData: "{}", Status: "draft"}
+	if err := db.Create(&draft).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(handler gin.HandlerFunc, method, period string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"employee_id": %d, "period": %q, "template_id": %d, "payroll_data": {"basic_salary": 8000}}`, employee.ID, period, template.ID)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(method, "/api/v1/payrolls", strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Params = gin.Params{{Key: "id", Value: draft.UUID}}
+		c.Set("user_id", uint(1))
+		c.Set("username", "admin")
+		handler(c)
+		return w
+	}
+	tests := []struct {
+		period string
+		status int
+	}{
+		{"2024-9", http.StatusBadRequest},
+		{"2024/09", http.StatusBadRequest},
+		{"202409", http.StatusBadRequest},
+		{"2024-13", http.StatusBadRequest},
+		{"2024-09-01", http.StatusBadRequest},
+		{"2024-09", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.period, func(t *testing.T) {
+			if w := send(createPayroll, http.MethodPost, tt.period); w.Code != tt.status {
+				t.Errorf("create: status = %d, want %d, body = %s", w.Code, tt.status, w.Body)
+			}
+			want := tt.status
+			if want == http.StatusCreated {
+				want = http.StatusOK
+			}
+			if w := send(updatePayroll, http.MethodPut, tt.period); w.Code != want {
+				t.Errorf("update: status = %d, want %d, body = %s", w.Code, want, w.Body)
+			}
+		})
+	}
+}
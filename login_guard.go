@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 登录尝试记录，用于按账号和IP统计失败次数
+type LoginAttempt struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	Username    string    `json:"username" gorm:"index;size:64"`
+	AdminUserID *uint     `json:"admin_user_id"` // 用户名不存在时为空
+	IPAddress   string    `json:"ip_address" gorm:"index;size:64"`
+	UserAgent   string    `json:"user_agent"`
+	Success     bool      `json:"success"`
+	CreatedAt   time.Time `json:"created_at" gorm:"index"`
+}
+
+// 登录锁定策略
+type LockoutPolicy struct {
+	MaxAttempts   int           `yaml:"max_attempts"`    // 同一账号连续失败多少次后锁定
+	IPMaxAttempts int           `yaml:"ip_max_attempts"` // 同一IP在统计窗口内失败多少次后暂停登录
+	Window        time.Duration `yaml:"window"`          // 失败次数统计窗口
+	Duration      time.Duration `yaml:"duration"`        // 账号锁定时长
+	BaseDelay     time.Duration `yaml:"base_delay"`      // 登录失败后的响应延迟，每多失败一次翻倍
+	MaxDelay      time.Duration `yaml:"max_delay"`       // 响应延迟上限
+}
+
+// 账号是否处于锁定状态
+func (u AdminUser) IsLocked() bool {
+	return u.LockedUntil != nil && u.LockedUntil.After(time.Now())
+}
+
+// IP在统计窗口内的失败次数，以及失败次数降到阈值以下的时间
+func ipFailures(ip string) (int64, time.Time) {
+	policy := appConfig.Auth.Lockout
+	since := time.Now().Add(-policy.Window)
+
+	var count int64
+	db.Model(&LoginAttempt{}).Where("ip_address = ? AND success = ? AND created_at > ?", ip, false, since).Count(&count)
+	if count < int64(policy.IPMaxAttempts) {
+		return count, time.Time{}
+	}
+
+	// 第 IPMaxAttempts 次最近的失败移出窗口后即可再次尝试
+	var attempt LoginAttempt
+	db.Where("ip_address = ? AND success = ? AND created_at > ?", ip, false, since).
+		Order("created_at DESC").Offset(policy.IPMaxAttempts - 1).First(&attempt)
+	return count, attempt.CreatedAt.Add(policy.Window)
+}
+
+// 失败后的渐进延迟
+func loginFailureDelay(failures int64) time.Duration {
+	policy := appConfig.Auth.Lockout
+	if failures <= 0 || policy.BaseDelay <= 0 {
+		return 0
+	}
+	delay := policy.BaseDelay
+	for i := int64(1); i < failures && delay < policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	return delay
+}
+
+// 记录登录失败，账号连续失败达到阈值时锁定，返回该账号或IP的失败次数
+func recordLoginFailure(c *gin.Context, user *AdminUser, username string) int64 {
+	policy := appConfig.Auth.Lockout
+	ip := c.ClientIP()
+	attempt := LoginAttempt{
+		Username:  username,
+		IPAddress: ip,
+		UserAgent: c.GetHeader("User-Agent"),
+	}
+	if user != nil {
+		attempt.AdminUserID = &user.ID
+	}
+	db.Create(&attempt)
+
+	logSecurityEvent("login_failed", map[string]interface{}{
+		"username": username,
+		"ip":       ip,
+	})
+
+	ipCount, _ := ipFailures(ip)
+	if ipCount == int64(policy.IPMaxAttempts) {
+		logSecurityEvent("ip_locked", map[string]interface{}{
+			"ip":       ip,
+			"failures": ipCount,
+			"window":   policy.Window.String(),
+		})
+	}
+	if user == nil {
+		return ipCount
+	}
+
+	now := time.Now()
+	// 在数据库中原子递增，避免并发失败相互覆盖；上次失败已超出统计窗口时重新计数
+	db.Model(&AdminUser{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"failed_login_count": gorm.Expr(
+			"CASE WHEN last_failed_login_at IS NULL OR last_failed_login_at < ? THEN 1 ELSE failed_login_count + 1 END",
+			now.Add(-policy.Window),
+		),
+		"last_failed_login_at": &now,
+	})
+	var failures int
+	db.Model(&AdminUser{}).Where("id = ?", user.ID).Select("failed_login_count").Scan(&failures)
+	if failures >= policy.MaxAttempts {
+		lockedUntil := now.Add(policy.Duration)
+		// 并发请求同时达到阈值时只有一个能加锁并清零计数
+		result := db.Model(&AdminUser{}).
+			Where("id = ? AND failed_login_count >= ?", user.ID, policy.MaxAttempts).
+			Updates(map[string]interface{}{
+				"locked_until":       &lockedUntil,
+				"failed_login_count": 0,
+			})
+		if result.RowsAffected > 0 {
+			logSecurityEvent("account_locked", map[string]interface{}{
+				"username":     user.Username,
+				"user_id":      user.ID,
+				"ip":           ip,
+				"failures":     failures,
+				"locked_until": lockedUntil.Format(time.RFC3339),
+			})
+		}
+	}
+
+	if int64(failures) > ipCount {
+		return int64(failures)
+	}
+	return ipCount
+}
+
+// 登录成功后清空失败计数
+func recordLoginSuccess(c *gin.Context, user AdminUser) {
+	db.Create(&LoginAttempt{
+		Username:    user.Username,
+		AdminUserID: &user.ID,
+		IPAddress:   c.ClientIP(),
+		UserAgent:   c.GetHeader("User-Agent"),
+		Success:     true,
+	})
+	if user.FailedLoginCount > 0 || user.LockedUntil != nil {
+		db.Model(&user).Updates(map[string]interface{}{
+			"failed_login_count":   0,
+			"last_failed_login_at": nil,
+			"locked_until":         nil,
+		})
+	}
+}
+
+// 登录失败：按失败次数延迟后返回统一的错误信息
+func rejectLogin(c *gin.Context, user *AdminUser, username string) {
+	failures := recordLoginFailure(c, user, username)
+	time.Sleep(loginFailureDelay(failures))
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
+}
+
+// IP失败次数过多时拒绝登录
+func checkIPLockout(c *gin.Context, username string) bool {
+	ip := c.ClientIP()
+	if _, retryAt := ipFailures(ip); !retryAt.IsZero() {
+		retryAfter := int(time.Until(retryAt).Seconds()) + 1
+		logSecurityEvent("login_blocked", map[string]interface{}{
+			"reason":   "ip_locked",
+			"username": username,
+			"ip":       ip,
+		})
+		c.Header("Retry-After", fmt.Sprint(retryAfter))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":       "登录失败次数过多，请稍后再试",
+			"code":        "ip_locked",
+			"retry_after": retryAfter,
+		})
+		return false
+	}
+	return true
+}
+
+// 账号锁定时拒绝登录
+func checkAccountLockout(c *gin.Context, user AdminUser) bool {
+	if !user.IsLocked() {
+		return true
+	}
+	logSecurityEvent("login_blocked", map[string]interface{}{
+		"reason":   "account_locked",
+		"username": user.Username,
+		"user_id":  user.ID,
+		"ip":       c.ClientIP(),
+	})
+	retryAfter := int(time.Until(*user.LockedUntil).Seconds()) + 1
+	c.Header("Retry-After", fmt.Sprint(retryAfter))
+	c.JSON(http.StatusLocked, gin.H{
+		"error":        "账号已被临时锁定，请稍后再试或联系管理员解锁",
+		"code":         "account_locked",
+		"locked_until": user.LockedUntil,
+		"retry_after":  retryAfter,
+	})
+	return false
+}
+
+// 清理过期的登录记录
+func purgeLoginAttempts(before time.Time) error {
+	return db.Where("created_at < ?", before).Delete(&LoginAttempt{}).Error
+}
+
+// 管理员解锁账号
+func unlockAdminUser(c *gin.Context) {
+	var user AdminUser
+	if err := db.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "管理员不存在"})
+		return
+	}
+
+	before := user
+	if err := db.Model(&user).Updates(map[string]interface{}{
+		"failed_login_count":   0,
+		"last_failed_login_at": nil,
+		"locked_until":         nil,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "解锁失败"})
+		return
+	}
+	db.First(&user, user.ID)
+	recordAudit(c, "admin_user.unlock", "admin_user", user.ID, before, user)
+
+	logSecurityEvent("account_unlocked", map[string]interface{}{
+		"username":    user.Username,
+		"user_id":     user.ID,
+		"unlocked_by": c.GetString("username"),
+		"ip":          c.ClientIP(),
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "账号已解锁"})
+}
+
+// 查看登录记录
+func getLoginAttempts(c *gin.Context) {
+	query := db.Order("created_at DESC").Limit(200)
+	if username := c.Query("username"); username != "" {
+		query = query.Where("username = ?", username)
+	}
+	if ip := c.Query("ip"); ip != "" {
+		query = query.Where("ip_address = ?", ip)
+	}
+	if success := c.Query("success"); success != "" {
+		query = query.Where("success = ?", success == "true")
+	}
+
+	var attempts []LoginAttempt
+	if err := query.Find(&attempts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取登录记录失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": attempts})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoginFailureDelay(t *testing.T) {
+	saved := appConfig.Auth.Lockout
+	t.Cleanup(func() { appConfig.Auth.Lockout = saved })
+
+	tests := []struct {
+		base     time.Duration
+		failures int64
+		want     time.Duration
+	}{
+		{500 * time.Millisecond, 0, 0},
+		{500 * time.Millisecond, 1, 500 * time.Millisecond},
+		{500 * time.Millisecond, 2, time.Second},
+		{500 * time.Millisecond, 4, 4 * time.Second},
+		{500 * time.Millisecond, 5, 8 * time.Second},
+		{500 * time.Millisecond, 50, 8 * time.Second},
+		{3 * time.Second, 3, 8 * time.Second},
+		{0, 5, 0},
+	}
+	for _, tt := range tests {
+		appConfig.Auth.Lockout.BaseDelay, appConfig.Auth.Lockout.MaxDelay = tt.base, 8*time.Second
+		if got := loginFailureDelay(tt.failures); got != tt.want {
+			t.Errorf("base %v, %d failures: delay = %v, want %v", tt.base, tt.failures, got, tt.want)
+		}
+	}
+}
+
+// 连续失败达到阈值后锁定账号，锁定期间正确密码也被拒绝；超出统计窗口的失败不累计
+func TestAccountLockout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const password = "Correct#Pass1"
+	type step struct {
+		password string
+		status   int
+	}
+	tests := []struct {
+		name   string
+		steps  []step
+		expire bool // 最后一步之前让锁定到期
+		aged   bool // 第2次失败后把上次失败时间移出统计窗口
+	}{
+		{
+			name:  "below threshold",
+			steps: []step{{"wrong", http.StatusUnauthorized}, {"wrong", http.StatusUnauthorized}, {password, http.StatusOK}},
+		},
+		{
+			name: "locked at threshold",
+			steps: []step{{"wrong", http.StatusUnauthorized}, {"wrong", http.StatusUnauthorized}, {"wrong", http.StatusUnauthorized},
+				{password, http.StatusLocked}, {"wrong", http.StatusLocked}},
+		},
+		{
+			name: "lock expired",
+			steps: []step{{"wrong", http.StatusUnauthorized}, {"wrong", http.StatusUnauthorized}, {"wrong", http.StatusUnauthorized},
+				{password, http.StatusOK}},
+			expire: true,
+		},
+		{
+			name:  "failures outside window",
+			steps: []step{{"wrong", http.StatusUnauthorized}, {"wrong", http.StatusUnauthorized}, {"wrong", http.StatusUnauthorized}, {password, http.StatusOK}},
+			aged:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			useFastAuthConfig(t)
+			appConfig.Auth.Lockout.MaxAttempts = 3
+			hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+			if err != nil {
+				t.Fatal(err)
+			}
+			user := createTestAdminUser(t, "locktest", string(hash))
+
+			for i, s := range tt.steps {
+				if tt.aged && i == 2 {
+					db.Model(&user).Update("last_failed_login_at", time.Now().Add(-appConfig.Auth.Lockout.Window-time.Minute))
+				}
+				if tt.expire && i == len(tt.steps)-1 {
+					db.Model(&user).Update("locked_until", time.Now().Add(-time.Second))
+				}
+				if w := postLogin(t, "locktest", s.password); w.Code != s.status {
+					t.Fatalf("step %d: status = %d, want %d, body = %s", i+1, w.Code, s.status, w.Body)
+				}
+			}
+			var stored AdminUser
+			db.First(&stored, user.ID)
+			if last := tt.steps[len(tt.steps)-1]; last.status == http.StatusOK && (stored.FailedLoginCount != 0 || stored.LockedUntil != nil) {
+				t.Errorf("after successful login: failed count = %d, locked until = %v, want reset", stored.FailedLoginCount, stored.LockedUntil)
+			}
+		})
+	}
+}
+
+// 同一IP失败次数达到阈值后，任何账号都暂时不能从该IP登录
+func TestIPLockout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupTestDB(t)
+	useFastAuthConfig(t)
+	appConfig.Auth.Lockout.IPMaxAttempts = 3
+	hash, err := bcrypt.GenerateFromPassword([]byte("Correct#Pass1"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	createTestAdminUser(t, "iptest", string(hash))
+
+	for _, username := range []string{"nobody1", "nobody2", "nobody3"} {
+		if w := postLogin(t, username, "guess"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: status = %d, want 401", username, w.Code)
+		}
+	}
+	w := postLogin(t, "iptest", "Correct#Pass1")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("status = %d, Retry-After = %q, want 429 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+}
@@ -1,40 +1,46 @@
 package main
 
 import (
-	"crypto/md5"
 	"crypto/rand"
 	"encoding/base64"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
-	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
 type Employee struct {
-	ID         uint       `json:"id" gorm:"primaryKey"`
-	Name       string     `json:"name"`
-	EmployeeNo string     `json:"employee_no" gorm:"uniqueIndex"`
-	Department string     `json:"department"`
-	Position   string     `json:"position"`
-	Email      string     `json:"email"`
-	Phone      string     `json:"phone"`
-	Status     string     `json:"status" gorm:"default:active"` // active, inactive, resigned
-	JoinDate   *time.Time `json:"join_date"`                   // 入职日期
-	LeaveDate  *time.Time `json:"leave_date"`                   // 离职日期
-	DeletedAt  *time.Time `json:"deleted_at" gorm:"index"`      // 软删除
-	CreatedAt  time.Time  `json:"created_at"`
-	UpdatedAt  time.Time  `json:"updated_at"`
+	ID                 uint       `json:"id" gorm:"primaryKey"`
+	Name               string     `json:"name"`
+	EmployeeNo         string     `json:"employee_no" gorm:"uniqueIndex;size:64"`
+	Department         string     `json:"department"`
+	Position           string     `json:"position"`
+	Email              string     `json:"email"`
+	Phone              string     `json:"phone"`
+	Status             string     `json:"status" gorm:"size:20;default:active"` // active, inactive, resigned
+	JoinDate           *time.Time `json:"join_date"`                            // 入职日期
+	LeaveDate          *time.Time `json:"leave_date"`                           // 离职日期
+	DeletedAt          *time.Time `json:"deleted_at" gorm:"index"`              // 软删除
+	PortalEnabled      bool       `json:"portal_enabled" gorm:"default:true"`   // 是否允许登录员工门户
+	PortalLastLoginAt  *time.Time `json:"portal_last_login_at"`
+	PortalTokenVersion int        `json:"-" gorm:"default:0"`                          // 关闭门户时递增，使已签发的员工令牌失效
+	SpecialDeduction   Money      `json:"special_deduction" gorm:"type:decimal(18,2)"` // 每月专项附加扣除合计（子女教育、住房贷款利息、赡养老人等）
+	ContributionCity   string     `json:"contribution_city" gorm:"size:64"`            // 社保公积金参保城市，对应 ContributionPolicy.City
+	ContributionBase   Money      `json:"contribution_base" gorm:"type:decimal(18,2)"` // 社保公积金缴费基数，按城市政策的上下限调整
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
 }
 
 type PayrollTemplate struct {
@@ -48,37 +54,46 @@ type PayrollTemplate struct {
 }
 
 type Payroll struct {
-	ID             uint            `json:"-" gorm:"primaryKey"`              // 内部ID，不对外暴露
-	UUID           string          `json:"id" gorm:"uniqueIndex;size:36"`    // 对外暴露的UUID
-	EmployeeID     uint            `json:"employee_id"`
-	Employee       Employee        `json:"employee" gorm:"foreignKey:EmployeeID"`
-	Period         string          `json:"period"` // 工资期间 2024-08
-	TemplateID     uint            `json:"template_id"`
-	Template       PayrollTemplate `json:"template" gorm:"foreignKey:TemplateID"`
-	WorkDays       float64         `json:"work_days" gorm:"default:0"`       // 实际工作天数
-	MonthDays      float64         `json:"month_days" gorm:"default:0"`      // 当月总天数
-	IsProrated     bool            `json:"is_prorated" gorm:"default:false"` // 是否按天数比例计算
-	PayrollData    string          `json:"payroll_data" gorm:"type:text"`    // JSON格式存储工资数据
-	OriginalGross  float64         `json:"original_gross"`                   // 原始应发工资（全月）
-	TotalGross     float64         `json:"total_gross"`                      // 实际应发工资
-	TotalNet       float64         `json:"total_net"`                        // 实发工资
-	Status         string          `json:"status" gorm:"default:draft"`      // draft, published, signed
-	PublishedAt    *time.Time      `json:"published_at"`
-	CreatedAt      time.Time       `json:"created_at"`
-	UpdatedAt      time.Time       `json:"updated_at"`
+	ID                    uint            `json:"-" gorm:"primaryKey"`           // 内部ID，不对外暴露
+	UUID                  string          `json:"id" gorm:"uniqueIndex;size:36"` // 对外暴露的UUID
+	EmployeeID            uint            `json:"employee_id"`
+	Employee              Employee        `json:"employee" gorm:"foreignKey:EmployeeID"`
+	Period                string          `json:"period"` // 工资期间 2024-08
+	TemplateID            uint            `json:"template_id"`
+	Template              PayrollTemplate `json:"template" gorm:"foreignKey:TemplateID"`
+	WorkDays              float64         `json:"work_days" gorm:"default:0"`                       // 实际工作天数
+	MonthDays             float64         `json:"month_days" gorm:"default:0"`                      // 当月总天数
+	IsProrated            bool            `json:"is_prorated" gorm:"default:false"`                 // 是否按天数比例计算
+	PayrollData           string          `json:"payroll_data" gorm:"type:text"`                    // JSON格式存储工资数据
+	OriginalGross         Money           `json:"original_gross" gorm:"type:decimal(18,2)"`         // 原始应发工资（全月）
+	TotalGross            Money           `json:"total_gross" gorm:"type:decimal(18,2)"`            // 实际应发工资
+	TotalNet              Money           `json:"total_net" gorm:"type:decimal(18,2)"`              // 实发工资
+	TaxableIncome         Money           `json:"taxable_income" gorm:"type:decimal(18,2)"`         // 本期应税所得（应税收入减税前扣除），用于累计预扣个税
+	SpecialDeduction      Money           `json:"special_deduction" gorm:"type:decimal(18,2)"`      // 本期专项附加扣除
+	IncomeTax             Money           `json:"income_tax" gorm:"type:decimal(18,2)"`             // 本期按累计预扣法计算的个税，模板没有个税计算字段时为0
+	EmployerContributions Money           `json:"employer_contributions" gorm:"type:decimal(18,2)"` // 单位缴纳合计（社保公积金单位部分等），不计入实发
+	Contributions         string          `json:"contributions" gorm:"type:text"`                   // JSON格式存储社保公积金计算结果，模板没有社保公积金计算字段时为空
+	CompensationProfileID *uint           `json:"compensation_profile_id"`                          // 带入默认值的薪酬档案
+	PayGroup              string          `json:"pay_group" gorm:"size:32"`                         // 发薪组，取自薪酬档案
+	Breakdown             string          `json:"breakdown" gorm:"type:text"`                       // JSON格式存储按模板字段计算的明细行
+	Status                string          `json:"status" gorm:"size:20;default:draft"`              // draft, published, signed
+	PublishedAt           *time.Time      `json:"published_at"`
+	CreatedAt             time.Time       `json:"created_at"`
+	UpdatedAt             time.Time       `json:"updated_at"`
 }
 
 type PayrollSignature struct {
 	ID            uint      `json:"id" gorm:"primaryKey"`
-	PayrollID     uint      `json:"payroll_id"`
+	PayrollID     uint      `json:"payroll_id" gorm:"uniqueIndex:idx_payroll_signatures_payroll"`
 	Payroll       Payroll   `json:"payroll" gorm:"foreignKey:PayrollID"`
 	SignatureData string    `json:"signature_data" gorm:"type:text"` // Base64签名图片
-	SignatureHash string    `json:"signature_hash"`                  // 签名哈希值
+	SignatureHash string    `json:"signature_hash"`                  // 签名记录哈希（SHA-256，哈希链）
 	IPAddress     string    `json:"ip_address"`
 	UserAgent     string    `json:"user_agent"`
 	DeviceInfo    string    `json:"device_info"`
 	SignedAt      time.Time `json:"signed_at"`
-	CreatedAt     time.Time `json:"created_at"`
+	SignatureIntegrity
+	CreatedAt time.Time `json:"created_at"`
 }
 
 type PayrollNotification struct {
@@ -90,6 +105,7 @@ type PayrollNotification struct {
 	Status    string     `json:"status"`    // pending, sent, failed
 	SentAt    *time.Time `json:"sent_at"`
 	ErrorMsg  string     `json:"error_msg"`
+	AccessLinkID *uint   `json:"access_link_id"` // 通知中附带的工资条访问链接
 	CreatedAt time.Time  `json:"created_at"`
 }
 
@@ -104,7 +120,7 @@ type ResignationApplication struct {
 	LastWorkingDate   time.Time  `json:"last_working_date"`  // 最后工作日
 	Reason            string     `json:"reason" gorm:"type:text"`              // 离职原因
 	HandoverNotes     string     `json:"handover_notes" gorm:"type:text"`      // 工作交接说明
-	Status            string     `json:"status" gorm:"default:draft"`          // draft, submitted, approved, rejected, completed
+	Status            string     `json:"status" gorm:"size:20;default:draft"`  // draft, submitted, approved, rejected, completed
 	ApprovedBy        *uint      `json:"approved_by"`        // 审批人ID
 	ApprovedAt        *time.Time `json:"approved_at"`        // 审批时间
 	ApprovalComments  string     `json:"approval_comments" gorm:"type:text"`   // 审批意见
@@ -122,6 +138,7 @@ type ResignationReport struct {
 	UnfinishedTasks         string                 `json:"unfinished_tasks" gorm:"type:text"`   // 未完成事项
 	CompanyPropertyReturned bool                   `json:"company_property_returned"`           // 公司财产是否已归还
 	FinancialSettlement     bool                   `json:"financial_settlement"`                // 财务是否已结清
+	TemplateVersion         int                    `json:"template_version"`                    // 生成报告时使用的文档模板版本，0 为内置模板
 	GeneratedAt             time.Time              `json:"generated_at"`
 	CreatedAt               time.Time              `json:"created_at"`
 	UpdatedAt               time.Time              `json:"updated_at"`
@@ -135,11 +152,12 @@ type ResignationSignature struct {
 	SignerType    string                 `json:"signer_type"`    // employee（员工）, hr（人事）, manager（主管）
 	SignerID      uint                   `json:"signer_id"`      // 签名人ID
 	SignatureData string                 `json:"signature_data" gorm:"type:text"` // Base64签名图片
-	SignatureHash string                 `json:"signature_hash"` // 签名哈希值
+	SignatureHash string                 `json:"signature_hash"` // 签名记录哈希（SHA-256，哈希链）
 	IPAddress     string                 `json:"ip_address"`
 	UserAgent     string                 `json:"user_agent"`
 	DeviceInfo    string                 `json:"device_info"`
 	SignedAt      time.Time              `json:"signed_at"`
+	SignatureIntegrity
 	CreatedAt     time.Time              `json:"created_at"`
 }
 
@@ -156,30 +174,32 @@ type ResignationSignToken struct {
 }
 
 type CreateEmployeeRequest struct {
-	Name       string `json:"name" binding:"required"`
-	EmployeeNo string `json:"employee_no" binding:"required"`
-	Department string `json:"department" binding:"required"`
-	Position   string `json:"position" binding:"required"`
-	Email      string `json:"email" binding:"required,email"`
-	Phone      string `json:"phone" binding:"required"`
-	JoinDate   string `json:"join_date"` // 以字符串接收日期
+	Name             string  `json:"name" binding:"required"`
+	EmployeeNo       string  `json:"employee_no" binding:"required"`
+	Department       string  `json:"department" binding:"required"`
+	Position         string  `json:"position" binding:"required"`
+	Email            string  `json:"email" binding:"required,email"`
+	Phone            string  `json:"phone" binding:"required"`
+	JoinDate         string  `json:"join_date"`         // 以字符串接收日期
+	SpecialDeduction *Money  `json:"special_deduction"` // 每月专项附加扣除，更新时不传则保持不变
+	ContributionCity *string `json:"contribution_city"` // 社保公积金参保城市，更新时不传则保持不变
+	ContributionBase *Money  `json:"contribution_base"` // 社保公积金缴费基数，更新时不传则保持不变
 }
 
 type CreatePayrollRequest struct {
-	EmployeeID  uint                   `json:"employee_id" binding:"required"`
-	Period      string                 `json:"period" binding:"required"`
-	TemplateID  uint                   `json:"template_id" binding:"required"`
-	WorkDays    float64                `json:"work_days"`    // 实际工作天数
-	MonthDays   float64                `json:"month_days"`   // 当月总天数
-	IsProrated  bool                   `json:"is_prorated"` // 是否按天数比例计算
-	PayrollData map[string]interface{} `json:"payroll_data" binding:"required"`
+	EmployeeID       uint                   `json:"employee_id" binding:"required"`
+	Period           string                 `json:"period" binding:"required"`
+	TemplateID       uint                   `json:"template_id"`       // 不传时使用薪酬档案的模板
+	WorkDays         float64                `json:"work_days"`         // 实际工作天数
+	MonthDays        float64                `json:"month_days"`        // 当月总天数
+	IsProrated       bool                   `json:"is_prorated"`       // 是否按天数比例计算
+	PayrollData      map[string]interface{} `json:"payroll_data"`      // 未填写的字段取薪酬档案的基本工资和固定津贴
+	SpecialDeduction *Money                 `json:"special_deduction"` // 本期专项附加扣除，不传时取员工设置
 }
 
 type SignPayrollRequest struct {
 	PayrollUUID   string `json:"payroll_id" binding:"required"` // 使用UUID
 	SignatureData string `json:"signature_data" binding:"required"`
-	IPAddress     string `json:"ip_address"`
-	UserAgent     string `json:"user_agent"`
 	DeviceInfo    string `json:"device_info"`
 }
 
@@ -229,9 +249,22 @@ type SignResignationRequest struct {
 // 管理员用户
 type AdminUser struct {
 	ID       uint   `json:"id" gorm:"primaryKey"`
-	Username string `json:"username" gorm:"uniqueIndex"`
+	Username string `json:"username" gorm:"uniqueIndex;size:64"`
 	Password string `json:"-"` // 不在JSON中显示
 	IsActive bool   `json:"is_active" gorm:"default:true"`
+	MustChangePassword bool `json:"must_change_password" gorm:"default:false"` // 首次登录必须修改密码
+	Role        string `json:"role" gorm:"size:20;default:admin"` // admin, hr, finance, manager, auditor
+	Department  string `json:"department"`                        // 部门主管负责的部门
+	Permissions string `json:"extra_permissions"`                 // 角色之外单独授予的权限，逗号分隔
+	LastLoginAt *time.Time `json:"last_login_at"`
+	LastLoginIP string     `json:"last_login_ip"`
+	TokenVersion int `json:"-" gorm:"default:0"` // 退出全部会话时递增，旧版本的访问令牌全部失效
+	FailedLoginCount  int        `json:"failed_login_count" gorm:"default:0"` // 统计窗口内连续登录失败次数
+	LastFailedLoginAt *time.Time `json:"last_failed_login_at"`
+	LockedUntil       *time.Time `json:"locked_until"` // 连续登录失败后的锁定截止时间
+	TOTPSecret   string `json:"-" gorm:"size:64"` // 两步验证密钥（Base32）
+	TOTPEnabled  bool   `json:"totp_enabled" gorm:"default:false"`
+	TOTPLastStep int64  `json:"-" gorm:"default:0"` // 最近一次使用的验证码时间步，防止重放
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -247,112 +280,118 @@ type LoginRequest struct {
 type LoginResponse struct {
 	Token    string    `json:"token"`
 	ExpiresAt time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
 	Username string    `json:"username"`
+	MustChangePassword bool `json:"must_change_password"`
+	TOTPSetupRequired  bool `json:"totp_setup_required"` // 系统强制两步验证但尚未绑定
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+}
+
+// 修改密码请求
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+	Remember    bool   `json:"remember"`
 }
 
 // JWT Claims
 type JWTClaims struct {
 	Username string `json:"username"`
 	UserID   uint   `json:"user_id"`
+	MustChangePassword bool `json:"must_change_password,omitempty"`
+	TOTPSetupRequired  bool `json:"totp_setup_required,omitempty"`
+	Role        string   `json:"role"`
+	Department  string   `json:"department,omitempty"`
+	Permissions []string `json:"permissions"`
+	SessionID    string `json:"sid,omitempty"` // 登录会话，对应刷新令牌
+	TokenVersion int    `json:"ver"`
 	jwt.RegisteredClaims
 }
 
 var db *gorm.DB
-var jwtSecret = []byte("payroll-jwt-secret-key-2025") // 实际部署时应该使用环境变量
 
 func initDB() {
 	var err error
-	db, err = gorm.Open(sqlite.Open("payroll.db"), &gorm.Config{})
+	db, err = openDatabase(appConfig.Database)
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 
-	err = db.AutoMigrate(
-		&Employee{}, 
-		&PayrollTemplate{}, 
-		&Payroll{}, 
-		&PayrollSignature{}, 
-		&PayrollNotification{}, 
-		&AdminUser{},
-		&ResignationApplication{},
-		&ResignationReport{},
-		&ResignationSignature{},
-		&ResignationSignToken{},
-	)
-	if err != nil {
-		log.Fatal("Failed to migrate database:", err)
+	if appConfig.Database.AutoMigrate {
+		if err := migrateUp(db); err != nil {
+			log.Fatal("Failed to migrate database:", err)
+		}
+	} else {
+		pending, err := pendingMigrations(db)
+		if err != nil {
+			log.Fatal("Failed to check migrations:", err)
+		}
+		if len(pending) > 0 {
+			log.Fatalf("Database has %d pending migrations, run `payroll migrate up` first", len(pending))
+		}
 	}
 
-	createSampleData()
-}
-
-func createSampleData() {
-	// 创建不同入职日期的员工示例
-	now := time.Now()
-	joinDate1 := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local) // 月初入职
-	joinDate2 := time.Date(now.Year(), now.Month(), 15, 0, 0, 0, 0, time.Local) // 15号入职
-	joinDate3 := time.Date(now.Year(), now.Month()-1, 20, 0, 0, 0, 0, time.Local) // 上月20号入职
-	
-	employees := []Employee{
-		{Name: "张三", EmployeeNo: "EMP001", Department: "技术部", Position: "高级工程师", Email: "zhangsan@company.com", Phone: "13800138001", JoinDate: &joinDate1},
-		{Name: "李四", EmployeeNo: "EMP002", Department: "产品部", Position: "产品经理", Email: "lisi@company.com", Phone: "13800138002", JoinDate: &joinDate2},
-		{Name: "王五", EmployeeNo: "EMP003", Department: "设计部", Position: "UI设计师", Email: "wangwu@company.com", Phone: "13800138003", JoinDate: &joinDate3},
-	}
+	bootstrapAdmin()
 
-	for _, emp := range employees {
-		var existingEmp Employee
-		if err := db.Where("employee_no = ?", emp.EmployeeNo).First(&existingEmp).Error; err != nil {
-			db.Create(&emp)
+	if !appConfig.IsDev() {
+		var defaultAdmin AdminUser
+		if err := db.Where("username = ? AND is_active = ?", "admin", true).First(&defaultAdmin).Error; err == nil &&
+			verifyPassword(defaultAdmin.Password, defaultAdminPassword) {
+			log.Fatal("Refusing to start: default admin password is still in use outside the dev profile")
 		}
 	}
+}
 
-	templateFields := map[string]interface{}{
-		"basic_salary":     map[string]string{"name": "基本工资", "type": "number"},
-		"performance":      map[string]string{"name": "绩效奖金", "type": "number"},
-		"meal_allowance":   map[string]string{"name": "餐补", "type": "number"},
-		"transport":        map[string]string{"name": "交通补贴", "type": "number"},
-		"tax":              map[string]string{"name": "个人所得税", "type": "number"},
-		"social_insurance": map[string]string{"name": "社保", "type": "number"},
+// 首次启动时创建初始管理员，首次登录后必须修改密码
+func bootstrapAdmin() {
+	var count int64
+	if err := db.Model(&AdminUser{}).Count(&count).Error; err != nil {
+		log.Fatal("Failed to check admin users:", err)
 	}
-	fieldsJSON, _ := json.Marshal(templateFields)
-
-	template := PayrollTemplate{
-		Name:        "标准工资模板",
-		Description: "包含基本工资、绩效、津贴和扣除项的标准模板",
-		Fields:      string(fieldsJSON),
-		IsActive:    true,
+	if count > 0 {
+		return
 	}
 
-	var existingTemplate PayrollTemplate
-	if err := db.Where("name = ?", template.Name).First(&existingTemplate).Error; err != nil {
-		db.Create(&template)
+	password := appConfig.Auth.AdminPassword
+	generated := password == ""
+	if generated {
+		password = generateSecureToken()[:16]
 	}
 
-	// 创建默认管理员用户
-	var existingAdmin AdminUser
-	if err := db.Where("username = ?", "admin").First(&existingAdmin).Error; err != nil {
-		// 默认密码: admin123
-		hashedPassword := hashPassword("admin123")
-		admin := AdminUser{
-			Username: "admin",
-			Password: hashedPassword,
-			IsActive: true,
+	err := db.Transaction(func(tx *gorm.DB) error {
+		admin := AdminUser{Username: "admin", Role: RoleAdmin, IsActive: true}
+		if err := tx.Create(&admin).Error; err != nil {
+			return err
 		}
-		db.Create(&admin)
-		log.Printf("创建默认管理员用户: admin/admin123")
+		return setAdminPassword(tx, &admin, password, true)
+	})
+	if err != nil {
+		log.Fatal("Failed to create initial admin user:", err)
+	}
+
+	if generated {
+		log.Printf("创建初始管理员: admin，临时密码: %s（首次登录后必须修改）", password)
+	} else {
+		log.Printf("创建初始管理员: admin（首次登录后必须修改密码）")
 	}
 }
 
-func setupRoutes() *gin.Engine {
+func setupRoutes(cfg *Config) *gin.Engine {
 	r := gin.Default()
+	// 客户端IP用于登录限流和签名记录，不能信任任意来源的 X-Forwarded-For
+	if err := r.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatal("Invalid trusted proxies:", err)
+	}
 
 	config := cors.DefaultConfig()
 	config.AllowAllOrigins = true
 	config.AllowHeaders = []string{"*"}
 	r.Use(cors.New(config))
 
-	r.Static("/uploads", "./uploads")
-	r.Static("/web", "./web")
+	r.Static("/uploads", cfg.Server.UploadsDir)
+	r.Static("/web", cfg.Server.WebDir)
 
 	api := r.Group("/api/v1")
 	{
@@ -361,13 +400,49 @@ func setupRoutes() *gin.Engine {
 		{
 			auth.POST("/login", login)
 			auth.POST("/verify", verifyToken)
+			auth.POST("/refresh", refreshToken)
+			auth.POST("/logout", tokenMiddleware(), logout)
+			auth.POST("/logout-all", tokenMiddleware(), logoutAllSessions)
+			auth.GET("/sessions", tokenMiddleware(), getSessions)
+			auth.POST("/change-password", tokenMiddleware(), changePassword)
+			auth.POST("/reset-password", resetPassword)
+
+			// 两步验证
+			auth.POST("/2fa/verify", verifyLoginChallenge)
+			auth.GET("/2fa", tokenMiddleware(), getTOTPStatus)
+			auth.POST("/2fa/setup", tokenMiddleware(), setupTOTP)
+			auth.POST("/2fa/enable", tokenMiddleware(), enableTOTP)
+			auth.POST("/2fa/disable", authMiddleware(), disableTOTP)
+			auth.POST("/2fa/recovery-codes", authMiddleware(), regenerateRecoveryCodes)
+		}
+
+		// 员工门户登录（无需鉴权）
+		employeeAuth := api.Group("/employee-auth")
+		{
+			employeeAuth.POST("/otp", requestEmployeeLogin("otp"))
+			employeeAuth.POST("/otp/verify", verifyEmployeeOTP)
+			employeeAuth.POST("/magic-link", requestEmployeeLogin("magic_link"))
+			employeeAuth.POST("/magic-link/verify", verifyEmployeeMagicLink)
+			employeeAuth.GET("/me", employeeAuthMiddleware(), getEmployeeProfile)
 		}
+		api.POST("/payslip-links/exchange", exchangePayslipLink)
+
+		// 文档封存公钥和封存包核验（无需鉴权）
+		api.GET("/seal-keys", getSealKeys)
+		api.POST("/seals/verify", verifySealBundle)
 
-		// 公开路由（无需鉴权）- 员工查看工资条
-		api.GET("/payrolls/:id", getPayroll)
-		api.GET("/payrolls/employee/:employee_id", getEmployeePayrolls)
-		api.POST("/payrolls/sign", signPayroll)
-		api.GET("/payrolls/:id/signature", getPayrollSignature)
+		// 员工查看工资条：员工令牌只能访问本人数据，管理员需要 payrolls:read 权限
+		payslips := api.Group("/")
+		payslips.Use(payslipAccessMiddleware())
+		{
+			payslips.GET("/payrolls/:id", getPayroll)
+			payslips.GET("/payrolls/employee/:employee_id", getEmployeePayrolls)
+			payslips.POST("/payrolls/sign", signPayroll)
+			payslips.GET("/payrolls/:id/signature", getPayrollSignature)
+			payslips.GET("/payrolls/:id/signature/verify", verifyPayrollSignature)
+			payslips.GET("/payrolls/:id/seal", getPayrollSeal)
+			payslips.GET("/payrolls/:id/pdf", getPayrollPDF)
+		}
 		
 		// IP地址获取接口（无需鉴权）
 		api.GET("/client-ip", getClientIP)
@@ -376,47 +451,92 @@ func setupRoutes() *gin.Engine {
 		api.GET("/resignations/:id", getResignation)  // 公开查看离职申请（用于签名页面）
 		api.POST("/resignations/sign", signResignation)  // 公开签名接口
 		api.GET("/resignations/:id/signatures", getResignationSignatures)  // 公开查看签名列表
+		api.GET("/resignations/:id/pdf", getSignedResignationPDF)  // 凭签名令牌下载离职文件PDF
 
 		// 需要鉴权的管理员路由
 		admin := api.Group("/")
 		admin.Use(authMiddleware())
 		{
-			admin.GET("/employees", getEmployees)
-			admin.POST("/employees", createEmployee)
-			admin.GET("/employees/:id", getEmployee)
-			admin.PUT("/employees/:id", updateEmployee)
-			admin.DELETE("/employees/:id", deleteEmployee)
-
-			admin.GET("/templates", getTemplates)
-			admin.POST("/templates", createTemplate)
-			admin.PUT("/templates/:id", updateTemplate)
-			admin.DELETE("/templates/:id", deleteTemplate)
-
-			admin.GET("/payrolls", getPayrolls)
-			admin.POST("/payrolls", createPayroll)
-			admin.PUT("/payrolls/:id", updatePayroll)
-			admin.DELETE("/payrolls/:id", deletePayroll)
+			admin.GET("/employees", requirePermission(PermEmployeesRead), getEmployees)
+			admin.POST("/employees", requirePermission(PermEmployeesWrite), createEmployee)
+			admin.GET("/employees/:id", requirePermission(PermEmployeesRead), getEmployee)
+			admin.PUT("/employees/:id", requirePermission(PermEmployeesWrite), updateEmployee)
+			admin.DELETE("/employees/:id", requirePermission(PermEmployeesWrite), deleteEmployee)
+			admin.PUT("/employees/:id/portal", requirePermission(PermEmployeesWrite), updateEmployeePortal)
+			admin.GET("/employees/:id/compensation", requirePermission(PermCompensationRead), getCompensationProfiles)
+			admin.GET("/employees/:id/compensation/current", requirePermission(PermCompensationRead), getCurrentCompensationProfile)
+			admin.POST("/employees/:id/compensation", requirePermission(PermCompensationWrite), createCompensationProfile)
+			admin.PUT("/employees/:id/compensation/:profile_id", requirePermission(PermCompensationWrite), updateCompensationProfile)
+			admin.DELETE("/employees/:id/compensation/:profile_id", requirePermission(PermCompensationWrite), deleteCompensationProfile)
+
+			admin.GET("/templates", requirePermission(PermTemplatesRead), getTemplates)
+			admin.POST("/templates", requirePermission(PermTemplatesWrite), createTemplate)
+			admin.PUT("/templates/:id", requirePermission(PermTemplatesWrite), updateTemplate)
+			admin.DELETE("/templates/:id", requirePermission(PermTemplatesWrite), deleteTemplate)
+
+			admin.GET("/payrolls", requirePermission(PermPayrollsRead), getPayrolls)
+			admin.POST("/payrolls", requirePermission(PermPayrollsWrite), createPayroll)
+			admin.PUT("/payrolls/:id", requirePermission(PermPayrollsWrite), updatePayroll)
+			admin.DELETE("/payrolls/:id", requirePermission(PermPayrollsWrite), deletePayroll)
 			
-			admin.POST("/payrolls/publish", publishPayrolls)
-			admin.GET("/notifications", getNotifications)
-			admin.POST("/notifications/resend", resendNotification)
+			admin.POST("/payrolls/publish", requirePermission(PermPayrollsPublish), publishPayrolls)
+			admin.GET("/payrolls/:id/links", requirePermission(PermPayrollsRead), getPayslipLinks)
+			admin.POST("/payrolls/:id/links", requirePermission(PermPayrollsPublish), createPayslipLink)
+			admin.POST("/payslip-links/:id/revoke", requirePermission(PermPayrollsPublish), revokePayslipLink)
+			admin.GET("/notifications", requirePermission(PermNotificationsRead), getNotifications)
+			admin.POST("/notifications/resend", requirePermission(PermNotificationsWrite), resendNotification)
 
 			// 离职管理路由
-			admin.GET("/resignations", getResignations)
-			admin.POST("/resignations", createResignation)
+			admin.GET("/resignations", requirePermission(PermResignationsRead), getResignations)
+			admin.POST("/resignations", requirePermission(PermResignationsWrite), createResignation)
 			// admin.GET("/resignations/:id", getResignation)  // 已移到公开路由
-			admin.PUT("/resignations/:id", updateResignation)
-			admin.DELETE("/resignations/:id", deleteResignation)
-			admin.POST("/resignations/:id/approve", approveResignation)
-			admin.POST("/resignations/:id/reject", rejectResignation)
-			admin.POST("/resignations/:id/generate-sign-token", generateSignToken)  // 生成签名令牌
+			admin.PUT("/resignations/:id", requirePermission(PermResignationsWrite), updateResignation)
+			admin.DELETE("/resignations/:id", requirePermission(PermResignationsWrite), deleteResignation)
+			admin.POST("/resignations/:id/approve", requirePermission(PermResignationsApprove), approveResignation)
+			admin.POST("/resignations/:id/reject", requirePermission(PermResignationsApprove), rejectResignation)
+			admin.GET("/resignations/:id/signatures/verify", requirePermission(PermResignationsRead), verifyResignationSignatures)
+			admin.GET("/resignations/:id/seal", requirePermission(PermResignationsRead), getResignationSeal)
+			admin.POST("/resignations/:id/generate-sign-token", requirePermission(PermResignationsWrite), generateSignToken)  // 生成签名令牌
 			
 			// 离职报告路由
-			admin.GET("/resignation-reports", getResignationReports)
-			admin.POST("/resignation-reports", createResignationReport)
-			admin.GET("/resignation-reports/:id", getResignationReport)
-			admin.PUT("/resignation-reports/:id", updateResignationReport)
-			admin.DELETE("/resignation-reports/:id", deleteResignationReport)
+			admin.GET("/resignation-reports", requirePermission(PermResignationReportsRead), getResignationReports)
+			admin.POST("/resignation-reports", requirePermission(PermResignationReportsWrite), createResignationReport)
+			admin.GET("/resignation-reports/:id", requirePermission(PermResignationReportsRead), getResignationReport)
+			admin.GET("/resignation-reports/:id/pdf", requirePermission(PermResignationReportsRead), getResignationReportPDF)
+			admin.PUT("/resignation-reports/:id", requirePermission(PermResignationReportsWrite), updateResignationReport)
+			admin.DELETE("/resignation-reports/:id", requirePermission(PermResignationReportsWrite), deleteResignationReport)
+
+			// 文档模板
+			admin.GET("/document-templates", requirePermission(PermDocumentTemplatesRead), getDocumentTemplates)
+			admin.GET("/document-templates/active", requirePermission(PermDocumentTemplatesRead), getActiveDocumentTemplate)
+			admin.GET("/document-templates/:id", requirePermission(PermDocumentTemplatesRead), getDocumentTemplate)
+			admin.POST("/document-templates", requirePermission(PermDocumentTemplatesWrite), createDocumentTemplate)
+			admin.POST("/document-templates/preview", requirePermission(PermDocumentTemplatesWrite), previewDocumentTemplate)
+			admin.POST("/document-templates/reset", requirePermission(PermDocumentTemplatesWrite), resetDocumentTemplate)
+			admin.POST("/document-templates/:id/activate", requirePermission(PermDocumentTemplatesWrite), activateDocumentTemplate)
+
+			// 社保公积金政策
+			admin.GET("/contribution-policies", requirePermission(PermContributionPoliciesRead), getContributionPolicies)
+			admin.GET("/contribution-policies/:id", requirePermission(PermContributionPoliciesRead), getContributionPolicy)
+			admin.POST("/contribution-policies", requirePermission(PermContributionPoliciesWrite), createContributionPolicy)
+			admin.PUT("/contribution-policies/:id", requirePermission(PermContributionPoliciesWrite), updateContributionPolicy)
+			admin.DELETE("/contribution-policies/:id", requirePermission(PermContributionPoliciesWrite), deleteContributionPolicy)
+			admin.GET("/contribution-report", requirePermission(PermPayrollsRead), getContributionReport)
+
+			// 管理员账号管理
+			admin.GET("/admin-users", requirePermission(PermAdminUsersRead), getAdminUsers)
+			admin.POST("/admin-users", requirePermission(PermAdminUsersWrite), createAdminUser)
+			admin.GET("/admin-users/:id", requirePermission(PermAdminUsersRead), getAdminUser)
+			admin.PUT("/admin-users/:id", requirePermission(PermAdminUsersWrite), updateAdminUser)
+			admin.DELETE("/admin-users/:id", requirePermission(PermAdminUsersWrite), deactivateAdminUser)
+			admin.POST("/admin-users/:id/reset-password", requirePermission(PermAdminUsersWrite), createPasswordResetToken)
+			admin.GET("/admin-users/:id/sessions", requirePermission(PermAdminUsersRead), getAdminUserSessions)
+			admin.POST("/admin-users/:id/logout-all", requirePermission(PermAdminUsersWrite), logoutAdminUserSessions)
+			admin.POST("/admin-users/:id/unlock", requirePermission(PermAdminUsersWrite), unlockAdminUser)
+			admin.POST("/admin-users/:id/reset-2fa", requirePermission(PermAdminUsersWrite), resetAdminUserTOTP)
+			admin.GET("/login-attempts", requirePermission(PermAdminUsersRead), getLoginAttempts)
+			admin.GET("/audit-logs", requirePermission(PermAuditLogsRead), getAuditLogs)
+			admin.GET("/audit-logs/export", requirePermission(PermAuditLogsRead), exportAuditLogs)
 		}
 	}
 
@@ -431,28 +551,106 @@ func login(c *gin.Context) {
 		return
 	}
 
+	if !checkIPLockout(c, req.Username) {
+		return
+	}
+
 	var user AdminUser
 	if err := db.Where("username = ? AND is_active = ?", req.Username, true).First(&user).Error; err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
+		verifyDummyPassword(req.Password)
+		rejectLogin(c, nil, req.Username)
+		return
+	}
+
+	if !checkAccountLockout(c, user) {
 		return
 	}
 
 	if !verifyPassword(user.Password, req.Password) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
+		rejectLogin(c, &user, req.Username)
+		return
+	}
+
+	// 旧版MD5或强度不足的哈希在登录成功后透明升级
+	if passwordNeedsRehash(user.Password) {
+		if hash, err := hashPassword(req.Password); err == nil {
+			if err := db.Model(&user).Update("password", hash).Error; err != nil {
+				log.Printf("Failed to rehash password for %s: %v", user.Username, err)
+			}
+		}
+	}
+
+	// 已启用两步验证时先返回挑战，验证码通过后才签发令牌
+	if user.TOTPEnabled {
+		issueLoginChallenge(c, user, req.Remember)
 		return
 	}
+	completeLogin(c, user, req.Remember)
+}
+
+// 全部认证步骤通过后记录登录并签发令牌
+func completeLogin(c *gin.Context, user AdminUser, remember bool) {
+	recordLoginSuccess(c, user)
 
-	token, expiresAt, err := generateJWTToken(user, req.Remember)
+	now := time.Now()
+	db.Model(&user).Updates(map[string]interface{}{
+		"last_login_at": &now,
+		"last_login_ip": c.ClientIP(),
+	})
+
+	resp, err := issueSession(c, user, remember)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成token失败"})
 		return
 	}
 
-	c.JSON(http.StatusOK, LoginResponse{
-		Token:     token,
-		ExpiresAt: expiresAt,
-		Username:  user.Username,
-	})
+	c.JSON(http.StatusOK, resp)
+}
+
+// 修改当前管理员密码
+func changePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误，新密码至少8位"})
+		return
+	}
+
+	var user AdminUser
+	if err := db.Where("id = ? AND is_active = ?", c.GetUint("user_id"), true).First(&user).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在或已停用"})
+		return
+	}
+
+	if !verifyPassword(user.Password, req.OldPassword) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "原密码错误"})
+		return
+	}
+	if err := validatePasswordPolicy(user, req.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 修改密码后其他会话全部退出，当前会话重新签发令牌
+	before := user
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := setAdminPassword(tx, &user, req.NewPassword, false); err != nil {
+			return err
+		}
+		return revokeAllSessions(tx, user.ID)
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "修改密码失败"})
+		return
+	}
+	db.First(&user, user.ID)
+	recordAudit(c, "admin_user.change_password", "admin_user", user.ID, before, user)
+
+	resp, err := issueSession(c, user, req.Remember)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成token失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
 }
 
 // 验证token
@@ -465,21 +663,26 @@ func verifyToken(c *gin.Context) {
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 	claims, err := verifyJWTToken(tokenString)
-	if err != nil {
+	if err != nil || isAccessTokenRevoked(claims) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的token"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"valid":    true,
-		"username": claims.Username,
-		"user_id":  claims.UserID,
+		"valid":       true,
+		"username":    claims.Username,
+		"user_id":     claims.UserID,
+		"role":        claims.Role,
+		"permissions": claims.Permissions,
 	})
 }
 
 func getEmployees(c *gin.Context) {
 	var employees []Employee
 	query := db.Where("deleted_at IS NULL")
+	if department, scoped := departmentScope(c); scoped {
+		query = query.Where("department = ?", department)
+	}
 	
 	// 支持状态筛选
 	if status := c.Query("status"); status != "" {
@@ -508,6 +711,16 @@ func createEmployee(c *gin.Context) {
 		Email:      req.Email,
 		Phone:      req.Phone,
 	}
+	if req.SpecialDeduction != nil {
+		if req.SpecialDeduction.IsNegative() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "专项附加扣除不能为负数"})
+			return
+		}
+		employee.SpecialDeduction = *req.SpecialDeduction
+	}
+	if !applyEmployeeContribution(c, &employee, req) {
+		return
+	}
 
 	// 处理入职日期
 	if req.JoinDate != "" {
@@ -521,6 +734,7 @@ func createEmployee(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(c, "employee.create", "employee", employee.ID, nil, employee)
 
 	c.JSON(http.StatusCreated, gin.H{"data": employee})
 }
@@ -532,6 +746,10 @@ func getEmployee(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
 		return
 	}
+	if department, scoped := departmentScope(c); scoped && employee.Department != department {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"data": employee})
 }
 
@@ -549,12 +767,23 @@ func updateEmployee(c *gin.Context) {
 		return
 	}
 
+	before := employee
 	// 更新员工信息
 	employee.Name = req.Name
 	employee.Department = req.Department
 	employee.Position = req.Position
 	employee.Email = req.Email
 	employee.Phone = req.Phone
+	if req.SpecialDeduction != nil {
+		if req.SpecialDeduction.IsNegative() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "专项附加扣除不能为负数"})
+			return
+		}
+		employee.SpecialDeduction = *req.SpecialDeduction
+	}
+	if !applyEmployeeContribution(c, &employee, req) {
+		return
+	}
 	
 	// 处理入职日期
 	if req.JoinDate != "" {
@@ -568,6 +797,7 @@ func updateEmployee(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(c, "employee.update", "employee", employee.ID, before, employee)
 
 	c.JSON(http.StatusOK, gin.H{"data": employee})
 }
@@ -580,6 +810,8 @@ func deleteEmployee(c *gin.Context) {
 		return
 	}
 
+	before := employee
+
 	// 检查是否有相关的工资条
 	var payrollCount int64
 	db.Model(&Payroll{}).Where("employee_id = ?", id).Count(&payrollCount)
@@ -595,6 +827,7 @@ func deleteEmployee(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		recordAudit(c, "employee.delete", "employee", employee.ID, before, employee)
 		
 		c.JSON(http.StatusOK, gin.H{
 			"message": "员工已标记为离职（保留历史数据）",
@@ -611,6 +844,7 @@ func deleteEmployee(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		recordAudit(c, "employee.delete", "employee", employee.ID, before, employee)
 		
 		c.JSON(http.StatusOK, gin.H{
 			"message": "员工已删除（软删除）",
@@ -634,11 +868,15 @@ func createTemplate(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !validateTemplateFields(c, template.Fields) {
+		return
+	}
 
 	if err := db.Create(&template).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(c, "template.create", "template", template.ID, nil, template)
 
 	c.JSON(http.StatusCreated, gin.H{"data": template})
 }
@@ -656,7 +894,11 @@ func updateTemplate(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !validateTemplateFields(c, req.Fields) {
+		return
+	}
 
+	before := template
 	template.Name = req.Name
 	template.Description = req.Description
 	template.Fields = req.Fields
@@ -666,6 +908,7 @@ func updateTemplate(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(c, "template.update", "template", template.ID, before, template)
 
 	c.JSON(http.StatusOK, gin.H{"data": template})
 }
@@ -683,11 +926,13 @@ func deleteTemplate(c *gin.Context) {
 	db.Model(&Payroll{}).Where("template_id = ?", id).Count(&count)
 	if count > 0 {
 		// 如果有工资条使用，只禁用模板而不删除
+		before := template
 		template.IsActive = false
 		if err := db.Save(&template).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		recordAudit(c, "template.disable", "template", template.ID, before, template)
 		c.JSON(http.StatusOK, gin.H{"message": "Template disabled (in use by payrolls)"})
 		return
 	}
@@ -697,6 +942,7 @@ func deleteTemplate(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(c, "template.delete", "template", template.ID, template, nil)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Template deleted successfully"})
 }
@@ -714,6 +960,9 @@ func getPayrolls(c *gin.Context) {
 	if employeeID := c.Query("employee_id"); employeeID != "" {
 		query = query.Where("employee_id = ?", employeeID)
 	}
+	if payGroup := c.Query("pay_group"); payGroup != "" {
+		query = query.Where("pay_group = ?", payGroup)
+	}
 
 	if err := query.Find(&payrolls).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -729,44 +978,49 @@ func createPayroll(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if _, err := time.Parse("2006-01", req.Period); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "工资期间格式应为 YYYY-MM"})
+		return
+	}
 
-	// 计算工资时考虑是否按比例
-	var totalGross, totalNet float64
-	if req.IsProrated && req.WorkDays > 0 && req.MonthDays > 0 {
-		// 按天数比例计算：只对收入项按比例，扣款项保持不变
-		ratio := req.WorkDays / req.MonthDays
-		totalGross, totalNet = calculateProratedPayroll(req.PayrollData, ratio)
-	} else {
-		// 全月工资
-		totalGross, totalNet = calculatePayroll(req.PayrollData)
-		if req.WorkDays == 0 {
-			req.WorkDays = req.MonthDays // 如果没有指定，默认为全月
-		}
+	// 按模板字段定义计算工资，标记为按出勤折算的字段按天数比例计算
+	calc, err := computePayroll(&req)
+	if err != nil {
+		respondPayrollCalculationError(c, err)
+		return
 	}
-	
-	// 保存原始的全月工资额用于参考
-	originalGross, _ := calculatePayroll(req.PayrollData)
 
 	payrollDataJSON, _ := json.Marshal(req.PayrollData)
+	breakdownJSON, _ := json.Marshal(calc.Lines)
+	profileID, payGroup := calc.compensationRef()
 	payroll := Payroll{
-		UUID:          generateUUID(),
-		EmployeeID:    req.EmployeeID,
-		Period:        req.Period,
-		TemplateID:    req.TemplateID,
-		WorkDays:      req.WorkDays,
-		MonthDays:     req.MonthDays,
-		IsProrated:    req.IsProrated,
-		PayrollData:   string(payrollDataJSON),
-		OriginalGross: originalGross,
-		TotalGross:    totalGross,
-		TotalNet:      totalNet,
-		Status:        "draft",
+		UUID:                  generateUUID(),
+		EmployeeID:            req.EmployeeID,
+		Period:                req.Period,
+		TemplateID:            req.TemplateID,
+		WorkDays:              req.WorkDays,
+		MonthDays:             req.MonthDays,
+		IsProrated:            req.IsProrated,
+		PayrollData:           string(payrollDataJSON),
+		OriginalGross:         calc.OriginalGross,
+		TotalGross:            calc.TotalGross,
+		TotalNet:              calc.TotalNet,
+		TaxableIncome:         calc.TaxableIncome,
+		SpecialDeduction:      calc.SpecialDeduction,
+		IncomeTax:             calc.IncomeTaxAmount(),
+		EmployerContributions: calc.EmployerContributions,
+		Contributions:         contributionsJSON(calc.Contributions),
+		CompensationProfileID: profileID,
+		PayGroup:              payGroup,
+		Breakdown:             string(breakdownJSON),
+		Status:                "draft",
 	}
 
 	if err := db.Create(&payroll).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(c, "payroll.create", "payroll", payroll.UUID, nil, payroll)
 
 	db.Preload("Employee").Preload("Template").First(&payroll, payroll.ID)
 
@@ -776,7 +1030,7 @@ func createPayroll(c *gin.Context) {
 func getPayroll(c *gin.Context) {
 	uuid := c.Param("id")
 	var payroll Payroll
-	if err := db.Preload("Employee").Preload("Template").Where("uuid = ?", uuid).First(&payroll).Error; err != nil {
+	if err := db.Preload("Employee").Preload("Template").Where("uuid = ?", uuid).First(&payroll).Error; err != nil || !canViewPayroll(c, payroll) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Payroll not found"})
 		return
 	}
@@ -785,6 +1039,11 @@ func getPayroll(c *gin.Context) {
 	if err := db.Where("payroll_id = ?", payroll.ID).First(&signature).Error; err == nil {
 		payroll.Status = "signed"
 	}
+	// 旧工资条没有保存明细，按模板字段定义补算
+	if payroll.Breakdown == "" {
+		breakdownJSON, _ := json.Marshal(payrollBreakdown(payroll, payroll.Template))
+		payroll.Breakdown = string(breakdownJSON)
+	}
 
 	c.JSON(http.StatusOK, gin.H{"data": payroll})
 }
@@ -802,43 +1061,55 @@ func updatePayroll(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if _, err := time.Parse("2006-01", req.Period); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "工资期间格式应为 YYYY-MM"})
+		return
+	}
 
 	if payroll.Status != "draft" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot update published or signed payroll"})
 		return
 	}
 
-	// 计算工资时考虑是否按比例
-	var totalGross, totalNet float64
-	if req.IsProrated && req.WorkDays > 0 && req.MonthDays > 0 {
-		// 按天数比例计算：只对收入项按比例，扣款项保持不变
-		ratio := req.WorkDays / req.MonthDays
-		totalGross, totalNet = calculateProratedPayroll(req.PayrollData, ratio)
-	} else {
-		// 全月工资
-		totalGross, totalNet = calculatePayroll(req.PayrollData)
-		if req.WorkDays == 0 {
-			req.WorkDays = req.MonthDays
-		}
+	// 员工和期间不随更新改变，个税按工资条原有的员工和期间累计
+	req.EmployeeID = payroll.EmployeeID
+	req.Period = payroll.Period
+	if req.TemplateID == 0 {
+		req.TemplateID = payroll.TemplateID
+	}
+
+	// 按模板字段定义计算工资，标记为按出勤折算的字段按天数比例计算
+	calc, err := computePayroll(&req)
+	if err != nil {
+		respondPayrollCalculationError(c, err)
+		return
 	}
-	
-	// 保存原始的全月工资额用于参考
-	originalGross, _ := calculatePayroll(req.PayrollData)
 
 	payrollDataJSON, _ := json.Marshal(req.PayrollData)
+	breakdownJSON, _ := json.Marshal(calc.Lines)
 
+	before := payroll
 	payroll.PayrollData = string(payrollDataJSON)
 	payroll.WorkDays = req.WorkDays
 	payroll.MonthDays = req.MonthDays
 	payroll.IsProrated = req.IsProrated
-	payroll.OriginalGross = originalGross
-	payroll.TotalGross = totalGross
-	payroll.TotalNet = totalNet
+	payroll.TemplateID = req.TemplateID
+	payroll.OriginalGross = calc.OriginalGross
+	payroll.TotalGross = calc.TotalGross
+	payroll.TotalNet = calc.TotalNet
+	payroll.TaxableIncome = calc.TaxableIncome
+	payroll.SpecialDeduction = calc.SpecialDeduction
+	payroll.IncomeTax = calc.IncomeTaxAmount()
+	payroll.EmployerContributions = calc.EmployerContributions
+	payroll.Contributions = contributionsJSON(calc.Contributions)
+	payroll.CompensationProfileID, payroll.PayGroup = calc.compensationRef()
+	payroll.Breakdown = string(breakdownJSON)
 
 	if err := db.Save(&payroll).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(c, "payroll.update", "payroll", payroll.UUID, before, payroll)
 
 	c.JSON(http.StatusOK, gin.H{"data": payroll})
 }
@@ -860,6 +1131,7 @@ func deletePayroll(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(c, "payroll.delete", "payroll", payroll.UUID, payroll, nil)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Payroll deleted successfully"})
 }
@@ -883,17 +1155,23 @@ func publishPayrolls(c *gin.Context) {
 	}
 
 	now := time.Now()
-	if err := db.Model(&Payroll{}).Where("uuid IN ?", req.PayrollUUIDs).Updates(map[string]interface{}{
+	if err := db.Model(&Payroll{}).Where("uuid IN ? AND status = ?", req.PayrollUUIDs, "draft").Updates(map[string]interface{}{
 		"status":       "published",
 		"published_at": &now,
 	}).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	for _, payroll := range payrolls {
+		published := payroll
+		published.Status = "published"
+		published.PublishedAt = &now
+		recordAudit(c, "payroll.publish", "payroll", payroll.UUID, payroll, published)
+	}
 
 	if req.NotifyEmployees {
 		for _, payroll := range payrolls {
-			sendPayrollNotification(payroll)
+			sendPayrollNotification(payroll, c.GetUint("user_id"))
 		}
 	}
 
@@ -904,7 +1182,18 @@ func publishPayrolls(c *gin.Context) {
 }
 
 func getEmployeePayrolls(c *gin.Context) {
-	employeeID := c.Param("employee_id")
+	id, err := strconv.ParseUint(c.Param("employee_id"), 10, 64)
+	employeeID := uint(id)
+	if ownID, ok := currentEmployeeID(c); ok {
+		// 员工只能查看本人的工资条，通过单个工资条链接登录时不能查看历史
+		if _, scoped := currentPayrollScope(c); scoped || err != nil || employeeID != ownID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "无权查看其他员工的工资条"})
+			return
+		}
+	} else if err != nil || !canAccessEmployee(c, employeeID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
+		return
+	}
 	var payrolls []Payroll
 
 	query := db.Preload("Employee").Preload("Template").Where("employee_id = ? AND status IN ?", employeeID, []string{"published", "signed"})
@@ -921,6 +1210,8 @@ func getEmployeePayrolls(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"data": payrolls})
 }
 
+var errPayrollAlreadySigned = errors.New("Payroll already signed")
+
 func signPayroll(c *gin.Context) {
 	var req SignPayrollRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -928,20 +1219,26 @@ func signPayroll(c *gin.Context) {
 		return
 	}
 
+	// 只有员工本人可以签收
+	employeeID, ok := currentEmployeeID(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只有员工本人可以签收工资条"})
+		return
+	}
+
 	var payroll Payroll
-	if err := db.Where("uuid = ? AND status = ?", req.PayrollUUID, "published").First(&payroll).Error; err != nil {
+	if err := db.Where("uuid = ? AND status = ? AND employee_id = ?", req.PayrollUUID, "published", employeeID).First(&payroll).Error; err != nil || !canViewPayroll(c, payroll) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Payroll not found or not published"})
 		return
 	}
 
-	var existingSignature PayrollSignature
-	if err := db.Where("payroll_id = ?", payroll.ID).First(&existingSignature).Error; err == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Payroll already signed"})
+	image, err := decodeSignatureImage(req.SignatureData)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	signatureHash := generateSignatureHash(req.SignatureData)
-	signatureFileName, err := saveSignatureImage(req.SignatureData, signatureHash)
+	integrity := newSignatureIntegrity(payrollSnapshot(payroll), image, "")
+	signatureFileName, err := saveSignatureImage(image, integrity.ImageHash)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save signature image"})
 		return
@@ -950,22 +1247,41 @@ func signPayroll(c *gin.Context) {
 	signature := PayrollSignature{
 		PayrollID:     payroll.ID, // 使用内部ID关联
 		SignatureData: signatureFileName, // 存储文件路径而不是base64数据
-		SignatureHash: signatureHash,
-		IPAddress:     req.IPAddress,
-		UserAgent:     req.UserAgent,
+		IPAddress:     c.ClientIP(),
+		UserAgent:     c.GetHeader("User-Agent"),
 		DeviceInfo:    req.DeviceInfo,
 		SignedAt:      time.Now(),
 	}
 
-	if err := db.Create(&signature).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	if err := db.Model(&payroll).Update("status", "signed").Error; err != nil {
+	// 签名记录哈希链接到该工资条上一条签名；是否已签收在同一事务内检查，并由唯一索引兜底
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		var signed int64
+		if err := tx.Model(&PayrollSignature{}).Where("payroll_id = ?", payroll.ID).Count(&signed).Error; err != nil {
+			return err
+		}
+		if signed > 0 {
+			return errPayrollAlreadySigned
+		}
+		integrity.PrevHash = lastSignatureHash(tx, &PayrollSignature{}, "payroll_id", payroll.ID)
+		signature.SignatureIntegrity = integrity
+		signature.SignatureHash = signatureRecordHash("payroll", payroll.UUID, "employee", signature.IPAddress, signature.SignedAt, integrity)
+		if err := tx.Create(&signature).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&payroll).Update("status", "signed").Error; err != nil {
+			return err
+		}
+		// 服务端对签收的工资条做数字签名封存
+		return sealPayroll(tx, payroll, signature)
+	}); err != nil {
+		if errors.Is(err, errPayrollAlreadySigned) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(c, "payroll.sign", "payroll", payroll.UUID, nil, signature)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Payroll signed successfully",
@@ -977,7 +1293,7 @@ func getPayrollSignature(c *gin.Context) {
 	payrollUUID := c.Param("id")
 	// 先找到工资条
 	var payroll Payroll
-	if err := db.Where("uuid = ?", payrollUUID).First(&payroll).Error; err != nil {
+	if err := db.Where("uuid = ?", payrollUUID).First(&payroll).Error; err != nil || !canViewPayroll(c, payroll) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Payroll not found"})
 		return
 	}
@@ -1021,7 +1337,8 @@ func resendNotification(c *gin.Context) {
 		return
 	}
 
-	success := sendPayrollNotification(notification.Payroll)
+	success := sendPayrollNotification(notification.Payroll, c.GetUint("user_id"))
+	recordAudit(c, "notification.resend", "payroll", notification.Payroll.UUID, nil, gin.H{"notification_id": notification.ID, "sent": success})
 	if success {
 		now := time.Now()
 		notification.Status = "sent"
@@ -1033,130 +1350,23 @@ func resendNotification(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Notification resent successfully"})
 }
 
-func calculatePayroll(data map[string]interface{}) (totalGross, totalNet float64) {
-	// 动态识别收入项和扣款项
-	// 包含这些关键词的字段被认为是扣款项
-	deductionKeywords := []string{"tax", "insurance", "deduction", "扣款", "扣除", "罚款", "penalty"}
-	
-	// 先计算所有收入项
-	for key, val := range data {
-		if amount, ok := val.(float64); ok {
-			// 检查是否为扣款项
-			isDeduction := false
-			keyLower := strings.ToLower(key)
-			for _, keyword := range deductionKeywords {
-				if strings.Contains(keyLower, keyword) {
-					isDeduction = true
-					break
-				}
-			}
-			
-			// 如果不是扣款项，就是收入项
-			if !isDeduction && amount > 0 {
-				totalGross += amount
-			}
-		}
-	}
-	
-	// 从总收入中减去所有扣款项
-	totalNet = totalGross
-	for key, val := range data {
-		if amount, ok := val.(float64); ok {
-			keyLower := strings.ToLower(key)
-			for _, keyword := range deductionKeywords {
-				if strings.Contains(keyLower, keyword) && amount > 0 {
-					totalNet -= amount
-					break
-				}
-			}
-		}
-	}
-
-	return totalGross, totalNet
-}
-
-// 按比例计算工资：只对基本工资按比例，其他收入项和扣款项都保持不变
-func calculateProratedPayroll(data map[string]interface{}, ratio float64) (totalGross, totalNet float64) {
-	// 扣款项关键词（这些项不按比例计算）
-	deductionKeywords := []string{"tax", "insurance", "deduction", "扣款", "扣除", "罚款", "penalty", "fund", "公积金"}
-	
-	// 基本工资关键词（只有这些按比例计算）
-	basicSalaryKeywords := []string{"basic_salary", "base_salary", "基本工资", "底薪"}
-	
-	// 计算所有收入项
-	for key, val := range data {
-		if amount, ok := val.(float64); ok && amount > 0 {
-			keyLower := strings.ToLower(key)
-			
-			// 检查是否为扣款项
-			isDeduction := false
-			for _, keyword := range deductionKeywords {
-				if strings.Contains(keyLower, keyword) {
-					isDeduction = true
-					break
-				}
-			}
-			
-			// 如果不是扣款项，就是收入项
-			if !isDeduction {
-				// 检查是否为基本工资（精确匹配）
-				isBasicSalary := false
-				for _, keyword := range basicSalaryKeywords {
-					if keyLower == keyword {
-						isBasicSalary = true
-						break
-					}
-				}
-				
-				// 基本工资按比例，其他收入项保持原值
-				if isBasicSalary {
-					totalGross += amount * ratio
-				} else {
-					totalGross += amount  // 绩效、餐补等保持原值
-				}
-			}
-		}
-	}
-	
-	// 从总收入中减去所有扣款项（扣款项不按比例，保持原值）
-	totalNet = totalGross
-	for key, val := range data {
-		if amount, ok := val.(float64); ok && amount > 0 {
-			keyLower := strings.ToLower(key)
-			for _, keyword := range deductionKeywords {
-				if strings.Contains(keyLower, keyword) {
-					totalNet -= amount  // 扣款项保持原值，不按比例
-					break
-				}
-			}
-		}
-	}
-
-	return totalGross, totalNet
-}
-
-// 密码哈希
-func hashPassword(password string) string {
-	hash := md5.Sum([]byte(password + "payroll-salt"))
-	return hex.EncodeToString(hash[:])
-}
-
-// 验证密码
-func verifyPassword(hashedPassword, password string) bool {
-	return hashedPassword == hashPassword(password)
-}
-
-// 生成JWT Token
-func generateJWTToken(user AdminUser, remember bool) (string, time.Time, error) {
-	expiresAt := time.Now().Add(24 * time.Hour) // 默认24小时
-	if remember {
-		expiresAt = time.Now().Add(7 * 24 * time.Hour) // 记住登录7天
-	}
+// 生成短期有效的JWT访问令牌
+func generateJWTToken(user AdminUser, sessionID string) (string, time.Time, error) {
+	expiresAt := time.Now().Add(appConfig.Auth.TokenTTL)
 
 	claims := JWTClaims{
 		Username: user.Username,
 		UserID:   user.ID,
+		MustChangePassword: user.MustChangePassword,
+		TOTPSetupRequired:  totpSetupRequired(user),
+		Role:        user.Role,
+		Department:  user.Department,
+		Permissions: user.EffectivePermissions(),
+		SessionID:    sessionID,
+		TokenVersion: user.TokenVersion,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        generateUUID(),
+			Audience:  jwt.ClaimStrings{adminAudience},
 			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    "payroll",
@@ -1164,15 +1374,15 @@ func generateJWTToken(user AdminUser, remember bool) (string, time.Time, error)
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtSecret)
+	tokenString, err := token.SignedString([]byte(appConfig.Auth.JWTSecret))
 	return tokenString, expiresAt, err
 }
 
-// 验证JWT Token
+// 验证管理员JWT Token，员工令牌的受众不同会被拒绝
 func verifyJWTToken(tokenString string) (*JWTClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
+		return []byte(appConfig.Auth.JWTSecret), nil
+	}, jwt.WithAudience(adminAudience), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 
 	if err != nil {
 		return nil, err
@@ -1185,47 +1395,88 @@ func verifyJWTToken(tokenString string) (*JWTClaims, error) {
 	return nil, jwt.ErrSignatureInvalid
 }
 
-// JWT中间件
-func authMiddleware() gin.HandlerFunc {
+// 校验请求中的token并将用户信息存储在上下文中
+func authenticateRequest(c *gin.Context) (*JWTClaims, bool) {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "需要登录"})
+		c.Abort()
+		return nil, false
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := verifyJWTToken(tokenString)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的token"})
+		c.Abort()
+		return nil, false
+	}
+	if isAccessTokenRevoked(claims) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "登录已失效，请重新登录"})
+		c.Abort()
+		return nil, false
+	}
+
+	c.Set("claims", claims)
+	c.Set("user_id", claims.UserID)
+	c.Set("username", claims.Username)
+	c.Set("role", claims.Role)
+	c.Set("department", claims.Department)
+	c.Set("permissions", claims.Permissions)
+	return claims, true
+}
+
+// 只校验token，用于修改密码等首次登录也允许访问的接口
+func tokenMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "需要登录"})
-			c.Abort()
-			return
+		if _, ok := authenticateRequest(c); ok {
+			c.Next()
 		}
+	}
+}
 
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		claims, err := verifyJWTToken(tokenString)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的token"})
-			c.Abort()
-			return
-		}
+// 校验管理员token，并要求已完成修改初始密码和绑定两步验证
+func authorizeAdmin(c *gin.Context) bool {
+	claims, ok := authenticateRequest(c)
+	if !ok {
+		return false
+	}
 
-		// 将用户信息存储在上下文中
-		c.Set("user_id", claims.UserID)
-		c.Set("username", claims.Username)
-		c.Next()
+	if claims.MustChangePassword {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "首次登录请先修改密码",
+			"code":  "password_change_required",
+		})
+		c.Abort()
+		return false
+	}
+	if claims.TOTPSetupRequired {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "请先绑定两步验证",
+			"code":  "totp_setup_required",
+		})
+		c.Abort()
+		return false
 	}
+	return true
 }
 
-func generateSignatureHash(signatureData string) string {
-	hash := md5.Sum([]byte(signatureData + time.Now().String()))
-	return hex.EncodeToString(hash[:])
+// JWT中间件
+func authMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if authorizeAdmin(c) {
+			c.Next()
+		}
+	}
 }
 
-func saveSignatureImage(base64Data, hash string) (string, error) {
-	uploadsDir := "./uploads/signatures"
+// 签名图片按内容哈希命名保存
+func saveSignatureImage(data []byte, hash string) (string, error) {
+	uploadsDir := filepath.Join(appConfig.Server.UploadsDir, "signatures")
 	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
 		return "", err
 	}
 
-	data, err := base64.StdEncoding.DecodeString(strings.Split(base64Data, ",")[1])
-	if err != nil {
-		return "", err
-	}
-
 	fileName := fmt.Sprintf("%s.png", hash)
 	filePath := filepath.Join(uploadsDir, fileName)
 
@@ -1236,7 +1487,8 @@ func saveSignatureImage(base64Data, hash string) (string, error) {
 	return fmt.Sprintf("/uploads/signatures/%s", fileName), nil
 }
 
-func sendPayrollNotification(payroll Payroll) bool {
+// 发送工资条通知，每次发送都签发新的访问链接并吊销之前的链接
+func sendPayrollNotification(payroll Payroll, issuedBy uint) bool {
 	notification := PayrollNotification{
 		PayrollID: payroll.ID,
 		Type:      "email",
@@ -1246,7 +1498,16 @@ func sendPayrollNotification(payroll Payroll) bool {
 
 	log.Printf("Sending payroll notification to %s for period %s", payroll.Employee.Email, payroll.Period)
 
-	success := true
+	link, token, err := issuePayslipLink(db, payroll, issuedBy, 0, 0, true)
+	if err == nil {
+		notification.AccessLinkID = &link.ID
+		subject := fmt.Sprintf("%s 工资条已发布", payroll.Period)
+		body := fmt.Sprintf("%s，您好：\n\n您 %s 的工资条已发布，请通过以下链接查看并签收（%s 前有效，最多可打开 %d 次，请勿转发）：\n%s\n\n链接失效后可登录员工门户查看：%s/web/employee-login.html",
+			payroll.Employee.Name, payroll.Period, link.ExpiresAt.Format("2006-01-02"), link.MaxUses,
+			payslipLinkURL(payroll, token), appConfig.Server.PublicURL)
+		err = sendMessage("email", payroll.Employee.Email, subject, body)
+	}
+	success := err == nil
 
 	if success {
 		now := time.Now()
@@ -1254,7 +1515,7 @@ func sendPayrollNotification(payroll Payroll) bool {
 		notification.SentAt = &now
 	} else {
 		notification.Status = "failed"
-		notification.ErrorMsg = "Failed to send email"
+		notification.ErrorMsg = err.Error()
 	}
 
 	db.Create(&notification)
@@ -1374,6 +1635,9 @@ func generateUUID() string {
 func getResignations(c *gin.Context) {
 	var resignations []ResignationApplication
 	query := db.Preload("Employee")
+	if department, scoped := departmentScope(c); scoped {
+		query = query.Where("employee_id IN (?)", db.Model(&Employee{}).Select("id").Where("department = ?", department))
+	}
 	
 	// 可选过滤参数
 	if status := c.Query("status"); status != "" {
@@ -1408,7 +1672,7 @@ func createResignation(c *gin.Context) {
 	
 	// 检查是否已有未完成的离职申请
 	var existingApp ResignationApplication
-	if err := db.Where("employee_id = ? AND status NOT IN ('completed', 'rejected')", req.EmployeeID).
+	if err := db.Where("employee_id = ? AND status NOT IN ?", req.EmployeeID, []string{"completed", "rejected"}).
 		First(&existingApp).Error; err == nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "该员工已有未完成的离职申请"})
 		return
@@ -1429,6 +1693,7 @@ func createResignation(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建离职申请失败"})
 		return
 	}
+	recordAudit(c, "resignation.create", "resignation", resignation.UUID, nil, resignation)
 	
 	// 加载关联数据
 	db.Preload("Employee").First(&resignation, resignation.ID)
@@ -1495,6 +1760,7 @@ func updateResignation(c *gin.Context) {
 		updates["status"] = req.Status
 	}
 	
+	before := resignation
 	if err := db.Model(&resignation).Updates(updates).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新离职申请失败"})
 		return
@@ -1502,6 +1768,7 @@ func updateResignation(c *gin.Context) {
 	
 	// 重新加载数据
 	db.Preload("Employee").First(&resignation, resignation.ID)
+	recordAudit(c, "resignation.update", "resignation", resignation.UUID, before, resignation)
 	
 	c.JSON(http.StatusOK, gin.H{
 		"message": "离职申请更新成功",
@@ -1529,6 +1796,7 @@ func deleteResignation(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除离职申请失败"})
 		return
 	}
+	recordAudit(c, "resignation.delete", "resignation", resignation.UUID, resignation, nil)
 	
 	c.JSON(http.StatusOK, gin.H{"message": "离职申请已删除"})
 }
@@ -1547,14 +1815,17 @@ func approveResignation(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "只能审批已提交的离职申请"})
 		return
 	}
+	if !canAccessEmployee(c, resignation.EmployeeID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只能审批本部门员工的离职申请"})
+		return
+	}
 	
 	var req struct {
 		ApprovalComments string `json:"approval_comments"`
 	}
 	c.ShouldBindJSON(&req)
 	
-	// 从JWT获取当前用户ID（这里简化处理）
-	userID := uint(1) // 实际应从context中获取
+	userID := c.GetUint("user_id")
 	now := time.Now()
 	
 	updates := map[string]interface{}{
@@ -1564,17 +1835,26 @@ func approveResignation(c *gin.Context) {
 		"approval_comments": req.ApprovalComments,
 	}
 	
+	before := resignation
 	if err := db.Model(&resignation).Updates(updates).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "审批失败"})
 		return
 	}
+	var approved ResignationApplication
+	db.First(&approved, resignation.ID)
+	recordAudit(c, "resignation.approve", "resignation", resignation.UUID, before, approved)
 	
 	// 更新员工状态
-	db.Model(&Employee{}).Where("id = ?", resignation.EmployeeID).
-		Updates(map[string]interface{}{
+	var employee Employee
+	if err := db.First(&employee, resignation.EmployeeID).Error; err == nil {
+		employeeBefore := employee
+		db.Model(&employee).Updates(map[string]interface{}{
 			"status":     "resigned",
 			"leave_date": resignation.LastWorkingDate,
 		})
+		db.First(&employee, employee.ID)
+		recordAudit(c, "employee.resign", "employee", employee.ID, employeeBefore, employee)
+	}
 	
 	c.JSON(http.StatusOK, gin.H{"message": "离职申请已批准"})
 }
@@ -1593,6 +1873,10 @@ func rejectResignation(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "只能驳回已提交的离职申请"})
 		return
 	}
+	if !canAccessEmployee(c, resignation.EmployeeID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只能驳回本部门员工的离职申请"})
+		return
+	}
 	
 	var req struct {
 		ApprovalComments string `json:"approval_comments"`
@@ -1604,10 +1888,14 @@ func rejectResignation(c *gin.Context) {
 		"approval_comments": req.ApprovalComments,
 	}
 	
+	before := resignation
 	if err := db.Model(&resignation).Updates(updates).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "驳回失败"})
 		return
 	}
+	var rejected ResignationApplication
+	db.First(&rejected, resignation.ID)
+	recordAudit(c, "resignation.reject", "resignation", resignation.UUID, before, rejected)
 	
 	c.JSON(http.StatusOK, gin.H{"message": "离职申请已驳回"})
 }
@@ -1616,6 +1904,10 @@ func rejectResignation(c *gin.Context) {
 func getResignationReports(c *gin.Context) {
 	var reports []ResignationReport
 	query := db.Preload("Application").Preload("Application.Employee")
+	if department, scoped := departmentScope(c); scoped {
+		query = query.Where("application_id IN (?)", db.Model(&ResignationApplication{}).Select("id").
+			Where("employee_id IN (?)", db.Model(&Employee{}).Select("id").Where("department = ?", department)))
+	}
 	
 	if err := query.Find(&reports).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取离职报告失败"})
@@ -1646,12 +1938,8 @@ func createResignationReport(c *gin.Context) {
 		return
 	}
 	
-	// 生成报告HTML内容
-	reportContent := generateResignationReportHTML(application, req)
-	
 	report := ResignationReport{
 		ApplicationID:           req.ApplicationID,
-		ReportContent:           reportContent,
 		WorkSummary:             req.WorkSummary,
 		UnfinishedTasks:         req.UnfinishedTasks,
 		CompanyPropertyReturned: req.CompanyPropertyReturned,
@@ -1659,10 +1947,21 @@ func createResignationReport(c *gin.Context) {
 		GeneratedAt:             time.Now(),
 	}
 	
+	// 按当前启用的文档模板生成报告HTML内容
+	reportContent, version, err := renderResignationReport(application, report)
+	if err != nil {
+		log.Printf("Failed to render resignation report for application %d: %v", application.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成离职报告失败"})
+		return
+	}
+	report.ReportContent = reportContent
+	report.TemplateVersion = version
+	
 	if err := db.Create(&report).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建离职报告失败"})
 		return
 	}
+	recordAudit(c, "resignation_report.create", "resignation_report", report.ID, nil, report)
 	
 	c.JSON(http.StatusOK, gin.H{
 		"message": "离职报告创建成功",
@@ -1680,6 +1979,10 @@ func getResignationReport(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "离职报告不存在"})
 		return
 	}
+	if !canAccessEmployee(c, report.Application.EmployeeID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "离职报告不存在"})
+		return
+	}
 	
 	c.JSON(http.StatusOK, gin.H{"data": report})
 }
@@ -1700,17 +2003,43 @@ func updateResignationReport(c *gin.Context) {
 		return
 	}
 	
+	var application ResignationApplication
+	if err := db.First(&application, report.ApplicationID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "离职申请不存在"})
+		return
+	}
+	
+	// 内容变化后按当前启用的文档模板重新生成报告HTML
+	regenerated := report
+	regenerated.WorkSummary = req.WorkSummary
+	regenerated.UnfinishedTasks = req.UnfinishedTasks
+	regenerated.CompanyPropertyReturned = req.CompanyPropertyReturned
+	regenerated.FinancialSettlement = req.FinancialSettlement
+	regenerated.GeneratedAt = time.Now()
+	reportContent, version, err := renderResignationReport(application, regenerated)
+	if err != nil {
+		log.Printf("Failed to render resignation report %d: %v", report.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成离职报告失败"})
+		return
+	}
+	
 	updates := map[string]interface{}{
 		"work_summary":              req.WorkSummary,
 		"unfinished_tasks":          req.UnfinishedTasks,
 		"company_property_returned": req.CompanyPropertyReturned,
 		"financial_settlement":      req.FinancialSettlement,
+		"report_content":            reportContent,
+		"template_version":          version,
+		"generated_at":              regenerated.GeneratedAt,
 	}
 	
+	before := report
 	if err := db.Model(&report).Updates(updates).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新离职报告失败"})
 		return
 	}
+	db.First(&report, report.ID)
+	recordAudit(c, "resignation_report.update", "resignation_report", report.ID, before, report)
 	
 	c.JSON(http.StatusOK, gin.H{
 		"message": "离职报告更新成功",
@@ -1732,6 +2061,7 @@ func deleteResignationReport(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除离职报告失败"})
 		return
 	}
+	recordAudit(c, "resignation_report.delete", "resignation_report", report.ID, report, nil)
 	
 	c.JSON(http.StatusOK, gin.H{
 		"message": "离职报告删除成功",
@@ -1760,8 +2090,8 @@ func generateSignToken(c *gin.Context) {
 	
 	// 检查是否已经生成过令牌
 	var existingToken ResignationSignToken
-	if err := db.Where("application_id = ? AND signer_type = ? AND used = false AND expires_at > ?", 
-		application.ID, req.SignerType, time.Now()).First(&existingToken).Error; err == nil {
+	if err := db.Where("application_id = ? AND signer_type = ? AND used = ? AND expires_at > ?", 
+		application.ID, req.SignerType, false, time.Now()).First(&existingToken).Error; err == nil {
 		// 如果存在有效令牌，返回该令牌
 		signUrl := fmt.Sprintf("/web/sign-resignation.html?id=%s&token=%s&type=%s", 
 			application.UUID, existingToken.Token, req.SignerType)
@@ -1776,7 +2106,7 @@ func generateSignToken(c *gin.Context) {
 	
 	// 生成新的令牌
 	token := generateSecureToken()
-	expiresAt := time.Now().Add(7 * 24 * time.Hour) // 7天有效期
+	expiresAt := time.Now().Add(appConfig.Auth.SignTokenTTL)
 	
 	signToken := ResignationSignToken{
 		ApplicationID: application.ID,
@@ -1790,6 +2120,7 @@ func generateSignToken(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成令牌失败"})
 		return
 	}
+	recordAudit(c, "resignation.sign_token", "resignation", application.UUID, nil, signToken)
 	
 	signUrl := fmt.Sprintf("/web/sign-resignation.html?id=%s&token=%s&type=%s", 
 		application.UUID, token, req.SignerType)
@@ -1816,6 +2147,11 @@ func signResignation(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
 		return
 	}
+	image, err := decodeSignatureImage(req.SignatureData)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	
 	// 添加令牌验证
 	token := c.Query("token")
@@ -1824,8 +2160,8 @@ func signResignation(c *gin.Context) {
 	if token != "" && signerType != "" {
 		// 验证令牌
 		var signToken ResignationSignToken
-		if err := db.Where("token = ? AND signer_type = ? AND used = false AND expires_at > ?", 
-			token, signerType, time.Now()).First(&signToken).Error; err != nil {
+		if err := db.Where("token = ? AND signer_type = ? AND used = ? AND expires_at > ?", 
+			token, signerType, false, time.Now()).First(&signToken).Error; err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "无效或过期的签名令牌"})
 			return
 		}
@@ -1869,26 +2205,39 @@ func signResignation(c *gin.Context) {
 		SignerType:    req.SignerType,
 		SignerID:      1, // 实际应根据签名类型确定
 		SignatureData: req.SignatureData,
-		SignatureHash: generateSignatureHash(req.SignatureData),
 		IPAddress:     c.ClientIP(),
 		UserAgent:     c.GetHeader("User-Agent"),
 		DeviceInfo:    req.DeviceInfo,
 		SignedAt:      time.Now(),
 	}
 	
-	if err := db.Create(&signature).Error; err != nil {
+	// 签名记录哈希链接到该离职申请上一条签名
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		integrity := newSignatureIntegrity(resignationSnapshot(application), image,
+			lastSignatureHash(tx, &ResignationSignature{}, "application_id", application.ID))
+		signature.SignatureIntegrity = integrity
+		signature.SignatureHash = signatureRecordHash("resignation", application.UUID, signature.SignerType, signature.IPAddress, signature.SignedAt, integrity)
+		if err := tx.Create(&signature).Error; err != nil {
+			return err
+		}
+		
+		// 检查是否所有必要的签名都已完成
+		var signatureCount int64
+		tx.Model(&ResignationSignature{}).Where("application_id = ?", application.ID).Count(&signatureCount)
+		
+		// 如果员工、HR和主管都已签名，更新申请状态为完成，并由服务端数字签名封存
+		if signatureCount >= 3 {
+			if err := tx.Model(&application).Update("status", "completed").Error; err != nil {
+				return err
+			}
+			return sealResignation(tx, application)
+		}
+		return nil
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存签名失败"})
 		return
 	}
-	
-	// 检查是否所有必要的签名都已完成
-	var signatureCount int64
-	db.Model(&ResignationSignature{}).Where("application_id = ?", application.ID).Count(&signatureCount)
-	
-	// 如果员工、HR和主管都已签名，更新申请状态为完成
-	if signatureCount >= 3 {
-		db.Model(&application).Update("status", "completed")
-	}
+	recordAudit(c, "resignation.sign", "resignation", application.UUID, nil, signature)
 	
 	c.JSON(http.StatusOK, gin.H{
 		"message": "签名成功",
@@ -1915,178 +2264,6 @@ func getResignationSignatures(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"data": signatures})
 }
 
-// 生成离职报告HTML
-func generateResignationReportHTML(app ResignationApplication, req CreateResignationReportRequest) string {
-	var employee Employee
-	db.First(&employee, app.EmployeeID)
-	
-	// 获取签名信息
-	var signatures []ResignationSignature
-	db.Where("application_id = ?", app.ID).Find(&signatures)
-	
-	// 构建签名显示HTML
-	signatureHTML := ""
-	signerTypes := []struct {
-		Type string
-		Name string
-	}{
-		{"employee", "员工本人"},
-		{"hr", "人力资源部"},
-		{"manager", "部门主管"},
-	}
-	
-	for _, signer := range signerTypes {
-		found := false
-		for _, sig := range signatures {
-			if sig.SignerType == signer.Type {
-				signatureHTML += fmt.Sprintf(`
-				<div style="margin: 15px 0; padding: 10px; background: #f0f0f0; border-radius: 5px;">
-					<p><strong>%s签名:</strong></p>
-					<img src="%s" style="max-width: 300px; height: 100px; border: 1px solid #ddd; background: white; padding: 5px;">
-					<p style="color: #666; font-size: 12px;">签名时间: %s</p>
-				</div>`, 
-					signer.Name, 
-					sig.SignatureData,
-					sig.SignedAt.Format("2006-01-02 15:04:05"))
-				found = true
-				break
-			}
-		}
-		if !found {
-			signatureHTML += fmt.Sprintf(`
-			<div style="margin: 15px 0; padding: 10px; background: #f9f9f9; border-radius: 5px;">
-				<p><strong>%s签名:</strong> <span style="color: #999;">待签名</span></p>
-			</div>`, signer.Name)
-		}
-	}
-	
-	// 转换离职类型为中文
-	resignationType := ""
-	switch app.ResignationType {
-	case "voluntary":
-		resignationType = "主动离职"
-	case "dismissal":
-		resignationType = "辞退"
-	case "contract_expiry":
-		resignationType = "合同到期"
-	default:
-		resignationType = app.ResignationType
-	}
-	
-	html := fmt.Sprintf(`
-	<html>
-	<head>
-		<title>离职报告</title>
-		<style>
-			body { 
-				font-family: 'Microsoft YaHei', Arial, sans-serif; 
-				margin: 20px; 
-				line-height: 1.6;
-			}
-			h1 { 
-				color: #333; 
-				text-align: center;
-				border-bottom: 2px solid #667eea;
-				padding-bottom: 10px;
-			}
-			.section { 
-				margin: 25px 0; 
-				padding: 15px;
-				background: #f8f9fa;
-				border-radius: 8px;
-			}
-			.label { 
-				font-weight: bold; 
-				color: #555;
-				display: inline-block;
-				width: 120px;
-			}
-			.info-row {
-				margin: 8px 0;
-			}
-			h2 {
-				color: #667eea;
-				border-bottom: 1px solid #e0e0e0;
-				padding-bottom: 5px;
-			}
-			.content-box {
-				background: white;
-				padding: 10px;
-				border-radius: 5px;
-				margin-top: 10px;
-			}
-			.signature-section {
-				background: white;
-				padding: 20px;
-				border-radius: 8px;
-				margin-top: 20px;
-			}
-			@media print {
-				body { margin: 10px; }
-				.section { background: white; }
-			}
-		</style>
-	</head>
-	<body>
-		<h1>离职报告</h1>
-		
-		<div class="section">
-			<h2>员工基本信息</h2>
-			<div class="info-row"><span class="label">员工姓名:</span> %s</div>
-			<div class="info-row"><span class="label">员工编号:</span> %s</div>
-			<div class="info-row"><span class="label">部门:</span> %s</div>
-			<div class="info-row"><span class="label">职位:</span> %s</div>
-			<div class="info-row"><span class="label">离职类型:</span> %s</div>
-			<div class="info-row"><span class="label">离职原因:</span> %s</div>
-			<div class="info-row"><span class="label">最后工作日:</span> %s</div>
-		</div>
-		
-		<div class="section">
-			<h2>工作总结</h2>
-			<div class="content-box">%s</div>
-		</div>
-		
-		<div class="section">
-			<h2>未完成事项</h2>
-			<div class="content-box">%s</div>
-		</div>
-		
-		<div class="section">
-			<h2>交接状态</h2>
-			<div class="info-row"><span class="label">公司财产归还:</span> %s</div>
-			<div class="info-row"><span class="label">财务结清:</span> %s</div>
-		</div>
-		
-		<div class="signature-section">
-			<h2>电子签名确认</h2>
-			%s
-		</div>
-		
-		<div style="text-align: center; margin-top: 30px; color: #666; font-size: 12px;">
-			<p>报告生成时间: %s</p>
-		</div>
-	</body>
-	</html>
-	`,
-		employee.Name,
-		employee.EmployeeNo,
-		employee.Department,
-		employee.Position,
-		resignationType,
-		app.Reason,
-		app.LastWorkingDate.Format("2006年01月02日"),
-		req.WorkSummary,
-		req.UnfinishedTasks,
-		boolToString(req.CompanyPropertyReturned),
-		boolToString(req.FinancialSettlement),
-		signatureHTML,
-		time.Now().Format("2006年01月02日 15:04:05"),
-	)
-	
-	return html
-}
-
-
 // bool转字符串
 func boolToString(b bool) string {
 	if b {
@@ -2095,17 +2272,69 @@ func boolToString(b bool) string {
 	return "否"
 }
 
+// 命令行子命令：migrate、seed
+func runCommand(cfg *Config, args []string) error {
+	if args[0] != "migrate" && args[0] != "seed" {
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+
+	conn, err := openDatabase(cfg.Database)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	if args[0] == "migrate" {
+		return runMigrateCommand(conn, args[1:])
+	}
+
+	pending, err := pendingMigrations(conn)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("database has %d pending migrations, run `payroll migrate up` first", len(pending))
+	}
+	return runSeedCommand(conn, args[1:])
+}
+
 func main() {
+	configPath := flag.String("config", "", "path to YAML config file")
+	flag.Parse()
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatal("Failed to load config:", err)
+	}
+	appConfig = cfg
+
+	if err := initSecurityLog(cfg.Server.SecurityLog); err != nil {
+		log.Fatal("Failed to open security log:", err)
+	}
+
+	if args := flag.Args(); len(args) > 0 {
+		if err := runCommand(cfg, args); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	initDB()
 
-	if err := os.MkdirAll("./uploads/signatures", 0755); err != nil {
+	if err := initDocumentSealer(cfg.Seal); err != nil {
+		log.Fatal("Failed to load seal key:", err)
+	}
+
+	purgeExpiredTokens()
+	startTokenPurger(time.Hour)
+
+	if err := os.MkdirAll(filepath.Join(cfg.Server.UploadsDir, "signatures"), 0755); err != nil {
 		log.Fatal("Failed to create uploads directory:", err)
 	}
 
-	r := setupRoutes()
+	r := setupRoutes(cfg)
 
-	log.Println("Server starting on :40010...")
-	if err := r.Run(":40010"); err != nil {
+	addr := fmt.Sprintf(":%d", cfg.Server.Port)
+	log.Printf("Server starting on %s (profile: %s)...", addr, cfg.Profile)
+	if err := r.Run(addr); err != nil {
 		log.Fatal("Failed to start server:", err)
 	}
 }
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	appConfig = defaultConfig()
+	os.Exit(m.Run())
+}
+
+// 每个测试使用独立的 SQLite 数据库并执行全部迁移，结束后恢复全局连接
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	cfg := appConfig.Database
+	cfg.Type, cfg.URL = "sqlite", filepath.Join(t.TempDir(), "payroll.db")
+	conn, err := openDatabase(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateUp(conn); err != nil {
+		t.Fatal(err)
+	}
+	previous := db
+	db = conn
+	t.Cleanup(func() {
+		db = previous
+		if sqlDB, err := conn.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+}
@@ -0,0 +1,1222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 数据库迁移
+//
+// 每个迁移使用自己的结构体快照描述当时的表结构，不引用业务模型，
+// 这样后续修改模型不会改变已发布迁移的行为。新增表或字段时在
+// migrations 列表末尾追加新版本，不要修改已发布的迁移。
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// 迁移记录表
+type SchemaMigration struct {
+	Version   int    `gorm:"primaryKey;autoIncrement:false"`
+	Name      string `gorm:"size:255"`
+	AppliedAt time.Time
+}
+
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "baseline",
+		Up:      migrateBaselineUp,
+		Down:    migrateBaselineDown,
+	},
+	{
+		Version: 2,
+		Name:    "backfill_payroll_original_gross",
+		Up: func(tx *gorm.DB) error {
+			// 旧数据没有 original_gross / work_days，按全月工资补齐
+			if err := tx.Table("payrolls").
+				Where("original_gross = ? AND is_prorated = ?", 0, false).
+				Update("original_gross", gorm.Expr("total_gross")).Error; err != nil {
+				return err
+			}
+			return tx.Table("payrolls").
+				Where("work_days = ? AND month_days > ? AND is_prorated = ?", 0, 0, false).
+				Update("work_days", gorm.Expr("month_days")).Error
+		},
+		Down: func(tx *gorm.DB) error { return nil }, // 数据回填无需回滚
+	},
+	{
+		Version: 3,
+		Name:    "admin_users_must_change_password",
+		Up: func(tx *gorm.DB) error {
+			type adminUser struct {
+				MustChangePassword bool `gorm:"default:false"`
+			}
+			return addColumns(tx, "admin_users", &adminUser{}, "MustChangePassword")
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, "admin_users", "must_change_password")
+		},
+	},
+	{
+		Version: 4,
+		Name:    "create_admin_password_histories",
+		Up: func(tx *gorm.DB) error {
+			type adminPasswordHistory struct {
+				ID           uint `gorm:"primaryKey"`
+				AdminUserID  uint `gorm:"index"`
+				PasswordHash string
+				CreatedAt    time.Time
+			}
+			return tx.Table("admin_password_histories").AutoMigrate(&adminPasswordHistory{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("admin_password_histories")
+		},
+	},
+	{
+		Version: 5,
+		Name:    "admin_users_roles",
+		Up: func(tx *gorm.DB) error {
+			type adminUser struct {
+				Role        string `gorm:"size:20;default:admin"`
+				Department  string
+				Permissions string
+			}
+			if err := addColumns(tx, "admin_users", &adminUser{}, "Role", "Department", "Permissions"); err != nil {
+				return err
+			}
+			// 已有管理员保留全部权限
+			return tx.Table("admin_users").Where("role IS NULL OR role = ?", "").Update("role", RoleAdmin).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, "admin_users", "role", "department", "permissions")
+		},
+	},
+	{
+		Version: 6,
+		Name:    "admin_user_management",
+		Up: func(tx *gorm.DB) error {
+			type adminUser struct {
+				LastLoginAt *time.Time
+				LastLoginIP string
+			}
+			if err := addColumns(tx, "admin_users", &adminUser{}, "LastLoginAt", "LastLoginIP"); err != nil {
+				return err
+			}
+			type adminPasswordResetToken struct {
+				ID          uint   `gorm:"primaryKey"`
+				AdminUserID uint   `gorm:"index"`
+				TokenHash   string `gorm:"uniqueIndex;size:64"`
+				CreatedBy   uint
+				ExpiresAt   time.Time
+				UsedAt      *time.Time
+				CreatedAt   time.Time
+			}
+			return tx.Table("admin_password_reset_tokens").AutoMigrate(&adminPasswordResetToken{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable("admin_password_reset_tokens"); err != nil {
+				return err
+			}
+			return dropColumns(tx, "admin_users", "last_login_at", "last_login_ip")
+		},
+	},
+	{
+		Version: 7,
+		Name:    "admin_sessions",
+		Up: func(tx *gorm.DB) error {
+			type adminUser struct {
+				TokenVersion int `gorm:"default:0"`
+			}
+			if err := addColumns(tx, "admin_users", &adminUser{}, "TokenVersion"); err != nil {
+				return err
+			}
+			type adminRefreshToken struct {
+				ID          uint   `gorm:"primaryKey"`
+				AdminUserID uint   `gorm:"index"`
+				SessionID   string `gorm:"index;size:36"`
+				TokenHash   string `gorm:"uniqueIndex;size:64"`
+				Remember    bool
+				IPAddress   string
+				UserAgent   string
+				ExpiresAt   time.Time
+				RevokedAt   *time.Time
+				CreatedAt   time.Time
+			}
+			if err := tx.Table("admin_refresh_tokens").AutoMigrate(&adminRefreshToken{}); err != nil {
+				return err
+			}
+			type revokedAccessToken struct {
+				ID          uint      `gorm:"primaryKey"`
+				JTI         string    `gorm:"uniqueIndex;size:36"`
+				AdminUserID uint      `gorm:"index"`
+				ExpiresAt   time.Time `gorm:"index"`
+				CreatedAt   time.Time
+			}
+			return tx.Table("revoked_access_tokens").AutoMigrate(&revokedAccessToken{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable("revoked_access_tokens", "admin_refresh_tokens"); err != nil {
+				return err
+			}
+			return dropColumns(tx, "admin_users", "token_version")
+		},
+	},
+	{
+		Version: 8,
+		Name:    "login_lockout",
+		Up: func(tx *gorm.DB) error {
+			type adminUser struct {
+				FailedLoginCount  int `gorm:"default:0"`
+				LastFailedLoginAt *time.Time
+				LockedUntil       *time.Time
+			}
+			if err := addColumns(tx, "admin_users", &adminUser{}, "FailedLoginCount", "LastFailedLoginAt", "LockedUntil"); err != nil {
+				return err
+			}
+			type loginAttempt struct {
+				ID          uint   `gorm:"primaryKey"`
+				Username    string `gorm:"index;size:64"`
+				AdminUserID *uint
+				IPAddress   string `gorm:"index;size:64"`
+				UserAgent   string
+				Success     bool
+				CreatedAt   time.Time `gorm:"index"`
+			}
+			return tx.Table("login_attempts").AutoMigrate(&loginAttempt{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable("login_attempts"); err != nil {
+				return err
+			}
+			return dropColumns(tx, "admin_users", "failed_login_count", "last_failed_login_at", "locked_until")
+		},
+	},
+	{
+		Version: 9,
+		Name:    "admin_totp",
+		Up: func(tx *gorm.DB) error {
+			type adminUser struct {
+				TOTPSecret   string `gorm:"size:64"`
+				TOTPEnabled  bool   `gorm:"default:false"`
+				TOTPLastStep int64  `gorm:"default:0"`
+			}
+			if err := addColumns(tx, "admin_users", &adminUser{}, "TOTPSecret", "TOTPEnabled", "TOTPLastStep"); err != nil {
+				return err
+			}
+			type adminRecoveryCode struct {
+				ID          uint   `gorm:"primaryKey"`
+				AdminUserID uint   `gorm:"index"`
+				CodeHash    string `gorm:"size:64"`
+				UsedAt      *time.Time
+				CreatedAt   time.Time
+			}
+			if err := tx.Table("admin_recovery_codes").AutoMigrate(&adminRecoveryCode{}); err != nil {
+				return err
+			}
+			type adminLoginChallenge struct {
+				ID          uint   `gorm:"primaryKey"`
+				AdminUserID uint   `gorm:"index"`
+				TokenHash   string `gorm:"uniqueIndex;size:64"`
+				Remember    bool
+				Attempts    int
+				ExpiresAt   time.Time
+				UsedAt      *time.Time
+				CreatedAt   time.Time
+			}
+			return tx.Table("admin_login_challenges").AutoMigrate(&adminLoginChallenge{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable("admin_login_challenges", "admin_recovery_codes"); err != nil {
+				return err
+			}
+			return dropColumns(tx, "admin_users", "totp_secret", "totp_enabled", "totp_last_step")
+		},
+	},
+	{
+		Version: 10,
+		Name:    "employee_portal",
+		Up: func(tx *gorm.DB) error {
+			type employee struct {
+				PortalEnabled      bool `gorm:"default:true"`
+				PortalLastLoginAt  *time.Time
+				PortalTokenVersion int `gorm:"default:0"`
+			}
+			if err := addColumns(tx, "employees", &employee{}, "PortalEnabled", "PortalLastLoginAt", "PortalTokenVersion"); err != nil {
+				return err
+			}
+			type employeeLoginCode struct {
+				ID         uint   `gorm:"primaryKey"`
+				EmployeeID uint   `gorm:"index"`
+				Method     string `gorm:"size:20"`
+				Channel    string `gorm:"size:20"`
+				CodeHash   string `gorm:"index;size:64"`
+				Attempts   int
+				IPAddress  string
+				ExpiresAt  time.Time
+				UsedAt     *time.Time
+				CreatedAt  time.Time
+			}
+			return tx.Table("employee_login_codes").AutoMigrate(&employeeLoginCode{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable("employee_login_codes"); err != nil {
+				return err
+			}
+			return dropColumns(tx, "employees", "portal_enabled", "portal_last_login_at", "portal_token_version")
+		},
+	},
+	{
+		Version: 11,
+		Name:    "payslip_access_links",
+		Up: func(tx *gorm.DB) error {
+			type payslipAccessLink struct {
+				ID         uint   `gorm:"primaryKey"`
+				PayrollID  uint   `gorm:"index"`
+				EmployeeID uint   `gorm:"index"`
+				TokenHash  string `gorm:"uniqueIndex;size:64"`
+				MaxUses    int
+				UseCount   int
+				ExpiresAt  time.Time
+				LastUsedAt *time.Time
+				LastUsedIP string
+				RevokedAt  *time.Time
+				RevokedBy  uint
+				CreatedBy  uint
+				CreatedAt  time.Time
+			}
+			if err := tx.Table("payslip_access_links").AutoMigrate(&payslipAccessLink{}); err != nil {
+				return err
+			}
+			type payrollNotification struct {
+				AccessLinkID *uint
+			}
+			return addColumns(tx, "payroll_notifications", &payrollNotification{}, "AccessLinkID")
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := dropColumns(tx, "payroll_notifications", "access_link_id"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropTable("payslip_access_links")
+		},
+	},
+	{
+		Version: 12,
+		Name:    "audit_logs",
+		Up: func(tx *gorm.DB) error {
+			type auditLog struct {
+				ID         uint   `gorm:"primaryKey"`
+				ActorType  string `gorm:"index;size:20"`
+				ActorID    uint   `gorm:"index"`
+				ActorName  string `gorm:"size:64"`
+				Action     string `gorm:"index;size:64"`
+				EntityType string `gorm:"index;size:40"`
+				EntityID   string `gorm:"index;size:64"`
+				Changes    string `gorm:"type:text"`
+				IPAddress  string `gorm:"size:64"`
+				UserAgent  string
+				CreatedAt  time.Time `gorm:"index"`
+			}
+			if err := tx.Table("audit_logs").AutoMigrate(&auditLog{}); err != nil {
+				return err
+			}
+			// 数据库层面禁止修改和删除审计日志
+			var stmts []string
+			switch tx.Dialector.Name() {
+			case "sqlite":
+				stmts = []string{
+					"CREATE TRIGGER audit_logs_no_update BEFORE UPDATE ON audit_logs BEGIN SELECT RAISE(ABORT, 'audit logs are append-only'); END",
+					"CREATE TRIGGER audit_logs_no_delete BEFORE DELETE ON audit_logs BEGIN SELECT RAISE(ABORT, 'audit logs are append-only'); END",
+				}
+			case "mysql":
+				stmts = []string{
+					"CREATE TRIGGER audit_logs_no_update BEFORE UPDATE ON audit_logs FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit logs are append-only'",
+					"CREATE TRIGGER audit_logs_no_delete BEFORE DELETE ON audit_logs FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit logs are append-only'",
+				}
+			case "postgres":
+				stmts = []string{
+					"CREATE OR REPLACE FUNCTION audit_logs_append_only() RETURNS trigger AS $$ BEGIN RAISE EXCEPTION 'audit logs are append-only'; END; $$ LANGUAGE plpgsql",
+					"CREATE TRIGGER audit_logs_no_update BEFORE UPDATE OR DELETE ON audit_logs FOR EACH ROW EXECUTE FUNCTION audit_logs_append_only()",
+				}
+			}
+			for _, stmt := range stmts {
+				if err := tx.Exec(stmt).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable("audit_logs"); err != nil {
+				return err
+			}
+			if tx.Dialector.Name() == "postgres" {
+				return tx.Exec("DROP FUNCTION IF EXISTS audit_logs_append_only()").Error
+			}
+			return nil
+		},
+	},
+	{
+		Version: 13,
+		Name:    "signature_integrity",
+		Up: func(tx *gorm.DB) error {
+			type signatureIntegrity struct {
+				ContentHash     string `gorm:"size:64"`
+				ImageHash       string `gorm:"size:64"`
+				PrevHash        string `gorm:"size:64"`
+				HashVersion     int    `gorm:"default:0"`
+				ContentSnapshot string `gorm:"type:text"`
+			}
+			fields := []string{"ContentHash", "ImageHash", "PrevHash", "HashVersion", "ContentSnapshot"}
+			for _, table := range []string{"payroll_signatures", "resignation_signatures"} {
+				if err := addColumns(tx, table, &signatureIntegrity{}, fields...); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, table := range []string{"payroll_signatures", "resignation_signatures"} {
+				if err := dropColumns(tx, table, "content_hash", "image_hash", "prev_hash", "hash_version", "content_snapshot"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 14,
+		Name:    "document_seals",
+		Up: func(tx *gorm.DB) error {
+			type documentSealKey struct {
+				ID          uint   `gorm:"primaryKey"`
+				KeyID       string `gorm:"uniqueIndex;size:64"`
+				Algorithm   string `gorm:"size:20"`
+				PublicKey   string `gorm:"type:text"`
+				Fingerprint string `gorm:"size:64"`
+				CreatedAt   time.Time
+			}
+			type documentSeal struct {
+				ID          uint   `gorm:"primaryKey"`
+				EntityType  string `gorm:"index:idx_document_seal_entity;size:20"`
+				EntityID    uint   `gorm:"index:idx_document_seal_entity"`
+				EntityUUID  string `gorm:"size:36"`
+				KeyID       string `gorm:"size:64"`
+				Algorithm   string `gorm:"size:20"`
+				Payload     string `gorm:"type:text"`
+				PayloadHash string `gorm:"size:64"`
+				Signature   string `gorm:"type:text"`
+				SealedAt    time.Time
+			}
+			if err := tx.Table("document_seal_keys").AutoMigrate(&documentSealKey{}); err != nil {
+				return err
+			}
+			return tx.Table("document_seals").AutoMigrate(&documentSeal{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("document_seals", "document_seal_keys")
+		},
+	},
+	{
+		Version: 15,
+		Name:    "document_templates",
+		Up: func(tx *gorm.DB) error {
+			type documentTemplate struct {
+				ID        uint   `gorm:"primaryKey"`
+				Kind      string `gorm:"uniqueIndex:idx_document_template_version;size:32"`
+				Version   int    `gorm:"uniqueIndex:idx_document_template_version"`
+				Name      string
+				Content   string `gorm:"type:text"`
+				Branding  string `gorm:"type:text"`
+				Comment   string
+				IsActive  bool `gorm:"default:false"`
+				CreatedBy uint
+				CreatedAt time.Time
+			}
+			type resignationReport struct {
+				TemplateVersion int
+			}
+			if err := tx.Table("document_templates").AutoMigrate(&documentTemplate{}); err != nil {
+				return err
+			}
+			return addColumns(tx, "resignation_reports", &resignationReport{}, "TemplateVersion")
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := dropColumns(tx, "resignation_reports", "template_version"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropTable("document_templates")
+		},
+	},
+	{
+		Version: 16,
+		Name:    "template_field_schema",
+		Up: func(tx *gorm.DB) error {
+			type payroll struct {
+				Breakdown string `gorm:"type:text"`
+			}
+			if err := addColumns(tx, "payrolls", &payroll{}, "Breakdown"); err != nil {
+				return err
+			}
+			type payrollTemplate struct {
+				ID     uint
+				Fields string
+			}
+			var templates []payrollTemplate
+			if err := tx.Table("payroll_templates").Select("id", "fields").Find(&templates).Error; err != nil {
+				return err
+			}
+			for _, t := range templates {
+				fields, changed := inferLegacyTemplateFields(t.Fields)
+				if !changed {
+					continue
+				}
+				if err := tx.Table("payroll_templates").Where("id = ?", t.ID).Update("fields", fields).Error; err != nil {
+					return fmt.Errorf("payroll_templates %d: %w", t.ID, err)
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			// 模板中补充的字段属性保留，旧版本会忽略这些属性
+			return dropColumns(tx, "payrolls", "breakdown")
+		},
+	},
+	{
+		Version: 17,
+		Name:    "income_tax_withholding",
+		Up: func(tx *gorm.DB) error {
+			type employee struct {
+				SpecialDeduction float64 `gorm:"default:0"`
+			}
+			if err := addColumns(tx, "employees", &employee{}, "SpecialDeduction"); err != nil {
+				return err
+			}
+			type payroll struct {
+				ID               uint
+				TemplateID       uint
+				WorkDays         float64
+				MonthDays        float64
+				IsProrated       bool
+				PayrollData      string
+				Breakdown        string
+				TaxableIncome    float64 `gorm:"default:0"`
+				SpecialDeduction float64 `gorm:"default:0"`
+				IncomeTax        float64 `gorm:"default:0"`
+			}
+			if err := addColumns(tx, "payrolls", &payroll{}, "TaxableIncome", "SpecialDeduction", "IncomeTax"); err != nil {
+				return err
+			}
+
+			// 已有工资条补齐应税所得和已扣个税，作为累计预扣的历史数据
+			type payrollTemplate struct {
+				ID     uint
+				Fields string
+			}
+			var templates []payrollTemplate
+			if err := tx.Table("payroll_templates").Select("id", "fields").Find(&templates).Error; err != nil {
+				return err
+			}
+			fieldsByTemplate := map[uint]string{}
+			for _, t := range templates {
+				fieldsByTemplate[t.ID] = t.Fields
+			}
+			var payrolls []payroll
+			if err := tx.Table("payrolls").Select("id", "template_id", "work_days", "month_days", "is_prorated", "payroll_data", "breakdown").
+				Find(&payrolls).Error; err != nil {
+				return err
+			}
+			for _, p := range payrolls {
+				taxable, tax := legacyPayrollTaxFigures(fieldsByTemplate[p.TemplateID], p.PayrollData, p.Breakdown, p.IsProrated, p.WorkDays, p.MonthDays)
+				if taxable == 0 && tax == 0 {
+					continue
+				}
+				if err := tx.Table("payrolls").Where("id = ?", p.ID).
+					Updates(map[string]interface{}{"taxable_income": taxable, "income_tax": tax}).Error; err != nil {
+					return fmt.Errorf("payrolls %d: %w", p.ID, err)
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := dropColumns(tx, "payrolls", "taxable_income", "special_deduction", "income_tax"); err != nil {
+				return err
+			}
+			return dropColumns(tx, "employees", "special_deduction")
+		},
+	},
+	{
+		Version: 18,
+		Name:    "social_insurance_contributions",
+		Up: func(tx *gorm.DB) error {
+			type contributionPolicy struct {
+				ID            uint   `gorm:"primaryKey"`
+				City          string `gorm:"uniqueIndex:idx_contribution_policy_city;size:64"`
+				EffectiveFrom string `gorm:"uniqueIndex:idx_contribution_policy_city;size:7"`
+				Rates         string `gorm:"type:text"`
+				Comment       string
+				CreatedAt     time.Time
+				UpdatedAt     time.Time
+			}
+			if err := tx.Table("contribution_policies").AutoMigrate(&contributionPolicy{}); err != nil {
+				return err
+			}
+			type employee struct {
+				ContributionCity string  `gorm:"size:64"`
+				ContributionBase float64 `gorm:"default:0"`
+			}
+			if err := addColumns(tx, "employees", &employee{}, "ContributionCity", "ContributionBase"); err != nil {
+				return err
+			}
+			// 已有工资条没有社保公积金计算结果，单位缴纳合计按明细中的单位缴纳项目补齐
+			type payroll struct {
+				ID                    uint
+				Breakdown             string
+				EmployerContributions float64 `gorm:"default:0"`
+				Contributions         string  `gorm:"type:text"`
+			}
+			if err := addColumns(tx, "payrolls", &payroll{}, "EmployerContributions", "Contributions"); err != nil {
+				return err
+			}
+			var payrolls []payroll
+			if err := tx.Table("payrolls").Select("id", "breakdown").Where("breakdown <> ''").Find(&payrolls).Error; err != nil {
+				return err
+			}
+			for _, p := range payrolls {
+				var lines []struct {
+					Kind   string          `json:"kind"`
+					Amount decimal.Decimal `json:"amount"`
+				}
+				if json.Unmarshal([]byte(p.Breakdown), &lines) != nil {
+					continue
+				}
+				employer := decimal.Zero
+				for _, line := range lines {
+					if line.Kind == "employer_contribution" {
+						employer = employer.Add(line.Amount)
+					}
+				}
+				if employer.IsZero() {
+					continue
+				}
+				if err := tx.Table("payrolls").Where("id = ?", p.ID).Update("employer_contributions", employer.InexactFloat64()).Error; err != nil {
+					return fmt.Errorf("payrolls %d: %w", p.ID, err)
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := dropColumns(tx, "payrolls", "employer_contributions", "contributions"); err != nil {
+				return err
+			}
+			if err := dropColumns(tx, "employees", "contribution_city", "contribution_base"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropTable("contribution_policies")
+		},
+	},
+	{
+		Version: 19,
+		Name:    "compensation_profiles",
+		Up: func(tx *gorm.DB) error {
+			type compensationProfile struct {
+				ID            uint   `gorm:"primaryKey"`
+				EmployeeID    uint   `gorm:"uniqueIndex:idx_compensation_employee_from"`
+				EffectiveFrom string `gorm:"uniqueIndex:idx_compensation_employee_from;size:7"`
+				BaseSalary    float64
+				Allowances    string `gorm:"type:text"`
+				TemplateID    uint
+				PayGroup      string `gorm:"size:32;index"`
+				BankName      string `gorm:"size:64"`
+				BankAccount   string `gorm:"size:34"`
+				Comment       string
+				CreatedBy     uint
+				CreatedAt     time.Time
+				UpdatedAt     time.Time
+			}
+			if err := tx.Table("compensation_profiles").AutoMigrate(&compensationProfile{}); err != nil {
+				return err
+			}
+			type payroll struct {
+				CompensationProfileID *uint
+				PayGroup              string `gorm:"size:32"`
+			}
+			return addColumns(tx, "payrolls", &payroll{}, "CompensationProfileID", "PayGroup")
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := dropColumns(tx, "payrolls", "compensation_profile_id", "pay_group"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropTable("compensation_profiles")
+		},
+	},
+	{
+		Version: 20,
+		Name:    "decimal_money_columns",
+		Up: func(tx *gorm.DB) error {
+			// 金额列改为 NUMERIC(18,2)，MySQL 和 PostgreSQL 按十进制精确存储；已是该类型的列重复修改无影响
+			type employee struct {
+				SpecialDeduction decimal.Decimal `gorm:"type:decimal(18,2);default:0"`
+				ContributionBase decimal.Decimal `gorm:"type:decimal(18,2);default:0"`
+			}
+			type payroll struct {
+				OriginalGross         decimal.Decimal `gorm:"type:decimal(18,2)"`
+				TotalGross            decimal.Decimal `gorm:"type:decimal(18,2)"`
+				TotalNet              decimal.Decimal `gorm:"type:decimal(18,2)"`
+				TaxableIncome         decimal.Decimal `gorm:"type:decimal(18,2);default:0"`
+				SpecialDeduction      decimal.Decimal `gorm:"type:decimal(18,2);default:0"`
+				IncomeTax             decimal.Decimal `gorm:"type:decimal(18,2);default:0"`
+				EmployerContributions decimal.Decimal `gorm:"type:decimal(18,2);default:0"`
+			}
+			type compensationProfile struct {
+				BaseSalary decimal.Decimal `gorm:"type:decimal(18,2)"`
+			}
+			if err := alterColumns(tx, "employees", &employee{}, "SpecialDeduction", "ContributionBase"); err != nil {
+				return err
+			}
+			if err := alterColumns(tx, "payrolls", &payroll{}, "OriginalGross", "TotalGross", "TotalNet",
+				"TaxableIncome", "SpecialDeduction", "IncomeTax", "EmployerContributions"); err != nil {
+				return err
+			}
+			return alterColumns(tx, "compensation_profiles", &compensationProfile{}, "BaseSalary")
+		},
+		Down: func(tx *gorm.DB) error {
+			type employee struct {
+				SpecialDeduction float64 `gorm:"default:0"`
+				ContributionBase float64 `gorm:"default:0"`
+			}
+			type payroll struct {
+				OriginalGross         float64
+				TotalGross            float64
+				TotalNet              float64
+				TaxableIncome         float64 `gorm:"default:0"`
+				SpecialDeduction      float64 `gorm:"default:0"`
+				IncomeTax             float64 `gorm:"default:0"`
+				EmployerContributions float64 `gorm:"default:0"`
+			}
+			type compensationProfile struct {
+				BaseSalary float64
+			}
+			if err := alterColumns(tx, "employees", &employee{}, "SpecialDeduction", "ContributionBase"); err != nil {
+				return err
+			}
+			if err := alterColumns(tx, "payrolls", &payroll{}, "OriginalGross", "TotalGross", "TotalNet",
+				"TaxableIncome", "SpecialDeduction", "IncomeTax", "EmployerContributions"); err != nil {
+				return err
+			}
+			return alterColumns(tx, "compensation_profiles", &compensationProfile{}, "BaseSalary")
+		},
+	},
+	{
+		Version: 21,
+		Name:    "payroll_signature_unique",
+		Up: func(tx *gorm.DB) error {
+			// 每张工资条只能签收一次；已有重复签收记录时需先人工处理，迁移不做删除
+			var duplicated []uint
+			if err := tx.Table("payroll_signatures").Select("payroll_id").Group("payroll_id").
+				Having("COUNT(*) > 1").Scan(&duplicated).Error; err != nil {
+				return err
+			}
+			if len(duplicated) > 0 {
+				return fmt.Errorf("payroll_signatures: payrolls %v have more than one signature", duplicated)
+			}
+			type payrollSignature struct {
+				PayrollID uint `gorm:"uniqueIndex:idx_payroll_signatures_payroll"`
+			}
+			return tx.Table("payroll_signatures").Migrator().CreateIndex(&payrollSignature{}, "idx_payroll_signatures_payroll")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropIndex("payroll_signatures", "idx_payroll_signatures_payroll")
+		},
+	},
+}
+
+func migrateBaselineUp(tx *gorm.DB) error {
+	type employee struct {
+		ID         uint `gorm:"primaryKey"`
+		Name       string
+		EmployeeNo string `gorm:"uniqueIndex;size:64"`
+		Department string
+		Position   string
+		Email      string
+		Phone      string
+		Status     string `gorm:"size:20;default:active"`
+		JoinDate   *time.Time
+		LeaveDate  *time.Time
+		DeletedAt  *time.Time `gorm:"index"`
+		CreatedAt  time.Time
+		UpdatedAt  time.Time
+	}
+	type payrollTemplate struct {
+		ID          uint `gorm:"primaryKey"`
+		Name        string
+		Description string
+		Fields      string `gorm:"type:text"`
+		IsActive    bool   `gorm:"default:true"`
+		CreatedAt   time.Time
+		UpdatedAt   time.Time
+	}
+	type payroll struct {
+		ID            uint   `gorm:"primaryKey"`
+		UUID          string `gorm:"uniqueIndex;size:36"`
+		EmployeeID    uint
+		Period        string
+		TemplateID    uint
+		WorkDays      float64 `gorm:"default:0"`
+		MonthDays     float64 `gorm:"default:0"`
+		IsProrated    bool    `gorm:"default:false"`
+		PayrollData   string  `gorm:"type:text"`
+		OriginalGross float64
+		TotalGross    float64
+		TotalNet      float64
+		Status        string `gorm:"size:20;default:draft"`
+		PublishedAt   *time.Time
+		CreatedAt     time.Time
+		UpdatedAt     time.Time
+	}
+	type payrollSignature struct {
+		ID            uint `gorm:"primaryKey"`
+		PayrollID     uint
+		SignatureData string `gorm:"type:text"`
+		SignatureHash string
+		IPAddress     string
+		UserAgent     string
+		DeviceInfo    string
+		SignedAt      time.Time
+		CreatedAt     time.Time
+	}
+	type payrollNotification struct {
+		ID        uint `gorm:"primaryKey"`
+		PayrollID uint
+		Type      string
+		Recipient string
+		Status    string
+		SentAt    *time.Time
+		ErrorMsg  string
+		CreatedAt time.Time
+	}
+	type adminUser struct {
+		ID        uint   `gorm:"primaryKey"`
+		Username  string `gorm:"uniqueIndex;size:64"`
+		Password  string
+		IsActive  bool `gorm:"default:true"`
+		CreatedAt time.Time
+		UpdatedAt time.Time
+	}
+	type resignationApplication struct {
+		ID               uint   `gorm:"primaryKey"`
+		UUID             string `gorm:"uniqueIndex;size:36"`
+		EmployeeID       uint
+		ResignationType  string
+		ResignationDate  time.Time
+		LastWorkingDate  time.Time
+		Reason           string `gorm:"type:text"`
+		HandoverNotes    string `gorm:"type:text"`
+		Status           string `gorm:"size:20;default:draft"`
+		ApprovedBy       *uint
+		ApprovedAt       *time.Time
+		ApprovalComments string `gorm:"type:text"`
+		CreatedAt        time.Time
+		UpdatedAt        time.Time
+	}
+	type resignationReport struct {
+		ID                      uint `gorm:"primaryKey"`
+		ApplicationID           uint
+		ReportContent           string `gorm:"type:text"`
+		WorkSummary             string `gorm:"type:text"`
+		UnfinishedTasks         string `gorm:"type:text"`
+		CompanyPropertyReturned bool
+		FinancialSettlement     bool
+		GeneratedAt             time.Time
+		CreatedAt               time.Time
+		UpdatedAt               time.Time
+	}
+	type resignationSignature struct {
+		ID            uint `gorm:"primaryKey"`
+		ApplicationID uint
+		SignerType    string
+		SignerID      uint
+		SignatureData string `gorm:"type:text"`
+		SignatureHash string
+		IPAddress     string
+		UserAgent     string
+		DeviceInfo    string
+		SignedAt      time.Time
+		CreatedAt     time.Time
+	}
+	type resignationSignToken struct {
+		ID            uint `gorm:"primaryKey"`
+		ApplicationID uint
+		SignerType    string
+		Token         string `gorm:"uniqueIndex;size:64"`
+		Used          bool   `gorm:"default:false"`
+		ExpiresAt     time.Time
+		CreatedAt     time.Time
+		UsedAt        *time.Time
+	}
+
+	// 已经通过 AutoMigrate 建好表的旧部署在这里只会补齐缺失的列
+	tables := []struct {
+		name  string
+		model interface{}
+	}{
+		{"employees", &employee{}},
+		{"payroll_templates", &payrollTemplate{}},
+		{"payrolls", &payroll{}},
+		{"payroll_signatures", &payrollSignature{}},
+		{"payroll_notifications", &payrollNotification{}},
+		{"admin_users", &adminUser{}},
+		{"resignation_applications", &resignationApplication{}},
+		{"resignation_reports", &resignationReport{}},
+		{"resignation_signatures", &resignationSignature{}},
+		{"resignation_sign_tokens", &resignationSignToken{}},
+	}
+	for _, t := range tables {
+		if err := tx.Table(t.name).AutoMigrate(t.model); err != nil {
+			return fmt.Errorf("%s: %w", t.name, err)
+		}
+	}
+	return nil
+}
+
+func migrateBaselineDown(tx *gorm.DB) error {
+	return tx.Migrator().DropTable(
+		"resignation_sign_tokens",
+		"resignation_signatures",
+		"resignation_reports",
+		"resignation_applications",
+		"admin_users",
+		"payroll_notifications",
+		"payroll_signatures",
+		"payrolls",
+		"payroll_templates",
+		"employees",
+	)
+}
+
+// 按旧版关键词规则推断模板字段的类型：包含扣款关键词的为扣款项，基本工资按出勤折算。
+// 英文关键词按下划线分隔的完整单词匹配，避免 taxi_allowance 之类的字段被误判为扣款。
+// 关键词是迁移时的快照，不随业务代码变化。已有 kind 的字段不修改。
+func inferLegacyTemplateFields(raw string) (string, bool) {
+	deductionKeywords := []string{"tax", "insurance", "deduction", "扣款", "扣除", "罚款", "penalty", "fund", "公积金"}
+	preTaxKeywords := []string{"insurance", "fund", "公积金", "社保"}
+	basicSalaryKeys := []string{"basic_salary", "base_salary", "基本工资", "底薪"}
+	contains := func(key string, keywords []string) bool {
+		words := strings.Split(key, "_")
+		for _, k := range keywords {
+			if k[0] >= 0x80 && strings.Contains(key, k) {
+				return true
+			}
+			for _, w := range words {
+				if w == k {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	var fields map[string]map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &fields); err != nil {
+		return raw, false
+	}
+	changed := false
+	result := map[string]map[string]interface{}{}
+	for i, key := range jsonObjectKeys(raw) {
+		def := fields[key]
+		if def == nil {
+			def = map[string]interface{}{}
+		}
+		if _, ok := def["kind"]; ok {
+			result[key] = def
+			continue
+		}
+		changed = true
+		lower := strings.ToLower(key)
+		name, _ := def["name"].(string)
+		if strings.TrimSpace(name) == "" {
+			name = key
+		}
+		fieldType, _ := def["type"].(string)
+		required, _ := def["required"].(bool)
+		field := map[string]interface{}{
+			"name":  name,
+			"type":  "number",
+			"order": i + 1,
+		}
+		switch {
+		case fieldType == "text":
+			field["type"] = "text"
+			field["kind"] = "informational"
+		case contains(lower, deductionKeywords):
+			field["kind"] = "deduction"
+			field["taxable"] = contains(lower, preTaxKeywords)
+		default:
+			field["kind"] = "earning"
+			field["taxable"] = true
+			for _, k := range basicSalaryKeys {
+				if lower == k {
+					field["prorate"] = true
+				}
+			}
+		}
+		if required {
+			field["required"] = true
+		}
+		result[key] = field
+	}
+	if !changed {
+		return raw, false
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return raw, false
+	}
+	return string(data), true
+}
+
+// 给已有的表补充列，model 为只包含新增字段的结构体快照
+func addColumns(tx *gorm.DB, table string, model interface{}, fields ...string) error {
+	m := tx.Table(table).Migrator()
+	for _, field := range fields {
+		if m.HasColumn(model, field) {
+			continue
+		}
+		if err := m.AddColumn(model, field); err != nil {
+			return fmt.Errorf("%s.%s: %w", table, field, err)
+		}
+	}
+	return nil
+}
+
+func dropColumns(tx *gorm.DB, table string, columns ...string) error {
+	m := tx.Migrator()
+	for _, column := range columns {
+		if !m.HasColumn(table, column) {
+			continue
+		}
+		// sqlite 驱动的 DropColumn 需要模型结构体，这里直接执行 DDL
+		if err := tx.Exec("ALTER TABLE ? DROP COLUMN ?", clause.Table{Name: table}, clause.Column{Name: column}).Error; err != nil {
+			return fmt.Errorf("%s.%s: %w", table, column, err)
+		}
+	}
+	return nil
+}
+
+// 按结构体快照修改已有列的类型
+func alterColumns(tx *gorm.DB, table string, model interface{}, fields ...string) error {
+	// sqlite 驱动修改列类型时重建整张表，原有索引不会保留，修改后按原定义重建
+	var indexes []string
+	if tx.Dialector.Name() == "sqlite" {
+		if err := tx.Raw("SELECT sql FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL", table).
+			Scan(&indexes).Error; err != nil {
+			return err
+		}
+	}
+	m := tx.Table(table).Migrator()
+	for _, field := range fields {
+		if err := m.AlterColumn(model, field); err != nil {
+			return fmt.Errorf("%s.%s: %w", table, field, err)
+		}
+	}
+	for _, index := range indexes {
+		if err := tx.Exec(index).Error; err != nil {
+			return fmt.Errorf("%s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// 已执行的迁移版本
+func appliedMigrations(conn *gorm.DB) (map[int]SchemaMigration, error) {
+	if err := conn.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, err
+	}
+	var records []SchemaMigration
+	if err := conn.Order("version").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]SchemaMigration, len(records))
+	for _, r := range records {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+func sortedMigrations() []Migration {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	return sorted
+}
+
+// 未执行的迁移
+func pendingMigrations(conn *gorm.DB) ([]Migration, error) {
+	applied, err := appliedMigrations(conn)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, m := range sortedMigrations() {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// 执行所有未执行的迁移
+func migrateUp(conn *gorm.DB) error {
+	pending, err := pendingMigrations(conn)
+	if err != nil {
+		return err
+	}
+	for _, m := range pending {
+		err := conn.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		log.Printf("Applied migration %d_%s", m.Version, m.Name)
+	}
+	return nil
+}
+
+// 回滚最近的 steps 个迁移
+func migrateDown(conn *gorm.DB, steps int) error {
+	applied, err := appliedMigrations(conn)
+	if err != nil {
+		return err
+	}
+	sorted := sortedMigrations()
+	for i := len(sorted) - 1; i >= 0 && steps > 0; i-- {
+		m := sorted[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		err := conn.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, m.Version).Error
+		})
+		if err != nil {
+			return fmt.Errorf("rollback %d_%s: %w", m.Version, m.Name, err)
+		}
+		log.Printf("Rolled back migration %d_%s", m.Version, m.Name)
+		steps--
+	}
+	return nil
+}
+
+// 打印迁移状态
+func printMigrationStatus(conn *gorm.DB) error {
+	applied, err := appliedMigrations(conn)
+	if err != nil {
+		return err
+	}
+	for _, m := range sortedMigrations() {
+		if r, ok := applied[m.Version]; ok {
+			fmt.Printf("applied  %4d_%s  %s\n", m.Version, m.Name, r.AppliedAt.Format("2006-01-02 15:04:05"))
+		} else {
+			fmt.Printf("pending  %4d_%s\n", m.Version, m.Name)
+		}
+	}
+	return nil
+}
+
+// payroll migrate up|down [n]|status
+func runMigrateCommand(conn *gorm.DB, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up|down [n]|status")
+	}
+	switch args[0] {
+	case "up":
+		return migrateUp(conn)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid step count: %s", args[1])
+			}
+			steps = n
+		}
+		return migrateDown(conn, steps)
+	case "status":
+		return printMigrationStatus(conn)
+	default:
+		return fmt.Errorf("unknown migrate command: %s", args[0])
+	}
+}
+
+// 旧工资条的应税所得和已扣个税：有明细行时按明细行汇总，否则按模板字段定义和工资数据计算。
+// 手工录入的个税字段按字段名识别（tax、personal_tax、income_tax 或名称含"所得税""个税"）
+func legacyPayrollTaxFigures(fields, data, breakdown string, prorated bool, workDays, monthDays float64) (float64, float64) {
+	type line struct {
+		Key     string
+		Name    string
+		Kind    string
+		Taxable bool
+		Prorate bool
+		Amount  float64
+	}
+	isTaxLine := func(l line) bool {
+		switch strings.ToLower(l.Key) {
+		case "tax", "personal_tax", "income_tax", "iit":
+			return true
+		}
+		return strings.Contains(l.Name, "所得税") || strings.Contains(l.Name, "个税")
+	}
+
+	var lines []line
+	if breakdown == "" || json.Unmarshal([]byte(breakdown), &lines) != nil {
+		lines = nil
+		var defs map[string]line
+		var values map[string]interface{}
+		if json.Unmarshal([]byte(fields), &defs) != nil || json.Unmarshal([]byte(data), &values) != nil {
+			return 0, 0
+		}
+		for key, def := range defs {
+			amount, _ := values[key].(float64)
+			if amount <= 0 {
+				continue
+			}
+			if def.Prorate && prorated && workDays > 0 && monthDays > 0 {
+				amount = amount * workDays / monthDays
+			}
+			def.Key, def.Amount = key, amount
+			lines = append(lines, def)
+		}
+	}
+
+	var taxable, tax decimal.Decimal
+	for _, l := range lines {
+		amount := decimal.NewFromFloat(l.Amount).Round(2)
+		switch {
+		case l.Kind == "earning" && l.Taxable:
+			taxable = taxable.Add(amount)
+		case l.Kind == "deduction" && l.Taxable:
+			taxable = taxable.Sub(amount)
+		case l.Kind == "deduction" && isTaxLine(l):
+			tax = tax.Add(amount)
+		}
+	}
+	return taxable.InexactFloat64(), tax.InexactFloat64()
+}
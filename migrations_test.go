@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// 迁移版本唯一且递增，每个迁移都有回滚
+func TestMigrationList(t *testing.T) {
+	seen := map[int]bool{}
+	for i, m := range migrations {
+		if seen[m.Version] || (i > 0 && m.Version <= migrations[i-1].Version) {
+			t.Errorf("migration %d_%s: version duplicated or out of order", m.Version, m.Name)
+		}
+		seen[m.Version] = true
+		if m.Name == "" || m.Up == nil || m.Down == nil {
+			t.Errorf("migration %d: name, up and down are required", m.Version)
+		}
+	}
+}
+
+func openEmptyTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	cfg := appConfig.Database
+	cfg.Type, cfg.URL = "sqlite", filepath.Join(t.TempDir(), "empty.db")
+	conn, err := openDatabase(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := conn.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return conn
+}
+
+// 捕获 migrate status 输出
+func migrationStatusOutput(t *testing.T, conn *gorm.DB) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	err = runMigrateCommand(conn, []string{"status"})
+	os.Stdout = stdout
+	w.Close()
+	var out bytes.Buffer
+	io.Copy(&out, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func countStatus(output, state string) int {
+	n := 0
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, state+" ") {
+			n++
+		}
+	}
+	return n
+}
+
+// 空数据库执行全部迁移，可重复执行；回滚后再次执行恢复到最新版本
+func TestMigrateCommand(t *testing.T) {
+	conn := openEmptyTestDB(t)
+	latest := migrations[len(migrations)-1]
+
+	if out := migrationStatusOutput(t, conn); countStatus(out, "pending") != len(migrations) || countStatus(out, "applied") != 0 {
+		t.Fatalf("status on empty database:\n%s", out)
+	}
+	for i := 0; i < 2; i++ {
+		if err := runMigrateCommand(conn, []string{"up"}); err != nil {
+			t.Fatalf("up #%d: %v", i+1, err)
+		}
+	}
+	out := migrationStatusOutput(t, conn)
+	if countStatus(out, "applied") != len(migrations) || countStatus(out, "pending") != 0 {
+		t.Fatalf("status after up:\n%s", out)
+	}
+	for _, table := range []string{"employees", "payrolls", "payroll_signatures", "compensation_profiles"} {
+		if !conn.Migrator().HasTable(table) {
+			t.Errorf("table %s missing after up", table)
+		}
+	}
+
+	if err := runMigrateCommand(conn, []string{"down", "2"}); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := pendingMigrations(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 || pending[1].Version != latest.Version {
+		t.Errorf("pending after down 2 = %v, want the last two migrations", pending)
+	}
+	if out := migrationStatusOutput(t, conn); !strings.Contains(out, fmt.Sprintf("pending  %4d_%s", latest.Version, latest.Name)) {
+		t.Errorf("status after down does not list %d_%s as pending:\n%s", latest.Version, latest.Name, out)
+	}
+
+	if err := runMigrateCommand(conn, []string{"down", strconv.Itoa(len(migrations))}); err != nil {
+		t.Fatal(err)
+	}
+	if conn.Migrator().HasTable("payrolls") {
+		t.Error("payrolls table remains after rolling back every migration")
+	}
+	if err := runMigrateCommand(conn, []string{"up"}); err != nil {
+		t.Fatalf("up after full rollback: %v", err)
+	}
+	if pending, _ := pendingMigrations(conn); len(pending) != 0 {
+		t.Errorf("pending after up = %d, want 0", len(pending))
+	}
+
+	for _, args := range [][]string{nil, {"down", "0"}, {"down", "x"}, {"sideways"}} {
+		if err := runMigrateCommand(conn, args); err == nil {
+			t.Errorf("migrate %v: want error", args)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// 金额：十进制定点数，计算和存储都不经过二进制浮点。
+// JSON 中输出为数字（如 7741.94），读取时数字和字符串都接受；
+// 数据库中为 NUMERIC(18,2) 列，MySQL 和 PostgreSQL 按十进制精确存储；SQLite 没有定点类型，
+// 按数值亲和性保存为浮点，读取时按最短表示还原，写入的值都已按舍入规则取整，往返不丢精度。
+type Money struct {
+	decimal.Decimal
+}
+
+func newMoney(d decimal.Decimal) Money {
+	return Money{d}
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		m.Decimal = decimal.Zero
+		return nil
+	}
+	return m.Decimal.UnmarshalJSON(data)
+}
+
+// 工资数据中的数字：录入的值由 JSON 解码为 float64，按最短十进制表示转换，8700.1 不会变成 8700.0999…；
+// 薪酬档案带入的默认金额和公式结果为 Money
+func decimalValue(v interface{}) (decimal.Decimal, bool) {
+	switch n := v.(type) {
+	case float64:
+		return decimal.NewFromFloat(n), true
+	case Money:
+		return n.Decimal, true
+	case decimal.Decimal:
+		return n, true
+	}
+	return decimal.Zero, false
+}
+
+// 舍入单位
+var roundingUnits = map[string]int32{
+	"fen":  2, // 分
+	"yuan": 0, // 元
+}
+
+// 舍入规则：Unit 为 fen 或 yuan；Mode 为 half_up（四舍五入）或 half_even（银行家舍入，四舍六入五成双）
+type RoundingPolicy struct {
+	Unit string `yaml:"unit"`
+	Mode string `yaml:"mode"`
+}
+
+func (p RoundingPolicy) validate(name string) error {
+	if _, ok := roundingUnits[p.Unit]; !ok {
+		return fmt.Errorf("%s.unit must be fen or yuan", name)
+	}
+	if p.Mode != "half_up" && p.Mode != "half_even" {
+		return fmt.Errorf("%s.mode must be half_up or half_even", name)
+	}
+	return nil
+}
+
+// 按规则舍入，half_up 时 .5 向远离0的方向进位
+func (p RoundingPolicy) round(d decimal.Decimal) decimal.Decimal {
+	places := roundingUnits[p.Unit]
+	if p.Mode == "half_even" {
+		return d.RoundBank(places)
+	}
+	return d.Round(places)
+}
+
+// 金额格式：千分位，两位小数
+func formatMoney(amount Money) string {
+	s := amount.StringFixed(2)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	integer, fraction, _ := strings.Cut(s, ".")
+	var grouped []string
+	for len(integer) > 3 {
+		grouped = append([]string{integer[len(integer)-3:]}, grouped...)
+		integer = integer[:len(integer)-3]
+	}
+	grouped = append([]string{integer}, grouped...)
+	result := strings.Join(grouped, ",") + "." + fraction
+	if negative {
+		result = "-" + result
+	}
+	return result
+}
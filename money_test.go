@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestRoundingPolicy(t *testing.T) {
+	tests := []struct {
+		unit, mode string
+		in, want   string
+	}{
+		{"fen", "half_up", "1.005", "1.01"},
+		{"fen", "half_even", "1.005", "1"},
+		{"fen", "half_up", "1.015", "1.02"},
+		{"fen", "half_even", "1.015", "1.02"},
+		{"fen", "half_even", "1.0051", "1.01"},
+		{"fen", "half_up", "-1.005", "-1.01"},
+		{"fen", "half_even", "-1.005", "-1"},
+		{"yuan", "half_up", "2.5", "3"},
+		{"yuan", "half_even", "2.5", "2"},
+		{"yuan", "half_up", "3.5", "4"},
+		{"yuan", "half_even", "3.5", "4"},
+		{"yuan", "half_even", "2.51", "3"},
+		{"yuan", "half_up", "-2.5", "-3"},
+		{"yuan", "half_even", "-2.5", "-2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.unit+"/"+tt.mode+"/"+tt.in, func(t *testing.T) {
+			p := RoundingPolicy{Unit: tt.unit, Mode: tt.mode}
+			if err := p.validate("rounding"); err != nil {
+				t.Fatal(err)
+			}
+			got := p.round(decimal.RequireFromString(tt.in))
+			if !got.Equal(decimal.RequireFromString(tt.want)) {
+				t.Errorf("round(%s) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoundingPolicyValidate(t *testing.T) {
+	for _, p := range []RoundingPolicy{{Unit: "jiao", Mode: "half_up"}, {Unit: "fen", Mode: "down"}} {
+		if err := p.validate("rounding"); err == nil {
+			t.Errorf("validate(%+v) = nil, want error", p)
+		}
+	}
+}
+
+func TestDecimalValue(t *testing.T) {
+	tests := []struct {
+		name string
+		in   interface{}
+		want string
+		ok   bool
+	}{
+		{"float64 shortest representation", 8700.1, "8700.1", true},
+		{"float64 whole yuan", 15000.0, "15000", true},
+		{"float64 from JSON", jsonNumber(t, "1964.52"), "1964.52", true},
+		{"money", newMoney(decimal.RequireFromString("12.34")), "12.34", true},
+		{"decimal", decimal.RequireFromString("-5.5"), "-5.5", true},
+		{"string", "8700.1", "0", false},
+		{"nil", nil, "0", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := decimalValue(tt.in)
+			if ok != tt.ok || !got.Equal(decimal.RequireFromString(tt.want)) {
+				t.Errorf("decimalValue(%#v) = %s, %v, want %s, %v", tt.in, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func jsonNumber(t *testing.T, s string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestMoneyJSON(t *testing.T) {
+	var m struct {
+		A Money `json:"a"`
+		B Money `json:"b"`
+		C Money `json:"c"`
+	}
+	if err := json.Unmarshal([]byte(`{"a": 8700.1, "b": "1964.52", "c": null}`), &m); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"a":8700.1,"b":1964.52,"c":0}`; string(data) != want {
+		t.Errorf("Marshal = %s, want %s", data, want)
+	}
+}
+
+func TestFormatMoney(t *testing.T) {
+	tests := map[string]string{
+		"0":          "0.00",
+		"12.5":       "12.50",
+		"1234.5":     "1,234.50",
+		"1234567.89": "1,234,567.89",
+		"-9876.5":    "-9,876.50",
+	}
+	for in, want := range tests {
+		if got := formatMoney(newMoney(decimal.RequireFromString(in))); got != want {
+			t.Errorf("formatMoney(%s) = %s, want %s", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// 通知渠道配置
+type NotifyConfig struct {
+	SMTP       SMTPConfig `yaml:"smtp"`
+	SMSWebhook string     `yaml:"sms_webhook"` // 短信网关地址，POST {"phone": "...", "message": "..."}
+}
+
+// 邮件服务配置
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+}
+
+var errChannelNotConfigured = errors.New("notification channel not configured")
+
+var smsClient = &http.Client{Timeout: 10 * time.Second}
+
+// 发送邮件或短信；dev环境未配置渠道时只写日志，方便本地调试
+func sendMessage(channel, recipient, subject, body string) error {
+	cfg := appConfig.Notify
+	switch channel {
+	case "email":
+		if cfg.SMTP.Host == "" {
+			return logMessage(channel, recipient, subject, body)
+		}
+		return sendEmail(cfg.SMTP, recipient, subject, body)
+	case "sms":
+		if cfg.SMSWebhook == "" {
+			return logMessage(channel, recipient, subject, body)
+		}
+		return sendSMS(cfg.SMSWebhook, recipient, body)
+	default:
+		return fmt.Errorf("unknown notification channel: %s", channel)
+	}
+}
+
+func logMessage(channel, recipient, subject, body string) error {
+	if !appConfig.IsDev() {
+		return fmt.Errorf("%s: %w", channel, errChannelNotConfigured)
+	}
+	log.Printf("[%s] to=%s subject=%q\n%s", channel, recipient, subject, body)
+	return nil
+}
+
+func sendEmail(cfg SMTPConfig, recipient, subject, body string) error {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", recipient)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+	addr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
+	return smtp.SendMail(addr, auth, cfg.From, []string{recipient}, []byte(msg.String()))
+}
+
+func sendSMS(webhook, phone, message string) error {
+	payload, err := json.Marshal(map[string]string{"phone": phone, "message": message})
+	if err != nil {
+		return err
+	}
+	resp, err := smsClient.Post(webhook, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("sms gateway returned %s", resp.Status)
+	}
+	return nil
+}
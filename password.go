@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// 管理员历史密码，用于禁止重复使用最近的密码
+type AdminPasswordHistory struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	AdminUserID  uint      `json:"admin_user_id" gorm:"index"`
+	PasswordHash string    `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// 密码策略
+type PasswordPolicy struct {
+	MinLength  int `yaml:"min_length"`  // 最小长度
+	MinClasses int `yaml:"min_classes"` // 至少包含的字符类型数：大写、小写、数字、符号
+	History    int `yaml:"history"`     // 不允许与最近N次密码相同
+}
+
+// 密码哈希（bcrypt，每个密码独立加盐）
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), appConfig.Auth.BcryptCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// 验证密码，兼容旧版MD5哈希
+func verifyPassword(hashedPassword, password string) bool {
+	if isLegacyPasswordHash(hashedPassword) {
+		legacy := legacyPasswordHash(password)
+		return subtle.ConstantTimeCompare([]byte(hashedPassword), []byte(legacy)) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) == nil
+}
+
+var (
+	dummyPasswordOnce sync.Once
+	dummyPasswordHash []byte
+)
+
+// 用户不存在时也执行一次同等强度的bcrypt比对，避免通过响应时间枚举用户名
+func verifyDummyPassword(password string) {
+	dummyPasswordOnce.Do(func() {
+		hash, err := bcrypt.GenerateFromPassword([]byte("payroll-dummy-password"), appConfig.Auth.BcryptCost)
+		if err == nil {
+			dummyPasswordHash = hash
+		}
+	})
+	if dummyPasswordHash != nil {
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+	}
+}
+
+// 是否需要用当前算法和强度重新哈希
+func passwordNeedsRehash(hashedPassword string) bool {
+	if isLegacyPasswordHash(hashedPassword) {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(hashedPassword))
+	return err != nil || cost != appConfig.Auth.BcryptCost
+}
+
+// 旧版本使用固定盐的MD5，仅用于登录时校验并升级
+func legacyPasswordHash(password string) string {
+	hash := md5.Sum([]byte(password + "payroll-salt"))
+	return hex.EncodeToString(hash[:])
+}
+
+func isLegacyPasswordHash(hashedPassword string) bool {
+	if len(hashedPassword) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(hashedPassword)
+	return err == nil
+}
+
+// 校验新密码是否符合密码策略
+func validatePasswordPolicy(user AdminUser, password string) error {
+	policy := appConfig.Auth.PasswordPolicy
+
+	if len([]rune(password)) < policy.MinLength {
+		return fmt.Errorf("密码长度不能少于%d位", policy.MinLength)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, ok := range []bool{upper, lower, digit, symbol} {
+		if ok {
+			classes++
+		}
+	}
+	if classes < policy.MinClasses {
+		return fmt.Errorf("密码需至少包含大写字母、小写字母、数字、符号中的%d种", policy.MinClasses)
+	}
+
+	if user.ID == 0 || policy.History <= 0 {
+		return nil
+	}
+	if user.Password != "" && verifyPassword(user.Password, password) {
+		return errors.New("新密码不能与当前密码相同")
+	}
+	var history []AdminPasswordHistory
+	db.Where("admin_user_id = ?", user.ID).Order("created_at DESC").Limit(policy.History).Find(&history)
+	for _, h := range history {
+		if verifyPassword(h.PasswordHash, password) {
+			return fmt.Errorf("新密码不能与最近%d次使用过的密码相同", policy.History)
+		}
+	}
+	return nil
+}
+
+// 设置管理员密码并记录历史
+func setAdminPassword(tx *gorm.DB, user *AdminUser, password string, mustChange bool) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(user).Updates(map[string]interface{}{
+		"password":             hash,
+		"must_change_password": mustChange,
+	}).Error; err != nil {
+		return err
+	}
+	user.Password = hash
+	user.MustChangePassword = mustChange
+	return tx.Create(&AdminPasswordHistory{AdminUserID: user.ID, PasswordHash: hash}).Error
+}
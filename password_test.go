@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 测试中使用最低的bcrypt强度，登录失败不延迟；结束后恢复配置
+func useFastAuthConfig(t *testing.T) {
+	t.Helper()
+	saved := appConfig.Auth
+	appConfig.Auth.BcryptCost = bcrypt.MinCost
+	appConfig.Auth.Lockout.BaseDelay = 0
+	t.Cleanup(func() { appConfig.Auth = saved })
+}
+
+func createTestAdminUser(t *testing.T, username, passwordHash string) AdminUser {
+	t.Helper()
+	user := AdminUser{Username: username, Password: passwordHash, Role: RoleAdmin, IsActive: true}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func postLogin(t *testing.T, username, password string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/login",
+		strings.NewReader(`{"username": "`+username+`", "password": "`+password+`"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.RemoteAddr = "198.51.100.1:40000"
+	login(c)
+	return w
+}
+
+// 旧版MD5和强度不足的bcrypt哈希在登录成功后升级为当前强度的bcrypt，登录失败不升级
+func TestLoginRehashesPassword(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const password = "Legacy#Pass2024"
+	weak, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		stored   string
+		password string
+		status   int
+		rehashed bool
+	}{
+		{"legacy md5", legacyPasswordHash(password), password, http.StatusOK, true},
+		{"legacy md5 wrong password", legacyPasswordHash(password), "Wrong#Pass2024", http.StatusUnauthorized, false},
+		{"weaker bcrypt cost", string(weak), password, http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			useFastAuthConfig(t)
+			appConfig.Auth.BcryptCost = bcrypt.MinCost + 1
+			user := createTestAdminUser(t, "legacy", tt.stored)
+
+			if w := postLogin(t, "legacy", tt.password); w.Code != tt.status {
+				t.Fatalf("status = %d, want %d, body = %s", w.Code, tt.status, w.Body)
+			}
+			db.First(&user, user.ID)
+			if rehashed := user.Password != tt.stored; rehashed != tt.rehashed {
+				t.Fatalf("rehashed = %v, want %v", rehashed, tt.rehashed)
+			}
+			if !tt.rehashed {
+				return
+			}
+			if cost, err := bcrypt.Cost([]byte(user.Password)); err != nil || cost != bcrypt.MinCost+1 {
+				t.Errorf("bcrypt cost = %d (%v), want %d", cost, err, bcrypt.MinCost+1)
+			}
+			if !verifyPassword(user.Password, password) || passwordNeedsRehash(user.Password) {
+				t.Error("upgraded hash does not verify or still needs rehash")
+			}
+		})
+	}
+}
+
+func TestValidatePasswordPolicy(t *testing.T) {
+	setupTestDB(t)
+	useFastAuthConfig(t)
+	appConfig.Auth.PasswordPolicy = PasswordPolicy{MinLength: 10, MinClasses: 3, History: 2}
+
+	user := createTestAdminUser(t, "policy", "")
+	// 依次使用过 First#Pass01、Second#Pass02、Third#Pass03，当前为最后一个
+	for _, password := range []string{"First#Pass01", "Second#Pass02", "Third#Pass03"} {
+		if err := setAdminPassword(db, &user, password, false); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond) // 历史按创建时间排序
+	}
+
+	tests := []struct {
+		password string
+		wantErr  string
+	}{
+		{"Short#1", "密码长度不能少于10位"},
+		{"alllowercase1", "至少包含"},
+		{"ALLUPPER#SYMBOLS", "至少包含"},
+		{"Third#Pass03", "新密码不能与当前密码相同"},
+		{"Second#Pass02", "新密码不能与最近2次使用过的密码相同"},
+		{"First#Pass01", ""}, // 已超出历史记录范围
+		{"中文密码Abc#123", ""},
+		{"Fresh#Pass04", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			err := validatePasswordPolicy(user, tt.password)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("validatePasswordPolicy() error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("validatePasswordPolicy() error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
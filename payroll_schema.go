@@ -0,0 +1,528 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// 工资项目类型
+const (
+	FieldKindEarning              = "earning"               // 收入项，计入应发
+	FieldKindDeduction            = "deduction"             // 扣款项，从应发中扣除
+	FieldKindEmployerContribution = "employer_contribution" // 单位缴纳部分，不计入应发和实发
+	FieldKindInformational        = "informational"         // 仅展示，不参与计算
+)
+
+var templateFieldKinds = map[string]string{
+	FieldKindEarning:              "收入",
+	FieldKindDeduction:            "扣款",
+	FieldKindEmployerContribution: "单位缴纳",
+	FieldKindInformational:        "信息",
+}
+
+// 由系统计算金额的字段，录入的值会被忽略
+const (
+	FieldCalculatorIncomeTax               = "income_tax"                // 个人所得税，累计预扣法
+	FieldCalculatorSocialInsurance         = "social_insurance"          // 社保个人部分
+	FieldCalculatorHousingFund             = "housing_fund"              // 公积金个人部分
+	FieldCalculatorSocialInsuranceEmployer = "social_insurance_employer" // 社保单位部分
+	FieldCalculatorHousingFundEmployer     = "housing_fund_employer"     // 公积金单位部分
+)
+
+// 系统计算字段只能用于对应类型的字段；Taxable 为扣款项是否必须设为税前扣除
+type fieldCalculator struct {
+	Name    string
+	Kind    string
+	Taxable bool
+}
+
+var templateFieldCalculators = map[string]fieldCalculator{
+	FieldCalculatorIncomeTax:               {"个人所得税（累计预扣法）", FieldKindDeduction, false},
+	FieldCalculatorSocialInsurance:         {"社保个人部分", FieldKindDeduction, true},
+	FieldCalculatorHousingFund:             {"公积金个人部分", FieldKindDeduction, true},
+	FieldCalculatorSocialInsuranceEmployer: {"社保单位部分", FieldKindEmployerContribution, false},
+	FieldCalculatorHousingFundEmployer:     {"公积金单位部分", FieldKindEmployerContribution, false},
+}
+
+// 字段名：字母（含中文）、数字和下划线，不以数字开头
+var templateFieldKeyPattern = regexp.MustCompile(`^[\p{L}_][\p{L}\p{N}_]*$`)
+
+// 工资模板字段定义，PayrollTemplate.Fields 为 字段名 → 定义 的JSON对象
+type TemplateField struct {
+	Name       string `json:"name"`                 // 显示名称
+	Type       string `json:"type"`                 // number（默认）或 text，text 只能用于信息项
+	Kind       string `json:"kind"`                 // earning, deduction, employer_contribution, informational
+	Taxable    bool   `json:"taxable"`              // 收入项：计入应纳税所得；扣款项：税前扣除
+	Prorate    bool   `json:"prorate"`              // 按出勤天数折算
+	Required   bool   `json:"required,omitempty"`   // 录入工资条时必填
+	Order      int    `json:"order"`                // 显示顺序，相同时按定义顺序
+	Group      string `json:"group,omitempty"`      // 显示分组，如 "津贴补贴"
+	Formula    string `json:"formula,omitempty"`    // 计算公式，如 "overtime_hours * hourly_rate * 1.5"，设置后由服务端计算
+	Calculator string `json:"calculator,omitempty"` // 系统计算：income_tax、social_insurance 等
+}
+
+// 解析后的模板字段，Keys 按显示顺序排列
+type TemplateSchema struct {
+	Fields       map[string]TemplateField
+	Keys         []string
+	Formulas     map[string]formulaNode // 公式字段的语法树
+	FormulaOrder []string               // 公式字段按依赖排列的计算顺序
+}
+
+// 模板字段校验错误，按字段列出
+type FieldErrors map[string]string
+
+func (e FieldErrors) Error() string {
+	keys := make([]string, 0, len(e))
+	for k := range e {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+": "+e[k])
+	}
+	return strings.Join(parts, "; ")
+}
+
+// 按JSON中的顺序返回对象的键，不是对象时返回 nil
+func jsonObjectKeys(raw string) []string {
+	dec := json.NewDecoder(strings.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil
+	}
+	var keys []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return keys
+		}
+		key, _ := tok.(string)
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return keys
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// 解析并校验模板字段定义
+func parseTemplateSchema(fields string) (TemplateSchema, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(fields), &raw); err != nil {
+		return TemplateSchema{}, errors.New("fields 必须是JSON对象，键为字段名，值为字段定义")
+	}
+	if len(raw) == 0 {
+		return TemplateSchema{}, fmt.Errorf("模板至少需要一个字段")
+	}
+
+	schema := TemplateSchema{Fields: map[string]TemplateField{}}
+	errs := FieldErrors{}
+	for _, key := range jsonObjectKeys(fields) {
+		if _, done := schema.Fields[key]; done {
+			errs[key] = "字段重复定义"
+			continue
+		}
+		var field TemplateField
+		dec := json.NewDecoder(strings.NewReader(string(raw[key])))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&field); err != nil {
+			errs[key] = "字段定义格式错误: " + err.Error()
+			continue
+		}
+		if msg := validateTemplateField(key, &field); msg != "" {
+			errs[key] = msg
+			continue
+		}
+		schema.Fields[key] = field
+		schema.Keys = append(schema.Keys, key)
+	}
+	if len(errs) > 0 {
+		return TemplateSchema{}, errs
+	}
+
+	// 按 order 排序，相同时保持定义顺序
+	sort.SliceStable(schema.Keys, func(i, j int) bool {
+		return schema.Fields[schema.Keys[i]].Order < schema.Fields[schema.Keys[j]].Order
+	})
+	if errs := compileTemplateFormulas(&schema); errs != nil {
+		return TemplateSchema{}, errs
+	}
+	// 每种系统计算最多用于一个字段
+	seen := map[string]string{}
+	for _, key := range schema.Keys {
+		calculator := schema.Fields[key].Calculator
+		if calculator == "" {
+			continue
+		}
+		if other, ok := seen[calculator]; ok {
+			errs[key] = fmt.Sprintf("calculator %s 已用于字段 %s", calculator, other)
+			continue
+		}
+		seen[calculator] = key
+	}
+	if len(errs) > 0 {
+		return TemplateSchema{}, errs
+	}
+	return schema, nil
+}
+
+func validateTemplateField(key string, field *TemplateField) string {
+	if utf8.RuneCountInString(key) > 64 || !templateFieldKeyPattern.MatchString(key) {
+		return "字段名只能包含字母、数字和下划线，且不能以数字开头"
+	}
+	field.Name = strings.TrimSpace(field.Name)
+	if field.Name == "" {
+		return "name 不能为空"
+	}
+	if utf8.RuneCountInString(field.Name) > 64 {
+		return "name 过长"
+	}
+	if utf8.RuneCountInString(field.Group) > 32 {
+		return "group 过长"
+	}
+	if _, ok := templateFieldKinds[field.Kind]; !ok {
+		return "kind 必须是 earning、deduction、employer_contribution 或 informational"
+	}
+	if field.Type == "" {
+		field.Type = "number"
+	}
+	switch field.Type {
+	case "number":
+	case "text":
+		if field.Kind != FieldKindInformational {
+			return "text 类型只能用于 informational 字段"
+		}
+	default:
+		return "type 必须是 number 或 text"
+	}
+	if field.Taxable && field.Kind != FieldKindEarning && field.Kind != FieldKindDeduction {
+		return "只有收入项和扣款项可以设置 taxable"
+	}
+	if field.Prorate && field.Kind == FieldKindInformational {
+		return "信息项不能按出勤折算"
+	}
+	if field.Order < 0 {
+		return "order 不能为负数"
+	}
+	if formulaBuiltinVars[key] {
+		return key + " 是公式保留变量，不能用作字段名"
+	}
+	if field.Calculator != "" {
+		calculator, ok := templateFieldCalculators[field.Calculator]
+		if !ok {
+			return "calculator 必须是 income_tax、social_insurance、housing_fund、social_insurance_employer 或 housing_fund_employer"
+		}
+		if field.Kind != calculator.Kind || field.Type != "number" {
+			return fmt.Sprintf("calculator %s 只能用于数字类型的%s项", field.Calculator, templateFieldKinds[calculator.Kind])
+		}
+		if calculator.Taxable && !field.Taxable {
+			return "社保公积金个人部分是税前扣除，必须设置 taxable"
+		}
+		if !calculator.Taxable && field.Taxable {
+			return fmt.Sprintf("calculator %s 不能设置 taxable", field.Calculator)
+		}
+		if field.Prorate || field.Required || field.Formula != "" {
+			return "系统计算字段不能设置 prorate、required 或 formula"
+		}
+	}
+	field.Formula = strings.TrimSpace(field.Formula)
+	if field.Formula != "" {
+		if field.Type == "text" {
+			return "text 类型字段不能设置公式"
+		}
+		if field.Required {
+			return "公式字段由系统计算，不能设置为必填"
+		}
+	}
+	return ""
+}
+
+// 读取模板字段定义
+func loadTemplateSchema(templateID uint) (PayrollTemplate, TemplateSchema, error) {
+	var template PayrollTemplate
+	if err := db.First(&template, templateID).Error; err != nil {
+		return template, TemplateSchema{}, err
+	}
+	schema, err := parseTemplateSchema(template.Fields)
+	return template, schema, err
+}
+
+// 工资条明细行，计算后保存在 Payroll.Breakdown 中
+type PayrollLine struct {
+	Key        string `json:"key"`
+	Name       string `json:"name"`
+	Kind       string `json:"kind"`
+	Group      string `json:"group,omitempty"`
+	Taxable    bool   `json:"taxable,omitempty"`
+	Amount     Money  `json:"amount"`
+	FullAmount *Money `json:"full_amount,omitempty"` // 按出勤折算前的全月金额，只有折算过的行才有
+	Prorated   bool   `json:"prorated,omitempty"`
+	Text       string `json:"text,omitempty"`    // 文本类信息项的内容
+	Formula    string `json:"formula,omitempty"` // 公式字段的计算公式，Amount 为计算结果
+	Note       string `json:"note,omitempty"`    // 系统计算字段的计算说明
+}
+
+// 工资计算结果
+type PayrollCalculation struct {
+	Lines                 []PayrollLine        `json:"lines"`
+	OriginalGross         Money                `json:"original_gross"` // 不折算时的应发
+	TotalGross            Money                `json:"total_gross"`
+	TotalDeductions       Money                `json:"total_deductions"`
+	TotalNet              Money                `json:"total_net"`
+	TaxableIncome         Money                `json:"taxable_income"`         // 应税收入减税前扣除
+	EmployerContributions Money                `json:"employer_contributions"` // 单位缴纳合计，不计入实发
+	SpecialDeduction      Money                `json:"special_deduction"`      // 本期专项附加扣除
+	IncomeTax             *IncomeTaxResult     `json:"income_tax,omitempty"`
+	Contributions         *ContributionResult  `json:"contributions,omitempty"`
+	CompensationProfile   *CompensationProfile `json:"compensation_profile,omitempty"` // 带入默认值的薪酬档案
+}
+
+// 工资条引用的薪酬档案ID和发薪组，没有适用档案时为空
+func (c PayrollCalculation) compensationRef() (*uint, string) {
+	if c.CompensationProfile == nil {
+		return nil, ""
+	}
+	return &c.CompensationProfile.ID, c.CompensationProfile.PayGroup
+}
+
+// 出勤折算：金额 × 实际工作天数 ÷ 当月天数，先乘后除，结果能整除时没有误差
+type proration struct {
+	workDays, monthDays decimal.Decimal
+}
+
+// 不按比例计算、天数无效或全勤时返回 nil
+func newProration(isProrated bool, workDays, monthDays float64) *proration {
+	if !isProrated || workDays <= 0 || monthDays <= 0 || workDays == monthDays {
+		return nil
+	}
+	return &proration{decimal.NewFromFloat(workDays), decimal.NewFromFloat(monthDays)}
+}
+
+func (p *proration) apply(amount decimal.Decimal) decimal.Decimal {
+	return amount.Mul(p.workDays).Div(p.monthDays)
+}
+
+// 按模板字段定义计算工资：收入项计入应发，扣款项从应发中扣除，单位缴纳和信息项不影响实发；
+// 标记 prorate 的字段按出勤折算。收入、扣款和单位缴纳每项按单项规则舍入，合计按合计规则舍入，
+// 实发为舍入后的应发减舍入后的扣款。与原有规则一致，金额不大于0的项目不计入。
+func calculatePayroll(schema TemplateSchema, data map[string]interface{}, p *proration) PayrollCalculation {
+	lineRounding := appConfig.Payroll.Rounding.Line
+	var calc PayrollCalculation
+	var originalGross, gross, deductions, taxable, employer decimal.Decimal
+	for _, key := range schema.Keys {
+		field := schema.Fields[key]
+		line := PayrollLine{Key: key, Name: field.Name, Kind: field.Kind, Group: field.Group, Taxable: field.Taxable, Formula: field.Formula}
+
+		if field.Type == "text" {
+			if text, ok := data[key].(string); ok && text != "" {
+				line.Text = text
+				calc.Lines = append(calc.Lines, line)
+			}
+			continue
+		}
+		amount, ok := decimalValue(data[key])
+		if !ok || !amount.IsPositive() {
+			continue
+		}
+		// 信息项只展示，保留录入的值
+		if field.Kind == FieldKindInformational {
+			line.Amount = newMoney(amount)
+			calc.Lines = append(calc.Lines, line)
+			continue
+		}
+		full := lineRounding.round(amount)
+		if full.IsZero() {
+			continue
+		}
+		actual := full
+		if field.Prorate && p != nil {
+			actual = lineRounding.round(p.apply(amount))
+			fullAmount := newMoney(full)
+			line.FullAmount = &fullAmount
+			line.Prorated = true
+		}
+		line.Amount = newMoney(actual)
+		calc.Lines = append(calc.Lines, line)
+
+		switch field.Kind {
+		case FieldKindEarning:
+			originalGross = originalGross.Add(full)
+			gross = gross.Add(actual)
+			if field.Taxable {
+				taxable = taxable.Add(actual)
+			}
+		case FieldKindDeduction:
+			deductions = deductions.Add(actual)
+			if field.Taxable {
+				taxable = taxable.Sub(actual)
+			}
+		case FieldKindEmployerContribution:
+			employer = employer.Add(actual)
+		}
+	}
+
+	totalRounding := appConfig.Payroll.Rounding.Total
+	calc.OriginalGross = newMoney(totalRounding.round(originalGross))
+	calc.TotalGross = newMoney(totalRounding.round(gross))
+	calc.TotalDeductions = newMoney(totalRounding.round(deductions))
+	calc.TotalNet = newMoney(calc.TotalGross.Sub(calc.TotalDeductions.Decimal))
+	calc.TaxableIncome = newMoney(totalRounding.round(taxable))
+	calc.EmployerContributions = newMoney(totalRounding.round(employer))
+	return calc
+}
+
+// 工资条保存的明细行；旧工资条没有明细时按模板重新计算
+func payrollBreakdown(payroll Payroll, template PayrollTemplate) []PayrollLine {
+	var lines []PayrollLine
+	if payroll.Breakdown != "" && json.Unmarshal([]byte(payroll.Breakdown), &lines) == nil {
+		return lines
+	}
+	schema, err := parseTemplateSchema(template.Fields)
+	if err != nil {
+		return nil
+	}
+	var data map[string]interface{}
+	json.Unmarshal([]byte(payroll.PayrollData), &data)
+	return calculatePayroll(schema, data, newProration(payroll.IsProrated, payroll.WorkDays, payroll.MonthDays)).Lines
+}
+
+// 按工资条请求计算工资，未按比例计算且未填写出勤天数时默认为全月。
+// 未指定的模板和未填写的金额先从工资期间适用的薪酬档案带入，再按模板校验工资数据，公式字段再按依赖顺序计算，然后按员工参保信息计算社保公积金、按累计预扣法计算个税，
+// 结果写回 req.PayrollData，与工资条一起保存
+func computePayroll(req *CreatePayrollRequest) (PayrollCalculation, error) {
+	profile, err := applyCompensationProfile(req)
+	if err != nil {
+		return PayrollCalculation{}, err
+	}
+	template, schema, err := loadTemplateSchema(req.TemplateID)
+	if err != nil {
+		return PayrollCalculation{}, err
+	}
+	if !template.IsActive && appConfig.Payroll.StrictTemplates {
+		return PayrollCalculation{}, errTemplateInactive
+	}
+	if req.PayrollData == nil {
+		req.PayrollData = map[string]interface{}{}
+	}
+	if profile != nil {
+		for key, amount := range profile.payrollDefaults(schema) {
+			if _, ok := req.PayrollData[key]; !ok {
+				req.PayrollData[key] = amount
+			}
+		}
+	}
+	if errs := validatePayrollData(schema, req.PayrollData); errs != nil {
+		return PayrollCalculation{}, &PayrollDataError{Message: "工资数据校验失败", Fields: errs}
+	}
+	if req.WorkDays == 0 {
+		req.WorkDays = req.MonthDays
+	}
+	var employee Employee
+	if err := db.First(&employee, req.EmployeeID).Error; err != nil {
+		return PayrollCalculation{}, &PayrollDataError{Message: "工资数据校验失败", Fields: FieldErrors{"employee_id": "员工不存在"}}
+	}
+	special := employee.SpecialDeduction
+	if req.SpecialDeduction != nil {
+		if req.SpecialDeduction.IsNegative() {
+			return PayrollCalculation{}, &PayrollDataError{Message: "工资数据校验失败", Fields: FieldErrors{"special_deduction": "不能为负数"}}
+		}
+		special = *req.SpecialDeduction
+	}
+	if errs := evaluateTemplateFormulas(schema, req.PayrollData, req.WorkDays, req.MonthDays); errs != nil {
+		return PayrollCalculation{}, &PayrollDataError{Message: "工资计算失败", Fields: errs}
+	}
+	// 社保公积金个人部分是税前扣除，在个税之前计算
+	contributions, err := applyContributions(schema, employee, req.Period, req.PayrollData)
+	if err != nil {
+		return PayrollCalculation{}, err
+	}
+
+	p := newProration(req.IsProrated, req.WorkDays, req.MonthDays)
+	calc := calculatePayroll(schema, req.PayrollData, p)
+	// 个税按本期应税所得累计计算，算出后写入个税字段再重新汇总
+	if taxKey := schema.calculatorField(FieldCalculatorIncomeTax); taxKey != "" {
+		result, err := calculateIncomeTax(employee, req.Period, calc.TaxableIncome.Decimal, special.Decimal)
+		if err != nil {
+			return PayrollCalculation{}, &PayrollDataError{Message: "工资计算失败", Fields: FieldErrors{taxKey: err.Error()}}
+		}
+		req.PayrollData[taxKey] = result.Tax
+		calc = calculatePayroll(schema, req.PayrollData, p)
+		calc.IncomeTax = &result
+		for i := range calc.Lines {
+			if calc.Lines[i].Key == taxKey {
+				calc.Lines[i].Note = result.note()
+			}
+		}
+	}
+	annotateContributions(schema, &calc, contributions)
+	calc.SpecialDeduction = special
+	calc.CompensationProfile = profile
+	return calc, nil
+}
+
+// 本期计算的个税，模板没有个税计算字段时为0
+func (c PayrollCalculation) IncomeTaxAmount() Money {
+	if c.IncomeTax == nil {
+		return Money{}
+	}
+	return c.IncomeTax.Tax
+}
+
+// 使用指定系统计算的字段名，没有时返回空
+func (s TemplateSchema) calculatorField(calculator string) string {
+	for _, key := range s.Keys {
+		if s.Fields[key].Calculator == calculator {
+			return key
+		}
+	}
+	return ""
+}
+
+// 模板字段定义无效时的响应，逐个字段列出错误
+func respondTemplateSchemaError(c *gin.Context, err error) {
+	var fieldErrs FieldErrors
+	if errors.As(err, &fieldErrs) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "模板字段定义错误", "field_errors": fieldErrs})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+// 工资计算失败时的响应：模板不存在或已停用、工资数据校验失败、模板字段定义无效或公式计算失败
+func respondPayrollCalculationError(c *gin.Context, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Template not found"})
+		return
+	}
+	if errors.Is(err, errTemplateInactive) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var dataErr *PayrollDataError
+	if errors.As(err, &dataErr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": dataErr.Message, "field_errors": dataErr.Fields})
+		return
+	}
+	respondTemplateSchemaError(c, err)
+}
+
+// 创建和更新模板时校验字段定义
+func validateTemplateFields(c *gin.Context, fields string) bool {
+	if _, err := parseTemplateSchema(fields); err != nil {
+		respondTemplateSchemaError(c, err)
+		return false
+	}
+	return true
+}
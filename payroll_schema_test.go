@@ -0,0 +1,142 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTemplateSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  string
+		wantKey string // 出错的字段，为空表示解析成功
+		wantErr string
+	}{
+		{"valid", `{"basic_salary": {"name": "基本工资", "kind": "earning", "taxable": true, "prorate": true}}`, "", ""},
+		{"unknown kind", `{"bonus": {"name": "奖金", "kind": "income"}}`, "bonus", "kind 必须是"},
+		{"unknown attribute", `{"bonus": {"name": "奖金", "kind": "earning", "taxible": true}}`, "bonus", "字段定义格式错误"},
+		{"text earning", `{"remark": {"name": "备注", "kind": "earning", "type": "text"}}`, "remark", "text 类型只能用于 informational 字段"},
+		{"taxable employer contribution", `{"pension_employer": {"name": "养老单位", "kind": "employer_contribution", "taxable": true}}`, "pension_employer", "只有收入项和扣款项可以设置 taxable"},
+		{"prorated informational", `{"days": {"name": "出勤", "kind": "informational", "prorate": true}}`, "days", "信息项不能按出勤折算"},
+		{"key starting with digit", `{"1st_bonus": {"name": "奖金", "kind": "earning"}}`, "1st_bonus", "字段名只能包含"},
+		{"social insurance not taxable", `{"si": {"name": "社保", "kind": "deduction", "calculator": "social_insurance"}}`, "si", "必须设置 taxable"},
+		{"duplicate calculator", `{"tax": {"name": "个税", "kind": "deduction", "calculator": "income_tax"},
+			"tax2": {"name": "个税2", "kind": "deduction", "calculator": "income_tax"}}`, "tax2", "已用于字段 tax"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTemplateSchema(tt.fields)
+			if tt.wantKey == "" {
+				if err != nil {
+					t.Fatalf("parseTemplateSchema() error: %v", err)
+				}
+				return
+			}
+			errs, ok := err.(FieldErrors)
+			if !ok || !strings.Contains(errs[tt.wantKey], tt.wantErr) {
+				t.Errorf("parseTemplateSchema() error = %v, want %s: %q", err, tt.wantKey, tt.wantErr)
+			}
+		})
+	}
+}
+
+// 字段按 order 排列，order 相同时保持定义顺序
+func TestParseTemplateSchemaOrder(t *testing.T) {
+	schema, err := parseTemplateSchema(`{
+		"pension": {"name": "养老保险", "kind": "deduction", "order": 2},
+		"meal": {"name": "餐补", "kind": "earning", "order": 1},
+		"basic_salary": {"name": "基本工资", "kind": "earning", "order": 1},
+		"remark": {"name": "备注", "kind": "informational", "type": "text"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"remark", "meal", "basic_salary", "pension"}; !reflect.DeepEqual(schema.Keys, want) {
+		t.Errorf("keys = %v, want %v", schema.Keys, want)
+	}
+	if schema.Fields["pension"].Type != "number" {
+		t.Errorf("default type = %q, want number", schema.Fields["pension"].Type)
+	}
+}
+
+// 只有 prorate 字段按出勤折算；taxable 收入计入应税所得，taxable 扣款税前扣除；单位缴纳和信息项不影响实发
+func TestCalculatePayrollFromSchema(t *testing.T) {
+	schema, err := parseTemplateSchema(`{
+		"basic_salary": {"name": "基本工资", "kind": "earning", "taxable": true, "prorate": true},
+		"meal": {"name": "餐补", "kind": "earning"},
+		"bonus": {"name": "奖金", "kind": "earning", "taxable": true},
+		"overtime": {"name": "加班费", "kind": "earning", "taxable": true},
+		"absence": {"name": "缺勤扣款", "kind": "deduction"},
+		"pension": {"name": "养老保险", "kind": "deduction", "taxable": true},
+		"pension_employer": {"name": "养老保险单位部分", "kind": "employer_contribution"},
+		"hours": {"name": "加班小时", "kind": "informational"},
+		"remark": {"name": "备注", "kind": "informational", "type": "text"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]interface{}{
+		"basic_salary":     10000.0,
+		"meal":             600.0,
+		"bonus":            money("1000"),
+		"overtime":         0.0,
+		"absence":          200.0,
+		"pension":          800.0,
+		"pension_employer": 1600.0,
+		"hours":            12.5,
+		"remark":           "九月",
+	}
+
+	tests := []struct {
+		name                 string
+		workDays, monthDays  float64
+		isProrated           bool
+		basic                string
+		gross, original, net string
+		taxable              string
+	}{
+		{"full month", 0, 0, false, "10000", "11600", "11600", "10600", "10200"},
+		{"proration off", 15, 30, false, "10000", "11600", "11600", "10600", "10200"},
+		{"full attendance", 30, 30, true, "10000", "11600", "11600", "10600", "10200"},
+		{"half month", 15, 30, true, "5000", "6600", "11600", "5600", "5200"},
+		{"rounded to fen", 10, 31, true, "3225.81", "4825.81", "11600", "3825.81", "3425.81"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calc := calculatePayroll(schema, data, newProration(tt.isProrated, tt.workDays, tt.monthDays))
+			lines := map[string]PayrollLine{}
+			for _, line := range calc.Lines {
+				lines[line.Key] = line
+			}
+			checks := []struct {
+				name      string
+				got, want string
+			}{
+				{"basic salary", lines["basic_salary"].Amount.String(), tt.basic},
+				{"meal", lines["meal"].Amount.String(), "600"},
+				{"total gross", calc.TotalGross.String(), tt.gross},
+				{"original gross", calc.OriginalGross.String(), tt.original},
+				{"total deductions", calc.TotalDeductions.String(), "1000"},
+				{"total net", calc.TotalNet.String(), tt.net},
+				{"taxable income", calc.TaxableIncome.String(), tt.taxable},
+				{"employer contributions", calc.EmployerContributions.String(), "1600"},
+				{"hours", lines["hours"].Amount.String(), "12.5"},
+				{"remark", lines["remark"].Text, "九月"},
+			}
+			for _, c := range checks {
+				if c.got != c.want {
+					t.Errorf("%s = %s, want %s", c.name, c.got, c.want)
+				}
+			}
+			if _, ok := lines["overtime"]; ok {
+				t.Error("zero amount line included")
+			}
+			prorated := tt.basic != "10000"
+			if basic := lines["basic_salary"]; basic.Prorated != prorated || (basic.FullAmount != nil) != prorated {
+				t.Errorf("basic salary prorated = %v, full amount = %v, want prorated %v", basic.Prorated, basic.FullAmount, prorated)
+			}
+			if lines["meal"].Prorated {
+				t.Error("field without prorate was prorated")
+			}
+		})
+	}
+}
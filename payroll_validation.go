@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 工资计算配置
+type PayrollConfig struct {
+	StrictTemplates bool            `yaml:"strict_templates"`  // 拒绝使用已停用模板创建或更新工资条
+	BaseSalaryField string          `yaml:"base_salary_field"` // 薪酬档案的基本工资带入的模板字段
+	Rounding        PayrollRounding `yaml:"rounding"`
+	IncomeTax       IncomeTaxConfig `yaml:"income_tax"`
+}
+
+// 金额舍入：Line 用于每个工资项目，Total 用于应发、扣款等合计
+type PayrollRounding struct {
+	Line  RoundingPolicy `yaml:"line"`
+	Total RoundingPolicy `yaml:"total"`
+}
+
+var errTemplateInactive = errors.New("工资模板已停用")
+
+// 工资数据错误，Fields 按字段列出原因
+type PayrollDataError struct {
+	Message string
+	Fields  FieldErrors
+}
+
+func (e *PayrollDataError) Error() string {
+	return e.Message + ": " + e.Fields.Error()
+}
+
+// 按模板字段定义校验录入的工资数据：
+// 不允许模板中没有的字段；数字字段必须是数字，收入、扣款和单位缴纳不能为负数；
+// 文本字段必须是字符串；必填字段不能缺失。公式字段和系统计算字段录入的值不校验。
+func validatePayrollData(schema TemplateSchema, data map[string]interface{}) FieldErrors {
+	errs := FieldErrors{}
+	for key, value := range data {
+		field, ok := schema.Fields[key]
+		if !ok {
+			errs[key] = "模板中没有该字段"
+			continue
+		}
+		if field.Formula != "" || field.Calculator != "" || value == nil {
+			continue
+		}
+		if field.Type == "text" {
+			if _, ok := value.(string); !ok {
+				errs[key] = "必须是文本"
+			}
+			continue
+		}
+		amount, ok := decimalValue(value)
+		if !ok {
+			errs[key] = "必须是数字"
+			continue
+		}
+		if amount.IsNegative() && field.Kind != FieldKindInformational {
+			errs[key] = fmt.Sprintf("%s不能为负数", templateFieldKinds[field.Kind])
+		}
+	}
+	for _, key := range schema.Keys {
+		if !schema.Fields[key].Required || errs[key] != "" {
+			continue
+		}
+		if value, ok := data[key]; !ok || value == nil || value == "" {
+			errs[key] = "必填字段缺失"
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestValidatePayrollData(t *testing.T) {
+	schema, err := parseTemplateSchema(`{
+		"basic_salary": {"name": "基本工资", "kind": "earning", "taxable": true, "required": true},
+		"absence": {"name": "缺勤扣款", "kind": "deduction"},
+		"adjustment": {"name": "调整", "kind": "informational"},
+		"remark": {"name": "备注", "kind": "informational", "type": "text"},
+		"overtime_pay": {"name": "加班费", "kind": "earning", "formula": "basic_salary / 174 * 1.5"},
+		"income_tax": {"name": "个税", "kind": "deduction", "calculator": "income_tax"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		data map[string]interface{}
+		want FieldErrors
+	}{
+		{"valid", map[string]interface{}{"basic_salary": 8000.0, "absence": 100.0, "remark": "九月"}, nil},
+		{"unknown field", map[string]interface{}{"basic_salary": 8000.0, "bonus": 500.0}, FieldErrors{"bonus": "模板中没有该字段"}},
+		{"missing required", map[string]interface{}{"absence": 100.0}, FieldErrors{"basic_salary": "必填字段缺失"}},
+		{"null required", map[string]interface{}{"basic_salary": nil}, FieldErrors{"basic_salary": "必填字段缺失"}},
+		{"number as string", map[string]interface{}{"basic_salary": "8000"}, FieldErrors{"basic_salary": "必须是数字"}},
+		{"text as number", map[string]interface{}{"basic_salary": 8000.0, "remark": 9.0}, FieldErrors{"remark": "必须是文本"}},
+		{"negative deduction", map[string]interface{}{"basic_salary": 8000.0, "absence": -100.0}, FieldErrors{"absence": "扣款不能为负数"}},
+		{"negative informational", map[string]interface{}{"basic_salary": 8000.0, "adjustment": -100.0}, nil},
+		{"computed fields ignored", map[string]interface{}{"basic_salary": 8000.0, "overtime_pay": "x", "income_tax": -1.0}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validatePayrollData(schema, tt.data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validatePayrollData() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// 严格模式下拒绝停用模板；工资数据按模板字段逐个返回错误
+func TestCreatePayrollTemplateValidation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	saved := appConfig.Payroll.StrictTemplates
+	t.Cleanup(func() { appConfig.Payroll.StrictTemplates = saved })
+	const fields = `{"basic_salary": {"name": "基本工资", "kind": "earning", "taxable": true, "required": true}}`
+
+	tests := []struct {
+		name       string
+		strict     bool
+		active     bool
+		data       string
+		status     int
+		wantError  string
+		wantFields FieldErrors
+	}{
+		{"active template", true, true, `{"basic_salary": 8000}`, http.StatusCreated, "", nil},
+		{"inactive template in strict mode", true, false, `{"basic_salary": 8000}`, http.StatusBadRequest, errTemplateInactive.Error(), nil},
+		{"inactive template in lenient mode", false, false, `{"basic_salary": 8000}`, http.StatusCreated, "", nil},
+		{"unknown field", true, true, `{"basic_salary": 8000, "bonus": 500}`, http.StatusBadRequest, "工资数据校验失败",
+			FieldErrors{"bonus": "模板中没有该字段"}},
+		{"missing required field", true, true, `{}`, http.StatusBadRequest, "工资数据校验失败",
+			FieldErrors{"basic_salary": "必填字段缺失"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			appConfig.Payroll.StrictTemplates = tt.strict
+			employee := createTaxTestEmployee(t, "2020-01-01")
+			template := PayrollTemplate{Name: "标准", Fields: fields, IsActive: true}
+			if err := db.Create(&template).Error; err != nil {
+				t.Fatal(err)
+			}
+			if !tt.active {
+				db.Model(&template).Update("is_active", false)
+			}
+
+			body := fmt.Sprintf(`{"employee_id": %d, "period": "2024-09", "template_id": %d, "payroll_data": %s}`, employee.ID, template.ID, tt.data)
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/payrolls", strings.NewReader(body))
+			c.Request.Header.Set("Content-Type", "application/json")
+			c.Set("user_id", uint(1))
+			c.Set("username", "admin")
+			createPayroll(c)
+
+			var resp struct {
+				Error       string      `json:"error"`
+				FieldErrors FieldErrors `json:"field_errors"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			if w.Code != tt.status || resp.Error != tt.wantError || !reflect.DeepEqual(resp.FieldErrors, tt.wantFields) {
+				t.Errorf("status = %d, body = %s, want %d %q %v", w.Code, w.Body, tt.status, tt.wantError, tt.wantFields)
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 工资条访问链接：每个员工每个工资条单独签发，有效期和使用次数受限，可吊销，令牌只保存哈希
+type PayslipAccessLink struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	PayrollID  uint       `json:"payroll_id" gorm:"index"`
+	EmployeeID uint       `json:"employee_id" gorm:"index"`
+	TokenHash  string     `json:"-" gorm:"uniqueIndex;size:64"`
+	MaxUses    int        `json:"max_uses"`
+	UseCount   int        `json:"use_count"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	RevokedBy  uint       `json:"revoked_by"`
+	CreatedBy  uint       `json:"created_by"` // 签发的管理员，0 表示系统
+	CreatedAt  time.Time  `json:"created_at"`
+	Status     string     `json:"status" gorm:"-"` // active, expired, exhausted, revoked
+}
+
+// 签发访问链接请求
+type IssuePayslipLinkRequest struct {
+	MaxUses        int   `json:"max_uses"`        // 为0时使用配置的默认值
+	TTLHours       int   `json:"ttl_hours"`       // 为0时使用配置的默认有效期
+	RevokeExisting *bool `json:"revoke_existing"` // 默认吊销该工资条之前签发的链接
+}
+
+type ExchangePayslipLinkRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// 链接当前状态
+func (l *PayslipAccessLink) currentStatus() string {
+	switch {
+	case l.RevokedAt != nil:
+		return "revoked"
+	case !l.ExpiresAt.After(time.Now()):
+		return "expired"
+	case l.UseCount >= l.MaxUses:
+		return "exhausted"
+	default:
+		return "active"
+	}
+}
+
+// 员工访问链接的地址
+func payslipLinkURL(payroll Payroll, token string) string {
+	return fmt.Sprintf("%s/web/employee.html?payroll=%s&token=%s",
+		appConfig.Server.PublicURL, url.QueryEscape(payroll.UUID), url.QueryEscape(token))
+}
+
+// 签发访问链接，revokeExisting 为 true 时同时吊销该工资条之前的链接
+func issuePayslipLink(tx *gorm.DB, payroll Payroll, createdBy uint, maxUses int, ttl time.Duration, revokeExisting bool) (PayslipAccessLink, string, error) {
+	if maxUses <= 0 {
+		maxUses = appConfig.Auth.PayslipLinkMaxUses
+	}
+	if ttl <= 0 {
+		ttl = appConfig.Auth.PayslipLinkTTL
+	}
+
+	if revokeExisting {
+		now := time.Now()
+		if err := tx.Model(&PayslipAccessLink{}).
+			Where("payroll_id = ? AND revoked_at IS NULL", payroll.ID).
+			Updates(map[string]interface{}{"revoked_at": &now, "revoked_by": createdBy}).Error; err != nil {
+			return PayslipAccessLink{}, "", err
+		}
+	}
+
+	token := generateSecureToken()
+	link := PayslipAccessLink{
+		PayrollID:  payroll.ID,
+		EmployeeID: payroll.EmployeeID,
+		TokenHash:  hashResetToken(token),
+		MaxUses:    maxUses,
+		ExpiresAt:  time.Now().Add(ttl),
+		CreatedBy:  createdBy,
+	}
+	if err := tx.Create(&link).Error; err != nil {
+		return PayslipAccessLink{}, "", err
+	}
+	link.Status = link.currentStatus()
+	return link, token, nil
+}
+
+// 访问链接是否已被吊销，吊销后通过该链接登录的令牌立即失效
+func isPayslipLinkRevoked(linkID uint) bool {
+	var link PayslipAccessLink
+	if err := db.Select("id", "revoked_at").First(&link, linkID).Error; err != nil {
+		return true
+	}
+	return link.RevokedAt != nil
+}
+
+// 使用访问链接换取只能查看该工资条的员工令牌，每次换取计一次使用
+func exchangePayslipLink(c *gin.Context) {
+	var req ExchangePayslipLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+
+	var link PayslipAccessLink
+	if err := db.Where("token_hash = ?", hashResetToken(req.Token)).First(&link).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "访问链接无效"})
+		return
+	}
+
+	now := time.Now()
+	result := db.Model(&PayslipAccessLink{}).
+		Where("id = ? AND revoked_at IS NULL AND expires_at > ? AND use_count < max_uses", link.ID, now).
+		Updates(map[string]interface{}{
+			"use_count":    gorm.Expr("use_count + 1"),
+			"last_used_at": &now,
+			"last_used_ip": c.ClientIP(),
+		})
+	if result.Error != nil || result.RowsAffected != 1 {
+		status := link.currentStatus()
+		logSecurityEvent("payslip_link_rejected", map[string]interface{}{
+			"link_id":    link.ID,
+			"payroll_id": link.PayrollID,
+			"status":     status,
+			"ip":         c.ClientIP(),
+		})
+		c.JSON(http.StatusGone, gin.H{
+			"error":  "访问链接已失效，请登录员工门户查看或联系人事重新发送",
+			"code":   "link_" + status,
+			"status": status,
+		})
+		return
+	}
+
+	var payroll Payroll
+	var employee Employee
+	if err := db.Where("id = ? AND status IN ?", link.PayrollID, []string{"published", "signed"}).First(&payroll).Error; err != nil ||
+		db.Where("id = ? AND portal_enabled = ? AND deleted_at IS NULL", link.EmployeeID, true).First(&employee).Error != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "访问链接无效"})
+		return
+	}
+
+	token, expiresAt, err := generateEmployeeToken(employee, &link)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成token失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":          token,
+		"expires_at":     expiresAt,
+		"employee":       employee,
+		"payroll_id":     payroll.UUID,
+		"remaining_uses": link.MaxUses - link.UseCount - 1,
+	})
+}
+
+// 查找管理员有权访问的工资条
+func findAccessiblePayroll(c *gin.Context) (Payroll, bool) {
+	var payroll Payroll
+	if err := db.Where("uuid = ?", c.Param("id")).First(&payroll).Error; err != nil || !canAccessEmployee(c, payroll.EmployeeID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payroll not found"})
+		return Payroll{}, false
+	}
+	return payroll, true
+}
+
+// 查看工资条的访问链接
+func getPayslipLinks(c *gin.Context) {
+	payroll, ok := findAccessiblePayroll(c)
+	if !ok {
+		return
+	}
+
+	var links []PayslipAccessLink
+	if err := db.Where("payroll_id = ?", payroll.ID).Order("created_at DESC").Find(&links).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range links {
+		links[i].Status = links[i].currentStatus()
+	}
+	c.JSON(http.StatusOK, gin.H{"data": links})
+}
+
+// 签发新的访问链接，默认吊销之前的链接
+func createPayslipLink(c *gin.Context) {
+	payroll, ok := findAccessiblePayroll(c)
+	if !ok {
+		return
+	}
+	if payroll.Status == "draft" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "工资条尚未发布"})
+		return
+	}
+
+	var req IssuePayslipLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.MaxUses < 0 || req.TTLHours < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_uses 和 ttl_hours 不能为负数"})
+		return
+	}
+	revokeExisting := req.RevokeExisting == nil || *req.RevokeExisting
+
+	var link PayslipAccessLink
+	var token string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		link, token, err = issuePayslipLink(tx, payroll, c.GetUint("user_id"), req.MaxUses, time.Duration(req.TTLHours)*time.Hour, revokeExisting)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成访问链接失败"})
+		return
+	}
+	recordAudit(c, "payslip_link.create", "payslip_link", link.ID, nil, link)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "访问链接生成成功",
+		"url":     payslipLinkURL(payroll, token),
+		"data":    link,
+	})
+}
+
+// 吊销访问链接
+func revokePayslipLink(c *gin.Context) {
+	var link PayslipAccessLink
+	if err := db.First(&link, c.Param("id")).Error; err != nil || !canAccessEmployee(c, link.EmployeeID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "访问链接不存在"})
+		return
+	}
+
+	if link.RevokedAt == nil {
+		before := link
+		now := time.Now()
+		if err := db.Model(&link).Updates(map[string]interface{}{
+			"revoked_at": &now,
+			"revoked_by": c.GetUint("user_id"),
+		}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "吊销访问链接失败"})
+			return
+		}
+		link.RevokedAt = &now
+		link.RevokedBy = c.GetUint("user_id")
+		recordAudit(c, "payslip_link.revoke", "payslip_link", link.ID, before, link)
+	}
+
+	link.Status = link.currentStatus()
+	c.JSON(http.StatusOK, gin.H{"message": "访问链接已吊销", "data": link})
+}
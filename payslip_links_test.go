@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func createLinkTestPayroll(t *testing.T, employee Employee, period string) Payroll {
+	t.Helper()
+	payroll := Payroll{UUID: generateUUID(), EmployeeID: employee.ID, Period: period, PayrollData: "{}", Status: "published"}
+	if err := db.Create(&payroll).Error; err != nil {
+		t.Fatal(err)
+	}
+	return payroll
+}
+
+func exchangeTestLink(t *testing.T, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/payslip-links/exchange", strings.NewReader(`{"token": "`+token+`"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	exchangePayslipLink(c)
+	return w
+}
+
+func revokeTestLink(t *testing.T, link PayslipAccessLink) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodDelete, "/api/v1/payslip-links/"+strconv.Itoa(int(link.ID)), nil)
+	c.Params = gin.Params{{Key: "id", Value: strconv.Itoa(int(link.ID))}}
+	c.Set("user_id", uint(1))
+	c.Set("username", "admin")
+	revokePayslipLink(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("revoke status = %d, body = %s", w.Code, w.Body)
+	}
+}
+
+// 链接过期、用完次数或被吊销后不能再换取令牌
+func TestExchangePayslipLink(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	type attempt struct {
+		status int
+		code   string
+	}
+	tests := []struct {
+		name     string
+		maxUses  int
+		prepare  func(t *testing.T, link PayslipAccessLink)
+		attempts []attempt
+	}{
+		{
+			name:     "within max uses",
+			maxUses:  2,
+			attempts: []attempt{{http.StatusOK, ""}, {http.StatusOK, ""}, {http.StatusGone, "link_exhausted"}},
+		},
+		{
+			name:    "expired",
+			maxUses: 2,
+			prepare: func(t *testing.T, link PayslipAccessLink) {
+				db.Model(&link).Update("expires_at", time.Now().Add(-time.Minute))
+			},
+			attempts: []attempt{{http.StatusGone, "link_expired"}},
+		},
+		{
+			name:     "revoked",
+			maxUses:  2,
+			prepare:  revokeTestLink,
+			attempts: []attempt{{http.StatusGone, "link_revoked"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			employee := createPortalEmployee(t, "E001", "zhangsan@example.com")
+			payroll := createLinkTestPayroll(t, employee, "2024-09")
+			link, token, err := issuePayslipLink(db, payroll, 1, tt.maxUses, time.Hour, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.prepare != nil {
+				tt.prepare(t, link)
+			}
+			for i, a := range tt.attempts {
+				w := exchangeTestLink(t, token)
+				var resp struct {
+					Code          string `json:"code"`
+					RemainingUses int    `json:"remaining_uses"`
+				}
+				json.Unmarshal(w.Body.Bytes(), &resp)
+				if w.Code != a.status || resp.Code != a.code {
+					t.Errorf("attempt %d: status = %d, code = %q, want %d, %q", i+1, w.Code, resp.Code, a.status, a.code)
+				}
+				if w.Code == http.StatusOK && resp.RemainingUses != tt.maxUses-i-1 {
+					t.Errorf("attempt %d: remaining uses = %d, want %d", i+1, resp.RemainingUses, tt.maxUses-i-1)
+				}
+			}
+		})
+	}
+
+	t.Run("unknown token", func(t *testing.T) {
+		setupTestDB(t)
+		if w := exchangeTestLink(t, "unknown"); w.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want 401", w.Code)
+		}
+	})
+}
+
+// 链接换取的令牌只能查看该工资条；吊销链接后令牌立即失效，重新签发时旧链接被吊销
+func TestPayslipLinkToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupTestDB(t)
+	employee := createPortalEmployee(t, "E001", "zhangsan@example.com")
+	september := createLinkTestPayroll(t, employee, "2024-09")
+	october := createLinkTestPayroll(t, employee, "2024-10")
+	link, token, err := issuePayslipLink(db, september, 1, 0, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.MaxUses != appConfig.Auth.PayslipLinkMaxUses || link.ExpiresAt.After(time.Now().Add(appConfig.Auth.PayslipLinkTTL)) {
+		t.Errorf("defaults: max uses = %d, expires at = %v", link.MaxUses, link.ExpiresAt)
+	}
+
+	w := exchangeTestLink(t, token)
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("exchange status = %d, body = %s", w.Code, w.Body)
+	}
+	claims, err := verifyEmployeeToken(resp.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	setEmployeeContext(c, claims)
+	if !canViewPayroll(c, september) || canViewPayroll(c, october) {
+		t.Error("link token must only view the linked payroll")
+	}
+
+	if _, _, err := issuePayslipLink(db, september, 1, 0, 0, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifyEmployeeToken(resp.Token); err == nil {
+		t.Error("token from a link revoked by reissue is still valid")
+	}
+	if w := exchangeTestLink(t, token); w.Code != http.StatusGone {
+		t.Errorf("exchange after reissue: status = %d, want 410", w.Code)
+	}
+}
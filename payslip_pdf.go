@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var payrollStatusNames = map[string]string{
+	"draft":     "草稿",
+	"published": "已发布",
+	"signed":    "已签收",
+}
+
+// 生成工资条PDF：员工信息、收入和扣款明细、合计以及员工签收的签名图片
+func renderPayslipPDF(payroll Payroll, signature *PayrollSignature) ([]byte, error) {
+	pdf, err := newPDFDocument(fmt.Sprintf("%s 工资条 %s", payroll.Period, payroll.Employee.Name))
+	if err != nil {
+		return nil, err
+	}
+
+	pdfHeading(pdf, fmt.Sprintf("%s 工资条", payroll.Period))
+
+	pdfSection(pdf, "员工信息")
+	status := payrollStatusNames[payroll.Status]
+	if status == "" {
+		status = payroll.Status
+	}
+	info := [][2]string{
+		{"姓名", payroll.Employee.Name},
+		{"工号", payroll.Employee.EmployeeNo},
+		{"部门", payroll.Employee.Department},
+		{"职位", payroll.Employee.Position},
+		{"工资期间", payroll.Period},
+		{"状态", status},
+		{"发布时间", formatPDFTime(payroll.PublishedAt)},
+		{"工资模板", payroll.Template.Name},
+	}
+	if payroll.IsProrated {
+		info = append(info, [2]string{"出勤天数", fmt.Sprintf("%g / %g", payroll.WorkDays, payroll.MonthDays)})
+	}
+	pdfInfoGrid(pdf, info)
+
+	sections := payslipLines(payroll, payroll.Template)
+
+	pdfSection(pdf, "收入项目")
+	pdfLineTable(pdf, sections.Earnings, "应发合计", payroll.TotalGross)
+
+	pdfSection(pdf, "扣款项目")
+	pdfLineTable(pdf, sections.Deductions, "扣款合计", newMoney(payroll.TotalGross.Sub(payroll.TotalNet.Decimal)))
+
+	pdf.Ln(2)
+	pdf.SetFont(pdfFontFamily, "", 14)
+	pdf.SetFillColor(232, 245, 233)
+	pdf.CellFormat(0, 11, fmt.Sprintf("实发工资：¥ %s", formatMoney(payroll.TotalNet)), "", 1, "R", true, 0, "")
+
+	if len(sections.EmployerContributions) > 0 {
+		var employerTotal Money
+		for _, line := range sections.EmployerContributions {
+			employerTotal = newMoney(employerTotal.Add(line.Amount.Decimal))
+		}
+		pdfSection(pdf, "单位缴纳（不计入实发）")
+		pdfLineTable(pdf, sections.EmployerContributions, "单位缴纳合计", employerTotal)
+	}
+	if len(sections.Informational) > 0 {
+		pdfSection(pdf, "其他信息")
+		pdfInfoGrid(pdf, sections.Informational)
+	}
+
+	pdfSection(pdf, "员工签收")
+	pdf.SetFont(pdfFontFamily, "", 10)
+	if signature == nil {
+		pdf.SetTextColor(140, 140, 140)
+		pdf.CellFormat(0, 8, "尚未签收", "", 1, "L", false, 0, "")
+		pdf.SetTextColor(0, 0, 0)
+	} else {
+		x, y := pdf.GetXY()
+		if err := pdfImage(pdf, fmt.Sprintf("signature-%d", signature.ID), loadSignatureImageFile(signature.SignatureData), x, y, 60, 28); err != nil {
+			log.Printf("Failed to embed signature image for payroll %s: %v", payroll.UUID, err)
+			pdf.CellFormat(60, 8, "签名图片无法显示", "1", 0, "C", false, 0, "")
+		}
+		pdf.SetXY(x+70, y)
+		pdf.CellFormat(0, 7, "签收时间："+formatPDFTime(&signature.SignedAt), "", 2, "L", false, 0, "")
+		pdf.CellFormat(0, 7, "签收IP："+signature.IPAddress, "", 2, "L", false, 0, "")
+		if signature.DeviceInfo != "" {
+			pdf.CellFormat(0, 7, "设备："+signature.DeviceInfo, "", 2, "L", false, 0, "")
+		}
+		pdf.SetFont(pdfFontFamily, "", 7)
+		pdf.MultiCell(0, 4, "签名哈希："+signature.SignatureHash, "", "L", false)
+		pdf.SetY(y + 32)
+	}
+
+	// 页脚：工资条编号和生成时间
+	pdf.SetY(-26)
+	pdf.SetFont(pdfFontFamily, "", 8)
+	pdf.SetTextColor(140, 140, 140)
+	now := time.Now()
+	pdf.CellFormat(0, 5, fmt.Sprintf("工资条编号：%s    生成时间：%s", payroll.UUID, formatPDFTime(&now)), "", 1, "C", false, 0, "")
+	pdf.CellFormat(0, 5, "本工资条由系统生成，签收记录可通过签名核验接口校验", "", 1, "C", false, 0, "")
+
+	return pdfOutput(pdf)
+}
+
+// 下载工资条PDF
+func getPayrollPDF(c *gin.Context) {
+	var payroll Payroll
+	if err := db.Preload("Employee").Preload("Template").Where("uuid = ?", c.Param("id")).First(&payroll).Error; err != nil || !canViewPayroll(c, payroll) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payroll not found"})
+		return
+	}
+
+	var signature *PayrollSignature
+	var sig PayrollSignature
+	if err := db.Where("payroll_id = ?", payroll.ID).Order("id DESC").First(&sig).Error; err == nil {
+		signature = &sig
+	}
+
+	data, err := renderPayslipPDF(payroll, signature)
+	if errors.Is(err, errPDFFontUnavailable) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to render payslip PDF %s: %v", payroll.UUID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成PDF失败"})
+		return
+	}
+
+	filename := fmt.Sprintf("payslip-%s-%s.pdf", payroll.Period, payroll.Employee.EmployeeNo)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Data(http.StatusOK, "application/pdf", data)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// PDF配置
+type PDFConfig struct {
+	FontFile string `yaml:"font_file"` // 含中文字形的TrueType字体（.ttf），留空时查找常见系统字体
+}
+
+const pdfFontFamily = "cjk"
+
+// 未配置字体时依次查找的常见中文TrueType字体
+var pdfFontCandidates = []string{
+	"./fonts/NotoSansSC-Regular.ttf",
+	"/usr/share/fonts/truetype/noto/NotoSansSC-Regular.ttf",
+	"/usr/share/fonts/truetype/droid/DroidSansFallbackFull.ttf",
+	"/usr/share/fonts/truetype/arphic-gkai00mp/gkai00mp.ttf",
+	"C:/Windows/Fonts/simhei.ttf",
+	"/Library/Fonts/Arial Unicode.ttf",
+}
+
+var errPDFFontUnavailable = errors.New("未配置PDF中文字体，请设置 pdf.font_file")
+
+var (
+	pdfFontOnce sync.Once
+	pdfFontData []byte
+	pdfFontErr  error
+)
+
+// 读取中文字体，只读取一次；PDF中只嵌入用到的字形
+func loadPDFFont() ([]byte, error) {
+	pdfFontOnce.Do(func() {
+		candidates := pdfFontCandidates
+		if appConfig.PDF.FontFile != "" {
+			candidates = []string{appConfig.PDF.FontFile}
+		}
+		for _, path := range candidates {
+			data, err := os.ReadFile(path)
+			if err == nil {
+				pdfFontData = data
+				return
+			}
+			if appConfig.PDF.FontFile != "" {
+				log.Printf("Failed to read PDF font %s: %v", path, err)
+			}
+		}
+		pdfFontErr = errPDFFontUnavailable
+	})
+	return pdfFontData, pdfFontErr
+}
+
+// 创建A4文档并加载中文字体
+func newPDFDocument(title string) (*fpdf.Fpdf, error) {
+	font, err := loadPDFFont()
+	if err != nil {
+		return nil, err
+	}
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle(title, true)
+	pdf.SetCreator("Payroll System", true)
+	pdf.AddUTF8FontFromBytes(pdfFontFamily, "", font)
+	if err := pdf.Error(); err != nil {
+		return nil, fmt.Errorf("load PDF font: %w", err)
+	}
+	pdf.SetMargins(18, 18, 18)
+	pdf.SetAutoPageBreak(true, 18)
+	pdf.AddPage()
+	return pdf, nil
+}
+
+func pdfOutput(pdf *fpdf.Fpdf) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 标题
+func pdfHeading(pdf *fpdf.Fpdf, text string) {
+	pdf.SetFont(pdfFontFamily, "", 18)
+	pdf.CellFormat(0, 12, text, "", 1, "C", false, 0, "")
+	pdf.Ln(2)
+}
+
+// 小节标题
+func pdfSection(pdf *fpdf.Fpdf, text string) {
+	pdf.Ln(3)
+	pdf.SetFont(pdfFontFamily, "", 12)
+	pdf.SetFillColor(238, 242, 247)
+	pdf.CellFormat(0, 8, text, "", 1, "L", true, 0, "")
+	pdf.Ln(1)
+}
+
+// 两列键值表，每行放两组
+func pdfInfoGrid(pdf *fpdf.Fpdf, pairs [][2]string) {
+	pdf.SetFont(pdfFontFamily, "", 10)
+	width, _ := pdf.GetPageSize()
+	left, _, right, _ := pdf.GetMargins()
+	col := (width - left - right) / 4
+	for i, pair := range pairs {
+		pdf.SetTextColor(110, 110, 110)
+		pdf.CellFormat(col*0.7, 7, pair[0], "", 0, "L", false, 0, "")
+		pdf.SetTextColor(0, 0, 0)
+		ln := 0
+		if i%2 == 1 || i == len(pairs)-1 {
+			ln = 1
+		}
+		pdf.CellFormat(col*1.3, 7, pair[1], "", ln, "L", false, 0, "")
+	}
+}
+
+// 嵌入签名图片，按比例缩放到 maxW×maxH 以内，图片无法识别时返回错误
+func pdfImage(pdf *fpdf.Fpdf, name string, data []byte, x, y, maxW, maxH float64) error {
+	if len(data) == 0 {
+		return errors.New("empty image")
+	}
+	imageType := "PNG"
+	if bytes.HasPrefix(data, []byte{0xFF, 0xD8}) {
+		imageType = "JPG"
+	}
+	opts := fpdf.ImageOptions{ImageType: imageType}
+	info := pdf.RegisterImageOptionsReader(name, opts, bytes.NewReader(data))
+	if err := pdf.Error(); err != nil || info == nil {
+		pdf.ClearError()
+		return fmt.Errorf("unsupported image: %v", err)
+	}
+	w, h := maxW, maxW*info.Height()/info.Width()
+	if h > maxH {
+		w, h = maxH*info.Width()/info.Height(), maxH
+	}
+	pdf.ImageOptions(name, x, y, w, h, false, opts, 0, "")
+	return nil
+}
+
+func formatPDFTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+func formatPDFDate(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006年01月02日")
+}
+
+func orDash(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return "-"
+	}
+	return s
+}
+
+// 多行正文，自动换行
+func pdfParagraph(pdf *fpdf.Fpdf, text string) {
+	pdf.SetFont(pdfFontFamily, "", 10)
+	pdf.SetTextColor(0, 0, 0)
+	pdf.MultiCell(0, 6, orDash(text), "", "L", false)
+}
+
+// 工资条明细行
+type payslipLine struct {
+	Name   string
+	Amount Money
+	Note   string
+}
+
+// 工资条按项目类型分组的明细
+type payslipSections struct {
+	Earnings              []payslipLine
+	Deductions            []payslipLine
+	EmployerContributions []payslipLine
+	Informational         [][2]string
+}
+
+// 按工资条保存的明细行拆分收入、扣款、单位缴纳和信息项，顺序与模板定义一致
+func payslipLines(payroll Payroll, template PayrollTemplate) payslipSections {
+	var sections payslipSections
+	for _, l := range payrollBreakdown(payroll, template) {
+		line := payslipLine{Name: l.Name, Amount: l.Amount}
+		if l.Prorated && l.FullAmount != nil {
+			line.Note = fmt.Sprintf("按出勤 %g/%g 天折算，全月 %s", payroll.WorkDays, payroll.MonthDays, formatMoney(*l.FullAmount))
+		} else if l.Formula != "" {
+			line.Note = "= " + l.Formula
+		} else if l.Note != "" {
+			line.Note = l.Note
+		}
+		switch l.Kind {
+		case FieldKindEarning:
+			sections.Earnings = append(sections.Earnings, line)
+		case FieldKindDeduction:
+			sections.Deductions = append(sections.Deductions, line)
+		case FieldKindEmployerContribution:
+			sections.EmployerContributions = append(sections.EmployerContributions, line)
+		case FieldKindInformational:
+			value := l.Text
+			if value == "" {
+				value = l.Amount.String()
+			}
+			sections.Informational = append(sections.Informational, [2]string{l.Name, value})
+		}
+	}
+	return sections
+}
+
+// 明细表格
+func pdfLineTable(pdf *fpdf.Fpdf, lines []payslipLine, totalLabel string, total Money) {
+	width, _ := pdf.GetPageSize()
+	left, _, right, _ := pdf.GetMargins()
+	full := width - left - right
+	amountWidth := 40.0
+
+	pdf.SetFont(pdfFontFamily, "", 10)
+	pdf.SetDrawColor(210, 214, 220)
+	if len(lines) == 0 {
+		pdf.SetTextColor(140, 140, 140)
+		pdf.CellFormat(full, 7, "无", "B", 1, "L", false, 0, "")
+		pdf.SetTextColor(0, 0, 0)
+	}
+	for _, line := range lines {
+		name := line.Name
+		if line.Note != "" {
+			name += "（" + line.Note + "）"
+		}
+		pdf.CellFormat(full-amountWidth, 7, name, "B", 0, "L", false, 0, "")
+		pdf.CellFormat(amountWidth, 7, formatMoney(line.Amount), "B", 1, "R", false, 0, "")
+	}
+	pdf.CellFormat(full-amountWidth, 8, totalLabel, "", 0, "R", false, 0, "")
+	pdf.CellFormat(amountWidth, 8, formatMoney(total), "", 1, "R", false, 0, "")
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 管理员角色
+const (
+	RoleAdmin   = "admin"   // 系统管理员，拥有全部权限
+	RoleHR      = "hr"      // 人事：员工档案、离职流程
+	RoleFinance = "finance" // 财务：工资模板、工资条发布
+	RoleManager = "manager" // 部门主管：查看本部门员工，审批本部门离职
+	RoleAuditor = "auditor" // 审计：只读
+)
+
+// 权限
+const (
+	PermEmployeesRead             = "employees:read"
+	PermEmployeesWrite            = "employees:write"
+	PermTemplatesRead             = "templates:read"
+	PermTemplatesWrite            = "templates:write"
+	PermPayrollsRead              = "payrolls:read"
+	PermPayrollsWrite             = "payrolls:write"
+	PermPayrollsPublish           = "payrolls:publish"
+	PermNotificationsRead         = "notifications:read"
+	PermNotificationsWrite        = "notifications:write"
+	PermResignationsRead          = "resignations:read"
+	PermResignationsWrite         = "resignations:write"
+	PermResignationsApprove       = "resignations:approve"
+	PermResignationReportsRead    = "resignation_reports:read"
+	PermResignationReportsWrite   = "resignation_reports:write"
+	PermAdminUsersRead            = "admin_users:read"
+	PermAdminUsersWrite           = "admin_users:write"
+	PermAuditLogsRead             = "audit_logs:read"
+	PermDocumentTemplatesRead     = "document_templates:read"
+	PermDocumentTemplatesWrite    = "document_templates:write"
+	PermContributionPoliciesRead  = "contribution_policies:read"
+	PermContributionPoliciesWrite = "contribution_policies:write"
+	PermCompensationRead          = "compensation:read"
+	PermCompensationWrite         = "compensation:write"
+)
+
+var allPermissions = []string{
+	PermEmployeesRead, PermEmployeesWrite,
+	PermTemplatesRead, PermTemplatesWrite,
+	PermPayrollsRead, PermPayrollsWrite, PermPayrollsPublish,
+	PermNotificationsRead, PermNotificationsWrite,
+	PermResignationsRead, PermResignationsWrite, PermResignationsApprove,
+	PermResignationReportsRead, PermResignationReportsWrite,
+	PermAdminUsersRead, PermAdminUsersWrite,
+	PermAuditLogsRead,
+	PermDocumentTemplatesRead, PermDocumentTemplatesWrite,
+	PermContributionPoliciesRead, PermContributionPoliciesWrite,
+	PermCompensationRead, PermCompensationWrite,
+}
+
+// 角色对应的权限
+var rolePermissions = map[string][]string{
+	RoleAdmin: allPermissions,
+	RoleHR: {
+		PermEmployeesRead, PermEmployeesWrite,
+		PermTemplatesRead,
+		PermPayrollsRead,
+		PermNotificationsRead,
+		PermResignationsRead, PermResignationsWrite, PermResignationsApprove,
+		PermResignationReportsRead, PermResignationReportsWrite,
+		PermDocumentTemplatesRead,
+		PermContributionPoliciesRead,
+		PermCompensationRead, PermCompensationWrite,
+	},
+	RoleFinance: {
+		PermEmployeesRead,
+		PermTemplatesRead, PermTemplatesWrite,
+		PermPayrollsRead, PermPayrollsWrite, PermPayrollsPublish,
+		PermNotificationsRead, PermNotificationsWrite,
+		PermContributionPoliciesRead, PermContributionPoliciesWrite,
+		PermCompensationRead,
+	},
+	RoleManager: {
+		PermEmployeesRead,
+		PermResignationsRead, PermResignationsApprove,
+		PermResignationReportsRead,
+	},
+	RoleAuditor: readOnlyPermissions(),
+}
+
+func readOnlyPermissions() []string {
+	var perms []string
+	for _, p := range allPermissions {
+		if strings.HasSuffix(p, ":read") {
+			perms = append(perms, p)
+		}
+	}
+	return perms
+}
+
+func isValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func isValidPermission(perm string) bool {
+	for _, p := range allPermissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// 管理员的有效权限：角色权限加上单独授予的权限
+func (u AdminUser) EffectivePermissions() []string {
+	set := map[string]bool{}
+	for _, p := range rolePermissions[u.Role] {
+		set[p] = true
+	}
+	for _, p := range strings.Split(u.Permissions, ",") {
+		if p = strings.TrimSpace(p); isValidPermission(p) {
+			set[p] = true
+		}
+	}
+	perms := make([]string, 0, len(set))
+	for p := range set {
+		perms = append(perms, p)
+	}
+	sort.Strings(perms)
+	return perms
+}
+
+// 当前请求的用户是否拥有某个权限
+func hasPermission(c *gin.Context, perm string) bool {
+	perms, _ := c.Get("permissions")
+	list, _ := perms.([]string)
+	for _, p := range list {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// 权限校验中间件，需放在 authMiddleware 之后
+func requirePermission(perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !hasPermission(c, perm) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "权限不足", "required_permission": perm})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// 部门主管只能访问本部门数据，scoped 为 true 时需要按 department 过滤
+func departmentScope(c *gin.Context) (department string, scoped bool) {
+	if c.GetString("role") != RoleManager {
+		return "", false
+	}
+	return c.GetString("department"), true
+}
+
+// 当前用户是否可以访问某个员工的数据
+func canAccessEmployee(c *gin.Context, employeeID uint) bool {
+	department, scoped := departmentScope(c)
+	if !scoped {
+		return true
+	}
+	var count int64
+	db.Model(&Employee{}).Where("id = ? AND department = ?", employeeID, department).Count(&count)
+	return count > 0
+}
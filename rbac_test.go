@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestEffectivePermissions(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    AdminUser
+		has     []string
+		hasNot  []string
+		allPerm bool
+	}{
+		{"admin", AdminUser{Role: RoleAdmin}, nil, nil, true},
+		{"auditor is read only", AdminUser{Role: RoleAuditor}, []string{PermPayrollsRead, PermAuditLogsRead}, []string{PermPayrollsWrite, PermAdminUsersWrite}, false},
+		{"finance", AdminUser{Role: RoleFinance}, []string{PermPayrollsPublish}, []string{PermEmployeesWrite, PermResignationsApprove}, false},
+		{"extra permission", AdminUser{Role: RoleManager, Permissions: " payrolls:read , bogus:perm"}, []string{PermPayrollsRead, PermResignationsApprove}, []string{"bogus:perm"}, false},
+		{"unknown role", AdminUser{Role: "intern"}, nil, []string{PermEmployeesRead}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			perms := tt.user.EffectivePermissions()
+			set := map[string]bool{}
+			for _, p := range perms {
+				set[p] = true
+			}
+			for _, p := range tt.has {
+				if !set[p] {
+					t.Errorf("missing %s in %v", p, perms)
+				}
+			}
+			for _, p := range tt.hasNot {
+				if set[p] {
+					t.Errorf("unexpected %s in %v", p, perms)
+				}
+			}
+			if tt.allPerm {
+				want := append([]string(nil), allPermissions...)
+				sort.Strings(want)
+				if !reflect.DeepEqual(perms, want) {
+					t.Errorf("admin permissions = %v, want all", perms)
+				}
+			}
+		})
+	}
+}
+
+func adminTestToken(t *testing.T, username, role, department, extra string) string {
+	t.Helper()
+	user := createTestAdminUser(t, username, "")
+	if err := db.Model(&user).Updates(map[string]interface{}{"role": role, "department": department, "permissions": extra}).Error; err != nil {
+		t.Fatal(err)
+	}
+	user.Role, user.Department, user.Permissions = role, department, extra
+	token, _, err := generateJWTToken(user, generateUUID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// 管理接口按角色权限放行，额外授予的权限同样生效
+func TestRequirePermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupTestDB(t)
+	ok := func(c *gin.Context) { c.Status(http.StatusNoContent) }
+	router := gin.New()
+	admin := router.Group("/api/v1/admin", authMiddleware())
+	admin.GET("/payrolls", requirePermission(PermPayrollsRead), ok)
+	admin.POST("/payrolls/publish", requirePermission(PermPayrollsPublish), ok)
+	admin.GET("/admin-users", requirePermission(PermAdminUsersRead), ok)
+
+	tokens := map[string]string{
+		"admin":         adminTestToken(t, "root", RoleAdmin, "", ""),
+		"finance":       adminTestToken(t, "finance", RoleFinance, "", ""),
+		"hr":            adminTestToken(t, "hr", RoleHR, "", ""),
+		"auditor":       adminTestToken(t, "auditor", RoleAuditor, "", ""),
+		"manager":       adminTestToken(t, "manager", RoleManager, "研发部", ""),
+		"manager+extra": adminTestToken(t, "manager2", RoleManager, "研发部", PermPayrollsRead),
+	}
+	tests := []struct {
+		user   string
+		method string
+		path   string
+		status int
+	}{
+		{"admin", http.MethodPost, "/api/v1/admin/payrolls/publish", http.StatusNoContent},
+		{"finance", http.MethodPost, "/api/v1/admin/payrolls/publish", http.StatusNoContent},
+		{"hr", http.MethodPost, "/api/v1/admin/payrolls/publish", http.StatusForbidden},
+		{"hr", http.MethodGet, "/api/v1/admin/payrolls", http.StatusNoContent},
+		{"auditor", http.MethodGet, "/api/v1/admin/admin-users", http.StatusNoContent},
+		{"auditor", http.MethodPost, "/api/v1/admin/payrolls/publish", http.StatusForbidden},
+		{"manager", http.MethodGet, "/api/v1/admin/payrolls", http.StatusForbidden},
+		{"manager+extra", http.MethodGet, "/api/v1/admin/payrolls", http.StatusNoContent},
+		{"", http.MethodGet, "/api/v1/admin/payrolls", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.user+" "+tt.method+" "+tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.user != "" {
+				req.Header.Set("Authorization", "Bearer "+tokens[tt.user])
+			}
+			router.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d, body = %s", w.Code, tt.status, w.Body)
+			}
+			if w.Code == http.StatusForbidden {
+				var resp struct {
+					RequiredPermission string `json:"required_permission"`
+				}
+				json.Unmarshal(w.Body.Bytes(), &resp)
+				if resp.RequiredPermission == "" {
+					t.Error("403 response does not name the required permission")
+				}
+			}
+		})
+	}
+}
+
+// 部门主管只能访问本部门员工，其他角色不受部门限制
+func TestCanAccessEmployee(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupTestDB(t)
+	rd := Employee{Name: "张三", EmployeeNo: "R001", Department: "研发部", Status: "active"}
+	sales := Employee{Name: "李四", EmployeeNo: "S001", Department: "销售部", Status: "active"}
+	for _, e := range []*Employee{&rd, &sales} {
+		if err := db.Create(e).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		role       string
+		department string
+		employee   Employee
+		want       bool
+	}{
+		{"manager own department", RoleManager, "研发部", rd, true},
+		{"manager other department", RoleManager, "研发部", sales, false},
+		{"manager without department", RoleManager, "", rd, false},
+		{"hr any department", RoleHR, "研发部", sales, true},
+		{"admin", RoleAdmin, "", sales, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Set("role", tt.role)
+			c.Set("department", tt.department)
+			if got := canAccessEmployee(c, tt.employee.ID); got != tt.want {
+				t.Errorf("canAccessEmployee() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	router := gin.New()
+	router.GET("/api/v1/admin/employees", authMiddleware(), requirePermission(PermEmployeesRead), getEmployees)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/employees", nil)
+	req.Header.Set("Authorization", "Bearer "+adminTestToken(t, "manager", RoleManager, "研发部", ""))
+	router.ServeHTTP(w, req)
+	var resp struct {
+		Data []Employee `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+	if len(resp.Data) != 1 || resp.Data[0].ID != rd.ID {
+		t.Errorf("manager employee list = %+v, want only 研发部", resp.Data)
+	}
+}
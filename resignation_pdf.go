@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pdf/fpdf"
+)
+
+// 离职文件的三方签名人
+var resignationSignerTypes = []struct {
+	Type string
+	Name string
+}{
+	{"employee", "员工本人"},
+	{"hr", "人力资源部"},
+	{"manager", "部门主管"},
+}
+
+var resignationTypeNames = map[string]string{
+	"voluntary":       "主动离职",
+	"dismissal":       "辞退",
+	"contract_expiry": "合同到期",
+}
+
+func resignationTypeName(t string) string {
+	if name, ok := resignationTypeNames[t]; ok {
+		return name
+	}
+	return t
+}
+
+// 生成离职报告PDF；report 为空时只输出离职申请和签名，作为离职确认书
+func renderResignationPDF(application ResignationApplication, report *ResignationReport, signatures []ResignationSignature) ([]byte, error) {
+	title := "离职报告"
+	if report == nil {
+		title = "离职确认书"
+	}
+	employee := application.Employee
+	pdf, err := newPDFDocument(fmt.Sprintf("%s %s", title, employee.Name))
+	if err != nil {
+		return nil, err
+	}
+
+	pdfHeading(pdf, title)
+
+	pdfSection(pdf, "员工基本信息")
+	pdfInfoGrid(pdf, [][2]string{
+		{"员工姓名", employee.Name},
+		{"员工编号", employee.EmployeeNo},
+		{"部门", employee.Department},
+		{"职位", employee.Position},
+		{"入职日期", formatPDFDate(employee.JoinDate)},
+		{"离职类型", resignationTypeName(application.ResignationType)},
+		{"申请日期", formatPDFDate(&application.ResignationDate)},
+		{"最后工作日", formatPDFDate(&application.LastWorkingDate)},
+	})
+
+	pdfSection(pdf, "离职原因")
+	pdfParagraph(pdf, application.Reason)
+	if application.HandoverNotes != "" {
+		pdfSection(pdf, "工作交接说明")
+		pdfParagraph(pdf, application.HandoverNotes)
+	}
+	if application.ApprovedAt != nil {
+		pdfSection(pdf, "审批意见")
+		pdfParagraph(pdf, fmt.Sprintf("%s（审批时间：%s）", orDash(application.ApprovalComments), formatPDFTime(application.ApprovedAt)))
+	}
+
+	if report != nil {
+		pdfSection(pdf, "工作总结")
+		pdfParagraph(pdf, report.WorkSummary)
+		pdfSection(pdf, "未完成事项")
+		pdfParagraph(pdf, report.UnfinishedTasks)
+		pdfSection(pdf, "交接状态")
+		pdfInfoGrid(pdf, [][2]string{
+			{"公司财产归还", boolToString(report.CompanyPropertyReturned)},
+			{"财务结清", boolToString(report.FinancialSettlement)},
+		})
+	}
+
+	pdfSection(pdf, "电子签名确认")
+	for _, signer := range resignationSignerTypes {
+		var signature *ResignationSignature
+		for i := range signatures {
+			if signatures[i].SignerType == signer.Type {
+				signature = &signatures[i]
+				break
+			}
+		}
+		pdfResignationSignature(pdf, signer.Name, signature)
+	}
+
+	// 页脚：申请编号、封存状态和生成时间
+	pdf.Ln(4)
+	pdf.SetFont(pdfFontFamily, "", 8)
+	pdf.SetTextColor(140, 140, 140)
+	now := time.Now()
+	pdf.CellFormat(0, 5, fmt.Sprintf("离职申请编号：%s    生成时间：%s", application.UUID, formatPDFTime(&now)), "", 1, "C", false, 0, "")
+	var seal DocumentSeal
+	if err := db.Where("entity_type = ? AND entity_id = ?", "resignation", application.ID).Order("id DESC").First(&seal).Error; err == nil {
+		pdf.CellFormat(0, 5, fmt.Sprintf("已于 %s 由服务端数字签名封存（密钥 %s），封存包可通过核验接口校验", formatPDFTime(&seal.SealedAt), seal.KeyID), "", 1, "C", false, 0, "")
+	}
+
+	return pdfOutput(pdf)
+}
+
+// 一位签名人的签名区：签名图片在左，签名时间和IP在右；未签名时显示待签名
+func pdfResignationSignature(pdf *fpdf.Fpdf, name string, signature *ResignationSignature) {
+	const boxHeight = 30.0
+	_, pageHeight := pdf.GetPageSize()
+	_, _, _, bottom := pdf.GetMargins()
+	if pdf.GetY()+boxHeight+8 > pageHeight-bottom {
+		pdf.AddPage()
+	}
+
+	pdf.SetFont(pdfFontFamily, "", 10)
+	pdf.SetTextColor(0, 0, 0)
+	pdf.CellFormat(0, 7, name+"签名：", "", 1, "L", false, 0, "")
+	x, y := pdf.GetXY()
+	if signature == nil {
+		pdf.SetTextColor(140, 140, 140)
+		pdf.CellFormat(60, 10, "待签名", "1", 1, "C", false, 0, "")
+		pdf.SetTextColor(0, 0, 0)
+		pdf.Ln(2)
+		return
+	}
+
+	image, _ := decodeSignatureImage(signature.SignatureData)
+	if err := pdfImage(pdf, fmt.Sprintf("resignation-signature-%d", signature.ID), image, x, y, 60, boxHeight-4); err != nil {
+		log.Printf("Failed to embed resignation signature %d: %v", signature.ID, err)
+		pdf.CellFormat(60, 8, "签名图片无法显示", "1", 0, "C", false, 0, "")
+	}
+	pdf.SetXY(x+70, y)
+	pdf.CellFormat(0, 6, "签名时间："+formatPDFTime(&signature.SignedAt), "", 2, "L", false, 0, "")
+	pdf.CellFormat(0, 6, "签名IP："+signature.IPAddress, "", 2, "L", false, 0, "")
+	pdf.SetFont(pdfFontFamily, "", 7)
+	pdf.MultiCell(0, 4, "签名哈希："+signature.SignatureHash, "", "L", false)
+	pdf.SetXY(x, y+boxHeight)
+}
+
+// 读取离职申请、最新的离职报告和签名
+func loadResignationDocument(application ResignationApplication) (*ResignationReport, []ResignationSignature, error) {
+	var signatures []ResignationSignature
+	if err := db.Where("application_id = ?", application.ID).Order("id").Find(&signatures).Error; err != nil {
+		return nil, nil, err
+	}
+	var report ResignationReport
+	if err := db.Where("application_id = ?", application.ID).Order("id DESC").First(&report).Error; err != nil {
+		return nil, signatures, nil
+	}
+	return &report, signatures, nil
+}
+
+func sendResignationPDF(c *gin.Context, application ResignationApplication, report *ResignationReport, signatures []ResignationSignature) {
+	data, err := renderResignationPDF(application, report, signatures)
+	if errors.Is(err, errPDFFontUnavailable) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to render resignation PDF %s: %v", application.UUID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成PDF失败"})
+		return
+	}
+
+	filename := fmt.Sprintf("resignation-%s.pdf", application.Employee.EmployeeNo)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Data(http.StatusOK, "application/pdf", data)
+}
+
+// 管理端下载离职报告PDF
+func getResignationReportPDF(c *gin.Context) {
+	var report ResignationReport
+	if err := db.Preload("Application").Preload("Application.Employee").First(&report, c.Param("id")).Error; err != nil ||
+		!canAccessEmployee(c, report.Application.EmployeeID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "离职报告不存在"})
+		return
+	}
+
+	var signatures []ResignationSignature
+	if err := db.Where("application_id = ?", report.ApplicationID).Order("id").Find(&signatures).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取签名失败"})
+		return
+	}
+	sendResignationPDF(c, report.Application, &report, signatures)
+}
+
+// 签名链接下载离职文件PDF：凭该申请的签名令牌在有效期内下载，签名后令牌已使用也可下载
+func getSignedResignationPDF(c *gin.Context) {
+	var application ResignationApplication
+	if err := db.Preload("Employee").First(&application, "uuid = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "离职申请不存在"})
+		return
+	}
+
+	var signToken ResignationSignToken
+	if err := db.Where("token = ? AND application_id = ? AND expires_at > ?", c.Query("token"), application.ID, time.Now()).
+		First(&signToken).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "无效或过期的签名令牌"})
+		return
+	}
+
+	report, signatures, err := loadResignationDocument(application)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取签名失败"})
+		return
+	}
+	sendResignationPDF(c, application, report, signatures)
+}
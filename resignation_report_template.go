@@ -0,0 +1,212 @@
+package main
+
+import (
+	"html/template"
+	"time"
+)
+
+// 离职报告模板数据
+type ResignationReportDocument struct {
+	Branding                map[string]string
+	EmployeeName            string
+	EmployeeNo              string
+	Department              string
+	Position                string
+	ResignationType         string
+	Reason                  string
+	HandoverNotes           string
+	ResignationDate         string
+	LastWorkingDate         string
+	ApprovalComments        string
+	WorkSummary             string
+	UnfinishedTasks         string
+	CompanyPropertyReturned bool
+	FinancialSettlement     bool
+	Signatures              []ResignationSignatureView
+	GeneratedAt             string
+}
+
+// 模板中的签名：Image 为已校验的图片 data URL，未签名时 Signed 为 false
+type ResignationSignatureView struct {
+	SignerType string
+	SignerName string
+	Signed     bool
+	Image      template.URL
+	SignedAt   string
+}
+
+func newResignationReportDocument(application ResignationApplication, report ResignationReport, branding map[string]string) *ResignationReportDocument {
+	var signatures []ResignationSignature
+	db.Where("application_id = ?", application.ID).Order("id").Find(&signatures)
+
+	doc := &ResignationReportDocument{
+		Branding:                branding,
+		EmployeeName:            application.Employee.Name,
+		EmployeeNo:              application.Employee.EmployeeNo,
+		Department:              application.Employee.Department,
+		Position:                application.Employee.Position,
+		ResignationType:         resignationTypeName(application.ResignationType),
+		Reason:                  application.Reason,
+		HandoverNotes:           application.HandoverNotes,
+		ResignationDate:         application.ResignationDate.Format("2006年01月02日"),
+		LastWorkingDate:         application.LastWorkingDate.Format("2006年01月02日"),
+		ApprovalComments:        application.ApprovalComments,
+		WorkSummary:             report.WorkSummary,
+		UnfinishedTasks:         report.UnfinishedTasks,
+		CompanyPropertyReturned: report.CompanyPropertyReturned,
+		FinancialSettlement:     report.FinancialSettlement,
+		GeneratedAt:             report.GeneratedAt.Format("2006年01月02日 15:04:05"),
+	}
+	for _, signer := range resignationSignerTypes {
+		view := ResignationSignatureView{SignerType: signer.Type, SignerName: signer.Name}
+		for _, sig := range signatures {
+			if sig.SignerType != signer.Type {
+				continue
+			}
+			view.Signed = true
+			view.SignedAt = sig.SignedAt.Format("2006-01-02 15:04:05")
+			// 只有格式正确的图片 data URL 才作为可信URL输出，否则 html/template 会将其替换为无害值
+			if _, err := decodeSignatureImage(sig.SignatureData); err == nil {
+				view.Image = template.URL(sig.SignatureData)
+			}
+			break
+		}
+		doc.Signatures = append(doc.Signatures, view)
+	}
+	return doc
+}
+
+// 示例数据，用于保存模板前试渲染和预览
+func sampleResignationReportDocument(branding map[string]string) interface{} {
+	now := time.Now()
+	doc := &ResignationReportDocument{
+		Branding:                branding,
+		EmployeeName:            "张三",
+		EmployeeNo:              "EMP001",
+		Department:              "技术部",
+		Position:                "高级工程师",
+		ResignationType:         resignationTypeName("voluntary"),
+		Reason:                  "个人职业发展",
+		HandoverNotes:           "项目文档和代码仓库权限已移交给李四",
+		ResignationDate:         now.AddDate(0, 0, -30).Format("2006年01月02日"),
+		LastWorkingDate:         now.Format("2006年01月02日"),
+		ApprovalComments:        "同意",
+		WorkSummary:             "负责工资系统后端开发和维护",
+		UnfinishedTasks:         "无",
+		CompanyPropertyReturned: true,
+		FinancialSettlement:     true,
+		GeneratedAt:             now.Format("2006年01月02日 15:04:05"),
+	}
+	for i, signer := range resignationSignerTypes {
+		doc.Signatures = append(doc.Signatures, ResignationSignatureView{
+			SignerType: signer.Type,
+			SignerName: signer.Name,
+			Signed:     i == 0,
+			SignedAt:   now.Format("2006-01-02 15:04:05"),
+		})
+	}
+	return doc
+}
+
+// 按当前启用的模板生成离职报告HTML，返回使用的模板版本
+func renderResignationReport(application ResignationApplication, report ResignationReport) (string, int, error) {
+	tpl, err := activeDocumentTemplate(documentKindResignationReport)
+	if err != nil {
+		return "", 0, err
+	}
+	if application.Employee.ID == 0 {
+		db.First(&application.Employee, application.EmployeeID)
+	}
+	html, err := renderDocumentTemplate(documentTemplateKinds[documentKindResignationReport], tpl.Content, parseBranding(tpl.Branding),
+		func(branding map[string]string) interface{} {
+			return newResignationReportDocument(application, report, branding)
+		})
+	return html, tpl.Version, err
+}
+
+// 内置离职报告模板
+const defaultResignationReportTemplate = `<html>
+<head>
+	<meta charset="utf-8">
+	<title>{{with .Branding.company_name}}{{.}} {{end}}离职报告</title>
+	<style>
+		body { font-family: 'Microsoft YaHei', Arial, sans-serif; margin: 20px; line-height: 1.6; }
+		h1 { color: #333; text-align: center; border-bottom: 2px solid {{.Branding.primary_color}}; padding-bottom: 10px; }
+		h2 { color: {{.Branding.primary_color}}; border-bottom: 1px solid #e0e0e0; padding-bottom: 5px; }
+		.brand { text-align: center; color: #555; }
+		.brand img { max-height: 60px; }
+		.section { margin: 25px 0; padding: 15px; background: #f8f9fa; border-radius: 8px; }
+		.label { font-weight: bold; color: #555; display: inline-block; width: 120px; }
+		.info-row { margin: 8px 0; }
+		.content-box { background: white; padding: 10px; border-radius: 5px; margin-top: 10px; white-space: pre-wrap; }
+		.signature-section { background: white; padding: 20px; border-radius: 8px; margin-top: 20px; }
+		.signature { margin: 15px 0; padding: 10px; background: #f0f0f0; border-radius: 5px; }
+		.signature img { max-width: 300px; height: 100px; border: 1px solid #ddd; background: white; padding: 5px; }
+		.signature.pending { background: #f9f9f9; }
+		.footer { text-align: center; margin-top: 30px; color: #666; font-size: 12px; }
+		@media print {
+			body { margin: 10px; }
+			.section { background: white; }
+		}
+	</style>
+</head>
+<body>
+	{{if or .Branding.logo_url .Branding.company_name}}
+	<div class="brand">
+		{{with .Branding.logo_url}}<img src="{{.}}" alt="logo">{{end}}
+		{{with .Branding.company_name}}<p>{{.}}</p>{{end}}
+	</div>
+	{{end}}
+	<h1>离职报告</h1>
+
+	<div class="section">
+		<h2>员工基本信息</h2>
+		<div class="info-row"><span class="label">员工姓名:</span> {{.EmployeeName}}</div>
+		<div class="info-row"><span class="label">员工编号:</span> {{.EmployeeNo}}</div>
+		<div class="info-row"><span class="label">部门:</span> {{.Department}}</div>
+		<div class="info-row"><span class="label">职位:</span> {{.Position}}</div>
+		<div class="info-row"><span class="label">离职类型:</span> {{.ResignationType}}</div>
+		<div class="info-row"><span class="label">离职原因:</span> {{.Reason}}</div>
+		<div class="info-row"><span class="label">最后工作日:</span> {{.LastWorkingDate}}</div>
+	</div>
+
+	<div class="section">
+		<h2>工作总结</h2>
+		<div class="content-box">{{.WorkSummary}}</div>
+	</div>
+
+	<div class="section">
+		<h2>未完成事项</h2>
+		<div class="content-box">{{.UnfinishedTasks}}</div>
+	</div>
+
+	<div class="section">
+		<h2>交接状态</h2>
+		<div class="info-row"><span class="label">公司财产归还:</span> {{yesno .CompanyPropertyReturned}}</div>
+		<div class="info-row"><span class="label">财务结清:</span> {{yesno .FinancialSettlement}}</div>
+	</div>
+
+	<div class="signature-section">
+		<h2>电子签名确认</h2>
+		{{range .Signatures}}
+		{{if .Signed}}
+		<div class="signature">
+			<p><strong>{{.SignerName}}签名:</strong></p>
+			{{if .Image}}<img src="{{.Image}}" alt="{{.SignerName}}签名">{{end}}
+			<p style="color: #666; font-size: 12px;">签名时间: {{.SignedAt}}</p>
+		</div>
+		{{else}}
+		<div class="signature pending">
+			<p><strong>{{.SignerName}}签名:</strong> <span style="color: #999;">待签名</span></p>
+		</div>
+		{{end}}
+		{{end}}
+	</div>
+
+	<div class="footer">
+		<p>报告生成时间: {{.GeneratedAt}}</p>
+		{{with .Branding.footer}}<p>{{.}}</p>{{end}}
+	</div>
+</body>
+</html>
+`
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 安全日志：登录失败、账号锁定、令牌重复使用等事件，每行一条JSON
+var securityLogger = log.New(os.Stderr, "[security] ", log.LstdFlags)
+
+// 未配置日志文件时输出到标准错误
+func initSecurityLog(path string) error {
+	if path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	securityLogger = log.New(f, "", 0)
+	return nil
+}
+
+// 记录安全事件
+func logSecurityEvent(event string, fields map[string]interface{}) {
+	entry := map[string]interface{}{
+		"time":  time.Now().Format(time.RFC3339),
+		"event": event,
+	}
+	for k, v := range fields {
+		entry[k] = v
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Failed to encode security event %s: %v", event, err)
+		return
+	}
+	securityLogger.Println(string(data))
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// 测试数据集
+//
+//	minimal   只创建标准工资模板
+//	demo      标准工资模板 + 演示员工，dev环境下额外创建 admin/admin123 演示账号
+//	load-test 标准工资模板 + N 个模拟员工及其工资条，用于压测
+var fixtureSets = map[string]func(tx *gorm.DB, opts seedOptions) error{
+	"minimal":   seedMinimal,
+	"demo":      seedDemo,
+	"load-test": seedLoadTest,
+}
+
+type seedOptions struct {
+	Employees int    // load-test 员工数量
+	Period    string // load-test 工资期间
+}
+
+// payroll seed minimal|demo|load-test [-employees N] [-period YYYY-MM]
+func runSeedCommand(conn *gorm.DB, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: seed minimal|demo|load-test [-employees N] [-period YYYY-MM]")
+	}
+	name := args[0]
+	seed, ok := fixtureSets[name]
+	if !ok {
+		return fmt.Errorf("unknown fixture set: %s", name)
+	}
+
+	fs := flag.NewFlagSet("seed "+name, flag.ContinueOnError)
+	opts := seedOptions{}
+	fs.IntVar(&opts.Employees, "employees", 100, "number of synthetic employees (load-test)")
+	fs.StringVar(&opts.Period, "period", time.Now().Format("2006-01"), "payroll period (load-test)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if err := conn.Transaction(func(tx *gorm.DB) error { return seed(tx, opts) }); err != nil {
+		return fmt.Errorf("seed %s: %w", name, err)
+	}
+	log.Printf("Seeded fixture set %s", name)
+	return nil
+}
+
+// 标准工资模板
+func seedStandardTemplate(tx *gorm.DB) (PayrollTemplate, error) {
+	templateFields := map[string]TemplateField{
+		"basic_salary":     {Name: "基本工资", Type: "number", Kind: FieldKindEarning, Taxable: true, Prorate: true, Required: true, Order: 1, Group: "基本工资"},
+		"performance":      {Name: "绩效奖金", Type: "number", Kind: FieldKindEarning, Taxable: true, Order: 2, Group: "奖金"},
+		"meal_allowance":   {Name: "餐补", Type: "number", Kind: FieldKindEarning, Taxable: true, Order: 3, Group: "津贴补贴"},
+		"transport":        {Name: "交通补贴", Type: "number", Kind: FieldKindEarning, Taxable: true, Order: 4, Group: "津贴补贴"},
+		"tax":              {Name: "个人所得税", Type: "number", Kind: FieldKindDeduction, Order: 10, Group: "税费"},
+		"social_insurance": {Name: "社保", Type: "number", Kind: FieldKindDeduction, Taxable: true, Order: 11, Group: "社保公积金"},
+	}
+	fieldsJSON, _ := json.Marshal(templateFields)
+
+	template := PayrollTemplate{
+		Name:        "标准工资模板",
+		Description: "包含基本工资、绩效、津贴和扣除项的标准模板",
+		Fields:      string(fieldsJSON),
+		IsActive:    true,
+	}
+
+	var existingTemplate PayrollTemplate
+	if err := tx.Where("name = ?", template.Name).First(&existingTemplate).Error; err == nil {
+		return existingTemplate, nil
+	}
+	err := tx.Create(&template).Error
+	return template, err
+}
+
+func seedMinimal(tx *gorm.DB, opts seedOptions) error {
+	_, err := seedStandardTemplate(tx)
+	return err
+}
+
+func seedDemo(tx *gorm.DB, opts seedOptions) error {
+	template, err := seedStandardTemplate(tx)
+	if err != nil {
+		return err
+	}
+
+	// 创建不同入职日期的员工示例
+	now := time.Now()
+	joinDate1 := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)    // 月初入职
+	joinDate2 := time.Date(now.Year(), now.Month(), 15, 0, 0, 0, 0, time.Local)   // 15号入职
+	joinDate3 := time.Date(now.Year(), now.Month()-1, 20, 0, 0, 0, 0, time.Local) // 上月20号入职
+
+	employees := []Employee{
+		{Name: "张三", EmployeeNo: "EMP001", Department: "技术部", Position: "高级工程师", Email: "zhangsan@company.com", Phone: "13800138001", JoinDate: &joinDate1},
+		{Name: "李四", EmployeeNo: "EMP002", Department: "产品部", Position: "产品经理", Email: "lisi@company.com", Phone: "13800138002", JoinDate: &joinDate2},
+		{Name: "王五", EmployeeNo: "EMP003", Department: "设计部", Position: "UI设计师", Email: "wangwu@company.com", Phone: "13800138003", JoinDate: &joinDate3},
+	}
+
+	baseSalaries := []int64{15000, 18000, 12000}
+
+	for i, emp := range employees {
+		var existingEmp Employee
+		if err := tx.Where("employee_no = ?", emp.EmployeeNo).First(&existingEmp).Error; err != nil {
+			if err := tx.Create(&emp).Error; err != nil {
+				return err
+			}
+			// 薪酬档案从入职当月生效，创建工资条时带入基本工资和固定津贴
+			profile := CompensationProfile{
+				EmployeeID:    emp.ID,
+				EffectiveFrom: emp.JoinDate.Format("2006-01"),
+				BaseSalary:    newMoney(decimal.NewFromInt(baseSalaries[i])),
+				Allowances:    map[string]Money{"meal_allowance": newMoney(decimal.NewFromInt(300)), "transport": newMoney(decimal.NewFromInt(200))},
+				TemplateID:    template.ID,
+				PayGroup:      "月薪",
+			}
+			if err := tx.Create(&profile).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	// 演示账号只在dev环境创建，方便本地调试和E2E测试
+	if appConfig.IsDev() {
+		var existingAdmin AdminUser
+		if err := tx.Where("username = ?", "admin").First(&existingAdmin).Error; err != nil {
+			admin := AdminUser{Username: "admin", Role: RoleAdmin, IsActive: true}
+			if err := tx.Create(&admin).Error; err != nil {
+				return err
+			}
+			if err := setAdminPassword(tx, &admin, defaultAdminPassword, false); err != nil {
+				return err
+			}
+			log.Printf("创建演示管理员账号: admin/%s", defaultAdminPassword)
+		}
+	}
+	return nil
+}
+
+func seedLoadTest(tx *gorm.DB, opts seedOptions) error {
+	if opts.Employees <= 0 {
+		return fmt.Errorf("employees must be positive")
+	}
+	if _, err := time.Parse("2006-01", opts.Period); err != nil {
+		return fmt.Errorf("invalid period %q, expected YYYY-MM", opts.Period)
+	}
+
+	template, err := seedStandardTemplate(tx)
+	if err != nil {
+		return err
+	}
+	schema, err := parseTemplateSchema(template.Fields)
+	if err != nil {
+		return err
+	}
+
+	departments := []string{"技术部", "产品部", "设计部", "市场部", "财务部", "人力资源部"}
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	runID := time.Now().Format("060102150405")
+
+	employees := make([]Employee, 0, opts.Employees)
+	for i := 1; i <= opts.Employees; i++ {
+		joinDate := time.Now().AddDate(0, -rng.Intn(60), -rng.Intn(28))
+		employees = append(employees, Employee{
+			Name:       fmt.Sprintf("压测员工%05d", i),
+			EmployeeNo: fmt.Sprintf("LT%s%05d", runID, i),
+			Department: departments[rng.Intn(len(departments))],
+			Position:   "工程师",
+			Email:      fmt.Sprintf("loadtest%s%05d@example.com", runID, i),
+			Phone:      fmt.Sprintf("139%08d", i),
+			JoinDate:   &joinDate,
+		})
+	}
+	if err := tx.CreateInBatches(&employees, 500).Error; err != nil {
+		return err
+	}
+
+	payrolls := make([]Payroll, 0, len(employees))
+	for _, emp := range employees {
+		data := map[string]interface{}{
+			"basic_salary":     float64(5000 + rng.Intn(25000)),
+			"performance":      float64(rng.Intn(5000)),
+			"meal_allowance":   float64(500),
+			"transport":        float64(300),
+			"tax":              float64(rng.Intn(2000)),
+			"social_insurance": float64(800 + rng.Intn(1200)),
+		}
+		calc := calculatePayroll(schema, data, nil)
+		dataJSON, _ := json.Marshal(data)
+		breakdownJSON, _ := json.Marshal(calc.Lines)
+		payrolls = append(payrolls, Payroll{
+			UUID:          generateUUID(),
+			EmployeeID:    emp.ID,
+			Period:        opts.Period,
+			TemplateID:    template.ID,
+			PayrollData:   string(dataJSON),
+			OriginalGross: calc.OriginalGross,
+			TotalGross:    calc.TotalGross,
+			TotalNet:      calc.TotalNet,
+			Breakdown:     string(breakdownJSON),
+			Status:        "draft",
+		})
+	}
+	return tx.CreateInBatches(&payrolls, 500).Error
+}
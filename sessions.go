@@ -0,0 +1,319 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 管理员刷新令牌，只保存令牌的哈希值
+//
+// 每次登录创建一个会话（SessionID），刷新时旧令牌作废并在同一会话下
+// 签发新令牌。已作废的令牌再次出现说明可能被盗用，整个会话随即吊销。
+type AdminRefreshToken struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	AdminUserID uint       `json:"admin_user_id" gorm:"index"`
+	SessionID   string     `json:"session_id" gorm:"index;size:36"`
+	TokenHash   string     `json:"-" gorm:"uniqueIndex;size:64"`
+	Remember    bool       `json:"remember"`
+	IPAddress   string     `json:"ip_address"`
+	UserAgent   string     `json:"user_agent"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// 已吊销的访问令牌（按jti记录），令牌过期后即可清理
+type RevokedAccessToken struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	JTI         string    `json:"jti" gorm:"uniqueIndex;size:36"`
+	AdminUserID uint      `json:"admin_user_id" gorm:"index"`
+	ExpiresAt   time.Time `json:"expires_at" gorm:"index"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// 刷新令牌请求
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// 退出登录请求，refresh_token 可选
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// 创建新会话，签发访问令牌和刷新令牌
+func issueSession(c *gin.Context, user AdminUser, remember bool) (LoginResponse, error) {
+	return issueRefreshToken(db, c, user, generateUUID(), remember)
+}
+
+// 在指定会话下签发一对新令牌
+func issueRefreshToken(tx *gorm.DB, c *gin.Context, user AdminUser, sessionID string, remember bool) (LoginResponse, error) {
+	refreshTTL := appConfig.Auth.RefreshTokenTTL
+	if remember {
+		refreshTTL = appConfig.Auth.RememberTokenTTL
+	}
+
+	refreshToken := generateSecureToken()
+	record := AdminRefreshToken{
+		AdminUserID: user.ID,
+		SessionID:   sessionID,
+		TokenHash:   hashResetToken(refreshToken),
+		Remember:    remember,
+		IPAddress:   c.ClientIP(),
+		UserAgent:   c.GetHeader("User-Agent"),
+		ExpiresAt:   time.Now().Add(refreshTTL),
+	}
+	if err := tx.Create(&record).Error; err != nil {
+		return LoginResponse{}, err
+	}
+
+	token, expiresAt, err := generateJWTToken(user, sessionID)
+	if err != nil {
+		return LoginResponse{}, err
+	}
+
+	return LoginResponse{
+		Token:              token,
+		ExpiresAt:          expiresAt,
+		RefreshToken:       refreshToken,
+		RefreshExpiresAt:   record.ExpiresAt,
+		Username:           user.Username,
+		MustChangePassword: user.MustChangePassword,
+		TOTPSetupRequired:  totpSetupRequired(user),
+		Role:               user.Role,
+		Permissions:        user.EffectivePermissions(),
+	}, nil
+}
+
+// 访问令牌是否已被吊销：单独退出、账号停用或退出全部会话
+func isAccessTokenRevoked(claims *JWTClaims) bool {
+	var count int64
+	if claims.ID != "" {
+		db.Model(&RevokedAccessToken{}).Where("jti = ?", claims.ID).Count(&count)
+		if count > 0 {
+			return true
+		}
+	}
+
+	var user AdminUser
+	if err := db.Select("id", "is_active", "token_version").First(&user, claims.UserID).Error; err != nil {
+		return true
+	}
+	return !user.IsActive || user.TokenVersion != claims.TokenVersion
+}
+
+// 吊销单个访问令牌
+func revokeAccessToken(tx *gorm.DB, claims *JWTClaims) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
+	}
+	return tx.Create(&RevokedAccessToken{
+		JTI:         claims.ID,
+		AdminUserID: claims.UserID,
+		ExpiresAt:   claims.ExpiresAt.Time,
+	}).Error
+}
+
+// 吊销某个会话下的全部刷新令牌
+func revokeSession(tx *gorm.DB, userID uint, sessionID string) error {
+	now := time.Now()
+	return tx.Model(&AdminRefreshToken{}).
+		Where("admin_user_id = ? AND session_id = ? AND revoked_at IS NULL", userID, sessionID).
+		Update("revoked_at", &now).Error
+}
+
+// 吊销管理员的全部会话：刷新令牌作废，已签发的访问令牌因版本号变化失效
+func revokeAllSessions(tx *gorm.DB, userID uint) error {
+	now := time.Now()
+	if err := tx.Model(&AdminRefreshToken{}).
+		Where("admin_user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", &now).Error; err != nil {
+		return err
+	}
+	return tx.Model(&AdminUser{}).Where("id = ?", userID).
+		Update("token_version", gorm.Expr("token_version + 1")).Error
+}
+
+// 清理已过期的刷新令牌、吊销记录、登录挑战、员工验证码和30天前的登录记录
+func purgeExpiredTokens() {
+	now := time.Now()
+	if err := db.Where("expires_at < ?", now).Delete(&RevokedAccessToken{}).Error; err != nil {
+		log.Printf("Failed to purge revoked access tokens: %v", err)
+	}
+	if err := db.Where("expires_at < ?", now).Delete(&AdminRefreshToken{}).Error; err != nil {
+		log.Printf("Failed to purge refresh tokens: %v", err)
+	}
+	if err := db.Where("expires_at < ?", now).Delete(&AdminLoginChallenge{}).Error; err != nil {
+		log.Printf("Failed to purge login challenges: %v", err)
+	}
+	if err := db.Where("expires_at < ?", now).Delete(&EmployeeLoginCode{}).Error; err != nil {
+		log.Printf("Failed to purge employee login codes: %v", err)
+	}
+	if err := purgeLoginAttempts(now.AddDate(0, 0, -30)); err != nil {
+		log.Printf("Failed to purge login attempts: %v", err)
+	}
+}
+
+// 定期清理过期令牌
+func startTokenPurger(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			purgeExpiredTokens()
+		}
+	}()
+}
+
+// 使用刷新令牌换取新的访问令牌，刷新令牌同时轮换
+func refreshToken(c *gin.Context) {
+	var req RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+
+	var record AdminRefreshToken
+	if err := db.Where("token_hash = ?", hashResetToken(req.RefreshToken)).First(&record).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的刷新令牌"})
+		return
+	}
+
+	if record.RevokedAt != nil {
+		// 已轮换的令牌被重复使用，吊销整个会话
+		logSecurityEvent("refresh_token_reuse", map[string]interface{}{
+			"user_id":    record.AdminUserID,
+			"session_id": record.SessionID,
+			"ip":         c.ClientIP(),
+		})
+		if err := revokeSession(db, record.AdminUserID, record.SessionID); err != nil {
+			log.Printf("Failed to revoke session %s: %v", record.SessionID, err)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "刷新令牌已失效，请重新登录"})
+		return
+	}
+	if time.Now().After(record.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "登录已过期，请重新登录"})
+		return
+	}
+
+	var user AdminUser
+	if err := db.Where("id = ? AND is_active = ?", record.AdminUserID, true).First(&user).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在或已停用"})
+		return
+	}
+
+	var resp LoginResponse
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		// 条件更新防止同一刷新令牌被并发使用两次
+		result := tx.Model(&AdminRefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", record.ID).
+			Update("revoked_at", &now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		var err error
+		resp, err = issueRefreshToken(tx, c, user, record.SessionID, record.Remember)
+		return err
+	})
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "刷新令牌已失效，请重新登录"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "刷新token失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// 退出当前会话
+func logout(c *gin.Context) {
+	var req LogoutRequest
+	_ = c.ShouldBindJSON(&req)
+
+	claimsValue, _ := c.Get("claims")
+	claims := claimsValue.(*JWTClaims)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := revokeAccessToken(tx, claims); err != nil {
+			return err
+		}
+		if claims.SessionID != "" {
+			if err := revokeSession(tx, claims.UserID, claims.SessionID); err != nil {
+				return err
+			}
+		}
+		if req.RefreshToken != "" {
+			now := time.Now()
+			return tx.Model(&AdminRefreshToken{}).
+				Where("token_hash = ? AND admin_user_id = ? AND revoked_at IS NULL", hashResetToken(req.RefreshToken), claims.UserID).
+				Update("revoked_at", &now).Error
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "退出登录失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已退出登录"})
+}
+
+// 退出当前管理员的全部会话
+func logoutAllSessions(c *gin.Context) {
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		return revokeAllSessions(tx, c.GetUint("user_id"))
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "退出登录失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已退出全部会话"})
+}
+
+// 查看当前管理员的有效会话
+func getSessions(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": activeSessions(c.GetUint("user_id"))})
+}
+
+func activeSessions(userID uint) []AdminRefreshToken {
+	var sessions []AdminRefreshToken
+	db.Where("admin_user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("created_at DESC").Find(&sessions)
+	return sessions
+}
+
+// 查看某个管理员的有效会话
+func getAdminUserSessions(c *gin.Context) {
+	var user AdminUser
+	if err := db.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "管理员不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": activeSessions(user.ID)})
+}
+
+// 强制某个管理员退出全部会话
+func logoutAdminUserSessions(c *gin.Context) {
+	var user AdminUser
+	if err := db.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "管理员不存在"})
+		return
+	}
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		return revokeAllSessions(tx, user.ID)
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "退出登录失败"})
+		return
+	}
+	recordAudit(c, "admin_user.sessions_revoke", "admin_user", user.ID, nil, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "已强制退出该管理员的全部会话"})
+}
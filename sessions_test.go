@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newSessionTestContext(w *httptest.ResponseRecorder, method, path, body string) *gin.Context {
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, path, strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.RemoteAddr = "198.51.100.1:40000"
+	return c
+}
+
+func issueTestSession(t *testing.T, user AdminUser) LoginResponse {
+	t.Helper()
+	resp, err := issueSession(newSessionTestContext(httptest.NewRecorder(), http.MethodPost, "/api/v1/login", ""), user, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func refreshTestToken(t *testing.T, token string) (int, LoginResponse) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	refreshToken(newSessionTestContext(w, http.MethodPost, "/api/v1/refresh", `{"refresh_token": "`+token+`"}`))
+	var resp LoginResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp
+}
+
+// 刷新令牌每次使用后轮换；已轮换的令牌再次使用时吊销整个会话，其他会话不受影响
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupTestDB(t)
+	user := createTestAdminUser(t, "session", "")
+	first, other := issueTestSession(t, user), issueTestSession(t, user)
+
+	status, second := refreshTestToken(t, first.RefreshToken)
+	if status != http.StatusOK || second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("refresh: status = %d, want 200 with a rotated refresh token", status)
+	}
+	status, third := refreshTestToken(t, second.RefreshToken)
+	if status != http.StatusOK {
+		t.Fatalf("second refresh: status = %d", status)
+	}
+
+	steps := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"reuse of rotated token", first.RefreshToken, http.StatusUnauthorized},
+		{"latest token of the same session", third.RefreshToken, http.StatusUnauthorized},
+		{"other session", other.RefreshToken, http.StatusOK},
+		{"unknown token", "unknown", http.StatusUnauthorized},
+	}
+	for _, s := range steps {
+		if status, _ := refreshTestToken(t, s.token); status != s.status {
+			t.Errorf("%s: status = %d, want %d", s.name, status, s.status)
+		}
+	}
+	if sessions := activeSessions(user.ID); len(sessions) != 1 {
+		t.Errorf("active sessions = %d, want 1", len(sessions))
+	}
+}
+
+// 过期的刷新令牌不能使用；退出登录后访问令牌和刷新令牌都失效
+func TestRefreshTokenExpiryAndLogout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupTestDB(t)
+	user := createTestAdminUser(t, "session", "")
+	expired := issueTestSession(t, user)
+	db.Model(&AdminRefreshToken{}).Where("token_hash = ?", hashResetToken(expired.RefreshToken)).Update("expires_at", time.Now().Add(-time.Minute))
+	if status, _ := refreshTestToken(t, expired.RefreshToken); status != http.StatusUnauthorized {
+		t.Errorf("expired refresh token: status = %d, want 401", status)
+	}
+
+	session := issueTestSession(t, user)
+	router := gin.New()
+	router.POST("/api/v1/logout", authMiddleware(), logout)
+	router.GET("/api/v1/sessions", authMiddleware(), getSessions)
+	request := func(method, path, body string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+session.Token)
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	if status := request(http.MethodPost, "/api/v1/logout", `{"refresh_token": "`+session.RefreshToken+`"}`); status != http.StatusOK {
+		t.Fatalf("logout: status = %d", status)
+	}
+	if status := request(http.MethodGet, "/api/v1/sessions", ""); status != http.StatusUnauthorized {
+		t.Errorf("access token after logout: status = %d, want 401", status)
+	}
+	if status, _ := refreshTestToken(t, session.RefreshToken); status != http.StatusUnauthorized {
+		t.Errorf("refresh token after logout: status = %d, want 401", status)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 签名哈希算法版本：0 为旧版MD5（无法复核），1 为 SHA-256 内容快照 + 哈希链
+const signatureHashVersion = 1
+
+var errInvalidSignatureImage = errors.New("签名图片格式错误")
+
+// 签名防篡改字段，工资条签名和离职签名共用
+type SignatureIntegrity struct {
+	ContentHash     string `json:"content_hash" gorm:"size:64"` // 签名时文档内容快照的SHA-256
+	ImageHash       string `json:"image_hash" gorm:"size:64"`   // 签名图片的SHA-256
+	PrevHash        string `json:"prev_hash" gorm:"size:64"`    // 同一文档上一条签名的哈希，第一条为空
+	HashVersion     int    `json:"hash_version" gorm:"default:0"`
+	ContentSnapshot string `json:"-" gorm:"type:text"` // 签名时的规范化内容快照，核验时用于指出被修改的字段
+}
+
+// 单条签名的核验结果
+type SignatureVerification struct {
+	SignatureID   uint      `json:"signature_id"`
+	SignerType    string    `json:"signer_type,omitempty"`
+	SignedAt      time.Time `json:"signed_at"`
+	Status        string    `json:"status"`        // valid, altered, legacy
+	RecordValid   bool      `json:"record_valid"`  // 签名记录本身（哈希、时间、IP、快照）未被修改
+	ChainValid    bool      `json:"chain_valid"`   // 与上一条签名的链接完整
+	ContentValid  bool      `json:"content_valid"` // 文档内容与签名时一致
+	ImageValid    bool      `json:"image_valid"`   // 签名图片与签名时一致
+	ChangedFields []string  `json:"changed_fields,omitempty"`
+}
+
+// 核验报告
+type VerificationReport struct {
+	EntityType string                  `json:"entity_type"`
+	EntityID   string                  `json:"entity_id"`
+	Valid      bool                    `json:"valid"` // 所有签名均可核验且未被篡改，旧版签名无法核验
+	Signatures []SignatureVerification `json:"signatures"`
+	VerifiedAt time.Time               `json:"verified_at"`
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// 解析 data URL 格式的签名图片
+func decodeSignatureImage(dataURL string) ([]byte, error) {
+	parts := strings.SplitN(dataURL, ",", 2)
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "data:image/") {
+		return nil, errInvalidSignatureImage
+	}
+	data, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil || len(data) == 0 {
+		return nil, errInvalidSignatureImage
+	}
+	return data, nil
+}
+
+// 时间统一为UTC并截断到秒，避免不同数据库的时间精度影响哈希
+func canonicalTime(t *time.Time) interface{} {
+	if t == nil || t.IsZero() {
+		return nil
+	}
+	return t.UTC().Truncate(time.Second).Format(time.RFC3339)
+}
+
+// 规范化JSON：map按键排序输出，payroll_data 等JSON字符串解析后重新编码
+func canonicalJSON(v map[string]interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func canonicalPayrollData(raw string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(raw), &v); err != nil {
+		return raw
+	}
+	return v
+}
+
+// 工资条内容快照：签收后状态会变化，因此不包含状态字段
+func payrollSnapshot(payroll Payroll) string {
+	var employee Employee
+	db.Select("id", "name", "employee_no").First(&employee, payroll.EmployeeID)
+	return canonicalJSON(map[string]interface{}{
+		"uuid":           payroll.UUID,
+		"employee_id":    payroll.EmployeeID,
+		"employee_no":    employee.EmployeeNo,
+		"employee_name":  employee.Name,
+		"period":         payroll.Period,
+		"template_id":    payroll.TemplateID,
+		"work_days":      payroll.WorkDays,
+		"month_days":     payroll.MonthDays,
+		"is_prorated":    payroll.IsProrated,
+		"payroll_data":   canonicalPayrollData(payroll.PayrollData),
+		"original_gross": payroll.OriginalGross,
+		"total_gross":    payroll.TotalGross,
+		"total_net":      payroll.TotalNet,
+	})
+}
+
+// 离职申请内容快照：签名完成后状态会变化，因此不包含状态字段
+func resignationSnapshot(application ResignationApplication) string {
+	var employee Employee
+	db.Select("id", "name", "employee_no").First(&employee, application.EmployeeID)
+	return canonicalJSON(map[string]interface{}{
+		"uuid":              application.UUID,
+		"employee_id":       application.EmployeeID,
+		"employee_no":       employee.EmployeeNo,
+		"employee_name":     employee.Name,
+		"resignation_type":  application.ResignationType,
+		"resignation_date":  canonicalTime(&application.ResignationDate),
+		"last_working_date": canonicalTime(&application.LastWorkingDate),
+		"reason":            application.Reason,
+		"handover_notes":    application.HandoverNotes,
+		"approved_by":       application.ApprovedBy,
+		"approved_at":       canonicalTime(application.ApprovedAt),
+		"approval_comments": application.ApprovalComments,
+	})
+}
+
+// 签名记录哈希，覆盖文档内容、签名图片、上一条签名、签名人、IP和时间
+func signatureRecordHash(entityType, entityID, signerType, ipAddress string, signedAt time.Time, s SignatureIntegrity) string {
+	return sha256Hex([]byte(canonicalJSON(map[string]interface{}{
+		"version":      s.HashVersion,
+		"entity_type":  entityType,
+		"entity_id":    entityID,
+		"signer_type":  signerType,
+		"ip_address":   ipAddress,
+		"signed_at":    canonicalTime(&signedAt),
+		"content_hash": s.ContentHash,
+		"image_hash":   s.ImageHash,
+		"prev_hash":    s.PrevHash,
+	})))
+}
+
+// 生成新签名的防篡改字段，prevHash 为同一文档上一条签名的哈希
+func newSignatureIntegrity(snapshot string, image []byte, prevHash string) SignatureIntegrity {
+	return SignatureIntegrity{
+		ContentHash:     sha256Hex([]byte(snapshot)),
+		ImageHash:       sha256Hex(image),
+		PrevHash:        prevHash,
+		HashVersion:     signatureHashVersion,
+		ContentSnapshot: snapshot,
+	}
+}
+
+// 比较签名时和当前的快照，返回被修改的字段
+func snapshotChangedFields(signed, current string) []string {
+	var a, b map[string]interface{}
+	json.Unmarshal([]byte(signed), &a)
+	json.Unmarshal([]byte(current), &b)
+	var fields []string
+	for key := range b {
+		if !reflect.DeepEqual(a[key], b[key]) {
+			fields = append(fields, key)
+		}
+	}
+	for key := range a {
+		if _, ok := b[key]; !ok {
+			fields = append(fields, key)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// 核验一条签名，prevHash 为链上前一条签名记录的哈希
+func verifySignature(entityType, entityID, signerType, ipAddress, signatureHash, prevHash, currentSnapshot string, signedAt time.Time, image []byte, s SignatureIntegrity) SignatureVerification {
+	result := SignatureVerification{SignedAt: signedAt, SignerType: signerType}
+	if s.HashVersion == 0 {
+		result.Status = "legacy"
+		return result
+	}
+
+	result.RecordValid = signatureRecordHash(entityType, entityID, signerType, ipAddress, signedAt, s) == signatureHash &&
+		sha256Hex([]byte(s.ContentSnapshot)) == s.ContentHash
+	result.ChainValid = s.PrevHash == prevHash
+	result.ContentValid = sha256Hex([]byte(currentSnapshot)) == s.ContentHash
+	result.ImageValid = image != nil && sha256Hex(image) == s.ImageHash
+	if !result.ContentValid {
+		result.ChangedFields = snapshotChangedFields(s.ContentSnapshot, currentSnapshot)
+	}
+
+	result.Status = "valid"
+	if !result.RecordValid || !result.ChainValid || !result.ContentValid || !result.ImageValid {
+		result.Status = "altered"
+	}
+	return result
+}
+
+func newVerificationReport(entityType, entityID string, signatures []SignatureVerification) VerificationReport {
+	report := VerificationReport{
+		EntityType: entityType,
+		EntityID:   entityID,
+		Valid:      true,
+		Signatures: signatures,
+		VerifiedAt: time.Now(),
+	}
+	for _, s := range signatures {
+		if s.Status != "valid" {
+			report.Valid = false
+		}
+	}
+	return report
+}
+
+// 读取已保存的工资条签名图片
+func loadSignatureImageFile(path string) []byte {
+	data, err := os.ReadFile(filepath.Join(appConfig.Server.UploadsDir, "signatures", filepath.Base(path)))
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// 同一文档最后一条签名的哈希，用于链接新签名
+func lastSignatureHash(tx *gorm.DB, model interface{}, column string, entityID uint) string {
+	var hashes []string
+	tx.Model(model).Where(column+" = ?", entityID).Order("id DESC").Limit(1).Pluck("signature_hash", &hashes)
+	if len(hashes) == 0 {
+		return ""
+	}
+	return hashes[0]
+}
+
+// 核验工资条签名：重新计算内容和签名图片哈希，检查签收后是否被修改
+func verifyPayrollSignature(c *gin.Context) {
+	var payroll Payroll
+	if err := db.Where("uuid = ?", c.Param("id")).First(&payroll).Error; err != nil || !canViewPayroll(c, payroll) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payroll not found"})
+		return
+	}
+
+	var signatures []PayrollSignature
+	if err := db.Where("payroll_id = ?", payroll.ID).Order("id").Find(&signatures).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(signatures) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Signature not found"})
+		return
+	}
+
+	snapshot := payrollSnapshot(payroll)
+	results := make([]SignatureVerification, 0, len(signatures))
+	prevHash := ""
+	for _, sig := range signatures {
+		result := verifySignature("payroll", payroll.UUID, "employee", sig.IPAddress, sig.SignatureHash, prevHash, snapshot,
+			sig.SignedAt, loadSignatureImageFile(sig.SignatureData), sig.SignatureIntegrity)
+		result.SignatureID = sig.ID
+		results = append(results, result)
+		prevHash = sig.SignatureHash
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": newVerificationReport("payroll", payroll.UUID, results)})
+}
+
+// 核验离职文件签名：按签名顺序检查哈希链、内容和签名图片
+func verifyResignationSignatures(c *gin.Context) {
+	var application ResignationApplication
+	if err := db.First(&application, "uuid = ?", c.Param("id")).Error; err != nil || !canAccessEmployee(c, application.EmployeeID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "离职申请不存在"})
+		return
+	}
+
+	var signatures []ResignationSignature
+	if err := db.Where("application_id = ?", application.ID).Order("id").Find(&signatures).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取签名失败"})
+		return
+	}
+	if len(signatures) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "暂无签名"})
+		return
+	}
+
+	snapshot := resignationSnapshot(application)
+	results := make([]SignatureVerification, 0, len(signatures))
+	prevHash := ""
+	for _, sig := range signatures {
+		image, _ := decodeSignatureImage(sig.SignatureData)
+		result := verifySignature("resignation", application.UUID, sig.SignerType, sig.IPAddress, sig.SignatureHash, prevHash, snapshot,
+			sig.SignedAt, image, sig.SignatureIntegrity)
+		result.SignatureID = sig.ID
+		results = append(results, result)
+		prevHash = sig.SignatureHash
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": newVerificationReport("resignation", application.UUID, results)})
+}
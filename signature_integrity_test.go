@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 测试用封存密钥：按 dev 环境生成临时 Ed25519 密钥，结束后恢复
+func setupTestSealer(t *testing.T) {
+	t.Helper()
+	savedProfile, savedSealer := appConfig.Profile, sealer
+	appConfig.Profile = "dev"
+	t.Cleanup(func() { appConfig.Profile, sealer = savedProfile, savedSealer })
+	if err := initDocumentSealer(SealConfig{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 已发布的工资条，签名图片保存在临时目录
+func createSignTestPayroll(t *testing.T) Payroll {
+	t.Helper()
+	savedUploads := appConfig.Server.UploadsDir
+	appConfig.Server.UploadsDir = t.TempDir()
+	t.Cleanup(func() { appConfig.Server.UploadsDir = savedUploads })
+
+	employee := Employee{Name: "王五", EmployeeNo: "S001", Status: "active"}
+	if err := db.Create(&employee).Error; err != nil {
+		t.Fatal(err)
+	}
+	payroll := Payroll{
+		UUID:        generateUUID(),
+		EmployeeID:  employee.ID,
+		Period:      "2024-09",
+		PayrollData: `{"basic_salary": 8000}`,
+		TotalGross:  money("8000"),
+		TotalNet:    money("8000"),
+		Status:      "published",
+	}
+	if err := db.Create(&payroll).Error; err != nil {
+		t.Fatal(err)
+	}
+	return payroll
+}
+
+var testSignatureImage = "data:image/png;base64," + base64.StdEncoding.EncodeToString([]byte("signature image"))
+
+func signTestPayroll(t *testing.T, payroll Payroll, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/payrolls/sign", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.Header.Set("User-Agent", "payroll-test/1.0")
+	c.Request.RemoteAddr = "203.0.113.7:52000"
+	c.Set("employee_id", payroll.EmployeeID)
+	signPayroll(c)
+	return w
+}
+
+// 签收IP和User-Agent取自请求连接，请求体中的同名字段不被采用；同一工资条只能有一条签收记录
+func TestSignPayrollRecordsServerSideClientInfo(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupTestDB(t)
+	setupTestSealer(t)
+	payroll := createSignTestPayroll(t)
+
+	w := signTestPayroll(t, payroll, `{"payroll_id": "`+payroll.UUID+`", "signature_data": "`+testSignatureImage+`",
+		"ip_address": "10.0.0.1", "user_agent": "forged", "device_info": "Windows"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+	var signature PayrollSignature
+	if err := db.Where("payroll_id = ?", payroll.ID).First(&signature).Error; err != nil {
+		t.Fatal(err)
+	}
+	if signature.IPAddress != "203.0.113.7" || signature.UserAgent != "payroll-test/1.0" {
+		t.Errorf("ip = %q, user agent = %q, want 203.0.113.7, payroll-test/1.0", signature.IPAddress, signature.UserAgent)
+	}
+	if signature.SignatureHash != signatureRecordHash("payroll", payroll.UUID, "employee", "203.0.113.7", signature.SignedAt, signature.SignatureIntegrity) {
+		t.Error("signature hash does not cover the server-side client IP")
+	}
+
+	duplicate := PayrollSignature{PayrollID: payroll.ID, SignedAt: signature.SignedAt}
+	if err := db.Create(&duplicate).Error; err == nil {
+		t.Error("second signature for the same payroll: want unique index violation")
+	}
+}
+
+// 签名后修改文档内容、签名记录、链接或签名图片都能被核验发现
+func TestVerifySignatureDetectsTampering(t *testing.T) {
+	signedAt := time.Date(2024, 10, 5, 9, 30, 0, 0, time.UTC)
+	snapshot := `{"period":"2024-09","total_gross":"8000","total_net":"8000"}`
+	edited := `{"period":"2024-09","total_gross":"8000","total_net":"9000"}`
+	type input struct {
+		integrity SignatureIntegrity
+		prevHash  string // 链上前一条签名的哈希
+		current   string // 当前文档快照
+		ip        string
+		image     []byte
+	}
+
+	tests := []struct {
+		name    string
+		tamper  func(in *input)
+		status  string
+		record  bool
+		chain   bool
+		content bool
+		img     bool
+		changed []string
+	}{
+		{
+			name:   "untouched",
+			tamper: func(*input) {},
+			status: "valid", record: true, chain: true, content: true, img: true,
+		},
+		{
+			name:   "document changed after signing",
+			tamper: func(in *input) { in.current = edited },
+			status: "altered", record: true, chain: true, img: true, changed: []string{"total_net"},
+		},
+		{
+			name: "snapshot rewritten to match changed document",
+			tamper: func(in *input) {
+				in.current = edited
+				in.integrity.ContentSnapshot = edited
+			},
+			status: "altered", chain: true, img: true,
+		},
+		{
+			name:   "previous signature replaced",
+			tamper: func(in *input) { in.prevHash = "a1b2c3" },
+			status: "altered", record: true, content: true, img: true,
+		},
+		{
+			name:   "prev hash rewritten",
+			tamper: func(in *input) { in.integrity.PrevHash = "a1b2c3" },
+			status: "altered", content: true, img: true,
+		},
+		{
+			name:   "signer ip altered",
+			tamper: func(in *input) { in.ip = "10.0.0.1" },
+			status: "altered", chain: true, content: true, img: true,
+		},
+		{
+			name:   "signature image replaced",
+			tamper: func(in *input) { in.image = []byte("other image") },
+			status: "altered", record: true, chain: true, content: true,
+		},
+		{
+			name:   "signature image missing",
+			tamper: func(in *input) { in.image = nil },
+			status: "altered", record: true, chain: true, content: true,
+		},
+		{
+			name:   "legacy signature without hashes",
+			tamper: func(in *input) { in.integrity = SignatureIntegrity{} },
+			status: "legacy",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := input{
+				integrity: newSignatureIntegrity(snapshot, []byte("signature image"), "0f1e2d"),
+				prevHash:  "0f1e2d",
+				current:   snapshot,
+				ip:        "203.0.113.7",
+				image:     []byte("signature image"),
+			}
+			hash := signatureRecordHash("payroll", "P-1", "employee", in.ip, signedAt, in.integrity)
+			tt.tamper(&in)
+
+			got := verifySignature("payroll", "P-1", "employee", in.ip, hash, in.prevHash, in.current, signedAt, in.image, in.integrity)
+			if got.Status != tt.status || got.RecordValid != tt.record || got.ChainValid != tt.chain ||
+				got.ContentValid != tt.content || got.ImageValid != tt.img {
+				t.Errorf("verifySignature() = %s record=%v chain=%v content=%v image=%v, want %s record=%v chain=%v content=%v image=%v",
+					got.Status, got.RecordValid, got.ChainValid, got.ContentValid, got.ImageValid,
+					tt.status, tt.record, tt.chain, tt.content, tt.img)
+			}
+			if !reflect.DeepEqual(got.ChangedFields, tt.changed) {
+				t.Errorf("changed fields = %v, want %v", got.ChangedFields, tt.changed)
+			}
+		})
+	}
+}
+
+// 签收后直接修改数据库中的工资条金额，核验接口报告被修改的字段
+func TestVerifyPayrollSignatureAfterEdit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupTestDB(t)
+	setupTestSealer(t)
+	payroll := createSignTestPayroll(t)
+	if w := signTestPayroll(t, payroll, `{"payroll_id": "`+payroll.UUID+`", "signature_data": "`+testSignatureImage+`"}`); w.Code != http.StatusOK {
+		t.Fatalf("sign status = %d, body = %s", w.Code, w.Body)
+	}
+
+	verify := func() VerificationReport {
+		t.Helper()
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/v1/payrolls/"+payroll.UUID+"/signature/verify", nil)
+		c.Params = gin.Params{{Key: "id", Value: payroll.UUID}}
+		c.Set("employee_id", payroll.EmployeeID)
+		verifyPayrollSignature(c)
+		var resp struct {
+			Data VerificationReport `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("verify status = %d, body = %s", w.Code, w.Body)
+		}
+		return resp.Data
+	}
+
+	if report := verify(); !report.Valid {
+		t.Fatalf("freshly signed payroll: report = %+v, want valid", report)
+	}
+	if err := db.Model(&Payroll{}).Where("id = ?", payroll.ID).Update("total_net", "9000").Error; err != nil {
+		t.Fatal(err)
+	}
+	report := verify()
+	if report.Valid || len(report.Signatures) != 1 {
+		t.Fatalf("edited payroll: report = %+v, want one altered signature", report)
+	}
+	if got := report.Signatures[0]; got.ContentValid || !got.RecordValid || !reflect.DeepEqual(got.ChangedFields, []string{"total_net"}) {
+		t.Errorf("signature = %+v, want content altered in total_net only", got)
+	}
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+)
+
+// 社保公积金：按城市维护各险种的缴费基数上下限和个人、单位缴费比例。
+// 同一城市按生效月份保存多条政策，计算时取工资期间适用的最近一条；
+// 员工按自己的缴费基数和参保城市计算，基数低于下限按下限、高于上限按上限。
+
+// 险种
+const (
+	ContributionPension      = "pension"      // 养老保险
+	ContributionMedical      = "medical"      // 医疗保险
+	ContributionUnemployment = "unemployment" // 失业保险
+	ContributionInjury       = "injury"       // 工伤保险
+	ContributionMaternity    = "maternity"    // 生育保险
+	ContributionHousingFund  = "housing_fund" // 住房公积金
+)
+
+// 险种按显示顺序排列，Short 用于明细行说明
+var contributionItems = []struct {
+	Key, Name, Short string
+}{
+	{ContributionPension, "养老保险", "养老"},
+	{ContributionMedical, "医疗保险", "医疗"},
+	{ContributionUnemployment, "失业保险", "失业"},
+	{ContributionInjury, "工伤保险", "工伤"},
+	{ContributionMaternity, "生育保险", "生育"},
+	{ContributionHousingFund, "住房公积金", "公积金"},
+}
+
+func contributionItemShort(key string) string {
+	for _, item := range contributionItems {
+		if item.Key == key {
+			return item.Short
+		}
+	}
+	return key
+}
+
+// 单个险种的基数上下限和缴费比例
+type ContributionRate struct {
+	BaseFloor    Money   `json:"base_floor"`    // 缴费基数下限，0 表示不限
+	BaseCeiling  Money   `json:"base_ceiling"`  // 缴费基数上限，0 表示不限
+	EmployeeRate float64 `json:"employee_rate"` // 个人缴费比例，如 0.08
+	EmployerRate float64 `json:"employer_rate"` // 单位缴费比例，如 0.16
+}
+
+// 城市社保公积金政策，Rates 为 险种 → 基数和比例，未列出的险种不缴纳
+type ContributionPolicy struct {
+	ID            uint                        `json:"id" gorm:"primaryKey"`
+	City          string                      `json:"city" gorm:"uniqueIndex:idx_contribution_policy_city;size:64"`
+	EffectiveFrom string                      `json:"effective_from" gorm:"uniqueIndex:idx_contribution_policy_city;size:7"` // 生效月份 2024-07
+	Rates         map[string]ContributionRate `json:"rates" gorm:"type:text;serializer:json"`
+	Comment       string                      `json:"comment"`
+	CreatedAt     time.Time                   `json:"created_at"`
+	UpdatedAt     time.Time                   `json:"updated_at"`
+}
+
+// 创建和更新政策请求
+type ContributionPolicyRequest struct {
+	City          string                      `json:"city" binding:"required"`
+	EffectiveFrom string                      `json:"effective_from" binding:"required"`
+	Rates         map[string]ContributionRate `json:"rates" binding:"required"`
+	Comment       string                      `json:"comment"`
+}
+
+// 校验政策，按 city、effective_from 和 rates.险种 列出错误
+func (req *ContributionPolicyRequest) validate() FieldErrors {
+	errs := FieldErrors{}
+	req.City = strings.TrimSpace(req.City)
+	if req.City == "" || utf8.RuneCountInString(req.City) > 64 {
+		errs["city"] = "城市不能为空且不超过64个字符"
+	}
+	if _, err := time.Parse("2006-01", req.EffectiveFrom); err != nil {
+		errs["effective_from"] = "生效月份格式应为 YYYY-MM"
+	}
+	known := map[string]bool{}
+	for _, item := range contributionItems {
+		known[item.Key] = true
+	}
+	for key, rate := range req.Rates {
+		name := "rates." + key
+		switch {
+		case !known[key]:
+			errs[name] = "险种必须是 pension、medical、unemployment、injury、maternity 或 housing_fund"
+		case rate.BaseFloor.IsNegative() || rate.BaseCeiling.IsNegative():
+			errs[name] = "缴费基数上下限不能为负数"
+		case rate.BaseCeiling.IsPositive() && rate.BaseCeiling.LessThan(rate.BaseFloor.Decimal):
+			errs[name] = "缴费基数上限不能低于下限"
+		case rate.EmployeeRate < 0 || rate.EmployeeRate > 1 || rate.EmployerRate < 0 || rate.EmployerRate > 1:
+			errs[name] = "缴费比例必须在0到1之间"
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// 按上下限调整缴费基数
+func (r ContributionRate) base(declared decimal.Decimal) decimal.Decimal {
+	if r.BaseFloor.IsPositive() && declared.LessThan(r.BaseFloor.Decimal) {
+		return r.BaseFloor.Decimal
+	}
+	if r.BaseCeiling.IsPositive() && declared.GreaterThan(r.BaseCeiling.Decimal) {
+		return r.BaseCeiling.Decimal
+	}
+	return declared
+}
+
+// 单个险种的计算结果
+type ContributionLine struct {
+	Item         string  `json:"item"`
+	Name         string  `json:"name"`
+	Base         Money   `json:"base"` // 按上下限调整后的缴费基数
+	EmployeeRate float64 `json:"employee_rate"`
+	EmployerRate float64 `json:"employer_rate"`
+	Employee     Money   `json:"employee"`
+	Employer     Money   `json:"employer"`
+}
+
+// 社保公积金计算结果，随工资条保存，用于核对和单位成本统计
+type ContributionResult struct {
+	City                    string             `json:"city"`
+	EffectiveFrom           string             `json:"effective_from"` // 适用政策的生效月份
+	DeclaredBase            Money              `json:"declared_base"`  // 员工的缴费基数
+	Lines                   []ContributionLine `json:"lines"`
+	EmployeeSocialInsurance Money              `json:"employee_social_insurance"` // 社保个人部分
+	EmployeeHousingFund     Money              `json:"employee_housing_fund"`     // 公积金个人部分
+	EmployerSocialInsurance Money              `json:"employer_social_insurance"` // 社保单位部分
+	EmployerHousingFund     Money              `json:"employer_housing_fund"`     // 公积金单位部分
+}
+
+// 查找城市在工资期间适用的政策
+func findContributionPolicy(city, period string) (ContributionPolicy, error) {
+	var policy ContributionPolicy
+	err := db.Where("city = ? AND effective_from <= ?", city, period).Order("effective_from DESC").First(&policy).Error
+	return policy, err
+}
+
+// 按员工的参保城市和缴费基数计算本期社保公积金，每个险种的个人和单位部分分别按单项规则舍入
+func calculateContributions(employee Employee, period string) (ContributionResult, error) {
+	if employee.ContributionCity == "" || !employee.ContributionBase.IsPositive() {
+		return ContributionResult{}, fmt.Errorf("员工未设置参保城市或缴费基数")
+	}
+	policy, err := findContributionPolicy(employee.ContributionCity, period)
+	if err != nil {
+		return ContributionResult{}, fmt.Errorf("参保城市 %s 没有 %s 适用的社保公积金政策", employee.ContributionCity, period)
+	}
+
+	lineRounding := appConfig.Payroll.Rounding.Line
+	result := ContributionResult{City: policy.City, EffectiveFrom: policy.EffectiveFrom, DeclaredBase: employee.ContributionBase}
+	var employeeSocial, employeeHousing, employerSocial, employerHousing decimal.Decimal
+	for _, item := range contributionItems {
+		rate, ok := policy.Rates[item.Key]
+		if !ok {
+			continue
+		}
+		base := rate.base(employee.ContributionBase.Decimal)
+		line := ContributionLine{
+			Item:         item.Key,
+			Name:         item.Name,
+			Base:         newMoney(base),
+			EmployeeRate: rate.EmployeeRate,
+			EmployerRate: rate.EmployerRate,
+			Employee:     newMoney(lineRounding.round(base.Mul(decimal.NewFromFloat(rate.EmployeeRate)))),
+			Employer:     newMoney(lineRounding.round(base.Mul(decimal.NewFromFloat(rate.EmployerRate)))),
+		}
+		result.Lines = append(result.Lines, line)
+		if item.Key == ContributionHousingFund {
+			employeeHousing = employeeHousing.Add(line.Employee.Decimal)
+			employerHousing = employerHousing.Add(line.Employer.Decimal)
+		} else {
+			employeeSocial = employeeSocial.Add(line.Employee.Decimal)
+			employerSocial = employerSocial.Add(line.Employer.Decimal)
+		}
+	}
+	result.EmployeeSocialInsurance = newMoney(employeeSocial)
+	result.EmployeeHousingFund = newMoney(employeeHousing)
+	result.EmployerSocialInsurance = newMoney(employerSocial)
+	result.EmployerHousingFund = newMoney(employerHousing)
+	return result, nil
+}
+
+// 社保公积金系统计算字段：housing 为公积金（否则为社保五险），employer 为单位部分（否则为个人部分）
+var contributionCalculators = map[string]struct{ housing, employer bool }{
+	FieldCalculatorSocialInsurance:         {false, false},
+	FieldCalculatorHousingFund:             {true, false},
+	FieldCalculatorSocialInsuranceEmployer: {false, true},
+	FieldCalculatorHousingFundEmployer:     {true, true},
+}
+
+func (r ContributionResult) amount(housing, employer bool) Money {
+	switch {
+	case housing && employer:
+		return r.EmployerHousingFund
+	case housing:
+		return r.EmployeeHousingFund
+	case employer:
+		return r.EmployerSocialInsurance
+	}
+	return r.EmployeeSocialInsurance
+}
+
+// 明细行说明，如 "基数 12,000.00：养老 8% 医疗 2% 失业 0.5%"；险种基数不同时在该险种后注明
+func (r ContributionResult) note(housing, employer bool) string {
+	var parts []string
+	var base Money
+	for _, line := range r.Lines {
+		if (line.Item == ContributionHousingFund) != housing {
+			continue
+		}
+		rate := line.EmployeeRate
+		if employer {
+			rate = line.EmployerRate
+		}
+		if rate == 0 {
+			continue
+		}
+		part := fmt.Sprintf("%s %s%%", contributionItemShort(line.Item), decimal.NewFromFloat(rate).Shift(2))
+		if len(parts) == 0 {
+			base = line.Base
+		} else if !line.Base.Equal(base.Decimal) {
+			part += "（基数 " + formatMoney(line.Base) + "）"
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "基数 " + formatMoney(base) + "：" + strings.Join(parts, " ")
+}
+
+// 模板有社保公积金计算字段时按员工参保信息计算，金额写入工资数据；没有这类字段时返回 nil
+func applyContributions(schema TemplateSchema, employee Employee, period string, data map[string]interface{}) (*ContributionResult, error) {
+	var result *ContributionResult
+	for _, key := range schema.Keys {
+		calculator, ok := contributionCalculators[schema.Fields[key].Calculator]
+		if !ok {
+			continue
+		}
+		if result == nil {
+			r, err := calculateContributions(employee, period)
+			if err != nil {
+				return nil, &PayrollDataError{Message: "工资计算失败", Fields: FieldErrors{key: err.Error()}}
+			}
+			result = &r
+		}
+		data[key] = result.amount(calculator.housing, calculator.employer)
+	}
+	return result, nil
+}
+
+// 给社保公积金明细行补充说明；模板没有单位部分字段时，单位缴纳金额同样计入单位缴纳合计，不影响实发
+func annotateContributions(schema TemplateSchema, calc *PayrollCalculation, result *ContributionResult) {
+	if result == nil {
+		return
+	}
+	calc.Contributions = result
+	for i := range calc.Lines {
+		if calculator, ok := contributionCalculators[schema.Fields[calc.Lines[i].Key].Calculator]; ok {
+			calc.Lines[i].Note = result.note(calculator.housing, calculator.employer)
+		}
+	}
+	employer := calc.EmployerContributions.Decimal
+	if schema.calculatorField(FieldCalculatorSocialInsuranceEmployer) == "" {
+		employer = employer.Add(result.EmployerSocialInsurance.Decimal)
+	}
+	if schema.calculatorField(FieldCalculatorHousingFundEmployer) == "" {
+		employer = employer.Add(result.EmployerHousingFund.Decimal)
+	}
+	calc.EmployerContributions = newMoney(employer)
+}
+
+// 工资条保存的社保公积金计算结果的JSON，未计算时为空
+func contributionsJSON(result *ContributionResult) string {
+	if result == nil {
+		return ""
+	}
+	data, _ := json.Marshal(result)
+	return string(data)
+}
+
+// 按员工请求设置参保城市和缴费基数，不传时保持不变；参数无效时返回 400
+func applyEmployeeContribution(c *gin.Context, employee *Employee, req CreateEmployeeRequest) bool {
+	if req.ContributionCity != nil {
+		city := strings.TrimSpace(*req.ContributionCity)
+		if utf8.RuneCountInString(city) > 64 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参保城市不超过64个字符"})
+			return false
+		}
+		employee.ContributionCity = city
+	}
+	if req.ContributionBase != nil {
+		if req.ContributionBase.IsNegative() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "缴费基数不能为负数"})
+			return false
+		}
+		employee.ContributionBase = *req.ContributionBase
+	}
+	return true
+}
+
+// 获取社保公积金政策列表，可按城市筛选
+func getContributionPolicies(c *gin.Context) {
+	query := db.Order("city, effective_from DESC")
+	if city := c.Query("city"); city != "" {
+		query = query.Where("city = ?", city)
+	}
+	var policies []ContributionPolicy
+	if err := query.Find(&policies).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取社保公积金政策失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": policies})
+}
+
+func getContributionPolicy(c *gin.Context) {
+	var policy ContributionPolicy
+	if err := db.First(&policy, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "社保公积金政策不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": policy})
+}
+
+// 绑定并校验政策请求，同一城市同一生效月份只能有一条
+func bindContributionPolicy(c *gin.Context, id uint) (ContributionPolicyRequest, bool) {
+	var req ContributionPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return req, false
+	}
+	if errs := req.validate(); errs != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "社保公积金政策校验失败", "field_errors": errs})
+		return req, false
+	}
+	var count int64
+	db.Model(&ContributionPolicy{}).Where("city = ? AND effective_from = ? AND id <> ?", req.City, req.EffectiveFrom, id).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "该城市同一生效月份的政策已存在"})
+		return req, false
+	}
+	return req, true
+}
+
+func createContributionPolicy(c *gin.Context) {
+	req, ok := bindContributionPolicy(c, 0)
+	if !ok {
+		return
+	}
+	policy := ContributionPolicy{City: req.City, EffectiveFrom: req.EffectiveFrom, Rates: req.Rates, Comment: req.Comment}
+	if err := db.Create(&policy).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存社保公积金政策失败"})
+		return
+	}
+	recordAudit(c, "contribution_policy.create", "contribution_policy", policy.ID, nil, policy)
+
+	c.JSON(http.StatusCreated, gin.H{"data": policy})
+}
+
+// 更新政策只影响之后计算的工资条，已保存的工资条保留计算时的结果
+func updateContributionPolicy(c *gin.Context) {
+	var policy ContributionPolicy
+	if err := db.First(&policy, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "社保公积金政策不存在"})
+		return
+	}
+	req, ok := bindContributionPolicy(c, policy.ID)
+	if !ok {
+		return
+	}
+	before := policy
+	policy.City = req.City
+	policy.EffectiveFrom = req.EffectiveFrom
+	policy.Rates = req.Rates
+	policy.Comment = req.Comment
+	if err := db.Save(&policy).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存社保公积金政策失败"})
+		return
+	}
+	recordAudit(c, "contribution_policy.update", "contribution_policy", policy.ID, before, policy)
+
+	c.JSON(http.StatusOK, gin.H{"data": policy})
+}
+
+func deleteContributionPolicy(c *gin.Context) {
+	var policy ContributionPolicy
+	if err := db.First(&policy, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "社保公积金政策不存在"})
+		return
+	}
+	if err := db.Delete(&policy).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除社保公积金政策失败"})
+		return
+	}
+	recordAudit(c, "contribution_policy.delete", "contribution_policy", policy.ID, policy, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "社保公积金政策已删除"})
+}
+
+// 按险种汇总的缴纳金额
+type ContributionReportItem struct {
+	Item     string `json:"item"`
+	Name     string `json:"name"`
+	Employee Money  `json:"employee"`
+	Employer Money  `json:"employer"`
+}
+
+// 单位用工成本统计：汇总工资期间内各工资条保存的社保公积金计算结果，
+// employer_contributions 为各工资条单位缴纳合计，包括模板中手工录入的单位缴纳项目
+func getContributionReport(c *gin.Context) {
+	period := c.Query("period")
+	if _, err := time.Parse("2006-01", period); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "工资期间格式应为 YYYY-MM"})
+		return
+	}
+	// 只统计已发布和已签收的工资条，草稿可能还会修改或删除
+	var payrolls []Payroll
+	if err := db.Select("contributions", "employer_contributions").
+		Where("period = ? AND status IN ?", period, []string{"published", "signed"}).Find(&payrolls).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "统计社保公积金失败"})
+		return
+	}
+
+	employeeByItem := map[string]decimal.Decimal{}
+	employerByItem := map[string]decimal.Decimal{}
+	var employeeTotal, employerTotal, employerContributions decimal.Decimal
+	for _, p := range payrolls {
+		employerContributions = employerContributions.Add(p.EmployerContributions.Decimal)
+		var result ContributionResult
+		if p.Contributions == "" || json.Unmarshal([]byte(p.Contributions), &result) != nil {
+			continue
+		}
+		for _, line := range result.Lines {
+			employeeByItem[line.Item] = employeeByItem[line.Item].Add(line.Employee.Decimal)
+			employerByItem[line.Item] = employerByItem[line.Item].Add(line.Employer.Decimal)
+			employeeTotal = employeeTotal.Add(line.Employee.Decimal)
+			employerTotal = employerTotal.Add(line.Employer.Decimal)
+		}
+	}
+	items := []ContributionReportItem{}
+	for _, item := range contributionItems {
+		items = append(items, ContributionReportItem{
+			Item:     item.Key,
+			Name:     item.Name,
+			Employee: newMoney(employeeByItem[item.Key]),
+			Employer: newMoney(employerByItem[item.Key]),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"period":                 period,
+		"payroll_count":          len(payrolls),
+		"items":                  items,
+		"employee_total":         newMoney(employeeTotal),
+		"employer_total":         newMoney(employerTotal),
+		"employer_contributions": newMoney(employerContributions),
+	}})
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+)
+
+func money(s string) Money {
+	return newMoney(decimal.RequireFromString(s))
+}
+
+func TestContributionRateBase(t *testing.T) {
+	tests := []struct {
+		name           string
+		floor, ceil    string
+		declared, want string
+	}{
+		{"within range", "5000", "30000", "12000", "12000"},
+		{"below floor", "5000", "30000", "3000", "5000"},
+		{"at floor", "5000", "30000", "5000", "5000"},
+		{"above ceiling", "5000", "30000", "45000.5", "30000"},
+		{"at ceiling", "5000", "30000", "30000", "30000"},
+		{"no floor", "0", "30000", "1000", "1000"},
+		{"no ceiling", "5000", "0", "99999.99", "99999.99"},
+		{"no limits", "0", "0", "12345.67", "12345.67"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rate := ContributionRate{BaseFloor: money(tt.floor), BaseCeiling: money(tt.ceil)}
+			if got := rate.base(decimal.RequireFromString(tt.declared)); !got.Equal(decimal.RequireFromString(tt.want)) {
+				t.Errorf("base(%s) = %s, want %s", tt.declared, got, tt.want)
+			}
+		})
+	}
+}
+
+func createTestContributionPolicy(t *testing.T) ContributionPolicy {
+	t.Helper()
+	policy := ContributionPolicy{
+		City:          "上海",
+		EffectiveFrom: "2024-07",
+		Rates: map[string]ContributionRate{
+			ContributionPension:      {BaseFloor: money("7310"), BaseCeiling: money("36549"), EmployeeRate: 0.08, EmployerRate: 0.16},
+			ContributionMedical:      {BaseFloor: money("7310"), BaseCeiling: money("36549"), EmployeeRate: 0.02, EmployerRate: 0.09},
+			ContributionUnemployment: {BaseFloor: money("7310"), BaseCeiling: money("36549"), EmployeeRate: 0.005, EmployerRate: 0.005},
+			ContributionHousingFund:  {BaseFloor: money("2690"), BaseCeiling: money("36549"), EmployeeRate: 0.07, EmployerRate: 0.07},
+		},
+	}
+	if err := db.Create(&policy).Error; err != nil {
+		t.Fatal(err)
+	}
+	return policy
+}
+
+// 每个险种的个人和单位部分分别按单项规则舍入，合计为舍入后各项之和
+func TestCalculateContributions(t *testing.T) {
+	setupTestDB(t)
+	createTestContributionPolicy(t)
+	saved := appConfig.Payroll.Rounding.Line
+	t.Cleanup(func() { appConfig.Payroll.Rounding.Line = saved })
+
+	tests := []struct {
+		name                                            string
+		base                                            string
+		rounding                                        RoundingPolicy
+		pensionBase, pension, unemployment              string
+		employeeSocial, employeeHousing, employerSocial string
+	}{
+		{
+			// 养老 987.6536 医疗 246.9134 失业 61.72835，公积金 864.1969
+			name: "fen", base: "12345.67", rounding: RoundingPolicy{Unit: "fen", Mode: "half_up"},
+			pensionBase: "12345.67", pension: "987.65", unemployment: "61.73",
+			employeeSocial: "1296.29", employeeHousing: "864.2", employerSocial: "3148.15",
+		},
+		{
+			name: "yuan", base: "12345.67", rounding: RoundingPolicy{Unit: "yuan", Mode: "half_up"},
+			pensionBase: "12345.67", pension: "988", unemployment: "62",
+			employeeSocial: "1297", employeeHousing: "864", employerSocial: "3148",
+		},
+		{
+			// 低于下限按 7310 计算
+			name: "below floor", base: "5000", rounding: RoundingPolicy{Unit: "fen", Mode: "half_even"},
+			pensionBase: "7310", pension: "584.8", unemployment: "36.55",
+			employeeSocial: "767.55", employeeHousing: "350", employerSocial: "1864.05",
+		},
+		{
+			name: "above ceiling", base: "50000", rounding: RoundingPolicy{Unit: "fen", Mode: "half_up"},
+			pensionBase: "36549", pension: "2923.92", unemployment: "182.75",
+			employeeSocial: "3837.65", employeeHousing: "2558.43", employerSocial: "9320",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appConfig.Payroll.Rounding.Line = tt.rounding
+			employee := Employee{ContributionCity: "上海", ContributionBase: money(tt.base)}
+			result, err := calculateContributions(employee, "2024-09")
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := map[string]ContributionLine{}
+			for _, line := range result.Lines {
+				lines[line.Item] = line
+			}
+			checks := []struct {
+				name      string
+				got, want string
+			}{
+				{"pension base", lines[ContributionPension].Base.String(), tt.pensionBase},
+				{"pension", lines[ContributionPension].Employee.String(), tt.pension},
+				{"unemployment", lines[ContributionUnemployment].Employee.String(), tt.unemployment},
+				{"employee social insurance", result.EmployeeSocialInsurance.String(), tt.employeeSocial},
+				{"employee housing fund", result.EmployeeHousingFund.String(), tt.employeeHousing},
+				{"employer social insurance", result.EmployerSocialInsurance.String(), tt.employerSocial},
+			}
+			for _, c := range checks {
+				if c.got != c.want {
+					t.Errorf("%s = %s, want %s", c.name, c.got, c.want)
+				}
+			}
+			if _, ok := lines[ContributionInjury]; ok {
+				t.Error("items not in the policy must not be calculated")
+			}
+		})
+	}
+
+	if _, err := calculateContributions(Employee{ContributionCity: "上海", ContributionBase: money("10000")}, "2024-06"); err == nil {
+		t.Error("period before the policy takes effect: want error")
+	}
+	if _, err := calculateContributions(Employee{ContributionCity: "上海"}, "2024-09"); err == nil {
+		t.Error("employee without contribution base: want error")
+	}
+}
+
+// 单位缴纳不计入实发：模板有单位部分字段时按字段汇总，没有时计入单位缴纳合计
+func TestContributionsExcludedFromNet(t *testing.T) {
+	setupTestDB(t)
+	createTestContributionPolicy(t)
+	employee := Employee{Name: "李四", EmployeeNo: "E001", Status: "active", ContributionCity: "上海", ContributionBase: money("12000")}
+	if err := db.Create(&employee).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	const employeeFields = `
+		"basic_salary": {"name": "基本工资", "kind": "earning", "taxable": true},
+		"social_insurance": {"name": "社保", "kind": "deduction", "taxable": true, "calculator": "social_insurance"},
+		"housing_fund": {"name": "公积金", "kind": "deduction", "taxable": true, "calculator": "housing_fund"}`
+	tests := []struct {
+		name   string
+		fields string
+	}{
+		{"employer fields", `{` + employeeFields + `,
+			"social_insurance_employer": {"name": "社保单位部分", "kind": "employer_contribution", "calculator": "social_insurance_employer"},
+			"housing_fund_employer": {"name": "公积金单位部分", "kind": "employer_contribution", "calculator": "housing_fund_employer"}}`},
+		{"no employer fields", `{` + employeeFields + `}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template := PayrollTemplate{Name: tt.name, Fields: tt.fields, IsActive: true}
+			if err := db.Create(&template).Error; err != nil {
+				t.Fatal(err)
+			}
+			req := CreatePayrollRequest{
+				EmployeeID:  employee.ID,
+				Period:      "2024-09",
+				TemplateID:  template.ID,
+				PayrollData: map[string]interface{}{"basic_salary": 15000.0},
+			}
+			calc, err := computePayroll(&req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 个人：养老 960 医疗 240 失业 60，公积金 840；单位：养老 1920 医疗 1080 失业 60，公积金 840
+			checks := []struct {
+				name      string
+				got, want string
+			}{
+				{"total gross", calc.TotalGross.String(), "15000"},
+				{"total deductions", calc.TotalDeductions.String(), "2100"},
+				{"total net", calc.TotalNet.String(), "12900"},
+				{"taxable income", calc.TaxableIncome.String(), "12900"},
+				{"employer contributions", calc.EmployerContributions.String(), "3900"},
+			}
+			for _, c := range checks {
+				if c.got != c.want {
+					t.Errorf("%s = %s, want %s", c.name, c.got, c.want)
+				}
+			}
+		})
+	}
+}
+
+// 修改政策中的单位比例时，审计日志按 rates.险种.字段 记录变化
+func TestUpdateContributionPolicyAudit(t *testing.T) {
+	setupTestDB(t)
+	gin.SetMode(gin.TestMode)
+	policy := createTestContributionPolicy(t)
+
+	rates := map[string]ContributionRate{}
+	for key, rate := range policy.Rates {
+		rates[key] = rate
+	}
+	pension := rates[ContributionPension]
+	pension.EmployerRate = 0.14
+	rates[ContributionPension] = pension
+	body, _ := json.Marshal(ContributionPolicyRequest{City: policy.City, EffectiveFrom: policy.EffectiveFrom, Rates: rates})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPut, "/api/v1/contribution-policies/1", strings.NewReader(string(body)))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = gin.Params{{Key: "id", Value: "1"}}
+	c.Set("user_id", uint(1))
+	c.Set("username", "admin")
+	updateContributionPolicy(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+
+	var entry AuditLog
+	if err := db.Where("action = ?", "contribution_policy.update").First(&entry).Error; err != nil {
+		t.Fatal(err)
+	}
+	var changes map[string]AuditChange
+	if err := json.Unmarshal([]byte(entry.Changes), &changes); err != nil {
+		t.Fatal(err)
+	}
+	want := AuditChange{Before: 0.16, After: 0.14}
+	if len(changes) != 1 || changes["rates.pension.employer_rate"] != want {
+		t.Errorf("changes = %#v, want only rates.pension.employer_rate %v", changes, want)
+	}
+}
+
+// 缴费报表只统计已发布和已签收的工资条
+func TestContributionReportExcludesDrafts(t *testing.T) {
+	setupTestDB(t)
+	gin.SetMode(gin.TestMode)
+	createTestContributionPolicy(t)
+	result, err := calculateContributions(Employee{ContributionCity: "上海", ContributionBase: money("10000")}, "2024-09")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contributions, _ := json.Marshal(result)
+	employer := result.EmployerSocialInsurance.Add(result.EmployerHousingFund.Decimal)
+	for i, status := range []string{"draft", "published", "signed"} {
+		payroll := Payroll{
+			UUID:                  generateUUID(),
+			EmployeeID:            uint(i + 1),
+			Period:                "2024-09",
+			Status:                status,
+			Contributions:         string(contributions),
+			EmployerContributions: newMoney(employer),
+		}
+		if err := db.Create(&payroll).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/v1/contribution-report?period=2024-09", nil)
+	getContributionReport(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Data struct {
+			PayrollCount          int   `json:"payroll_count"`
+			EmployeeTotal         Money `json:"employee_total"`
+			EmployerContributions Money `json:"employer_contributions"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	// 个人：养老 800 医疗 200 失业 50，公积金 700；单位：养老 1600 医疗 900 失业 50，公积金 700
+	checks := []struct {
+		name      string
+		got, want string
+	}{
+		{"payroll count", strconv.Itoa(resp.Data.PayrollCount), "2"},
+		{"employee total", resp.Data.EmployeeTotal.String(), "3500"},
+		{"employer contributions", resp.Data.EmployerContributions.String(), "6500"},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s = %s, want %s", c.name, c.got, c.want)
+		}
+	}
+}
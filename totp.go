@@ -0,0 +1,524 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+	"gorm.io/gorm"
+)
+
+// RFC 6238 TOTP 参数，与主流验证器App的默认值一致
+const (
+	totpPeriod = 30 // 秒
+	totpDigits = 6
+	totpSkew   = 1 // 允许前后各一个时间步的时钟误差
+
+	recoveryCodeCount     = 10
+	maxChallengeAttempts  = 5
+	recoveryCodeAlphabet  = "abcdefghjkmnpqrstuvwxyz23456789"
+	recoveryCodeHalfChars = 5
+)
+
+// 两步验证配置
+type TOTPConfig struct {
+	Issuer       string        `yaml:"issuer"`        // 验证器App中显示的签发方
+	Required     bool          `yaml:"required"`      // 是否强制所有管理员启用
+	ChallengeTTL time.Duration `yaml:"challenge_ttl"` // 密码验证通过后输入验证码的有效期
+}
+
+// 两步验证恢复码，只保存哈希值，每个只能使用一次
+type AdminRecoveryCode struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	AdminUserID uint       `json:"admin_user_id" gorm:"index"`
+	CodeHash    string     `json:"-" gorm:"size:64"`
+	UsedAt      *time.Time `json:"used_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// 登录第二步的挑战，密码验证通过后签发
+type AdminLoginChallenge struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	AdminUserID uint       `json:"admin_user_id" gorm:"index"`
+	TokenHash   string     `json:"-" gorm:"uniqueIndex;size:64"`
+	Remember    bool       `json:"remember"`
+	Attempts    int        `json:"attempts"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	UsedAt      *time.Time `json:"used_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// 需要两步验证时的登录响应
+type MFAChallengeResponse struct {
+	MFARequired bool      `json:"mfa_required"`
+	Challenge   string    `json:"challenge"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// 登录第二步请求，code 可以是验证码或恢复码
+type VerifyMFARequest struct {
+	Challenge string `json:"challenge" binding:"required"`
+	Code      string `json:"code" binding:"required"`
+}
+
+type TOTPCodeRequest struct {
+	Code     string `json:"code" binding:"required"`
+	Remember bool   `json:"remember"`
+}
+
+type DisableTOTPRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// 启用两步验证的响应：恢复码只显示这一次，同时签发新的会话
+type TOTPEnableResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+	LoginResponse
+}
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func generateTOTPSecret() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return totpEncoding.EncodeToString(b)
+}
+
+// 计算某个时间步的验证码
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// 校验验证码，返回匹配的时间步；不接受已使用过的时间步，防止验证码重放
+func validateTOTP(secret, code string, lastStep int64) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := time.Now().Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		expected, err := totpCode(secret, step)
+		if err == nil && hmac.Equal([]byte(expected), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// 验证器App扫码使用的 otpauth URI
+func totpProvisioningURI(secret, username string) string {
+	issuer := appConfig.Auth.TOTP.Issuer
+	label := url.PathEscape(issuer + ":" + username)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// 是否因系统强制要求而需要先完成两步验证绑定
+func totpSetupRequired(user AdminUser) bool {
+	return appConfig.Auth.TOTP.Required && !user.TOTPEnabled
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// 重新生成恢复码，旧的恢复码全部作废
+func generateRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("admin_user_id = ?", userID).Delete(&AdminRecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, recoveryCodeHalfChars*2)
+		for j := range b {
+			// 均匀选取字符，避免取模带来的分布偏差
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(recoveryCodeAlphabet))))
+			if err != nil {
+				return nil, err
+			}
+			b[j] = recoveryCodeAlphabet[n.Int64()]
+		}
+		code := string(b[:recoveryCodeHalfChars]) + "-" + string(b[recoveryCodeHalfChars:])
+		if err := tx.Create(&AdminRecoveryCode{
+			AdminUserID: userID,
+			CodeHash:    hashResetToken(normalizeRecoveryCode(code)),
+		}).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// 校验第二因素：6位验证码或恢复码，返回使用的方式
+func verifySecondFactor(user AdminUser, code string) (string, bool) {
+	if !user.TOTPEnabled || user.TOTPSecret == "" {
+		return "", false
+	}
+
+	if step, ok := validateTOTP(user.TOTPSecret, code, user.TOTPLastStep); ok {
+		// 条件更新保证同一验证码只能使用一次
+		result := db.Model(&AdminUser{}).
+			Where("id = ? AND totp_last_step < ?", user.ID, step).
+			Update("totp_last_step", step)
+		return "totp", result.Error == nil && result.RowsAffected == 1
+	}
+
+	now := time.Now()
+	result := db.Model(&AdminRecoveryCode{}).
+		Where("admin_user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashResetToken(normalizeRecoveryCode(code))).
+		Update("used_at", &now)
+	if result.Error == nil && result.RowsAffected == 1 {
+		return "recovery_code", true
+	}
+	return "", false
+}
+
+// 密码验证通过后签发登录挑战
+func issueLoginChallenge(c *gin.Context, user AdminUser, remember bool) {
+	token := generateSecureToken()
+	challenge := AdminLoginChallenge{
+		AdminUserID: user.ID,
+		TokenHash:   hashResetToken(token),
+		Remember:    remember,
+		ExpiresAt:   time.Now().Add(appConfig.Auth.TOTP.ChallengeTTL),
+	}
+	if err := db.Create(&challenge).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "登录失败，请稍后重试"})
+		return
+	}
+	c.JSON(http.StatusOK, MFAChallengeResponse{
+		MFARequired: true,
+		Challenge:   token,
+		ExpiresAt:   challenge.ExpiresAt,
+	})
+}
+
+// 登录第二步：校验验证码或恢复码后签发令牌
+func verifyLoginChallenge(c *gin.Context) {
+	var req VerifyMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+
+	var challenge AdminLoginChallenge
+	if err := db.Where("token_hash = ? AND used_at IS NULL AND expires_at > ? AND attempts < ?",
+		hashResetToken(req.Challenge), time.Now(), maxChallengeAttempts).First(&challenge).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "验证已过期，请重新登录"})
+		return
+	}
+
+	var user AdminUser
+	if err := db.Where("id = ? AND is_active = ?", challenge.AdminUserID, true).First(&user).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "验证已过期，请重新登录"})
+		return
+	}
+	if !checkAccountLockout(c, user) {
+		return
+	}
+
+	db.Model(&challenge).Update("attempts", gorm.Expr("attempts + 1"))
+
+	method, ok := verifySecondFactor(user, req.Code)
+	if !ok {
+		failures := recordLoginFailure(c, &user, user.Username)
+		time.Sleep(loginFailureDelay(failures))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "验证码错误"})
+		return
+	}
+
+	now := time.Now()
+	result := db.Model(&AdminLoginChallenge{}).
+		Where("id = ? AND used_at IS NULL", challenge.ID).
+		Update("used_at", &now)
+	if result.Error != nil || result.RowsAffected == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "验证已过期，请重新登录"})
+		return
+	}
+
+	if method == "recovery_code" {
+		logSecurityEvent("recovery_code_used", map[string]interface{}{
+			"username": user.Username,
+			"user_id":  user.ID,
+			"ip":       c.ClientIP(),
+		})
+	}
+	completeLogin(c, user, challenge.Remember)
+}
+
+// 当前管理员的两步验证状态
+func getTOTPStatus(c *gin.Context) {
+	var user AdminUser
+	if err := db.First(&user, c.GetUint("user_id")).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在或已停用"})
+		return
+	}
+	var remaining int64
+	db.Model(&AdminRecoveryCode{}).Where("admin_user_id = ? AND used_at IS NULL", user.ID).Count(&remaining)
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":                  user.TOTPEnabled,
+		"required":                 appConfig.Auth.TOTP.Required,
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// 生成新的TOTP密钥和二维码，输入验证码确认后才会启用
+func setupTOTP(c *gin.Context) {
+	var user AdminUser
+	if err := db.Where("id = ? AND is_active = ?", c.GetUint("user_id"), true).First(&user).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在或已停用"})
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "已启用两步验证，如需更换请先停用"})
+		return
+	}
+
+	secret := generateTOTPSecret()
+	if err := db.Model(&user).Updates(map[string]interface{}{
+		"totp_secret":    secret,
+		"totp_last_step": 0,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成密钥失败"})
+		return
+	}
+
+	uri := totpProvisioningURI(secret, user.Username)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成二维码失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":       secret,
+		"otpauth_url":  uri,
+		"qr_code":      "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+		"period":       totpPeriod,
+		"digits":       totpDigits,
+		"instructions": "使用验证器App扫描二维码，然后输入App中显示的6位验证码完成绑定",
+	})
+}
+
+// 确认验证码并启用两步验证，返回恢复码
+func enableTOTP(c *gin.Context) {
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+
+	var user AdminUser
+	if err := db.Where("id = ? AND is_active = ?", c.GetUint("user_id"), true).First(&user).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在或已停用"})
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "已启用两步验证"})
+		return
+	}
+	if user.TOTPSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请先获取两步验证二维码"})
+		return
+	}
+	step, ok := validateTOTP(user.TOTPSecret, req.Code, user.TOTPLastStep)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "验证码错误"})
+		return
+	}
+
+	var codes []string
+	before := user
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"totp_enabled":   true,
+			"totp_last_step": step,
+		}).Error; err != nil {
+			return err
+		}
+		var err error
+		if codes, err = generateRecoveryCodes(tx, user.ID); err != nil {
+			return err
+		}
+		// 启用后其他未经两步验证的会话全部退出
+		return revokeAllSessions(tx, user.ID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "启用两步验证失败"})
+		return
+	}
+	db.First(&user, user.ID)
+	recordAudit(c, "admin_user.totp_enable", "admin_user", user.ID, before, user)
+
+	logSecurityEvent("totp_enabled", map[string]interface{}{
+		"username": user.Username,
+		"user_id":  user.ID,
+		"ip":       c.ClientIP(),
+	})
+
+	resp, err := issueSession(c, user, req.Remember)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成token失败"})
+		return
+	}
+	c.JSON(http.StatusOK, TOTPEnableResponse{RecoveryCodes: codes, LoginResponse: resp})
+}
+
+// 停用两步验证，需要同时提供密码和验证码
+func disableTOTP(c *gin.Context) {
+	if appConfig.Auth.TOTP.Required {
+		c.JSON(http.StatusForbidden, gin.H{"error": "系统要求所有管理员启用两步验证"})
+		return
+	}
+
+	var req DisableTOTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+
+	var user AdminUser
+	if err := db.First(&user, c.GetUint("user_id")).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在或已停用"})
+		return
+	}
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "未启用两步验证"})
+		return
+	}
+	if !verifyPassword(user.Password, req.Password) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "密码错误"})
+		return
+	}
+	if _, ok := verifySecondFactor(user, req.Code); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "验证码错误"})
+		return
+	}
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		return clearTOTP(tx, user.ID)
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "停用两步验证失败"})
+		return
+	}
+	after := user
+	after.TOTPEnabled = false
+	recordAudit(c, "admin_user.totp_disable", "admin_user", user.ID, user, after)
+
+	logSecurityEvent("totp_disabled", map[string]interface{}{
+		"username": user.Username,
+		"user_id":  user.ID,
+		"ip":       c.ClientIP(),
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "两步验证已停用"})
+}
+
+// 重新生成恢复码
+func regenerateRecoveryCodes(c *gin.Context) {
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+
+	var user AdminUser
+	if err := db.First(&user, c.GetUint("user_id")).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在或已停用"})
+		return
+	}
+	if _, ok := verifySecondFactor(user, req.Code); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "验证码错误"})
+		return
+	}
+
+	var codes []string
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = generateRecoveryCodes(tx, user.ID)
+		return err
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成恢复码失败"})
+		return
+	}
+	recordAudit(c, "admin_user.recovery_codes_regenerate", "admin_user", user.ID, nil, nil)
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// 清除两步验证绑定和恢复码
+func clearTOTP(tx *gorm.DB, userID uint) error {
+	if err := tx.Model(&AdminUser{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"totp_secret":    "",
+		"totp_enabled":   false,
+		"totp_last_step": 0,
+	}).Error; err != nil {
+		return err
+	}
+	return tx.Where("admin_user_id = ?", userID).Delete(&AdminRecoveryCode{}).Error
+}
+
+// 管理员重置他人的两步验证（手机丢失等情况），该账号需重新绑定
+func resetAdminUserTOTP(c *gin.Context) {
+	var user AdminUser
+	if err := db.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "管理员不存在"})
+		return
+	}
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := clearTOTP(tx, user.ID); err != nil {
+			return err
+		}
+		return revokeAllSessions(tx, user.ID)
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "重置两步验证失败"})
+		return
+	}
+	after := user
+	after.TOTPEnabled = false
+	recordAudit(c, "admin_user.totp_reset", "admin_user", user.ID, user, after)
+
+	logSecurityEvent("totp_reset", map[string]interface{}{
+		"username": user.Username,
+		"user_id":  user.ID,
+		"reset_by": c.GetString("username"),
+		"ip":       c.ClientIP(),
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "两步验证已重置，该管理员下次登录需重新绑定"})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 附录B的 SHA-1 测试向量，取后6位
+func TestTOTPCode(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := totpCode(secret, tt.unix/totpPeriod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("totpCode(T=%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+// 避免在时间步切换的瞬间取当前时间步
+func currentTOTPStep(t *testing.T) int64 {
+	t.Helper()
+	if time.Now().Unix()%totpPeriod >= totpPeriod-2 {
+		time.Sleep(3 * time.Second)
+	}
+	return time.Now().Unix() / totpPeriod
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret := generateTOTPSecret()
+	current := currentTOTPStep(t)
+	code := func(step int64) string {
+		c, err := totpCode(secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		wantStep int64
+		ok       bool
+	}{
+		{"current step", code(current), 0, current, true},
+		{"previous step within skew", code(current - 1), 0, current - 1, true},
+		{"next step within skew", code(current + 1), 0, current + 1, true},
+		{"outside skew", code(current - 2), 0, 0, false},
+		{"replayed step", code(current), current, 0, false},
+		{"earlier than last used step", code(current - 1), current, 0, false},
+		{"wrong length", code(current)[:5], 0, 0, false},
+		{"surrounding spaces", " " + code(current) + " ", 0, current, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := validateTOTP(secret, tt.code, tt.lastStep)
+			if ok != tt.ok || step != tt.wantStep {
+				t.Errorf("validateTOTP() = %d, %v, want %d, %v", step, ok, tt.wantStep, tt.ok)
+			}
+		})
+	}
+}
+
+// 同一验证码只能使用一次，即使调用方持有的用户记录已过时；恢复码只能使用一次，重新生成后旧码作废
+func TestVerifySecondFactorSingleUse(t *testing.T) {
+	setupTestDB(t)
+	user := createTestAdminUser(t, "mfa", "")
+	user.TOTPSecret, user.TOTPEnabled = generateTOTPSecret(), true
+	if err := db.Model(&user).Updates(map[string]interface{}{"totp_secret": user.TOTPSecret, "totp_enabled": true}).Error; err != nil {
+		t.Fatal(err)
+	}
+	codes, err := generateRecoveryCodes(db, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("recovery codes = %d, want %d", len(codes), recoveryCodeCount)
+	}
+	totp, err := totpCode(user.TOTPSecret, currentTOTPStep(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stale := user
+	steps := []struct {
+		name   string
+		code   string
+		method string
+		ok     bool
+	}{
+		{"totp", totp, "totp", true},
+		{"totp replay", totp, "totp", false},
+		{"recovery code", codes[0], "recovery_code", true},
+		{"recovery code reuse", codes[0], "", false},
+		{"recovery code normalized", strings.ToUpper(strings.ReplaceAll(codes[1], "-", " ")), "recovery_code", true},
+		{"unknown code", "abcde-fghjk", "", false},
+	}
+	for _, s := range steps {
+		method, ok := verifySecondFactor(stale, s.code)
+		if ok != s.ok || (s.ok && method != s.method) {
+			t.Errorf("%s: verifySecondFactor() = %q, %v, want %q, %v", s.name, method, ok, s.method, s.ok)
+		}
+	}
+
+	if _, err := generateRecoveryCodes(db, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := verifySecondFactor(stale, codes[2]); ok {
+		t.Error("recovery code from before regeneration was accepted")
+	}
+}
@@ -1,18 +1,85 @@
 class PayrollAPI {
-    constructor(baseURL = '/api/v1') {
+    // tokenKey 为 employeeToken 时使用员工门户令牌
+    constructor(baseURL = '/api/v1', tokenKey = 'adminToken') {
         this.baseURL = baseURL;
+        this.tokenKey = tokenKey;
     }
 
     // 获取认证头
     getAuthHeaders() {
-        const token = localStorage.getItem('adminToken');
+        const token = localStorage.getItem(this.tokenKey);
         if (token) {
             return { 'Authorization': 'Bearer ' + token };
         }
         return {};
     }
 
-    async request(endpoint, options = {}) {
+    // 保存登录/刷新接口返回的令牌
+    static saveSession(data) {
+        localStorage.setItem('adminToken', data.token);
+        localStorage.setItem('adminRefreshToken', data.refresh_token);
+        localStorage.setItem('tokenExpiry', new Date(data.refresh_expires_at).getTime());
+    }
+
+    static clearSession() {
+        localStorage.removeItem('adminToken');
+        localStorage.removeItem('adminRefreshToken');
+        localStorage.removeItem('tokenExpiry');
+    }
+
+    // 员工门户登录后保存令牌
+    static saveEmployeeSession(data) {
+        localStorage.setItem('employeeToken', data.token);
+        localStorage.setItem('employeeTokenExpiry', new Date(data.expires_at).getTime());
+    }
+
+    static clearEmployeeSession() {
+        localStorage.removeItem('employeeToken');
+        localStorage.removeItem('employeeTokenExpiry');
+    }
+
+    // 访问令牌过期后用刷新令牌换取新令牌，多个请求同时过期时只刷新一次
+    async refreshSession() {
+        const refreshToken = localStorage.getItem('adminRefreshToken');
+        if (this.tokenKey !== 'adminToken' || !refreshToken) {
+            return false;
+        }
+        if (!PayrollAPI.refreshing) {
+            PayrollAPI.refreshing = fetch(`${this.baseURL}/auth/refresh`, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ refresh_token: refreshToken }),
+            }).then(async response => {
+                if (!response.ok) {
+                    PayrollAPI.clearSession();
+                    return false;
+                }
+                PayrollAPI.saveSession(await response.json());
+                return true;
+            }).catch(() => false).finally(() => {
+                PayrollAPI.refreshing = null;
+            });
+        }
+        return await PayrollAPI.refreshing;
+    }
+
+    async logout() {
+        const refreshToken = localStorage.getItem('adminRefreshToken');
+        try {
+            await fetch(`${this.baseURL}/auth/logout`, {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json',
+                    ...this.getAuthHeaders(),
+                },
+                body: JSON.stringify({ refresh_token: refreshToken || '' }),
+            });
+        } finally {
+            PayrollAPI.clearSession();
+        }
+    }
+
+    async request(endpoint, options = {}, retried = false) {
         const url = `${this.baseURL}${endpoint}`;
         const config = {
             headers: {
@@ -25,10 +92,15 @@ class PayrollAPI {
 
         try {
             const response = await fetch(url, config);
+            if (response.status === 401 && !retried && await this.refreshSession()) {
+                return await this.request(endpoint, options, true);
+            }
             const data = await response.json();
 
             if (!response.ok) {
-                throw new Error(data.error || 'Request failed');
+                // 字段级错误逐项附在错误信息后
+                const fieldErrors = Object.entries(data.field_errors || {}).map(([k, v]) => `${k}: ${v}`);
+                throw new Error([data.error || 'Request failed', ...fieldErrors].join('\n'));
             }
 
             return data;
@@ -147,6 +219,75 @@ class PayrollAPI {
         return await this.request(`/payrolls/${payrollId}/signature`);
     }
 
+    // 员工门户登录
+    async requestEmployeeOTP(identifier) {
+        return await this.request('/employee-auth/otp', {
+            method: 'POST',
+            body: JSON.stringify({ identifier }),
+        });
+    }
+
+    async verifyEmployeeOTP(identifier, code) {
+        return await this.request('/employee-auth/otp/verify', {
+            method: 'POST',
+            body: JSON.stringify({ identifier, code }),
+        });
+    }
+
+    async requestEmployeeMagicLink(identifier) {
+        return await this.request('/employee-auth/magic-link', {
+            method: 'POST',
+            body: JSON.stringify({ identifier }),
+        });
+    }
+
+    async verifyEmployeeMagicLink(token) {
+        return await this.request('/employee-auth/magic-link/verify', {
+            method: 'POST',
+            body: JSON.stringify({ token }),
+        });
+    }
+
+    // 工资条访问链接
+    async exchangePayslipLink(token) {
+        return await this.request('/payslip-links/exchange', {
+            method: 'POST',
+            body: JSON.stringify({ token }),
+        });
+    }
+
+    async getPayslipLinks(payrollId) {
+        return await this.request(`/payrolls/${payrollId}/links`);
+    }
+
+    async createPayslipLink(payrollId, options = {}) {
+        return await this.request(`/payrolls/${payrollId}/links`, {
+            method: 'POST',
+            body: JSON.stringify(options),
+        });
+    }
+
+    async revokePayslipLink(linkId) {
+        return await this.request(`/payslip-links/${linkId}/revoke`, {
+            method: 'POST',
+        });
+    }
+
+    async getEmployeeProfile() {
+        return await this.request('/employee-auth/me');
+    }
+
+    async getCurrentCompensation(employeeId, period) {
+        return await this.request(`/employees/${employeeId}/compensation/current?period=${period}`);
+    }
+
+    async setEmployeePortal(employeeId, enabled) {
+        return await this.request(`/employees/${employeeId}/portal`, {
+            method: 'PUT',
+            body: JSON.stringify({ enabled }),
+        });
+    }
+
     async getNotifications(status = null) {
         const params = status ? `?status=${status}` : '';
         return await this.request(`/notifications${params}`);
@@ -203,8 +344,6 @@ class PayrollManager {
             const signatureData = {
                 payroll_id: payrollId,
                 signature_data: signatureDataURL,
-                ip_address: await this.getClientIP(),
-                user_agent: navigator.userAgent,
                 device_info: this.getDeviceInfo()
             };
 